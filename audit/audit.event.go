@@ -129,6 +129,10 @@ type AuditEvent struct {
 
 	// Metadata contains additional context (changes, before/after, reason, etc.)
 	Metadata map[string]any `json:"metadata,omitempty"`
+
+	// Integrity links the event into its tenant's tamper-evident chain.
+	// Set by a Publisher configured WithIntegrity; nil otherwise.
+	Integrity *Integrity `json:"integrity,omitempty"`
 }
 
 // Change represents a single field modification in an UPDATE action.
@@ -137,4 +141,16 @@ type Change struct {
 	Field    string `json:"field"`
 	OldValue any    `json:"oldValue,omitempty"`
 	NewValue any    `json:"newValue,omitempty"`
+
+	// Op is set when a whole map key or slice element was added or
+	// removed; empty for a modified value.
+	Op ChangeOp `json:"op,omitempty"`
 }
+
+// ChangeOp marks a Change that added or removed a value as a whole.
+type ChangeOp string
+
+const (
+	ChangeAdded   ChangeOp = "added"
+	ChangeRemoved ChangeOp = "removed"
+)
@@ -1,46 +1,168 @@
 package audit
 
 import (
+	"bytes"
+	"encoding"
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// DiffChanges compares two structs (before/after) and returns a list of Changes
-// for fields that differ. Uses json struct tags for field names if available,
-// otherwise uses the Go field name.
+// DiffChanges compares two values (before/after) and returns a Change for
+// every leaf value that differs, named by its dotted path with json struct
+// tags for field names if available, otherwise the Go field name. Nested
+// structs and maps are walked; slice elements are matched by position, or
+// by the field an `audit:"key=<field>"` tag names. A map key or element
+// present on one side only is a single Change with Op set.
+//
+// before and after are two structs of the same type, two maps (bson.M,
+// bson.D, map[string]any), or two JSON documents ([]byte,
+// json.RawMessage).
+//
+// Values of sensitive fields (passwords, tokens, keys — logger's sensitive
+// keys) are redacted and personal ones (mobile, email — logger's personal
+// keys) masked as in logs, along with every value nested under them; tag a
+// field `audit:"redact"` to redact it too, or `audit:"-"` to leave it out.
 //
 // Usage:
 //
-//	changes := audit.DiffChanges(oldSubscriber, newSubscriber)
-//	// → [{Field:"mobile", OldValue:"0501234567", NewValue:"0509876543"}, ...]
+//	changes := audit.DiffChanges(oldPolicy, newPolicy)
+//	// → [{Field:"assets.peerScope", OldValue:"tenant", NewValue:"global"},
+//	//    {Field:"assets.peers[id=p2]", Op:"added", NewValue:{...}}, ...]
 func DiffChanges(before, after any) []Change {
 	if before == nil || after == nil {
 		return nil
 	}
 
-	beforeVal := reflect.ValueOf(before)
-	afterVal := reflect.ValueOf(after)
-
-	// Dereference pointers
-	if beforeVal.Kind() == reflect.Ptr {
-		beforeVal = beforeVal.Elem()
-	}
-	if afterVal.Kind() == reflect.Ptr {
-		afterVal = afterVal.Elem()
+	beforeVal := indirect(diffable(before))
+	afterVal := indirect(diffable(after))
+	if !beforeVal.IsValid() || !afterVal.IsValid() {
+		return nil
 	}
 
 	// Must be same type
-	if beforeVal.Type() != afterVal.Type() {
+	if beforeVal.Kind() != afterVal.Kind() {
 		return nil
 	}
-
-	if beforeVal.Kind() != reflect.Struct {
+	switch beforeVal.Kind() {
+	case reflect.Struct:
+		if beforeVal.Type() != afterVal.Type() {
+			return nil
+		}
+	case reflect.Map:
+	default:
 		return nil
 	}
 
-	var changes []Change
-	t := beforeVal.Type()
+	var d differ
+	d.diff(diffPath{}, beforeVal, afterVal, fieldOptions{})
+	return d.changes
+}
+
+// fieldOptions is a parsed `audit` struct tag.
+type fieldOptions struct {
+	skip   bool   // "-"
+	redact bool   // "redact"
+	key    string // "key=<field>": identifies the elements of a slice
+}
+
+func parseFieldOptions(tag string) fieldOptions {
+	var opts fieldOptions
+	for _, part := range strings.Split(tag, ",") {
+		switch part = strings.TrimSpace(part); {
+		case part == "-":
+			opts.skip = true
+		case part == "redact":
+			opts.redact = true
+		case strings.HasPrefix(part, "key="):
+			opts.key = strings.TrimPrefix(part, "key=")
+		}
+	}
+	return opts
+}
+
+// diffPath is where in the document the differ is.
+type diffPath struct {
+	field     string // dotted path reported as Change.Field
+	name      string // last field or map key, for personal data masking
+	sensitive bool   // the value, or a parent, must be redacted
+	personal  bool   // the value, or a parent, must be masked
+}
+
+func (p diffPath) child(name string, opts fieldOptions) diffPath {
+	field := name
+	if p.field != "" {
+		field = p.field + "." + name
+	}
+	return diffPath{
+		field:     field,
+		name:      name,
+		sensitive: p.sensitive || opts.redact || isSensitiveField(name),
+		personal:  p.personal || isPersonalField(name),
+	}
+}
+
+func (p diffPath) index(label string) diffPath {
+	p.field += "[" + label + "]"
+	return p
+}
+
+type differ struct {
+	changes []Change
+}
+
+func (d *differ) diff(p diffPath, before, after reflect.Value, opts fieldOptions) {
+	before, after = indirect(before), indirect(after)
+	switch {
+	case !before.IsValid() && !after.IsValid():
+		return
+	case !before.IsValid():
+		d.changes = append(d.changes, Change{Field: p.field, Op: ChangeAdded, NewValue: snapshot(p, after)})
+		return
+	case !after.IsValid():
+		d.changes = append(d.changes, Change{Field: p.field, Op: ChangeRemoved, OldValue: snapshot(p, before)})
+		return
+	}
+
+	if isLeaf(before) || isLeaf(after) || before.Kind() != after.Kind() {
+		d.leaf(p, before, after)
+		return
+	}
+	switch before.Kind() {
+	case reflect.Struct:
+		if before.Type() != after.Type() {
+			d.leaf(p, before, after)
+			return
+		}
+		d.diffStruct(p, before, after)
+	case reflect.Map:
+		d.diffMap(p, before, after)
+	case reflect.Slice, reflect.Array:
+		if opts.key != "" && d.diffKeyed(p, before, after, opts.key) {
+			return
+		}
+		d.diffPositional(p, before, after)
+	}
+}
+
+func (d *differ) leaf(p diffPath, before, after reflect.Value) {
+	beforeStr := formatValue(before)
+	afterStr := formatValue(after)
+	if beforeStr != afterStr {
+		d.changes = append(d.changes, Change{
+			Field:    p.field,
+			OldValue: protect(p, beforeStr),
+			NewValue: protect(p, afterStr),
+		})
+	}
+}
 
+func (d *differ) diffStruct(p diffPath, before, after reflect.Value) {
+	t := before.Type()
 	for i := 0; i < t.NumField(); i++ {
 		field := t.Field(i)
 
@@ -49,43 +171,288 @@ func DiffChanges(before, after any) []Change {
 			continue
 		}
 
-		// Skip fields with `audit:"-"` tag
-		if tag := field.Tag.Get("audit"); tag == "-" {
+		opts := parseFieldOptions(field.Tag.Get("audit"))
+		if opts.skip {
+			continue
+		}
+
+		fieldName, named := jsonFieldName(field)
+		if field.Anonymous && !named && indirectType(field.Type).Kind() == reflect.Struct {
+			// Embedded struct: its fields are promoted, as in its JSON
+			d.diff(p, before.Field(i), after.Field(i), opts)
+			continue
+		}
+		d.diff(p.child(fieldName, opts), before.Field(i), after.Field(i), opts)
+	}
+}
+
+func (d *differ) diffMap(p diffPath, before, after reflect.Value) {
+	if before.Type().Key().Kind() != reflect.String || after.Type().Key().Kind() != reflect.String {
+		d.leaf(p, before, after)
+		return
+	}
+	for _, key := range mapKeys(before, after) {
+		d.diff(p.child(key, fieldOptions{}), mapIndex(before, key), mapIndex(after, key), fieldOptions{})
+	}
+}
+
+// diffKeyed matches elements by their key field. A sensitive or personal key
+// is protected in Change.Field as its values would be. It returns false,
+// leaving the slices to diffPositional, when an element has no key, a key
+// repeats or two keys look the same once protected.
+func (d *differ) diffKeyed(p diffPath, before, after reflect.Value, key string) bool {
+	beforeKeys, ok := elementKeys(before, key)
+	if !ok {
+		return false
+	}
+	afterKeys, ok := elementKeys(after, key)
+	if !ok {
+		return false
+	}
+	keyPath := p.child(key, fieldOptions{})
+	labels := make(map[string]diffPath, len(beforeKeys)+len(afterKeys))
+	used := make(map[string]bool, len(beforeKeys)+len(afterKeys))
+	for _, keys := range [][]string{beforeKeys, afterKeys} {
+		for _, k := range keys {
+			if _, ok := labels[k]; ok {
+				continue
+			}
+			label := key + "=" + fmt.Sprint(protect(keyPath, k))
+			if used[label] {
+				return false
+			}
+			used[label] = true
+			labels[k] = p.index(label)
+		}
+	}
+	afterIndex := make(map[string]int, len(afterKeys))
+	for i, k := range afterKeys {
+		afterIndex[k] = i
+	}
+	beforeIndex := make(map[string]int, len(beforeKeys))
+	for i, k := range beforeKeys {
+		beforeIndex[k] = i
+		if j, ok := afterIndex[k]; ok {
+			d.diff(labels[k], before.Index(i), after.Index(j), fieldOptions{})
+		} else {
+			d.diff(labels[k], before.Index(i), reflect.Value{}, fieldOptions{})
+		}
+	}
+	for j, k := range afterKeys {
+		if _, ok := beforeIndex[k]; !ok {
+			d.diff(labels[k], reflect.Value{}, after.Index(j), fieldOptions{})
+		}
+	}
+	return true
+}
+
+func (d *differ) diffPositional(p diffPath, before, after reflect.Value) {
+	for i := 0; i < max(before.Len(), after.Len()); i++ {
+		var b, a reflect.Value
+		if i < before.Len() {
+			b = before.Index(i)
+		}
+		if i < after.Len() {
+			a = after.Index(i)
+		}
+		d.diff(p.index(fmt.Sprint(i)), b, a, fieldOptions{})
+	}
+}
+
+// elementKeys returns the key of every element of a slice of structs or
+// maps, and false when one has none or two share one.
+func elementKeys(v reflect.Value, key string) ([]string, bool) {
+	keys := make([]string, v.Len())
+	seen := make(map[string]bool, v.Len())
+	for i := range keys {
+		elem := indirect(v.Index(i))
+		var k reflect.Value
+		switch {
+		case !elem.IsValid():
+		case elem.Kind() == reflect.Struct:
+			k = structField(elem, key)
+		case elem.Kind() == reflect.Map && elem.Type().Key().Kind() == reflect.String:
+			k = mapIndex(elem, key)
+		}
+		if k = indirect(k); !k.IsValid() {
+			return nil, false
+		}
+		keys[i] = formatValue(k)
+		if seen[keys[i]] {
+			return nil, false
+		}
+		seen[keys[i]] = true
+	}
+	return keys, true
+}
+
+// structField finds a field by JSON name, else Go name.
+func structField(v reflect.Value, name string) reflect.Value {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if fieldName, _ := jsonFieldName(t.Field(i)); fieldName == name && t.Field(i).IsExported() {
+			return v.Field(i)
+		}
+	}
+	if f, ok := t.FieldByName(name); ok && f.IsExported() {
+		return v.FieldByIndex(f.Index)
+	}
+	return reflect.Value{}
+}
+
+// snapshot renders a value added or removed as a whole: structs and maps
+// become map[string]any, slices []any, leaves strings, with sensitive and
+// personal values protected as in changes.
+func snapshot(p diffPath, v reflect.Value) any {
+	v = indirect(v)
+	if !v.IsValid() {
+		return nil
+	}
+	if isLeaf(v) {
+		return protect(p, formatValue(v))
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		out := map[string]any{}
+		snapshotStruct(p, v, out)
+		return out
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return protect(p, formatValue(v))
+		}
+		out := make(map[string]any, v.Len())
+		for _, key := range mapKeys(v) {
+			out[key] = snapshot(p.child(key, fieldOptions{}), mapIndex(v, key))
+		}
+		return out
+	default: // slice, array
+		out := make([]any, v.Len())
+		for i := range out {
+			out[i] = snapshot(p, v.Index(i))
+		}
+		return out
+	}
+}
+
+func snapshotStruct(p diffPath, v reflect.Value, out map[string]any) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		opts := parseFieldOptions(field.Tag.Get("audit"))
+		if !field.IsExported() || opts.skip {
 			continue
 		}
+		fieldName, named := jsonFieldName(field)
+		if field.Anonymous && !named {
+			if embedded := indirect(v.Field(i)); embedded.IsValid() && embedded.Kind() == reflect.Struct && !isLeaf(embedded) {
+				snapshotStruct(p, embedded, out)
+				continue
+			}
+		}
+		out[fieldName] = snapshot(p.child(fieldName, opts), v.Field(i))
+	}
+}
+
+// jsonFieldName names a struct field by its json tag, else its Go name;
+// named reports whether the tag gave the name.
+func jsonFieldName(field reflect.StructField) (name string, named bool) {
+	if jsonTag := field.Tag.Get("json"); jsonTag != "" && jsonTag != "-" {
+		// Parse json tag (take first part before comma)
+		if jsonTag, _, _ = strings.Cut(jsonTag, ","); jsonTag != "" {
+			return jsonTag, true
+		}
+	}
+	return field.Name, false
+}
 
-		beforeField := beforeVal.Field(i)
-		afterField := afterVal.Field(i)
-
-		// Compare values
-		beforeStr := formatValue(beforeField)
-		afterStr := formatValue(afterField)
-
-		if beforeStr != afterStr {
-			// Use json tag for field name if available
-			fieldName := field.Name
-			if jsonTag := field.Tag.Get("json"); jsonTag != "" && jsonTag != "-" {
-				// Parse json tag (take first part before comma)
-				for j := 0; j < len(jsonTag); j++ {
-					if jsonTag[j] == ',' {
-						jsonTag = jsonTag[:j]
-						break
-					}
-				}
-				if jsonTag != "" {
-					fieldName = jsonTag
-				}
+func mapKeys(maps ...reflect.Value) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, m := range maps {
+		for _, k := range m.MapKeys() {
+			if key := k.String(); !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
 			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func mapIndex(m reflect.Value, key string) reflect.Value {
+	return m.MapIndex(reflect.ValueOf(key).Convert(m.Type().Key()))
+}
+
+// diffable turns the document forms DiffChanges accepts into values it can
+// walk: JSON is decoded, bson.D made a map.
+func diffable(v any) reflect.Value {
+	switch doc := v.(type) {
+	case json.RawMessage:
+		return decodeJSON(doc)
+	case []byte:
+		return decodeJSON(doc)
+	}
+	return reflect.ValueOf(v)
+}
+
+func decodeJSON(raw []byte) reflect.Value {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return reflect.Value{}
+	}
+	return reflect.ValueOf(doc)
+}
 
-			changes = append(changes, Change{
-				Field:    fieldName,
-				OldValue: beforeStr,
-				NewValue: afterStr,
-			})
+var (
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	bsonDType         = reflect.TypeOf(primitive.D{})
+)
+
+// indirect dereferences pointers and interfaces, returning the zero Value
+// for nil, and turns a bson.D into a map.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
 		}
+		v = v.Elem()
 	}
+	if v.IsValid() && v.Type() == bsonDType {
+		m := make(map[string]any, v.Len())
+		for _, e := range v.Interface().(primitive.D) {
+			m[e.Key] = e.Value
+		}
+		return reflect.ValueOf(m)
+	}
+	return v
+}
 
-	return changes
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// isLeaf reports whether v is compared as a whole: scalars, byte slices,
+// and types with their own text form (time.Time, ObjectID, Decimal128).
+func isLeaf(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		return t.Implements(textMarshalerType) || t.Implements(jsonMarshalerType) ||
+			reflect.PointerTo(t).Implements(textMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType)
+	case reflect.Map:
+		return false
+	case reflect.Slice, reflect.Array:
+		return v.Type().Elem().Kind() == reflect.Uint8
+	default:
+		return true
+	}
 }
 
 // formatValue converts a reflect.Value to its string representation
@@ -0,0 +1,97 @@
+package audit
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/praction-networks/common/logger"
+)
+
+// RedactedValue replaces the values of sensitive fields in Changes.
+const RedactedValue = "[REDACTED]"
+
+// The logger's key lists, so a field hidden from logs is hidden from the
+// audit trail too.
+var (
+	sensitiveFieldKeys = logger.SensitiveKeys()
+	personalFieldKeys  = logger.PersonalKeys()
+)
+
+// isSensitiveField reports whether a field's values must be redacted. Unlike
+// the logger's substring match, entries are matched against the name's
+// words ("wifiPassword" → wifi, password), so short entries like "iv" or
+// "pan" don't catch "isActive" or "panel"; an entry of four letters or more
+// also matches as a word prefix ("pass" → "password").
+func isSensitiveField(name string) bool {
+	words := fieldWords(name)
+	joined := strings.Join(words, "")
+	for _, key := range sensitiveFieldKeys {
+		if joined == key {
+			return true
+		}
+		for _, w := range words {
+			if w == key || (len(key) >= 4 && strings.HasPrefix(w, key)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isPersonalField reports whether a field holds PII to mask: its whole name
+// is a personal key, or ends in one of five letters or more
+// ("primaryMobile", "billingAddress" but not "planName").
+func isPersonalField(name string) bool {
+	joined := strings.Join(fieldWords(name), "")
+	for _, key := range personalFieldKeys {
+		key = strings.ReplaceAll(key, "_", "")
+		if joined == key || (len(key) >= 5 && strings.HasSuffix(joined, key)) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldWords splits a field name into lower-case words at case changes,
+// digits and separators: "peerScope_v2" → peer, scope, v2; "userID" → user, id.
+func fieldWords(name string) []string {
+	var words []string
+	var word []rune
+	runes := []rune(name)
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	for i, r := range runes {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+			continue
+		case unicode.IsUpper(r) && i > 0:
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				flush()
+			}
+		}
+		word = append(word, r)
+	}
+	flush()
+	return words
+}
+
+// protect redacts or masks a changed value according to where it is.
+func protect(p diffPath, value string) any {
+	if value == "" {
+		return ""
+	}
+	if p.sensitive {
+		return RedactedValue
+	}
+	if p.personal {
+		return logger.MaskPII(p.name, value)
+	}
+	return value
+}
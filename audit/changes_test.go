@@ -0,0 +1,197 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/praction-networks/common/logger"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type peer struct {
+	ID    string `json:"id"`
+	Scope string `json:"scope"`
+	Token string `json:"token"`
+}
+
+type assets struct {
+	PeerScope string `json:"peerScope"`
+	Peers     []peer `json:"peers" audit:"key=id"`
+	Ports     []int  `json:"ports"`
+}
+
+type policy struct {
+	Name      string            `json:"name"`
+	Mobile    string            `json:"mobile"`
+	Password  string            `json:"password"`
+	IsActive  bool              `json:"isActive"`
+	Internal  string            `json:"internal" audit:"-"`
+	Note      string            `json:"note" audit:"redact"`
+	Assets    assets            `json:"assets"`
+	Labels    map[string]string `json:"labels"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+func changesByField(changes []Change) map[string]Change {
+	out := make(map[string]Change, len(changes))
+	for _, c := range changes {
+		out[c.Field] = c
+	}
+	return out
+}
+
+func TestDiffChangesNested(t *testing.T) {
+	cfg := logger.GetComplianceConfig()
+	enabled := cfg.Enabled
+	cfg.Enabled = true
+	defer func() { cfg.Enabled = enabled }()
+
+	ts := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := policy{
+		Name: "Gold", Mobile: "0501234567", Password: "a", IsActive: true, Internal: "x", Note: "old",
+		Assets: assets{
+			PeerScope: "tenant",
+			Peers:     []peer{{ID: "p1", Scope: "read", Token: "t1"}, {ID: "p2", Scope: "read"}},
+			Ports:     []int{80, 443},
+		},
+		Labels:    map[string]string{"env": "prod", "tier": "1"},
+		UpdatedAt: ts,
+	}
+	after := before
+	after.Name, after.Mobile, after.Password, after.IsActive, after.Internal, after.Note = "Platinum", "0509876543", "b", false, "y", "new"
+	after.Assets = assets{
+		PeerScope: "global",
+		Peers:     []peer{{ID: "p2", Scope: "write"}, {ID: "p1", Scope: "read", Token: "t2"}, {ID: "p3", Scope: "read", Token: "t3"}},
+		Ports:     []int{80},
+	}
+	after.Labels = map[string]string{"env": "prod", "zone": "a"}
+	after.UpdatedAt = ts.Add(time.Hour)
+
+	got := changesByField(DiffChanges(&before, &after))
+	want := map[string]Change{
+		"name":                      {Field: "name", OldValue: "G**d", NewValue: "P******m"},
+		"mobile":                    {Field: "mobile", OldValue: "05******67", NewValue: "05******43"},
+		"password":                  {Field: "password", OldValue: RedactedValue, NewValue: RedactedValue},
+		"isActive":                  {Field: "isActive", OldValue: "true", NewValue: "false"},
+		"note":                      {Field: "note", OldValue: RedactedValue, NewValue: RedactedValue},
+		"assets.peerScope":          {Field: "assets.peerScope", OldValue: "tenant", NewValue: "global"},
+		"assets.peers[id=p1].token": {Field: "assets.peers[id=p1].token", OldValue: RedactedValue, NewValue: RedactedValue},
+		"assets.peers[id=p2].scope": {Field: "assets.peers[id=p2].scope", OldValue: "read", NewValue: "write"},
+		"assets.peers[id=p3]": {Field: "assets.peers[id=p3]", Op: ChangeAdded,
+			NewValue: map[string]any{"id": "p3", "scope": "read", "token": RedactedValue}},
+		"assets.ports[1]": {Field: "assets.ports[1]", Op: ChangeRemoved, OldValue: "443"},
+		"labels.tier":     {Field: "labels.tier", Op: ChangeRemoved, OldValue: "1"},
+		"labels.zone":     {Field: "labels.zone", Op: ChangeAdded, NewValue: "a"},
+		"updatedAt":       {Field: "updatedAt", OldValue: ts.String(), NewValue: ts.Add(time.Hour).String()},
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d changes, want %d: %+v", len(got), len(want), got)
+	}
+	for field, w := range want {
+		if g, ok := got[field]; !ok || !reflect.DeepEqual(g, w) {
+			t.Errorf("%s: got %+v, want %+v", field, g, w)
+		}
+	}
+}
+
+func TestDiffChangesDocuments(t *testing.T) {
+	before := bson.M{"plan": "gold", "limits": bson.M{"down": 100, "up": 20}, "apiKey": "k1"}
+	after := bson.D{{Key: "plan", Value: "gold"}, {Key: "limits", Value: bson.M{"down": 200, "up": 20}}, {Key: "apiKey", Value: "k2"}}
+	got := DiffChanges(before, after)
+	want := []Change{
+		{Field: "apiKey", OldValue: RedactedValue, NewValue: RedactedValue},
+		{Field: "limits.down", OldValue: "100", NewValue: "200"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("bson: got %+v, want %+v", got, want)
+	}
+
+	got = DiffChanges([]byte(`{"a":{"b":[1,2]},"c":1.50}`), []byte(`{"a":{"b":[1,3]},"c":1.5}`))
+	want = []Change{
+		{Field: "a.b[1]", OldValue: "2", NewValue: "3"},
+		{Field: "c", OldValue: "1.50", NewValue: "1.5"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("json: got %+v, want %+v", got, want)
+	}
+
+	if got := DiffChanges(policy{}, peer{}); got != nil {
+		t.Fatalf("different types: %+v", got)
+	}
+}
+
+func TestDiffChangesProtectsKeys(t *testing.T) {
+	cfg := logger.GetComplianceConfig()
+	enabled := cfg.Enabled
+	cfg.Enabled = true
+	defer func() { cfg.Enabled = enabled }()
+
+	type contact struct {
+		Email string `json:"email"`
+		Scope string `json:"scope"`
+	}
+	type credential struct {
+		Token string `json:"token"`
+		Scope string `json:"scope"`
+	}
+	type address struct {
+		Line1 string `json:"line1"`
+	}
+	type account struct {
+		Contacts    []contact         `json:"contacts" audit:"key=email"`
+		Credentials []credential      `json:"credentials" audit:"key=token"`
+		Address     address           `json:"address"`
+		Location    map[string]string `json:"location"`
+	}
+	before := account{
+		Contacts:    []contact{{Email: "alice@example.com", Scope: "billing"}},
+		Credentials: []credential{{Token: "t1", Scope: "read"}, {Token: "t2", Scope: "read"}},
+		Address:     address{Line1: "12 Park Street"},
+		Location:    map[string]string{"lat": "22.5726"},
+	}
+	after := account{
+		Contacts:    []contact{{Email: "alice@example.com", Scope: "all"}},
+		Credentials: []credential{{Token: "t1", Scope: "read"}, {Token: "t2", Scope: "write"}},
+		Address:     address{Line1: "7 Lake Road"},
+		Location:    map[string]string{"lat": "22.5958"},
+	}
+
+	masked := logger.MaskPII("email", "alice@example.com")
+	if masked == "alice@example.com" {
+		t.Fatal("email not masked by the compliance config")
+	}
+	// Redacted tokens can't tell the credentials apart: matched by position.
+	// Every leaf under a personal field is masked, whatever its own name.
+	want := []Change{
+		{Field: "contacts[email=" + masked + "].scope", OldValue: "billing", NewValue: "all"},
+		{Field: "credentials[1].scope", OldValue: "read", NewValue: "write"},
+		{Field: "address.line1", OldValue: logger.MaskPII("line1", "12 Park Street"), NewValue: logger.MaskPII("line1", "7 Lake Road")},
+		{Field: "location.lat", OldValue: logger.MaskPII("lat", "22.5726"), NewValue: logger.MaskPII("lat", "22.5958")},
+	}
+	if want[2].OldValue == "12 Park Street" {
+		t.Fatal("address not masked by the compliance config")
+	}
+	if got := DiffChanges(before, after); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestFieldClassification(t *testing.T) {
+	for name, want := range map[string]bool{
+		"password": true, "wifiPassword": true, "api_key": true, "refreshToken": true,
+		"isActive": false, "panel": false, "status": false, "description": false,
+	} {
+		if got := isSensitiveField(name); got != want {
+			t.Errorf("isSensitiveField(%q) = %v", name, got)
+		}
+	}
+	for name, want := range map[string]bool{
+		"mobile": true, "primaryMobile": true, "email": true, "name": true,
+		"planName": false, "status": false,
+	} {
+		if got := isPersonalField(name); got != want {
+			t.Errorf("isPersonalField(%q) = %v", name, got)
+		}
+	}
+}
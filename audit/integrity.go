@@ -0,0 +1,296 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Integrity links an AuditEvent into its tenant's tamper-evident chain.
+// Events of one chain carry consecutive sequence numbers, each names the
+// hash of its predecessor, and the hash of each is signed, so an auditor
+// with the verification key can prove that no event of the trail was
+// edited, removed, inserted or reordered (see ChainVerifier).
+type Integrity struct {
+	// Chain is the chain the event belongs to: its tenant ID, or
+	// SystemChain for events without a tenant.
+	Chain string `json:"chain"`
+
+	// Seq numbers the events of the chain from 1 without gaps.
+	Seq uint64 `json:"seq"`
+
+	// PrevHash is the Hash of event Seq-1; empty for the first event.
+	PrevHash string `json:"prevHash,omitempty"`
+
+	// Hash is the hex SHA-256 of the event's canonical JSON (see
+	// HashEvent), which covers every field except Hash and Signature.
+	Hash string `json:"hash"`
+
+	// Alg and KeyID identify the key that produced Signature.
+	Alg   string `json:"alg"`
+	KeyID string `json:"keyId,omitempty"`
+
+	// Signature is the base64 signature of the raw Hash bytes.
+	Signature string `json:"signature"`
+}
+
+// SystemChain is the chain of events published without a tenant.
+const SystemChain = "system"
+
+// Signature algorithms.
+const (
+	AlgHMACSHA256 = "hmac-sha256"
+	AlgEd25519    = "ed25519"
+)
+
+// Signer signs event hashes for a Publisher.
+type Signer interface {
+	Alg() string
+	KeyID() string
+	Sign(digest []byte) ([]byte, error)
+}
+
+// SignatureVerifier checks event signatures for a ChainVerifier.
+type SignatureVerifier interface {
+	VerifySignature(alg, keyID string, digest, sig []byte) error
+}
+
+// ErrBadSignature is returned by a SignatureVerifier for a signature that
+// doesn't match.
+var ErrBadSignature = errors.New("audit: signature mismatch")
+
+type hmacSigner struct {
+	keyID string
+	key   []byte
+}
+
+// NewHMACSigner signs with HMAC-SHA256. Anyone who can verify can also
+// sign, so prefer Ed25519 when the auditor is not trusted with the key.
+func NewHMACSigner(keyID string, key []byte) Signer {
+	return &hmacSigner{keyID: keyID, key: key}
+}
+
+func (s *hmacSigner) Alg() string   { return AlgHMACSHA256 }
+func (s *hmacSigner) KeyID() string { return s.keyID }
+func (s *hmacSigner) Sign(digest []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(digest)
+	return mac.Sum(nil), nil
+}
+
+type ed25519Signer struct {
+	keyID string
+	key   ed25519.PrivateKey
+}
+
+// NewEd25519Signer signs with an Ed25519 private key; auditors verify with
+// the public key only.
+func NewEd25519Signer(keyID string, key ed25519.PrivateKey) Signer {
+	return &ed25519Signer{keyID: keyID, key: key}
+}
+
+func (s *ed25519Signer) Alg() string   { return AlgEd25519 }
+func (s *ed25519Signer) KeyID() string { return s.keyID }
+func (s *ed25519Signer) Sign(digest []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, digest), nil
+}
+
+// VerificationKeys holds the keys a ChainVerifier accepts, by key ID.
+type VerificationKeys struct {
+	HMAC    map[string][]byte
+	Ed25519 map[string]ed25519.PublicKey
+}
+
+// VerifySignature implements SignatureVerifier.
+func (k VerificationKeys) VerifySignature(alg, keyID string, digest, sig []byte) error {
+	switch alg {
+	case AlgHMACSHA256:
+		key, ok := k.HMAC[keyID]
+		if !ok {
+			return fmt.Errorf("audit: unknown %s key %q", alg, keyID)
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write(digest)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrBadSignature
+		}
+		return nil
+	case AlgEd25519:
+		key, ok := k.Ed25519[keyID]
+		if !ok {
+			return fmt.Errorf("audit: unknown %s key %q", alg, keyID)
+		}
+		if !ed25519.Verify(key, digest, sig) {
+			return ErrBadSignature
+		}
+		return nil
+	default:
+		return fmt.Errorf("audit: unsupported signature algorithm %q", alg)
+	}
+}
+
+// HashEvent returns the hex SHA-256 of a serialized AuditEvent's canonical
+// form: the JSON re-encoded with sorted object keys and numbers kept
+// verbatim, with integrity.hash and integrity.signature removed. It works on
+// the raw JSON so fields a newer publisher added are covered too.
+func HashEvent(raw []byte) (string, error) {
+	canonical, err := canonicalEvent(raw)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func canonicalEvent(raw []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("audit: decode event: %w", err)
+	}
+	if integrity, ok := doc["integrity"].(map[string]any); ok {
+		delete(integrity, "hash")
+		delete(integrity, "signature")
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ChainHead is the last event of a chain.
+type ChainHead struct {
+	Seq  uint64
+	Hash string
+}
+
+// ChainStore keeps the head of every chain, shared by all publishers of the
+// trail so sequence numbers stay unique across instances.
+type ChainStore interface {
+	// Head returns the chain's head; the zero ChainHead for a new chain.
+	Head(ctx context.Context, chain string) (ChainHead, error)
+
+	// Advance moves the head from prev to next, failing with
+	// ErrChainConflict when the head is no longer prev.
+	Advance(ctx context.Context, chain string, prev, next ChainHead) error
+}
+
+// ErrChainConflict is returned by ChainStore.Advance when another publisher
+// moved the head first.
+var ErrChainConflict = errors.New("audit: chain head moved")
+
+// MemoryChainStore is a ChainStore for a single process and for tests.
+type MemoryChainStore struct {
+	mu    sync.Mutex
+	heads map[string]ChainHead
+}
+
+// NewMemoryChainStore creates an empty MemoryChainStore.
+func NewMemoryChainStore() *MemoryChainStore {
+	return &MemoryChainStore{heads: map[string]ChainHead{}}
+}
+
+// Head implements ChainStore.
+func (s *MemoryChainStore) Head(_ context.Context, chain string) (ChainHead, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.heads[chain], nil
+}
+
+// Advance implements ChainStore.
+func (s *MemoryChainStore) Advance(_ context.Context, chain string, prev, next ChainHead) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.heads[chain] != prev {
+		return ErrChainConflict
+	}
+	s.heads[chain] = next
+	return nil
+}
+
+func chainOf(event *AuditEvent) string {
+	if event.TenantID == "" {
+		return SystemChain
+	}
+	return event.TenantID
+}
+
+// maxChainConflicts bounds the retries when other instances keep moving
+// a chain's head.
+const maxChainConflicts = 10
+
+// chainer seals events into their chains for a Publisher.
+type chainer struct {
+	store  ChainStore
+	signer Signer
+	locks  sync.Map // chain → *sync.Mutex
+}
+
+func (c *chainer) lock(chain string) func() {
+	mu, _ := c.locks.LoadOrStore(chain, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// seal links event to the head of its chain, signs it and reserves its
+// sequence number, returning the serialized event. A reserved sequence
+// number is never given back: a failed publish may still have been stored,
+// and another instance may already have chained onto it. The caller
+// re-publishes the same bytes under the event ID, or spools them.
+func (c *chainer) seal(ctx context.Context, event *AuditEvent) (data []byte, err error) {
+	chain := chainOf(event)
+	for range maxChainConflicts {
+		head, err := c.store.Head(ctx, chain)
+		if err != nil {
+			return nil, fmt.Errorf("audit: read chain head: %w", err)
+		}
+		event.Integrity = &Integrity{
+			Chain:    chain,
+			Seq:      head.Seq + 1,
+			PrevHash: head.Hash,
+			Alg:      c.signer.Alg(),
+			KeyID:    c.signer.KeyID(),
+		}
+		unsigned, err := json.Marshal(event)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal audit event: %w", err)
+		}
+		hash, err := HashEvent(unsigned)
+		if err != nil {
+			return nil, err
+		}
+		digest, _ := hex.DecodeString(hash)
+		sig, err := c.signer.Sign(digest)
+		if err != nil {
+			return nil, fmt.Errorf("audit: sign event: %w", err)
+		}
+		event.Integrity.Hash = hash
+		event.Integrity.Signature = base64.StdEncoding.EncodeToString(sig)
+		if data, err = json.Marshal(event); err != nil {
+			return nil, fmt.Errorf("failed to marshal audit event: %w", err)
+		}
+
+		next := ChainHead{Seq: head.Seq + 1, Hash: hash}
+		err = c.store.Advance(ctx, chain, head, next)
+		if errors.Is(err, ErrChainConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("audit: advance chain head: %w", err)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("audit: chain %s: %w after %d attempts", chain, ErrChainConflict, maxChainConflicts)
+}
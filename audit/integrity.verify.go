@@ -0,0 +1,226 @@
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// ChainIssueKind classifies what a ChainVerifier found wrong with a chain.
+type ChainIssueKind string
+
+const (
+	IssueUnsealed     ChainIssueKind = "unsealed"      // event carries no Integrity
+	IssueModified     ChainIssueKind = "modified"      // content doesn't match its hash
+	IssueBadSignature ChainIssueKind = "bad_signature" // hash not signed by a trusted key
+	IssueGap          ChainIssueKind = "gap"           // sequence numbers missing
+	IssueDuplicate    ChainIssueKind = "duplicate"     // same event stored twice
+	IssueFork         ChainIssueKind = "fork"          // two different events with one sequence number
+	IssueBrokenLink   ChainIssueKind = "broken_link"   // prevHash doesn't name the previous event
+)
+
+// ChainIssue is one finding of a ChainVerifier.
+type ChainIssue struct {
+	Kind    ChainIssueKind `json:"kind"`
+	Chain   string         `json:"chain"`
+	Seq     uint64         `json:"seq"`
+	EventID string         `json:"eventId,omitempty"`
+	Detail  string         `json:"detail,omitempty"`
+}
+
+func (i ChainIssue) String() string {
+	return fmt.Sprintf("%s: chain %s seq %d (event %s): %s", i.Kind, i.Chain, i.Seq, i.EventID, i.Detail)
+}
+
+// ChainReport sums up one verified chain.
+type ChainReport struct {
+	Chain    string       `json:"chain"`
+	Events   int          `json:"events"`
+	FirstSeq uint64       `json:"firstSeq"`
+	LastSeq  uint64       `json:"lastSeq"`
+	Issues   []ChainIssue `json:"issues,omitempty"`
+}
+
+// OK reports whether the chain verified clean.
+func (r ChainReport) OK() bool { return len(r.Issues) == 0 }
+
+// ChainVerifier checks stored audit events, in any order, and reports per
+// chain whether they still form the unbroken sequence the publisher sealed.
+// Events are linked by seq, not by where they were stored: a spooled event
+// replayed after later events of its chain verifies like any other. Feed it
+// every event of the period under audit with Add, then read Reports. A
+// chain is checked from its first stored event on, so a trail trimmed by
+// retention still verifies. Events lost after the last stored one leave no
+// trace in the events themselves; pass the chain heads with ExpectHead or
+// ExpectHeads to catch a truncated tail.
+type ChainVerifier struct {
+	keys      SignatureVerifier
+	chains    map[string]*chainWalk
+	heads     map[string]ChainHead
+	unsealed  []ChainIssue
+	malformed []ChainIssue
+}
+
+type chainLink struct {
+	eventID  string
+	hash     string // as stored
+	prevHash string
+}
+
+type chainWalk struct {
+	events int
+	links  map[uint64]chainLink
+	issues []ChainIssue
+}
+
+// NewChainVerifier verifies signatures with keys.
+func NewChainVerifier(keys SignatureVerifier) *ChainVerifier {
+	return &ChainVerifier{keys: keys, chains: map[string]*chainWalk{}, heads: map[string]ChainHead{}}
+}
+
+// ExpectHead tells Reports where chain ends: its ChainStore head, read once
+// every event of the period has been stored. Missing events up to the head
+// are reported as a gap, and a last event that isn't the head as a fork.
+func (v *ChainVerifier) ExpectHead(chain string, head ChainHead) {
+	v.heads[chain] = head
+}
+
+// ExpectHeads reads the head of every chain added so far from store; see
+// ExpectHead. Call it after the last Add.
+func (v *ChainVerifier) ExpectHeads(ctx context.Context, store ChainStore) error {
+	for chain := range v.chains {
+		head, err := store.Head(ctx, chain)
+		if err != nil {
+			return fmt.Errorf("audit: read head of chain %s: %w", chain, err)
+		}
+		v.ExpectHead(chain, head)
+	}
+	return nil
+}
+
+// Add checks a stored event.
+func (v *ChainVerifier) Add(raw []byte) {
+	var event AuditEvent
+	if err := json.Unmarshal(raw, &event); err != nil {
+		v.malformed = append(v.malformed, ChainIssue{Kind: IssueModified, Detail: "undecodable event: " + err.Error()})
+		return
+	}
+	in := event.Integrity
+	if in == nil {
+		v.unsealed = append(v.unsealed, ChainIssue{Kind: IssueUnsealed, Chain: chainOf(&event), EventID: event.ID})
+		return
+	}
+
+	w := v.chains[in.Chain]
+	if w == nil {
+		w = &chainWalk{links: map[uint64]chainLink{}}
+		v.chains[in.Chain] = w
+	}
+	w.events++
+	issue := func(kind ChainIssueKind, detail string) {
+		w.issues = append(w.issues, ChainIssue{Kind: kind, Chain: in.Chain, Seq: in.Seq, EventID: event.ID, Detail: detail})
+	}
+
+	if hash, err := HashEvent(raw); err != nil || hash != in.Hash {
+		issue(IssueModified, "content does not match its hash")
+	} else if err := v.verifySignature(in); err != nil {
+		issue(IssueBadSignature, err.Error())
+	}
+
+	if prev, seen := w.links[in.Seq]; seen {
+		if prev.hash == in.Hash && prev.eventID == event.ID {
+			issue(IssueDuplicate, "stored more than once")
+		} else {
+			issue(IssueFork, "sequence number also used by event "+prev.eventID)
+		}
+		return
+	}
+	w.links[in.Seq] = chainLink{eventID: event.ID, hash: in.Hash, prevHash: in.PrevHash}
+}
+
+func (v *ChainVerifier) verifySignature(in *Integrity) error {
+	if v.keys == nil {
+		return nil
+	}
+	digest, err := hex.DecodeString(in.Hash)
+	if err != nil {
+		return fmt.Errorf("malformed hash: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(in.Signature)
+	if err != nil {
+		return fmt.Errorf("malformed signature: %w", err)
+	}
+	return v.keys.VerifySignature(in.Alg, in.KeyID, digest, sig)
+}
+
+// Reports returns one report per chain, sorted by chain, after checking
+// for gaps and broken links, and against the expected heads. Events that
+// could not be attributed to a chain (undecodable or unsealed) are reported
+// under the chain "".
+func (v *ChainVerifier) Reports() []ChainReport {
+	var reports []ChainReport
+	if len(v.malformed)+len(v.unsealed) > 0 {
+		issues := append(append([]ChainIssue(nil), v.malformed...), v.unsealed...)
+		reports = append(reports, ChainReport{Events: len(issues), Issues: issues})
+	}
+	for chain, head := range v.heads {
+		if _, stored := v.chains[chain]; !stored && head.Seq > 0 {
+			reports = append(reports, ChainReport{Chain: chain, Issues: []ChainIssue{{Kind: IssueGap, Chain: chain, Seq: 1,
+				Detail: fmt.Sprintf("seq 1-%d missing, head is at %d", head.Seq, head.Seq)}}})
+		}
+	}
+	for chain, w := range v.chains {
+		seqs := make([]uint64, 0, len(w.links))
+		for seq := range w.links {
+			seqs = append(seqs, seq)
+		}
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+		r := ChainReport{Chain: chain, Events: w.events, FirstSeq: seqs[0], LastSeq: seqs[len(seqs)-1]}
+		r.Issues = append(r.Issues, w.issues...)
+		if first := w.links[seqs[0]]; seqs[0] == 1 && first.prevHash != "" {
+			r.Issues = append(r.Issues, ChainIssue{Kind: IssueBrokenLink, Chain: chain, Seq: 1, EventID: first.eventID,
+				Detail: "first event names a predecessor"})
+		}
+		for i := 1; i < len(seqs); i++ {
+			prevSeq, seq := seqs[i-1], seqs[i]
+			link := w.links[seq]
+			if seq != prevSeq+1 {
+				r.Issues = append(r.Issues, ChainIssue{Kind: IssueGap, Chain: chain, Seq: prevSeq + 1,
+					Detail: fmt.Sprintf("seq %d-%d missing", prevSeq+1, seq-1)})
+				continue
+			}
+			if link.prevHash != w.links[prevSeq].hash {
+				r.Issues = append(r.Issues, ChainIssue{Kind: IssueBrokenLink, Chain: chain, Seq: seq, EventID: link.eventID,
+					Detail: fmt.Sprintf("prevHash does not match seq %d", prevSeq)})
+			}
+		}
+		if head, ok := v.heads[chain]; ok {
+			last := w.links[r.LastSeq]
+			switch {
+			case head.Seq > r.LastSeq:
+				r.Issues = append(r.Issues, ChainIssue{Kind: IssueGap, Chain: chain, Seq: r.LastSeq + 1,
+					Detail: fmt.Sprintf("seq %d-%d missing, head is at %d", r.LastSeq+1, head.Seq, head.Seq)})
+			case head.Seq == r.LastSeq && head.Hash != last.hash:
+				r.Issues = append(r.Issues, ChainIssue{Kind: IssueFork, Chain: chain, Seq: r.LastSeq, EventID: last.eventID,
+					Detail: "last event is not the chain head"})
+			}
+		}
+		sort.SliceStable(r.Issues, func(i, j int) bool { return r.Issues[i].Seq < r.Issues[j].Seq })
+		reports = append(reports, r)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Chain < reports[j].Chain })
+	return reports
+}
+
+// VerifyChain verifies a batch of stored events; see ChainVerifier.
+func VerifyChain(keys SignatureVerifier, rawEvents [][]byte) []ChainReport {
+	v := NewChainVerifier(keys)
+	for _, raw := range rawEvents {
+		v.Add(raw)
+	}
+	return v.Reports()
+}
@@ -0,0 +1,241 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/praction-networks/common/events"
+	"github.com/praction-networks/common/events/eventstest"
+	"github.com/praction-networks/common/logger"
+)
+
+// TestMain initializes the logger the Publisher logs through.
+func TestMain(m *testing.M) {
+	if err := logger.InitializeLogger(logger.LoggerConfig{LogLevel: "error"}); err != nil {
+		panic("test logger init: " + err.Error())
+	}
+	os.Exit(m.Run())
+}
+
+func newChainedPublisher(t *testing.T) (*Publisher, *eventstest.JetStream, VerificationKeys) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := eventstest.New()
+	js.AddStreams(t, events.AuditGlobalStream)
+	p := NewPublisher(js.StreamManager(), "test-service").
+		WithIntegrity(NewMemoryChainStore(), NewEd25519Signer("k1", priv))
+	return p, js, VerificationKeys{Ed25519: map[string]ed25519.PublicKey{"k1": pub}}
+}
+
+func publishedRaw(js *eventstest.JetStream) [][]byte {
+	var out [][]byte
+	for _, m := range js.Published("audit.>") {
+		out = append(out, m.Data)
+	}
+	return out
+}
+
+func issueKinds(reports []ChainReport) map[string][]ChainIssueKind {
+	out := map[string][]ChainIssueKind{}
+	for _, r := range reports {
+		for _, i := range r.Issues {
+			out[r.Chain] = append(out[r.Chain], i.Kind)
+		}
+	}
+	return out
+}
+
+func TestChainedPublishVerifies(t *testing.T) {
+	p, js, keys := newChainedPublisher(t)
+	ctx := context.Background()
+	for _, tenant := range []string{"t1", "t1", "t2", "t1", ""} {
+		if err := p.Publish(ctx, AuditEvent{TenantID: tenant, Action: ActionUpdate, Resource: "plan",
+			Metadata: map[string]any{"limit": 1 << 60, "reason": "<b>"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reports := VerifyChain(keys, publishedRaw(js))
+	if len(reports) != 3 {
+		t.Fatalf("reports = %+v", reports)
+	}
+	want := map[string]ChainReport{
+		SystemChain: {Chain: SystemChain, Events: 1, FirstSeq: 1, LastSeq: 1},
+		"t1":        {Chain: "t1", Events: 3, FirstSeq: 1, LastSeq: 3},
+		"t2":        {Chain: "t2", Events: 1, FirstSeq: 1, LastSeq: 1},
+	}
+	for _, r := range reports {
+		if !r.OK() || r.Events != want[r.Chain].Events || r.LastSeq != want[r.Chain].LastSeq {
+			t.Errorf("report %+v, want %+v", r, want[r.Chain])
+		}
+	}
+
+	// A failed attempt is retried with the same bytes and MsgID.
+	js.FailPublish(events.AuditPlanActionSubject, errors.New("nats down"), 1)
+	if err := p.Publish(ctx, AuditEvent{TenantID: "t1", Resource: "plan"}); err != nil {
+		t.Fatal(err)
+	}
+	if kinds := issueKinds(VerifyChain(keys, publishedRaw(js))); len(kinds) != 0 {
+		t.Fatalf("issues after retried publish: %v", kinds)
+	}
+
+	// Once the attempts run out the sealed event is spooled, keeping its
+	// sequence number; replayed after a later event, the chain has no gap
+	// and is not out of order.
+	spool := NewFileSpool(filepath.Join(t.TempDir(), "audit.spool"))
+	p.WithSpool(spool)
+	js.FailPublish(events.AuditPlanActionSubject, errors.New("nats down"), publishAttempts)
+	if err := p.Publish(ctx, AuditEvent{TenantID: "t1", Resource: "plan"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish(ctx, AuditEvent{TenantID: "t1", Resource: "plan"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := spool.Replay(ctx, js.StreamManager()); err != nil || n != 1 {
+		t.Fatalf("Replay = %d, %v", n, err)
+	}
+	reports = VerifyChain(keys, publishedRaw(js))
+	for _, r := range reports {
+		if r.Chain == "t1" && (!r.OK() || r.LastSeq != 6) {
+			t.Fatalf("t1 after replay: %+v", r)
+		}
+	}
+}
+
+func TestChainVerifierDetectsTampering(t *testing.T) {
+	p, js, keys := newChainedPublisher(t)
+	for range 4 {
+		if err := p.Publish(context.Background(), AuditEvent{TenantID: "t1", Resource: "subscriber", ResourceID: "s1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw := publishedRaw(js)
+
+	edit := func(r []byte) []byte {
+		var doc map[string]any
+		_ = json.Unmarshal(r, &doc)
+		doc["resourceId"] = "s2"
+		out, _ := json.Marshal(doc)
+		return out
+	}
+
+	cases := []struct {
+		name   string
+		events [][]byte
+		want   []ChainIssueKind
+	}{
+		{"modified", [][]byte{raw[0], edit(raw[1]), raw[2], raw[3]}, []ChainIssueKind{IssueModified}},
+		{"removed", [][]byte{raw[0], raw[1], raw[3]}, []ChainIssueKind{IssueGap}},
+		{"stored out of order", [][]byte{raw[0], raw[2], raw[1], raw[3]}, nil},
+		{"duplicate", [][]byte{raw[0], raw[1], raw[1], raw[2], raw[3]}, []ChainIssueKind{IssueDuplicate}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := issueKinds(VerifyChain(keys, tc.events))["t1"]
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Fatalf("issues = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	// An event re-sealed with someone else's key: hash fine, signature not.
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	fjs := eventstest.New()
+	fjs.AddStreams(t, events.AuditGlobalStream)
+	forged := NewPublisher(fjs.StreamManager(), "test-service").
+		WithIntegrity(NewMemoryChainStore(), NewEd25519Signer("k1", otherKey))
+	if err := forged.Publish(context.Background(), AuditEvent{TenantID: "t1", Resource: "subscriber"}); err != nil {
+		t.Fatal(err)
+	}
+	got := issueKinds(VerifyChain(keys, publishedRaw(fjs)))["t1"]
+	if len(got) != 1 || got[0] != IssueBadSignature {
+		t.Fatalf("forged event issues = %v", got)
+	}
+
+	// Events without Integrity are reported, not silently accepted.
+	plain, _ := json.Marshal(AuditEvent{ID: "x", TenantID: "t1"})
+	if kinds := issueKinds(VerifyChain(keys, [][]byte{plain}))[""]; len(kinds) != 1 || kinds[0] != IssueUnsealed {
+		t.Fatalf("unsealed issues = %v", kinds)
+	}
+}
+
+func TestChainVerifierChecksHeads(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	keys := VerificationKeys{Ed25519: map[string]ed25519.PublicKey{"k1": pub}}
+	js := eventstest.New()
+	js.AddStreams(t, events.AuditGlobalStream)
+	heads := NewMemoryChainStore()
+	p := NewPublisher(js.StreamManager(), "test-service").WithIntegrity(heads, NewEd25519Signer("k1", priv))
+	ctx := context.Background()
+	for _, tenant := range []string{"t1", "t1", "t1", "t2"} {
+		if err := p.Publish(ctx, AuditEvent{TenantID: tenant, Resource: "subscriber"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw := publishedRaw(js)
+
+	verify := func(events [][]byte) map[string][]ChainIssue {
+		t.Helper()
+		v := NewChainVerifier(keys)
+		for _, e := range events {
+			v.Add(e)
+		}
+		if err := v.ExpectHeads(ctx, heads); err != nil {
+			t.Fatal(err)
+		}
+		// A chain none of whose events were stored.
+		v.ExpectHead("t3", ChainHead{Seq: 2, Hash: "h2"})
+		out := map[string][]ChainIssue{}
+		for _, r := range v.Reports() {
+			out[r.Chain] = r.Issues
+		}
+		return out
+	}
+
+	got := verify(raw)
+	if len(got["t1"]) != 0 || len(got["t2"]) != 0 {
+		t.Fatalf("complete chains: %+v", got)
+	}
+	if i := got["t3"]; len(i) != 1 || i[0].Kind != IssueGap || i[0].Seq != 1 {
+		t.Fatalf("unstored chain: %+v", i)
+	}
+
+	// The last event of t1 lost: nothing in the stored events shows it.
+	if kinds := issueKinds(VerifyChain(keys, [][]byte{raw[0], raw[1], raw[3]})); len(kinds) != 0 {
+		t.Fatalf("without heads: %v", kinds)
+	}
+	if i := verify([][]byte{raw[0], raw[1], raw[3]})["t1"]; len(i) != 1 || i[0].Kind != IssueGap || i[0].Seq != 3 {
+		t.Fatalf("truncated tail: %+v", i)
+	}
+
+	// A head that moved to another event at the same seq.
+	head, _ := heads.Head(ctx, "t2")
+	if err := heads.Advance(ctx, "t2", head, ChainHead{Seq: 1, Hash: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if i := verify(raw)["t2"]; len(i) != 1 || i[0].Kind != IssueFork || i[0].Seq != 1 {
+		t.Fatalf("head mismatch: %+v", i)
+	}
+}
+
+func TestHMACSignerVerifies(t *testing.T) {
+	key := []byte("secret")
+	s := NewHMACSigner("h1", key)
+	sig, _ := s.Sign([]byte("digest"))
+	keys := VerificationKeys{HMAC: map[string][]byte{"h1": key}}
+	if err := keys.VerifySignature(AlgHMACSHA256, "h1", []byte("digest"), sig); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.VerifySignature(AlgHMACSHA256, "h1", []byte("other"), sig); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("err = %v, want ErrBadSignature", err)
+	}
+}
@@ -7,17 +7,27 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
 // Middleware returns a Chi-compatible HTTP middleware that automatically
 // publishes audit events for every request. Services only need to enrich
-// the event with ResourceName and Changes via context.
+// the event with ResourceName and Changes via context (SetResourceName,
+// SetChanges), and annotate routes whose resource or action the URL doesn't
+// tell (Annotate).
 //
 // Usage:
 //
 //	r := chi.NewRouter()
 //	r.Use(audit.Middleware(publisher, "my-service"))
 func Middleware(publisher *Publisher, serviceName string) func(next http.Handler) http.Handler {
+	return MiddlewareWithRoutes(publisher, serviceName, nil)
+}
+
+// MiddlewareWithRoutes is Middleware with Route annotations registered by
+// route pattern; inline Annotate annotations take precedence.
+func MiddlewareWithRoutes(publisher *Publisher, serviceName string, routes *Routes) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Skip health checks and metrics
@@ -26,6 +36,12 @@ func Middleware(publisher *Publisher, serviceName string) func(next http.Handler
 				return
 			}
 
+			ra := &requestAudit{}
+			if route, ok := routes.match(r); ok && route.CaptureBody {
+				ra.body = captureBody(r)
+			}
+			r = r.WithContext(context.WithValue(r.Context(), requestAuditKey{}, ra))
+
 			// Wrap response writer to capture status code
 			rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
@@ -41,30 +57,82 @@ func Middleware(publisher *Publisher, serviceName string) func(next http.Handler
 				return // skip non-tenant requests
 			}
 
-			resource, resourceID := extractResourceAndID(r.URL.Path)
-			event := AuditEvent{
-				TenantID:   tenantID,
-				UserID:     userID,
-				UserName:   userName,
-				Action:     AuditAction(httpMethodToAction(r.Method)),
-				Resource:   resource,
-				ResourceID: resourceID,
-				Service:    serviceName,
-				IPAddress:  extractIP(r),
-				UserAgent:  r.UserAgent(),
-				Status:     statusFromCode(rw.statusCode),
-				StatusCode: rw.statusCode,
-				Timestamp:  time.Now().UTC(),
+			event, ok := buildEvent(r, ra, routes)
+			if !ok {
+				return
 			}
+			event.TenantID = tenantID
+			event.UserID = userID
+			event.UserName = userName
+			event.Service = serviceName
+			event.IPAddress = extractIP(r)
+			event.UserAgent = r.UserAgent()
+			event.Status = statusFromCode(rw.statusCode)
+			event.StatusCode = rw.statusCode
+			event.Timestamp = time.Now().UTC()
 
-			// Publish asynchronously — don't block the response
-			go func() {
-				_ = publisher.Publish(context.Background(), event)
-			}()
+			// Publish asynchronously — don't block the response. Queued on
+			// the publisher's Pipeline when it has one.
+			publisher.PublishAsync(r.Context(), event)
 		})
 	}
 }
 
+// buildEvent fills the resource, ID, action and changes of the request's
+// event from its Route annotation, falling back to guessing them from the
+// URL and method. It returns false for a route annotated Skip.
+func buildEvent(r *http.Request, ra *requestAudit, routes *Routes) (AuditEvent, bool) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+
+	route := ra.route
+	if route == nil {
+		if annotated, ok := routes.lookup(r.Method, routePattern(r)); ok {
+			route = &annotated
+		}
+	}
+	if route != nil && route.Skip {
+		return AuditEvent{}, false
+	}
+
+	resource, resourceID := extractResourceAndID(r.URL.Path)
+	event := AuditEvent{
+		Action:       AuditAction(httpMethodToAction(r.Method)),
+		Resource:     resource,
+		ResourceID:   resourceID,
+		ResourceName: ra.resourceName,
+		Changes:      ra.changes,
+	}
+	if route != nil {
+		if route.Resource != "" {
+			event.Resource = route.Resource
+		}
+		if route.IDParam != "" {
+			event.ResourceID = chi.URLParam(r, route.IDParam)
+		}
+		if route.Action != "" {
+			event.Action = route.Action
+		}
+		if route.CaptureBody && len(event.Changes) == 0 && ra.body != nil {
+			event.Changes = bodyChanges(ra.body, ra.before)
+		}
+	}
+	if ra.resourceID != "" {
+		event.ResourceID = ra.resourceID
+	}
+	return event, true
+}
+
+// routePattern returns the chi pattern the request was routed to, "" when
+// it wasn't routed by chi.
+func routePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+	return rctx.RoutePattern()
+}
+
 // responseWriter wraps http.ResponseWriter to capture the status code
 type responseWriter struct {
 	http.ResponseWriter
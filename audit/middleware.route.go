@@ -0,0 +1,248 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Route is the audit annotation of one chi route. Middleware otherwise
+// guesses the resource and ID from the URL and the action from the method,
+// which turns e.g. "assign plan" into a generic CREATE of "subscriber".
+//
+// Annotate a route inline, which works inside sub-routers:
+//
+//	r.With(audit.Annotate(audit.Route{Resource: "subscriber", IDParam: "subscriberID",
+//		Action: audit.ActionAssignPlan})).Post("/{subscriberID}/plan", h.AssignPlan)
+//
+// or register it by its full pattern on a Routes passed to
+// MiddlewareWithRoutes.
+type Route struct {
+	// Resource is the canonical resource name, e.g. "subscriber".
+	Resource string
+
+	// IDParam is the chi URL parameter holding the resource ID.
+	IDParam string
+
+	// Action overrides the action derived from the HTTP method.
+	Action AuditAction
+
+	// CaptureBody records the JSON request body's fields as the event's
+	// Changes, diffed against the state the handler passed to SetBefore.
+	CaptureBody bool
+
+	// Skip suppresses the audit event for the route.
+	Skip bool
+}
+
+// maxCapturedBody bounds the request body Route.CaptureBody buffers; larger
+// bodies are passed through and not captured.
+const maxCapturedBody = 64 << 10
+
+// Routes holds Route annotations by chi route pattern, for routes annotated
+// where they are registered rather than inline.
+type Routes struct {
+	mu      sync.RWMutex
+	routes  map[string]Route // "METHOD pattern", method "*" for any
+	capture bool             // some route has CaptureBody
+}
+
+// NewRoutes creates an empty Routes.
+func NewRoutes() *Routes {
+	return &Routes{routes: map[string]Route{}}
+}
+
+// Register annotates the route with method (empty for any) and the full
+// pattern chi reports for it, sub-router prefixes included, e.g.
+// "/api/v1/subscribers/{subscriberID}/plan".
+func (rs *Routes) Register(method, pattern string, route Route) *Routes {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.routes[routeKey(method, pattern)] = route
+	rs.capture = rs.capture || route.CaptureBody
+	return rs
+}
+
+func (rs *Routes) lookup(method, pattern string) (Route, bool) {
+	if rs == nil || pattern == "" {
+		return Route{}, false
+	}
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	if route, ok := rs.routes[routeKey(method, pattern)]; ok {
+		return route, true
+	}
+	route, ok := rs.routes[routeKey("", pattern)]
+	return route, ok
+}
+
+// Unmatched returns the registered annotations that name no route of
+// router, typically a pattern registered without its sub-router prefix.
+// Check it at startup.
+func (rs *Routes) Unmatched(router chi.Routes) ([]string, error) {
+	seen := map[string]bool{}
+	err := chi.Walk(router, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		seen[routeKey(method, route)] = true
+		seen[routeKey("", route)] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	var out []string
+	for key := range rs.routes {
+		if !seen[key] {
+			out = append(out, key)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// match finds the annotation of the route r will be routed to, before
+// routing, so a registered CaptureBody route gets its body buffered.
+func (rs *Routes) match(r *http.Request) (Route, bool) {
+	if rs == nil {
+		return Route{}, false
+	}
+	rs.mu.RLock()
+	capture := rs.capture
+	rs.mu.RUnlock()
+	rctx := chi.RouteContext(r.Context())
+	if !capture || rctx == nil || rctx.Routes == nil {
+		return Route{}, false
+	}
+	pattern := rctx.Routes.Find(chi.NewRouteContext(), r.Method, r.URL.Path)
+	return rs.lookup(r.Method, pattern)
+}
+
+// routeKey normalizes a pattern the way chi reports it under sub-routers:
+// mount wildcards dropped, no trailing slash.
+func routeKey(method, pattern string) string {
+	if method == "" {
+		method = "*"
+	}
+	pattern = strings.ReplaceAll(pattern, "/*/", "/")
+	if len(pattern) > 1 {
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	return strings.ToUpper(method) + " " + pattern
+}
+
+// requestAudit is the per-request state Middleware puts in the context for
+// Annotate and the Set* helpers.
+type requestAudit struct {
+	mu           sync.Mutex
+	route        *Route
+	resourceID   string
+	resourceName string
+	changes      []Change
+	before       any
+	body         map[string]any
+}
+
+type requestAuditKey struct{}
+
+func requestAuditFrom(ctx context.Context) *requestAudit {
+	ra, _ := ctx.Value(requestAuditKey{}).(*requestAudit)
+	return ra
+}
+
+// update runs fn on the request's audit state, if Middleware set one.
+func update(ctx context.Context, fn func(ra *requestAudit)) {
+	if ra := requestAuditFrom(ctx); ra != nil {
+		ra.mu.Lock()
+		defer ra.mu.Unlock()
+		fn(ra)
+	}
+}
+
+// Annotate returns an inline chi middleware that applies route to the
+// requests it handles, capturing the request body when route asks for it.
+func Annotate(route Route) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			update(r.Context(), func(ra *requestAudit) {
+				ra.route = &route
+				if route.CaptureBody && ra.body == nil {
+					ra.body = captureBody(r)
+				}
+			})
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SetResourceID records the ID of the resource the request acted on, for
+// routes whose URL doesn't carry it (e.g. the ID a CREATE assigned).
+func SetResourceID(ctx context.Context, id string) {
+	update(ctx, func(ra *requestAudit) { ra.resourceID = id })
+}
+
+// SetResourceName records the human-readable name of the resource, as in
+// "updated mobile of Subscriber Ahmed".
+func SetResourceName(ctx context.Context, name string) {
+	update(ctx, func(ra *requestAudit) { ra.resourceName = name })
+}
+
+// SetChanges records the request's changes explicitly, e.g. from
+// DiffChanges; they take precedence over a captured body.
+func SetChanges(ctx context.Context, changes ...Change) {
+	update(ctx, func(ra *requestAudit) { ra.changes = append(ra.changes, changes...) })
+}
+
+// SetBefore records the resource as it was before the request, for
+// Route.CaptureBody to diff the body against.
+func SetBefore(ctx context.Context, before any) {
+	update(ctx, func(ra *requestAudit) { ra.before = before })
+}
+
+// captureBody buffers a JSON object body and puts it back for the handler.
+// It returns nil for bodies that are too large or not an object.
+func captureBody(r *http.Request) map[string]any {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(r.Body, maxCapturedBody+1))
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+	if err != nil || len(buf) > maxCapturedBody {
+		return nil
+	}
+	var body map[string]any
+	if err := json.Unmarshal(buf, &body); err != nil {
+		return nil
+	}
+	return body
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// bodyChanges diffs the body's fields against the same fields of before;
+// all of them are added when there is no before.
+func bodyChanges(body map[string]any, before any) []Change {
+	prev := map[string]any{}
+	if before != nil {
+		var doc map[string]any
+		if raw, err := json.Marshal(before); err == nil {
+			_ = json.Unmarshal(raw, &doc)
+		}
+		for field := range body {
+			if v, ok := doc[field]; ok {
+				prev[field] = v
+			}
+		}
+	}
+	return DiffChanges(prev, body)
+}
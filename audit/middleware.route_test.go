@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/praction-networks/common/events/eventstest"
+)
+
+type subscriber struct {
+	ID     string `json:"id"`
+	Mobile string `json:"mobile"`
+	Plan   string `json:"plan"`
+}
+
+func publishedEvents(t *testing.T, js *eventstest.JetStream) []AuditEvent {
+	t.Helper()
+	var out []AuditEvent
+	for _, raw := range publishedRaw(js) {
+		var e AuditEvent
+		if err := json.Unmarshal(raw, &e); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func TestMiddlewareUsesRouteAnnotations(t *testing.T) {
+	p, js := newPipelinePublisher(t)
+	pl := NewPipeline(p, PipelineConfig{Workers: 1})
+
+	routes := NewRoutes().
+		Register(http.MethodPatch, "/api/v1/subscribers/{subscriberID}", Route{Resource: "subscriber", IDParam: "subscriberID", CaptureBody: true}).
+		Register("", "/api/v1/subscribers/{subscriberID}/sessions", Route{Skip: true})
+
+	var gotBody string
+	r := chi.NewRouter()
+	r.Use(MiddlewareWithRoutes(p, "subscriber-service", routes))
+	r.Route("/api/v1/subscribers/{subscriberID}", func(r chi.Router) {
+		r.With(Annotate(Route{Resource: "subscriber", IDParam: "subscriberID", Action: ActionAssignPlan})).
+			Post("/plan", func(w http.ResponseWriter, r *http.Request) {
+				SetResourceName(r.Context(), "Subscriber Ahmed")
+				w.WriteHeader(http.StatusNoContent)
+			})
+		r.Patch("/", func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			gotBody = string(body)
+			SetBefore(r.Context(), subscriber{ID: "s1", Mobile: "0501234567", Plan: "gold"})
+		})
+		r.Get("/sessions", func(w http.ResponseWriter, r *http.Request) {})
+	})
+	if unmatched, err := routes.Unmatched(r); err != nil || len(unmatched) != 0 {
+		t.Fatalf("Unmatched = %v, %v", unmatched, err)
+	}
+
+	serve := func(method, path, body string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-tenant-id", "t1")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	serve(http.MethodPost, "/api/v1/subscribers/s1/plan", "")
+	serve(http.MethodPatch, "/api/v1/subscribers/s1", `{"mobile":"0509876543","plan":"gold"}`)
+	serve(http.MethodGet, "/api/v1/subscribers/s1/sessions", "")
+	drain(t, pl)
+
+	if gotBody != `{"mobile":"0509876543","plan":"gold"}` {
+		t.Fatalf("handler read body %q", gotBody)
+	}
+	got := publishedEvents(t, js)
+	if len(got) != 2 {
+		t.Fatalf("published %d events, want 2", len(got))
+	}
+	if e := got[0]; e.Action != ActionAssignPlan || e.Resource != "subscriber" || e.ResourceID != "s1" ||
+		e.ResourceName != "Subscriber Ahmed" || e.StatusCode != http.StatusNoContent {
+		t.Errorf("annotated event = %+v", e)
+	}
+	e := got[1]
+	if e.Action != ActionUpdate || e.ResourceID != "s1" || len(e.Changes) != 1 ||
+		e.Changes[0] != (Change{Field: "mobile", OldValue: "0501234567", NewValue: "0509876543"}) {
+		t.Errorf("captured event = %+v", e)
+	}
+}
+
+func TestMiddlewareWithoutAnnotationGuesses(t *testing.T) {
+	p, js := newPipelinePublisher(t)
+	pl := NewPipeline(p, PipelineConfig{Workers: 1})
+	r := chi.NewRouter()
+	r.Use(Middleware(p, "plan-service"))
+	r.Post("/api/v1/plans/{planID}/publish", func(w http.ResponseWriter, r *http.Request) {
+		SetChanges(r.Context(), Change{Field: "status", OldValue: "draft", NewValue: "live"})
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/plans/42/publish", nil)
+	req.Header.Set("x-tenant-id", "t1")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	drain(t, pl)
+
+	got := publishedEvents(t, js)
+	if len(got) != 1 || got[0].Action != ActionCreate || got[0].Resource != "plan" || got[0].ResourceID != "42" ||
+		len(got[0].Changes) != 1 || got[0].Service != "test-service" {
+		t.Fatalf("events = %+v", got)
+	}
+}
@@ -0,0 +1,93 @@
+// Package mongochain provides a Mongo-backed audit.ChainStore.
+//
+// Storage shape — one document per chain (tenant):
+//
+//	{
+//	  _id:       "<chain>",
+//	  seq:       <last sequence number>,
+//	  hash:      "<hash of the last event>",
+//	  updatedAt: <ISO timestamp>,
+//	}
+//
+// Advance is a compare-and-set on {seq, hash}: the insert of a new chain
+// wins for exactly one publisher, and later moves are conditional updates,
+// so two instances can never hand out the same sequence number.
+package mongochain
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/praction-networks/common/audit"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrNoCollection is returned when the store was constructed without a
+// collection.
+var ErrNoCollection = errors.New("mongochain: no collection configured")
+
+// Store implements audit.ChainStore against a Mongo collection.
+type Store struct {
+	coll *mongo.Collection
+}
+
+// New constructs a Mongo-backed store.
+func New(coll *mongo.Collection) *Store {
+	return &Store{coll: coll}
+}
+
+// Head implements audit.ChainStore.
+func (s *Store) Head(ctx context.Context, chain string) (audit.ChainHead, error) {
+	if s == nil || s.coll == nil {
+		return audit.ChainHead{}, ErrNoCollection
+	}
+	var doc struct {
+		Seq  int64  `bson:"seq"`
+		Hash string `bson:"hash"`
+	}
+	err := s.coll.FindOne(ctx, bson.M{"_id": chain}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return audit.ChainHead{}, nil
+	}
+	if err != nil {
+		return audit.ChainHead{}, err
+	}
+	return audit.ChainHead{Seq: uint64(doc.Seq), Hash: doc.Hash}, nil
+}
+
+// Advance implements audit.ChainStore.
+func (s *Store) Advance(ctx context.Context, chain string, prev, next audit.ChainHead) error {
+	if s == nil || s.coll == nil {
+		return ErrNoCollection
+	}
+	now := time.Now().UTC()
+
+	if prev.Seq == 0 {
+		_, err := s.coll.InsertOne(ctx, bson.M{
+			"_id":       chain,
+			"seq":       int64(next.Seq),
+			"hash":      next.Hash,
+			"updatedAt": now,
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			return audit.ErrChainConflict
+		}
+		return err
+	}
+
+	filter := bson.M{"_id": chain, "seq": int64(prev.Seq), "hash": prev.Hash}
+	res, err := s.coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"seq":       int64(next.Seq),
+		"hash":      next.Hash,
+		"updatedAt": now,
+	}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return audit.ErrChainConflict
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+// Package mongostore provides a Mongo-backed audit.Store.
+//
+// Storage shape — one document per audit event:
+//
+//	{
+//	  _id:          "<event id>",
+//	  tenantId:     "<tenant>",
+//	  userId:       "<user>",
+//	  userName:     "<display name>",       // optional
+//	  userRole:     "<role label>",         // optional
+//	  action:       "UPDATE",
+//	  resource:     "subscriber",
+//	  resourceId:   "<id>",
+//	  resourceName: "Subscriber Ahmed",     // optional
+//	  changes:      [{field, oldValue, newValue, op}],
+//	  service:      "subscriber-service",
+//	  ipAddress:    "<ip>",
+//	  userAgent:    "<ua>",
+//	  status:       "SUCCESS",
+//	  statusCode:   200,
+//	  timestamp:    <ISO timestamp>,
+//	  metadata:     {...},
+//	  integrity:    {chain, seq, prevHash, hash, alg, keyId, signature},
+//	  raw:          <the event as published>, // SaveRaw only
+//	}
+//
+// Find pages newest first on {timestamp, _id}; EnsureIndexes installs the
+// indexes its tenant, user and resource filters run on. The fields above
+// keep timestamps to the millisecond and drop fields this version of
+// AuditEvent doesn't know, so a sealed event re-serialized from them no
+// longer matches its hash. Consumers of AuditGlobalStream therefore save
+// the message bodies with SaveRaw, which keeps them verbatim in raw, and
+// Verify checks integrity chains against those bytes.
+package mongostore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/praction-networks/common/audit"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNoCollection is returned when the store was constructed without a
+// collection.
+var ErrNoCollection = errors.New("mongostore: no collection configured")
+
+// withoutRaw leaves the verbatim copy out of Get and Find results.
+var withoutRaw = bson.M{"raw": 0}
+
+// Store implements audit.Store against a Mongo collection.
+type Store struct {
+	coll      *mongo.Collection
+	hierarchy audit.Descendants
+}
+
+// New constructs a Mongo-backed store.
+func New(coll *mongo.Collection) *Store {
+	return &Store{coll: coll}
+}
+
+// WithHierarchy makes Query.IncludeDescendants expand tenants through
+// hierarchy, typically the service's hierarchy.TenantHierarchyCache.
+func (s *Store) WithHierarchy(hierarchy audit.Descendants) *Store {
+	s.hierarchy = hierarchy
+	return s
+}
+
+// EnsureIndexes installs the indexes Find relies on. Idempotent; run at
+// service startup.
+func (s *Store) EnsureIndexes(ctx context.Context) error {
+	if s == nil || s.coll == nil {
+		return ErrNoCollection
+	}
+	_, err := s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			// Tenant timeline, the default audit view
+			Keys:    bson.D{{Key: "tenantId", Value: 1}, {Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("tenant_timestamp"),
+		},
+		{
+			// History of one resource
+			Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "resource", Value: 1}, {Key: "resourceId", Value: 1},
+				{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("tenant_resource_timestamp"),
+		},
+		{
+			// What one user did
+			Keys:    bson.D{{Key: "tenantId", Value: 1}, {Key: "userId", Value: 1}, {Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("tenant_user_timestamp"),
+		},
+		{
+			// Cross-tenant timeline for system users
+			Keys:    bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("timestamp"),
+		},
+	})
+	return err
+}
+
+// Save implements audit.Store. Events already stored are skipped.
+func (s *Store) Save(ctx context.Context, events ...audit.AuditEvent) error {
+	if s == nil || s.coll == nil {
+		return ErrNoCollection
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	docs := make([]any, len(events))
+	for i, e := range events {
+		docs[i] = toDoc(e)
+	}
+	return s.insert(ctx, docs)
+}
+
+// SaveRaw saves events given as published, e.g. the bodies of messages
+// consumed from AuditGlobalStream, keeping each verbatim for Verify.
+// Events already stored are skipped.
+func (s *Store) SaveRaw(ctx context.Context, raw ...[]byte) error {
+	if s == nil || s.coll == nil {
+		return ErrNoCollection
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+	docs := make([]any, len(raw))
+	for i, r := range raw {
+		var e audit.AuditEvent
+		if err := json.Unmarshal(r, &e); err != nil {
+			return fmt.Errorf("mongostore: decode event %d: %w", i, err)
+		}
+		doc := toDoc(e)
+		doc.Raw = r
+		docs[i] = doc
+	}
+	return s.insert(ctx, docs)
+}
+
+func (s *Store) insert(ctx context.Context, docs []any) error {
+	_, err := s.coll.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err != nil && !onlyDuplicates(err) {
+		return err
+	}
+	return nil
+}
+
+// Verify runs the integrity chains of the events q selects through an
+// audit.ChainVerifier; q's Cursor and Limit are ignored. Events saved with
+// SaveRaw are checked as published. Others are re-serialized, which is
+// enough to report them unsealed but not to verify a seal.
+func (s *Store) Verify(ctx context.Context, q audit.Query, keys audit.SignatureVerifier) ([]audit.ChainReport, error) {
+	if s == nil || s.coll == nil {
+		return nil, ErrNoCollection
+	}
+	q.Cursor = ""
+	filter, err := s.filter(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := s.coll.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	v := audit.NewChainVerifier(keys)
+	for cur.Next(ctx) {
+		var doc eventDoc
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		raw := doc.Raw
+		if raw == nil {
+			if raw, err = json.Marshal(doc.event()); err != nil {
+				return nil, err
+			}
+		}
+		v.Add(raw)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return v.Reports(), nil
+}
+
+// onlyDuplicates reports whether every write error of an unordered insert
+// is a duplicate key, i.e. a redelivered event.
+func onlyDuplicates(err error) bool {
+	var bulk mongo.BulkWriteException
+	if !errors.As(err, &bulk) || bulk.WriteConcernError != nil || len(bulk.WriteErrors) == 0 {
+		return false
+	}
+	for _, we := range bulk.WriteErrors {
+		if we.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
+
+// Get implements audit.Store.
+func (s *Store) Get(ctx context.Context, id string) (audit.AuditEvent, error) {
+	if s == nil || s.coll == nil {
+		return audit.AuditEvent{}, ErrNoCollection
+	}
+	filter := bson.M{"_id": id}
+	tenants, all, err := audit.Query{}.ResolveTenants(ctx, nil)
+	if err != nil {
+		return audit.AuditEvent{}, err
+	}
+	if !all {
+		filter["tenantId"] = bson.M{"$in": tenants}
+	}
+	var doc eventDoc
+	err = s.coll.FindOne(ctx, filter, options.FindOne().SetProjection(withoutRaw)).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return audit.AuditEvent{}, audit.ErrEventNotFound
+	}
+	if err != nil {
+		return audit.AuditEvent{}, err
+	}
+	return doc.event(), nil
+}
+
+// Find implements audit.Store.
+func (s *Store) Find(ctx context.Context, q audit.Query) (audit.Page, error) {
+	if s == nil || s.coll == nil {
+		return audit.Page{}, ErrNoCollection
+	}
+	filter, err := s.filter(ctx, q)
+	if err != nil {
+		return audit.Page{}, err
+	}
+	limit := q.PageLimit()
+	cur, err := s.coll.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit+1)).
+		SetProjection(withoutRaw))
+	if err != nil {
+		return audit.Page{}, err
+	}
+	var docs []eventDoc
+	if err := cur.All(ctx, &docs); err != nil {
+		return audit.Page{}, err
+	}
+
+	page := audit.Page{Events: make([]audit.AuditEvent, 0, min(len(docs), limit))}
+	for i, doc := range docs {
+		if i == limit {
+			page.NextCursor = audit.EncodeCursor(page.Events[limit-1])
+			break
+		}
+		page.Events = append(page.Events, doc.event())
+	}
+	return page, nil
+}
+
+func (s *Store) filter(ctx context.Context, q audit.Query) (bson.M, error) {
+	filter := bson.M{}
+	tenants, all, err := q.ResolveTenants(ctx, s.hierarchy)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case all:
+	case len(tenants) == 1:
+		filter["tenantId"] = tenants[0]
+	default:
+		filter["tenantId"] = bson.M{"$in": tenants}
+	}
+
+	if q.UserID != "" {
+		filter["userId"] = q.UserID
+	}
+	if q.Resource != "" {
+		filter["resource"] = q.Resource
+	}
+	if q.ResourceID != "" {
+		filter["resourceId"] = q.ResourceID
+	}
+	if len(q.Actions) > 0 {
+		filter["action"] = bson.M{"$in": q.Actions}
+	}
+	if len(q.Statuses) > 0 {
+		filter["status"] = bson.M{"$in": q.Statuses}
+	}
+
+	timestamp := bson.M{}
+	if !q.From.IsZero() {
+		timestamp["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		timestamp["$lt"] = q.To
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+
+	if q.Cursor != "" {
+		ts, id, err := audit.DecodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		filter["$or"] = bson.A{
+			bson.M{"timestamp": bson.M{"$lt": ts}},
+			bson.M{"timestamp": ts, "_id": bson.M{"$lt": id}},
+		}
+	}
+	return filter, nil
+}
+
+type eventDoc struct {
+	ID           string            `bson:"_id"`
+	TenantID     string            `bson:"tenantId"`
+	UserID       string            `bson:"userId"`
+	UserName     string            `bson:"userName,omitempty"`
+	UserRole     string            `bson:"userRole,omitempty"`
+	Action       audit.AuditAction `bson:"action"`
+	Resource     string            `bson:"resource"`
+	ResourceID   string            `bson:"resourceId"`
+	ResourceName string            `bson:"resourceName,omitempty"`
+	Changes      []changeDoc       `bson:"changes,omitempty"`
+	Service      string            `bson:"service"`
+	IPAddress    string            `bson:"ipAddress,omitempty"`
+	UserAgent    string            `bson:"userAgent,omitempty"`
+	Status       audit.AuditStatus `bson:"status"`
+	StatusCode   int               `bson:"statusCode"`
+	Timestamp    time.Time         `bson:"timestamp"`
+	Metadata     map[string]any    `bson:"metadata,omitempty"`
+	Integrity    *integrityDoc     `bson:"integrity,omitempty"`
+	Raw          []byte            `bson:"raw,omitempty"`
+}
+
+type changeDoc struct {
+	Field    string         `bson:"field"`
+	OldValue any            `bson:"oldValue,omitempty"`
+	NewValue any            `bson:"newValue,omitempty"`
+	Op       audit.ChangeOp `bson:"op,omitempty"`
+}
+
+type integrityDoc struct {
+	Chain     string `bson:"chain"`
+	Seq       int64  `bson:"seq"`
+	PrevHash  string `bson:"prevHash,omitempty"`
+	Hash      string `bson:"hash"`
+	Alg       string `bson:"alg"`
+	KeyID     string `bson:"keyId,omitempty"`
+	Signature string `bson:"signature"`
+}
+
+func toDoc(e audit.AuditEvent) eventDoc {
+	doc := eventDoc{
+		ID: e.ID, TenantID: e.TenantID, UserID: e.UserID, UserName: e.UserName, UserRole: e.UserRole,
+		Action: e.Action, Resource: e.Resource, ResourceID: e.ResourceID, ResourceName: e.ResourceName,
+		Service: e.Service, IPAddress: e.IPAddress, UserAgent: e.UserAgent,
+		Status: e.Status, StatusCode: e.StatusCode, Timestamp: e.Timestamp, Metadata: e.Metadata,
+	}
+	for _, c := range e.Changes {
+		doc.Changes = append(doc.Changes, changeDoc(c))
+	}
+	if in := e.Integrity; in != nil {
+		doc.Integrity = &integrityDoc{Chain: in.Chain, Seq: int64(in.Seq), PrevHash: in.PrevHash, Hash: in.Hash,
+			Alg: in.Alg, KeyID: in.KeyID, Signature: in.Signature}
+	}
+	return doc
+}
+
+func (doc eventDoc) event() audit.AuditEvent {
+	e := audit.AuditEvent{
+		ID: doc.ID, TenantID: doc.TenantID, UserID: doc.UserID, UserName: doc.UserName, UserRole: doc.UserRole,
+		Action: doc.Action, Resource: doc.Resource, ResourceID: doc.ResourceID, ResourceName: doc.ResourceName,
+		Service: doc.Service, IPAddress: doc.IPAddress, UserAgent: doc.UserAgent,
+		Status: doc.Status, StatusCode: doc.StatusCode, Timestamp: doc.Timestamp, Metadata: doc.Metadata,
+	}
+	for _, c := range doc.Changes {
+		e.Changes = append(e.Changes, audit.Change(c))
+	}
+	if in := doc.Integrity; in != nil {
+		e.Integrity = &audit.Integrity{Chain: in.Chain, Seq: uint64(in.Seq), PrevHash: in.PrevHash, Hash: in.Hash,
+			Alg: in.Alg, KeyID: in.KeyID, Signature: in.Signature}
+	}
+	return e
+}
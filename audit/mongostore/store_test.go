@@ -0,0 +1,104 @@
+package mongostore
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"os"
+	"testing"
+
+	"github.com/praction-networks/common/audit"
+	"github.com/praction-networks/common/events"
+	"github.com/praction-networks/common/events/eventstest"
+	"github.com/praction-networks/common/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestMain initializes the logger the audit Publisher logs through.
+func TestMain(m *testing.M) {
+	if err := logger.InitializeLogger(logger.LoggerConfig{LogLevel: "error"}); err != nil {
+		panic("test logger init: " + err.Error())
+	}
+	os.Exit(m.Run())
+}
+
+// sealedEvents publishes n sealed events of tenant t1 and returns them as
+// published.
+func sealedEvents(t *testing.T, n int) ([][]byte, audit.VerificationKeys) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := eventstest.New()
+	js.AddStreams(t, events.AuditGlobalStream)
+	p := audit.NewPublisher(js.StreamManager(), "test-service").
+		WithIntegrity(audit.NewMemoryChainStore(), audit.NewEd25519Signer("k1", priv))
+	for range n {
+		// Nanosecond timestamps and a large integer are what the stored
+		// fields can't hold exactly
+		err := p.Publish(context.Background(), audit.AuditEvent{TenantID: "t1", Resource: "plan",
+			Action: audit.ActionUpdate, Metadata: map[string]any{"limit": 1<<60 + 1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var raw [][]byte
+	for _, m := range js.Published("audit.>") {
+		raw = append(raw, m.Data)
+	}
+	return raw, audit.VerificationKeys{Ed25519: map[string]ed25519.PublicKey{"k1": pub}}
+}
+
+func TestSaveRawVerifies(t *testing.T) {
+	raw, keys := sealedEvents(t, 2)
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("verify", func(mt *mtest.T) {
+		s := New(mt.Coll)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		if err := s.SaveRaw(context.Background(), raw...); err != nil {
+			t.Fatal(err)
+		}
+
+		// The inserted documents carry the published bytes
+		values, err := mt.GetStartedEvent().Command.Lookup("documents").Array().Values()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stored, stripped []bson.D
+		for i, v := range values {
+			var doc bson.D
+			if err := bson.Unmarshal(v.Document(), &doc); err != nil {
+				t.Fatal(err)
+			}
+			_, data := v.Document().Lookup("raw").Binary()
+			if !bytes.Equal(data, raw[i]) {
+				t.Fatalf("document %d raw = %s, want %s", i, data, raw[i])
+			}
+			stored = append(stored, doc)
+			stripped = append(stripped, doc[:len(doc)-1]) // raw is last
+		}
+
+		verify := func(docs []bson.D) []audit.ChainReport {
+			t.Helper()
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, "db."+mt.Coll.Name(), mtest.FirstBatch, docs...))
+			reports, err := s.Verify(context.Background(), audit.Query{TenantIDs: []string{"t1"}}, keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return reports
+		}
+		if reports := verify(stored); len(reports) != 1 || !reports[0].OK() || reports[0].LastSeq != 2 {
+			t.Fatalf("reports = %+v", reports)
+		}
+
+		// Re-serialized from the stored fields, the events no longer match
+		// their hashes
+		reports := verify(stripped)
+		if len(reports) != 1 || len(reports[0].Issues) != 2 || reports[0].Issues[0].Kind != audit.IssueModified {
+			t.Fatalf("reports without raw = %+v", reports)
+		}
+	})
+}
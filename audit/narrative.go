@@ -0,0 +1,158 @@
+package audit
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// actionVerbs phrases an action as "<past> <object>" and, for failed or
+// denied events, "tried to <base> <object>".
+var actionVerbs = map[AuditAction]struct{ past, base string }{
+	ActionCreate:  {"created", "create"},
+	ActionRead:    {"viewed", "view"},
+	ActionUpdate:  {"updated", "update"},
+	ActionDelete:  {"deleted", "delete"},
+	ActionLogin:   {"logged in to", "log in to"},
+	ActionLogout:  {"logged out of", "log out of"},
+	ActionExport:  {"exported", "export"},
+	ActionImport:  {"imported", "import"},
+	ActionApprove: {"approved", "approve"},
+	ActionDeny:    {"denied", "deny"},
+	ActionRevoke:  {"revoked", "revoke"},
+	ActionGrant:   {"granted", "grant"},
+
+	ActionAssignPlan: {"assigned a plan to", "assign a plan to"},
+	ActionKYCVerify:  {"verified KYC of", "verify KYC of"},
+	ActionKYCReject:  {"rejected KYC of", "reject KYC of"},
+	ActionSuspend:    {"suspended", "suspend"},
+	ActionResume:     {"resumed", "resume"},
+
+	ActionCollectPayment: {"collected a payment for", "collect a payment for"},
+	ActionRefund:         {"refunded", "refund"},
+	ActionVoidInvoice:    {"voided", "void"},
+	ActionApplyDiscount:  {"applied a discount to", "apply a discount to"},
+
+	ActionProvision:    {"provisioned", "provision"},
+	ActionReboot:       {"rebooted", "reboot"},
+	ActionFactoryReset: {"factory-reset", "factory-reset"},
+	ActionApplyPolicy:  {"applied a policy to", "apply a policy to"},
+
+	ActionConfigureOLT:  {"configured", "configure"},
+	ActionSyncOLT:       {"synced", "sync"},
+	ActionAckAlarm:      {"acknowledged", "acknowledge"},
+	ActionRegisterONT:   {"registered", "register"},
+	ActionDeregisterONT: {"deregistered", "deregister"},
+
+	ActionPasswordReset: {"reset the password of", "reset the password of"},
+	ActionMFAEnable:     {"enabled MFA for", "enable MFA for"},
+	ActionMFADisable:    {"disabled MFA for", "disable MFA for"},
+	ActionRoleChange:    {"changed the role of", "change the role of"},
+
+	ActionDisableTenant: {"disabled", "disable"},
+	ActionEnableTenant:  {"enabled", "enable"},
+	ActionFeatureToggle: {"toggled a feature of", "toggle a feature of"},
+}
+
+// maxNarrativeFields is how many changed fields a narrative names before
+// summing up the rest.
+const maxNarrativeFields = 3
+
+// Narrative renders event as the sentence the audit UI shows, e.g.
+//
+//	Rohit Kumar (NOC Engineer) updated mobile of Subscriber Ahmed
+//	Rohit Kumar (NOC Engineer) tried to delete Plan Gold (denied)
+//
+// The actor is UserName, else UserID, else "System": the Publisher keeps
+// IDs only, so resolve UserName before rendering to show names. The
+// object is ResourceName, else the resource and its ID.
+func Narrative(event AuditEvent) string {
+	var b strings.Builder
+
+	actor := event.UserName
+	if actor == "" {
+		actor = event.UserID
+	}
+	if actor == "" {
+		actor = "System"
+	}
+	b.WriteString(actor)
+	if event.UserRole != "" {
+		b.WriteString(" (" + event.UserRole + ")")
+	}
+
+	verb, ok := actionVerbs[event.Action]
+	if !ok {
+		action := strings.ToLower(strings.ReplaceAll(string(event.Action), "_", " "))
+		verb.past, verb.base = "performed "+action+" on", "perform "+action+" on"
+	}
+	failed := event.Status == StatusFailure || event.Status == StatusDenied
+	if failed {
+		b.WriteString(" tried to " + verb.base)
+	} else {
+		b.WriteString(" " + verb.past)
+	}
+
+	if fields := changedFields(event.Changes); fields != "" && !failed {
+		b.WriteString(" " + fields + " of")
+	}
+	b.WriteString(" " + narrativeObject(event))
+
+	if failed {
+		b.WriteString(" (" + strings.ToLower(string(event.Status)) + ")")
+	}
+	return b.String()
+}
+
+// changedFields names the changed fields: "mobile", "mobile and email",
+// "mobile, email, plan and 2 more fields".
+func changedFields(changes []Change) string {
+	var fields []string
+	seen := map[string]bool{}
+	for _, c := range changes {
+		if c.Field != "" && !seen[c.Field] {
+			seen[c.Field] = true
+			fields = append(fields, c.Field)
+		}
+	}
+	switch n := len(fields); {
+	case n == 0:
+		return ""
+	case n == 1:
+		return fields[0]
+	case n <= maxNarrativeFields:
+		return strings.Join(fields[:n-1], ", ") + " and " + fields[n-1]
+	default:
+		return strings.Join(fields[:maxNarrativeFields], ", ") + " and " + pluralMore(n-maxNarrativeFields)
+	}
+}
+
+func pluralMore(n int) string {
+	if n == 1 {
+		return "1 more field"
+	}
+	return strconv.Itoa(n) + " more fields"
+}
+
+// narrativeObject names the resource: "Subscriber Ahmed", "Tenant user
+// u-123", or "Subscriber" when nothing identifies it.
+func narrativeObject(event AuditEvent) string {
+	resource := strings.ReplaceAll(event.Resource, "-", " ")
+	if resource == "" || resource == "unknown" {
+		resource = "resource"
+	}
+	r := []rune(resource)
+	r[0] = unicode.ToUpper(r[0])
+	resource = string(r)
+
+	if event.ResourceName != "" {
+		if strings.HasPrefix(strings.ToLower(event.ResourceName), strings.ToLower(resource)+" ") {
+			return event.ResourceName // already "Subscriber Ahmed"
+		}
+		return resource + " " + event.ResourceName
+	}
+	if event.ResourceID != "" {
+		return resource + " " + event.ResourceID
+	}
+	return resource
+}
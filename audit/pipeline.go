@@ -0,0 +1,430 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/praction-networks/common/logger"
+	"github.com/praction-networks/common/metrics"
+)
+
+// Pipeline defaults.
+const (
+	DefaultPipelineQueueSize     = 4096
+	DefaultPipelineOverflowSize  = 1024
+	DefaultPipelineWorkers       = 4
+	DefaultPipelineBatchSize     = 64
+	DefaultPipelineFlushInterval = 200 * time.Millisecond
+	DefaultPipelineTimeout       = 10 * time.Second
+)
+
+// Pipeline outcomes, as counted in PipelineStats and
+// audit_pipeline_events_total.
+const (
+	OutcomePublished = "published"
+	OutcomeSpooled   = "spooled"
+	OutcomeDropped   = "dropped"
+)
+
+var (
+	errPipelineFull     = errors.New("audit pipeline queue full")
+	errPipelineOverflow = errors.New("audit pipeline queue and spool backlog full")
+	errPipelineStopped  = errors.New("audit pipeline stopped")
+)
+
+// PipelineConfig tunes a Pipeline; zero fields take the defaults.
+type PipelineConfig struct {
+	// QueueSize bounds the events waiting to be published, split evenly
+	// between the workers.
+	QueueSize int
+
+	// OverflowSize bounds the events waiting for the spool after finding
+	// the queue full. One goroutine spools them, so a slow spool never
+	// holds up Enqueue; beyond OverflowSize events are dropped.
+	OverflowSize int
+
+	// Workers publish concurrently. Events of one tenant always go to the
+	// same worker, so they are published in the order they were queued.
+	Workers int
+
+	// BatchSize is how many events a worker publishes in one round of
+	// async publishes; a partial batch goes out after FlushInterval.
+	BatchSize     int
+	FlushInterval time.Duration
+
+	// Timeout bounds each batch's wait for acks and each spool write.
+	Timeout time.Duration
+
+	// Spool takes the events that can't be published; default the
+	// publisher's (see Publisher.WithSpool). Without one they are dropped,
+	// logged and counted.
+	Spool Spool
+}
+
+// PipelineStats counts the events that left a Pipeline, by outcome.
+type PipelineStats struct {
+	Published uint64
+	Spooled   uint64
+	Dropped   uint64
+}
+
+// Pipeline publishes audit events off the request path with bounded memory
+// and goroutines: a fixed queue feeding a fixed pool of workers that publish
+// in batches. An event that can't be queued (queue full) or published (NATS
+// down, timeout) goes to the Spool instead, so a NATS blip costs latency in
+// the replay, not audit records. Only events queued after Drain are spooled
+// by the caller.
+//
+//	pipeline := audit.NewPipeline(publisher, audit.PipelineConfig{
+//		Spool: audit.NewFallbackSpool(db.Collection("failed_nats_events")),
+//	})
+//	r.Use(audit.Middleware(publisher, "subscriber-service")) // now queues
+//	...
+//	<-shutdown
+//	_ = pipeline.Drain(drainCtx)
+//
+// With WithIntegrity, spooled events keep the chain position they were
+// sealed with. ChainVerifier links events by seq, so one replayed after
+// later events of its chain still verifies; a dropped sealed event shows
+// up as a gap.
+type Pipeline struct {
+	publisher *Publisher
+	cfg       PipelineConfig
+	queues    []chan AuditEvent
+	overflow  chan AuditEvent
+
+	// ctx is cancelled when a drain runs out of time; workers then spool
+	// instead of publishing.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+
+	published, spooled, dropped atomic.Uint64
+}
+
+// NewPipeline starts a pipeline for publisher and attaches it, so
+// publisher.PublishAsync and Middleware queue through it. Call Drain on
+// shutdown.
+func NewPipeline(publisher *Publisher, cfg PipelineConfig) *Pipeline {
+	pl := newPipeline(publisher, cfg)
+	pl.start()
+	return pl
+}
+
+func newPipeline(publisher *Publisher, cfg PipelineConfig) *Pipeline {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultPipelineQueueSize
+	}
+	if cfg.OverflowSize <= 0 {
+		cfg.OverflowSize = DefaultPipelineOverflowSize
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultPipelineWorkers
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultPipelineBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultPipelineFlushInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultPipelineTimeout
+	}
+	if cfg.Spool == nil {
+		cfg.Spool = publisher.spool
+	}
+
+	pl := &Pipeline{publisher: publisher, cfg: cfg, queues: make([]chan AuditEvent, cfg.Workers),
+		overflow: make(chan AuditEvent, cfg.OverflowSize)}
+	for i := range pl.queues {
+		pl.queues[i] = make(chan AuditEvent, max(1, cfg.QueueSize/cfg.Workers))
+	}
+	pl.ctx, pl.cancel = context.WithCancel(context.Background())
+	publisher.pipeline = pl
+	return pl
+}
+
+func (pl *Pipeline) start() {
+	logger.GoroutineStarted("audit-pipeline", "service", pl.publisher.serviceName,
+		"workers", pl.cfg.Workers, "queueSize", pl.cfg.QueueSize)
+	for _, queue := range pl.queues {
+		pl.wg.Add(1)
+		go pl.work(queue)
+	}
+	pl.wg.Add(1)
+	go pl.spoolOverflow()
+}
+
+// Enqueue queues event without blocking. Caller identity is taken from ctx
+// now, as for Publish. When the queue is full the event is handed to the
+// overflow spooler, and dropped if that is backed up too. Once the pipeline
+// is drained the event is spooled by the caller instead.
+func (pl *Pipeline) Enqueue(ctx context.Context, event AuditEvent) {
+	event.ID = uuid.New().String()
+	pl.publisher.enrich(ctx, &event)
+
+	pl.mu.RLock()
+	if !pl.closed {
+		defer pl.mu.RUnlock()
+		select {
+		case pl.queues[pl.shard(chainOf(&event))] <- event:
+			metrics.SetAuditPipelineQueueDepth(pl.publisher.serviceName, pl.depth())
+			return
+		default:
+		}
+		select {
+		case pl.overflow <- event:
+		default:
+			pl.drop(&event, errPipelineOverflow)
+		}
+		return
+	}
+	pl.mu.RUnlock()
+
+	spoolCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), pl.cfg.Timeout)
+	defer cancel()
+	pl.spool(spoolCtx, []AuditEvent{event}, errPipelineStopped)
+}
+
+// Drain stops accepting events, publishes what is queued and waits for the
+// workers. If ctx ends first, the remaining events are spooled and an error
+// wrapping ctx.Err() is returned.
+func (pl *Pipeline) Drain(ctx context.Context) error {
+	pl.mu.Lock()
+	if pl.closed {
+		pl.mu.Unlock()
+		return nil
+	}
+	pl.closed = true
+	for _, queue := range pl.queues {
+		close(queue)
+	}
+	close(pl.overflow)
+	pl.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		pl.wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		pl.cancel()
+		<-done
+		err = fmt.Errorf("audit pipeline %s: drain: %w", pl.publisher.serviceName, ctx.Err())
+	}
+	pl.cancel()
+
+	stats := pl.Stats()
+	logger.GoroutineStopped("audit-pipeline", err, "service", pl.publisher.serviceName,
+		"published", stats.Published, "spooled", stats.Spooled, "dropped", stats.Dropped)
+	return err
+}
+
+// Stats returns the pipeline's counts since it started.
+func (pl *Pipeline) Stats() PipelineStats {
+	return PipelineStats{
+		Published: pl.published.Load(),
+		Spooled:   pl.spooled.Load(),
+		Dropped:   pl.dropped.Load(),
+	}
+}
+
+func (pl *Pipeline) shard(chain string) int {
+	h := fnv.New32a()
+	h.Write([]byte(chain))
+	return int(h.Sum32() % uint32(len(pl.queues)))
+}
+
+func (pl *Pipeline) depth() int {
+	n := 0
+	for _, queue := range pl.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+// spoolOverflow spools the events Enqueue found no queue room for until
+// the pipeline is drained.
+func (pl *Pipeline) spoolOverflow() {
+	defer pl.wg.Done()
+	for event := range pl.overflow {
+		spoolCtx, cancel := context.WithTimeout(context.Background(), pl.cfg.Timeout)
+		pl.spool(spoolCtx, []AuditEvent{event}, errPipelineFull)
+		cancel()
+	}
+}
+
+// work publishes queue's events in batches until the queue is closed and
+// empty, or until a timed-out drain aborts it.
+func (pl *Pipeline) work(queue chan AuditEvent) {
+	defer pl.wg.Done()
+	ticker := time.NewTicker(pl.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]AuditEvent, 0, pl.cfg.BatchSize)
+	for {
+		select {
+		case event, ok := <-queue:
+			if !ok {
+				pl.flush(batch)
+				return
+			}
+			if batch = append(batch, event); len(batch) < pl.cfg.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-pl.ctx.Done():
+			// Drain ran out of time, and has closed the queue
+			for event := range queue {
+				batch = append(batch, event)
+			}
+			spoolCtx, cancel := context.WithTimeout(context.Background(), pl.cfg.Timeout)
+			pl.spool(spoolCtx, batch, errPipelineStopped)
+			cancel()
+			return
+		}
+		pl.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// flush publishes batch asynchronously and spools the events that weren't
+// acked within Timeout.
+func (pl *Pipeline) flush(batch []AuditEvent) {
+	if len(batch) == 0 {
+		return
+	}
+	defer metrics.SetAuditPipelineQueueDepth(pl.publisher.serviceName, pl.depth())
+	if pl.ctx.Err() != nil {
+		spoolCtx, cancel := context.WithTimeout(context.Background(), pl.cfg.Timeout)
+		defer cancel()
+		pl.spool(spoolCtx, batch, errPipelineStopped)
+		return
+	}
+
+	if pl.publisher.streamManager == nil || pl.publisher.streamManager.JsClient == nil {
+		spoolCtx, cancel := context.WithTimeout(context.Background(), pl.cfg.Timeout)
+		defer cancel()
+		pl.spool(spoolCtx, batch, errors.New("audit publisher not initialized"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(pl.ctx, pl.cfg.Timeout)
+	defer cancel()
+	js := pl.publisher.streamManager.JsClient
+
+	type pending struct {
+		event  *AuditEvent
+		data   []byte
+		future jetstream.PubAckFuture
+		err    error
+	}
+	items := make([]pending, 0, len(batch))
+	for i := range batch {
+		event := &batch[i]
+		data, err := pl.encode(ctx, event)
+		if err != nil {
+			pl.drop(event, err)
+			continue
+		}
+		item := pending{event: event, data: data}
+		subject := pl.publisher.resolveSubject(event.Resource)
+		item.future, item.err = js.PublishMsgAsync(&nats.Msg{Subject: string(subject), Data: data}, jetstream.WithMsgID(event.ID))
+		items = append(items, item)
+	}
+
+	published := 0
+	var failed []pending
+	for _, item := range items {
+		err := item.err
+		if err == nil {
+			select {
+			case <-item.future.Ok():
+				published++
+				continue
+			case err = <-item.future.Err():
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+		item.err = err
+		failed = append(failed, item)
+	}
+	if published > 0 {
+		pl.published.Add(uint64(published))
+		metrics.RecordAuditPipelineEvents(pl.publisher.serviceName, OutcomePublished, published)
+		logger.Debug("Audit events published", "service", pl.publisher.serviceName, "count", published)
+	}
+
+	if len(failed) == 0 {
+		return
+	}
+	spoolCtx, spoolCancel := context.WithTimeout(context.Background(), pl.cfg.Timeout)
+	defer spoolCancel()
+	for _, item := range failed {
+		pl.spoolEncoded(spoolCtx, item.event, item.data, fmt.Errorf("failed to publish audit event: %w", item.err))
+	}
+}
+
+// encode serializes event, sealing it under its chain lock. One that fails
+// to publish is spooled with its seal.
+func (pl *Pipeline) encode(ctx context.Context, event *AuditEvent) ([]byte, error) {
+	if pl.publisher.chain != nil {
+		unlock := pl.publisher.chain.lock(chainOf(event))
+		defer unlock()
+	}
+	return pl.publisher.encode(ctx, event)
+}
+
+// spool encodes and spools events that never reached a worker.
+func (pl *Pipeline) spool(ctx context.Context, batch []AuditEvent, cause error) {
+	for i := range batch {
+		event := &batch[i]
+		data, err := pl.encode(ctx, event)
+		if err != nil {
+			pl.drop(event, err)
+			continue
+		}
+		pl.spoolEncoded(ctx, event, data, cause)
+	}
+}
+
+func (pl *Pipeline) spoolEncoded(ctx context.Context, event *AuditEvent, data []byte, cause error) {
+	if pl.cfg.Spool == nil {
+		pl.drop(event, cause)
+		return
+	}
+	subject := pl.publisher.resolveSubject(event.Resource)
+	if err := pl.cfg.Spool.Spool(ctx, subject, event.ID, data, cause); err != nil {
+		pl.drop(event, errors.Join(cause, fmt.Errorf("spool: %w", err)))
+		return
+	}
+	pl.spooled.Add(1)
+	metrics.RecordAuditPipelineEvents(pl.publisher.serviceName, OutcomeSpooled, 1)
+	logger.Warn("Audit event spooled", "service", pl.publisher.serviceName, "subject", string(subject),
+		"eventId", event.ID, "reason", cause.Error())
+}
+
+func (pl *Pipeline) drop(event *AuditEvent, cause error) {
+	pl.dropped.Add(1)
+	metrics.RecordAuditPipelineEvents(pl.publisher.serviceName, OutcomeDropped, 1)
+	logger.Error("Audit event dropped", cause, "service", pl.publisher.serviceName,
+		"eventId", event.ID, "resource", event.Resource, "action", string(event.Action))
+}
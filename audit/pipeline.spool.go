@@ -0,0 +1,161 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/praction-networks/common/events"
+	"github.com/praction-networks/common/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Spool keeps the audit events a Pipeline could not publish — queue full,
+// NATS down, or still queued when a drain ran out of time — until they can
+// be replayed. data is the serialized event, msgID its ID, which JetStream
+// deduplicates on, so replaying an event that did make it is harmless.
+type Spool interface {
+	Spool(ctx context.Context, subject events.Subject, msgID string, data []byte, cause error) error
+}
+
+type fallbackSpool struct {
+	coll *mongo.Collection
+}
+
+// NewFallbackSpool spools into an events fallback collection, the one the
+// service's events.FallbackReplayer already drains back to JetStream.
+func NewFallbackSpool(coll *mongo.Collection) Spool {
+	return &fallbackSpool{coll: coll}
+}
+
+func (s *fallbackSpool) Spool(ctx context.Context, subject events.Subject, msgID string, data []byte, cause error) error {
+	if s.coll == nil {
+		return errors.New("audit: fallback spool has no collection")
+	}
+	return events.StoreFallback(ctx, s.coll, events.AuditGlobalStream, subject, msgID, data, 0, cause)
+}
+
+// FileSpool spools to a local file, one events.FailedNATSEvent JSON document
+// per line, for services without Mongo. Replay it on startup and
+// periodically; events survive a restart only if path is on a persistent
+// volume.
+type FileSpool struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileSpool spools to path, created on first use.
+func NewFileSpool(path string) *FileSpool {
+	return &FileSpool{path: path}
+}
+
+// Spool implements Spool.
+func (s *FileSpool) Spool(_ context.Context, subject events.Subject, msgID string, data []byte, cause error) error {
+	doc := events.FailedNATSEvent{
+		ID:         fmt.Sprintf("%s|%s", events.AuditGlobalStream, msgID),
+		StreamName: string(events.AuditGlobalStream),
+		Subject:    string(subject),
+		Payload:    data,
+		Timestamp:  time.Now(),
+	}
+	if cause != nil {
+		doc.LastError = cause.Error()
+	}
+	line, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("audit: open spool file: %w", err)
+	}
+	if _, err = f.Write(append(line, '\n')); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("audit: write spool file: %w", err)
+	}
+	return nil
+}
+
+// Replay republishes every spooled event and rewrites the file with the
+// ones that still failed. It returns how many were published.
+func (s *FileSpool) Replay(ctx context.Context, streamManager *events.JsStreamManager) (int, error) {
+	if streamManager == nil || streamManager.JsClient == nil {
+		return 0, fmt.Errorf("audit publisher not initialized")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("audit: read spool file: %w", err)
+	}
+
+	var keep bytes.Buffer
+	replayed := 0
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var doc events.FailedNATSEvent
+		if err := json.Unmarshal(line, &doc); err != nil {
+			logger.Error("Dropping undecodable audit spool entry", err, "path", s.path)
+			continue
+		}
+		_, msgID, _ := strings.Cut(doc.ID, "|")
+		_, perr := streamManager.JsClient.PublishMsg(ctx, &nats.Msg{Subject: doc.Subject, Data: doc.Payload},
+			jetstream.WithMsgID(msgID), jetstream.WithExpectStream(doc.StreamName))
+		if perr != nil {
+			doc.Attempts++
+			doc.LastError = perr.Error()
+			doc.Timestamp = time.Now()
+			line, _ = json.Marshal(doc)
+			keep.Write(line)
+			keep.WriteByte('\n')
+			continue
+		}
+		replayed++
+	}
+	if err := scanner.Err(); err != nil {
+		return replayed, fmt.Errorf("audit: read spool file: %w", err)
+	}
+
+	if keep.Len() == 0 {
+		err = os.Remove(s.path)
+	} else {
+		// Write-then-rename, so a crash mid-rewrite never loses the spool
+		tmp := s.path + ".tmp"
+		if err = os.WriteFile(tmp, keep.Bytes(), 0o600); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		return replayed, fmt.Errorf("audit: rewrite spool file: %w", err)
+	}
+	if replayed > 0 {
+		logger.Info("Replayed spooled audit events", "path", s.path, "replayed", replayed)
+	}
+	return replayed, nil
+}
@@ -0,0 +1,95 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/praction-networks/common/events"
+	"github.com/praction-networks/common/events/eventstest"
+)
+
+func newPipelinePublisher(t *testing.T) (*Publisher, *eventstest.JetStream) {
+	t.Helper()
+	js := eventstest.New()
+	js.AddStreams(t, events.AuditGlobalStream)
+	return NewPublisher(js.StreamManager(), "test-service"), js
+}
+
+func drain(t *testing.T, pl *Pipeline) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), eventstest.DefaultWaitTimeout)
+	defer cancel()
+	if err := pl.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPipelinePublishesInBatches(t *testing.T) {
+	p, js := newPipelinePublisher(t)
+	pl := NewPipeline(p, PipelineConfig{QueueSize: 64, Workers: 3, BatchSize: 5, FlushInterval: time.Hour})
+	for i := range 23 {
+		tenant := []string{"t1", "t2", "t3", "t4"}[i%4]
+		p.PublishAsync(context.Background(), AuditEvent{TenantID: tenant, Resource: "subscriber", Action: ActionUpdate})
+	}
+	drain(t, pl)
+
+	js.AssertPublished(t, "audit.>", 23)
+	if stats := pl.Stats(); stats != (PipelineStats{Published: 23}) {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	// After Drain, events are spooled (here: dropped, there is no spool)
+	p.PublishAsync(context.Background(), AuditEvent{TenantID: "t1", Resource: "plan"})
+	if stats := pl.Stats(); stats.Dropped != 1 {
+		t.Fatalf("stats after drain = %+v", stats)
+	}
+}
+
+func TestPipelineSpoolsFailuresAndOverflow(t *testing.T) {
+	p, js := newPipelinePublisher(t)
+	spool := NewFileSpool(filepath.Join(t.TempDir(), "audit.spool"))
+	pl := newPipeline(p, PipelineConfig{QueueSize: 4, OverflowSize: 1, Workers: 1, BatchSize: 10, Spool: spool})
+
+	// Goroutines not started yet: the queue holds 4 and the overflow 1,
+	// the last is dropped; Enqueue itself spools nothing
+	for range 6 {
+		pl.Enqueue(context.Background(), AuditEvent{TenantID: "t1", Resource: "plan"})
+	}
+	if stats := pl.Stats(); stats != (PipelineStats{Dropped: 1}) {
+		t.Fatalf("stats after overflow = %+v", stats)
+	}
+
+	js.FailPublish(events.AuditPlanActionSubject, errors.New("nats down"), 3)
+	pl.start()
+	drain(t, pl)
+	if stats := pl.Stats(); stats != (PipelineStats{Published: 1, Spooled: 4, Dropped: 1}) {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	n, err := spool.Replay(context.Background(), js.StreamManager())
+	if err != nil || n != 4 {
+		t.Fatalf("Replay = %d, %v", n, err)
+	}
+	js.AssertPublished(t, events.AuditPlanActionSubject, 5)
+	if n, err := spool.Replay(context.Background(), js.StreamManager()); err != nil || n != 0 {
+		t.Fatalf("second Replay = %d, %v", n, err)
+	}
+}
+
+func TestPipelineKeepsChainsIntact(t *testing.T) {
+	p, js, keys := newChainedPublisher(t)
+	pl := NewPipeline(p, PipelineConfig{Workers: 4, BatchSize: 3, FlushInterval: time.Millisecond})
+	for i := range 40 {
+		p.PublishAsync(context.Background(), AuditEvent{TenantID: []string{"t1", "t2", ""}[i%3], Resource: "tenant"})
+	}
+	drain(t, pl)
+
+	for _, r := range VerifyChain(keys, publishedRaw(js)) {
+		if !r.OK() {
+			t.Errorf("chain %s: %v", r.Chain, r.Issues)
+		}
+	}
+}
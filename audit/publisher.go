@@ -3,10 +3,12 @@ package audit
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/praction-networks/common/events"
 	"github.com/praction-networks/common/helpers"
 	"github.com/praction-networks/common/logger"
@@ -35,6 +37,9 @@ var subjectMap = map[string]events.Subject{
 type Publisher struct {
 	streamManager *events.JsStreamManager
 	serviceName   string
+	chain         *chainer
+	spool         Spool
+	pipeline      *Pipeline
 }
 
 // NewPublisher creates a new audit event publisher for the given service.
@@ -47,15 +52,88 @@ func NewPublisher(streamManager *events.JsStreamManager, serviceName string) *Pu
 	}
 }
 
+// WithIntegrity makes p seal every event into its tenant's tamper-evident
+// chain (see Integrity). store must be shared by every publisher of the
+// trail; signer's key is what auditors verify against.
+func (p *Publisher) WithIntegrity(store ChainStore, signer Signer) *Publisher {
+	p.chain = &chainer{store: store, signer: signer}
+	return p
+}
+
+// WithSpool makes Publish spool an event it could not publish instead of
+// failing, and is the default Spool of a Pipeline attached to p. With
+// WithIntegrity, this keeps a failed publish from leaving a gap in its
+// chain.
+func (p *Publisher) WithSpool(spool Spool) *Publisher {
+	p.spool = spool
+	return p
+}
+
+// Publish attempts and the pause between them. Every attempt sends the
+// same bytes under the event ID, so JetStream stores the event once even
+// when an attempt timed out after the event was stored.
+const (
+	publishAttempts   = 3
+	publishRetryDelay = 100 * time.Millisecond
+)
+
 // Publish sends an audit event synchronously. Returns an error if
-// marshal or JetStream publish fails. Audit publish should rarely block
-// callers — prefer PublishAsync from request paths.
+// marshal or JetStream publish fails, unless the event was spooled (see
+// WithSpool). Audit publish should rarely block callers — prefer
+// PublishAsync from request paths.
 func (p *Publisher) Publish(ctx context.Context, event AuditEvent) error {
 	if p == nil || p.streamManager == nil || p.streamManager.JsClient == nil {
 		return fmt.Errorf("audit publisher not initialized")
 	}
 
 	event.ID = uuid.New().String()
+	p.enrich(ctx, &event)
+
+	if p.chain != nil {
+		// One event of a chain at a time, so events reach the stream in
+		// chain order
+		unlock := p.chain.lock(chainOf(&event))
+		defer unlock()
+	}
+	data, err := p.encode(ctx, &event)
+	if err != nil {
+		return err
+	}
+
+	subject := p.resolveSubject(event.Resource)
+
+	for attempt := 1; ; attempt++ {
+		_, err = p.streamManager.JsClient.Publish(ctx, string(subject), data, jetstream.WithMsgID(event.ID))
+		if err == nil || attempt == publishAttempts || ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-time.After(time.Duration(attempt) * publishRetryDelay):
+		case <-ctx.Done():
+		}
+	}
+	if err != nil {
+		logger.Error("Failed to publish audit event", err, "subject", string(subject), "resource", event.Resource)
+		err = fmt.Errorf("failed to publish audit event: %w", err)
+		if p.spool == nil {
+			return err
+		}
+		spoolCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		if spoolErr := p.spool.Spool(spoolCtx, subject, event.ID, data, err); spoolErr != nil {
+			return errors.Join(err, fmt.Errorf("spool: %w", spoolErr))
+		}
+		logger.Warn("Audit event spooled", "service", p.serviceName, "subject", string(subject), "eventId", event.ID, "reason", err.Error())
+		return nil
+	}
+
+	logger.Debug("Audit event published", "subject", string(subject), "action", string(event.Action), "resource", event.Resource, "resourceId", event.ResourceID)
+	return nil
+}
+
+// enrich fills the fields Publish owns: service, timestamp, and the caller
+// identity from ctx when not already set.
+func (p *Publisher) enrich(ctx context.Context, event *AuditEvent) {
 	event.Service = p.serviceName
 
 	if event.Timestamp.IsZero() {
@@ -76,31 +154,39 @@ func (p *Publisher) Publish(ctx context.Context, event AuditEvent) error {
 	// The audit log keeps IDs only — frontend resolves userId → name
 	// against tenant-user-service at render time. Keeps the audit
 	// store immutable + small, avoids stale snapshots when users rename.
+}
 
-	data, err := json.Marshal(event)
-	if err != nil {
-		logger.Error("Failed to marshal audit event", err, "resource", event.Resource, "action", string(event.Action))
-		return fmt.Errorf("failed to marshal audit event: %w", err)
+// encode serializes event, sealing it into its chain when p has one. The
+// caller holds the chain lock; a sealed event keeps its sequence number
+// whether or not it is ever stored (see chainer.seal).
+func (p *Publisher) encode(ctx context.Context, event *AuditEvent) (data []byte, err error) {
+	if p.chain != nil {
+		if data, err = p.chain.seal(ctx, event); err != nil {
+			logger.Error("Failed to seal audit event", err, "resource", event.Resource, "action", string(event.Action))
+			return nil, err
+		}
+		return data, nil
 	}
-
-	subject := p.resolveSubject(event.Resource)
-
-	if _, err = p.streamManager.JsClient.Publish(ctx, string(subject), data); err != nil {
-		logger.Error("Failed to publish audit event", err, "subject", string(subject), "resource", event.Resource)
-		return fmt.Errorf("failed to publish audit event: %w", err)
+	if data, err = json.Marshal(event); err != nil {
+		logger.Error("Failed to marshal audit event", err, "resource", event.Resource, "action", string(event.Action))
+		return nil, fmt.Errorf("failed to marshal audit event: %w", err)
 	}
-
-	logger.Debug("Audit event published", "subject", string(subject), "action", string(event.Action), "resource", event.Resource, "resourceId", event.ResourceID)
-	return nil
+	return data, nil
 }
 
-// PublishAsync sends an audit event in a goroutine with its own short
-// timeout so a slow / unavailable NATS never blocks the request path.
-// Errors are logged but not returned.
+// PublishAsync sends an audit event without blocking the caller. With a
+// Pipeline attached (see NewPipeline) the event is queued there; otherwise
+// it is published in a goroutine with its own short timeout so a slow /
+// unavailable NATS never blocks the request path. Errors are logged but
+// not returned.
 func (p *Publisher) PublishAsync(ctx context.Context, event AuditEvent) {
 	if p == nil {
 		return
 	}
+	if p.pipeline != nil {
+		p.pipeline.Enqueue(ctx, event)
+		return
+	}
 	// Snapshot ctx values that affect enrichment before detaching, since
 	// the caller's ctx may be cancelled by the time the goroutine runs.
 	if event.UserID == "" {
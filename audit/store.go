@@ -0,0 +1,158 @@
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/praction-networks/common/helpers"
+)
+
+// Store reads and writes persisted AuditEvents, so audit UIs and the
+// service that consumes AuditGlobalStream share one query implementation.
+// See audit/mongostore for the Mongo implementation.
+type Store interface {
+	// Save persists events. Saving an event again (a redelivery) is a
+	// no-op.
+	Save(ctx context.Context, events ...AuditEvent) error
+
+	// Get returns one event, ErrEventNotFound when it doesn't exist or
+	// lies outside the caller's tenants (see Query.TenantIDs).
+	Get(ctx context.Context, id string) (AuditEvent, error)
+
+	// Find returns one page of the events q selects, newest first.
+	Find(ctx context.Context, q Query) (Page, error)
+}
+
+// Query limits.
+const (
+	DefaultQueryLimit = 50
+	MaxQueryLimit     = 500
+)
+
+var (
+	// ErrEventNotFound is returned by Store.Get.
+	ErrEventNotFound = errors.New("audit: event not found")
+
+	// ErrTenantNotAccessible is returned when a query names only tenants
+	// outside the caller's accessible tenants.
+	ErrTenantNotAccessible = errors.New("audit: tenant not accessible")
+
+	// ErrBadCursor is returned for a Query.Cursor not issued by the store.
+	ErrBadCursor = errors.New("audit: malformed cursor")
+)
+
+// Query selects audit events. Zero fields don't filter.
+type Query struct {
+	// TenantIDs restricts the events to these tenants. A Store always
+	// narrows the tenants to helpers.GetAccessibleTenants(ctx) when the
+	// caller has that list (see guard.AccessibleTenantsMiddleware), so
+	// tenant users only ever see their own subtree; system users with no
+	// list and no TenantIDs see every tenant.
+	TenantIDs []string
+
+	// IncludeDescendants widens TenantIDs to their descendant tenants,
+	// through the Store's hierarchy cache.
+	IncludeDescendants bool
+
+	UserID     string
+	Resource   string
+	ResourceID string
+	Actions    []AuditAction
+	Statuses   []AuditStatus
+
+	// From and To bound Timestamp: From inclusive, To exclusive.
+	From time.Time
+	To   time.Time
+
+	// Cursor continues from a previous Page.NextCursor.
+	Cursor string
+
+	// Limit is the page size: default DefaultQueryLimit, at most
+	// MaxQueryLimit.
+	Limit int
+}
+
+// Page is one page of a Find.
+type Page struct {
+	Events []AuditEvent `json:"events"`
+
+	// NextCursor fetches the next page; empty on the last one.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// PageLimit returns the effective page size.
+func (q Query) PageLimit() int {
+	switch {
+	case q.Limit <= 0:
+		return DefaultQueryLimit
+	case q.Limit > MaxQueryLimit:
+		return MaxQueryLimit
+	default:
+		return q.Limit
+	}
+}
+
+// Descendants is the part of hierarchy.TenantHierarchyCache a Store needs.
+type Descendants interface {
+	GetDescendants(parentID string) []string
+}
+
+// ResolveTenants returns the tenants a Store must restrict q to, with
+// descendants expanded through hierarchy (may be nil) and narrowed to the
+// caller's accessible tenants. all reports that the caller may read every
+// tenant and q names none.
+func (q Query) ResolveTenants(ctx context.Context, hierarchy Descendants) (tenants []string, all bool, err error) {
+	tenants = slices.Clone(q.TenantIDs)
+	if q.IncludeDescendants && hierarchy != nil {
+		for _, id := range q.TenantIDs {
+			tenants = append(tenants, hierarchy.GetDescendants(id)...)
+		}
+	}
+	slices.Sort(tenants)
+	tenants = slices.Compact(tenants)
+
+	accessible := helpers.GetAccessibleTenants(ctx)
+	switch {
+	case accessible == nil && len(tenants) == 0:
+		return nil, true, nil
+	case accessible == nil:
+		return tenants, false, nil
+	case len(tenants) == 0:
+		return slices.Clone(accessible), false, nil
+	}
+	tenants = slices.DeleteFunc(tenants, func(id string) bool { return !slices.Contains(accessible, id) })
+	if len(tenants) == 0 {
+		return nil, false, ErrTenantNotAccessible
+	}
+	return tenants, false, nil
+}
+
+// EncodeCursor builds the Page.NextCursor that continues after event: the
+// sort position (timestamp, ID) of the last event of a page.
+func EncodeCursor(event AuditEvent) string {
+	raw := strconv.FormatInt(event.Timestamp.UnixMilli(), 10) + "|" + event.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor returns the sort position a cursor continues after.
+func DecodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("%w: %v", ErrBadCursor, err)
+	}
+	ms, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return time.Time{}, "", ErrBadCursor
+	}
+	n, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("%w: %v", ErrBadCursor, err)
+	}
+	return time.UnixMilli(n).UTC(), id, nil
+}
@@ -0,0 +1,87 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/praction-networks/common/helpers"
+)
+
+type fakeHierarchy map[string][]string
+
+func (h fakeHierarchy) GetDescendants(parentID string) []string { return h[parentID] }
+
+func TestResolveTenants(t *testing.T) {
+	h := fakeHierarchy{"isp": {"reseller-a", "reseller-b"}, "reseller-a": {"branch-1"}}
+	system := context.Background()
+	tenant := helpers.SetAccessibleTenants(context.Background(), []string{"reseller-a", "branch-1"})
+
+	cases := []struct {
+		name    string
+		ctx     context.Context
+		q       Query
+		want    []string
+		all     bool
+		wantErr error
+	}{
+		{name: "system sees all", ctx: system, all: true},
+		{name: "system named", ctx: system, q: Query{TenantIDs: []string{"isp"}}, want: []string{"isp"}},
+		{name: "descendants", ctx: system, q: Query{TenantIDs: []string{"isp"}, IncludeDescendants: true},
+			want: []string{"isp", "reseller-a", "reseller-b"}},
+		{name: "tenant defaults to accessible", ctx: tenant, want: []string{"reseller-a", "branch-1"}},
+		{name: "tenant narrowed", ctx: tenant, q: Query{TenantIDs: []string{"isp"}, IncludeDescendants: true},
+			want: []string{"reseller-a"}},
+		{name: "tenant outside", ctx: tenant, q: Query{TenantIDs: []string{"reseller-b"}}, wantErr: ErrTenantNotAccessible},
+	}
+	for _, tc := range cases {
+		got, all, err := tc.q.ResolveTenants(tc.ctx, h)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: err %v, want %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if all != tc.all || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v (all %v), want %v (all %v)", tc.name, got, all, tc.want, tc.all)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	ts := time.Date(2026, 3, 1, 10, 0, 0, 123e6, time.UTC)
+	gotTS, gotID, err := DecodeCursor(EncodeCursor(AuditEvent{ID: "evt|1", Timestamp: ts}))
+	if err != nil || !gotTS.Equal(ts) || gotID != "evt|1" {
+		t.Fatalf("round trip: %v %q %v", gotTS, gotID, err)
+	}
+	for _, bad := range []string{"!!", "bm9waXBl", "eHw="} {
+		if _, _, err := DecodeCursor(bad); !errors.Is(err, ErrBadCursor) {
+			t.Errorf("DecodeCursor(%q) = %v", bad, err)
+		}
+	}
+	if got := (Query{Limit: 10000}).PageLimit(); got != MaxQueryLimit {
+		t.Errorf("PageLimit = %d", got)
+	}
+}
+
+func TestNarrative(t *testing.T) {
+	cases := []struct {
+		event AuditEvent
+		want  string
+	}{
+		{AuditEvent{UserName: "Rohit Kumar", UserRole: "NOC Engineer", Action: ActionUpdate, Resource: "subscriber",
+			ResourceName: "Ahmed", Status: StatusSuccess, Changes: []Change{{Field: "mobile"}}},
+			"Rohit Kumar (NOC Engineer) updated mobile of Subscriber Ahmed"},
+		{AuditEvent{UserName: "Rohit Kumar", UserRole: "NOC Engineer", Action: ActionDelete, Resource: "plan",
+			ResourceName: "Plan Gold", Status: StatusDenied}, "Rohit Kumar (NOC Engineer) tried to delete Plan Gold (denied)"},
+		{AuditEvent{UserID: "u-1", Action: ActionUpdate, Resource: "tenant-user", ResourceID: "u-123", Status: StatusSuccess,
+			Changes: []Change{{Field: "a"}, {Field: "b"}, {Field: "c"}, {Field: "d"}, {Field: "e"}}},
+			"u-1 updated a, b, c and 2 more fields of Tenant user u-123"},
+		{AuditEvent{Action: ActionReboot, Resource: "device", Status: StatusSuccess}, "System rebooted Device"},
+	}
+	for _, tc := range cases {
+		if got := Narrative(tc.event); got != tc.want {
+			t.Errorf("Narrative = %q, want %q", got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+// Package catalog registers the payload types of the platform's event
+// subjects in an events.SchemaRegistry. It lives outside the events package
+// because it imports the per-domain model packages, which events must not.
+//
+// Services that validate incoming payloads call Register(events.Schemas) at
+// boot and set Listener.Schemas. The catalog test compares the generated
+// schemas with testdata/schemas.snapshot.json so a breaking payload change
+// fails CI; regenerate it with
+//
+//	go test ./events/catalog -run TestSchemaSnapshot -update
+//
+// after bumping the subject's version here.
+package catalog
+
+import (
+	"github.com/praction-networks/common/events"
+	"github.com/praction-networks/common/events/models/authevent"
+	"github.com/praction-networks/common/events/models/billingservice"
+	"github.com/praction-networks/common/events/models/captiveportalevent"
+	"github.com/praction-networks/common/events/models/captiveportalservice"
+	"github.com/praction-networks/common/events/models/global"
+	"github.com/praction-networks/common/events/models/inventoryevent"
+	"github.com/praction-networks/common/events/models/licenseevent"
+	"github.com/praction-networks/common/events/models/oltevent"
+	"github.com/praction-networks/common/events/models/planservice"
+	"github.com/praction-networks/common/events/models/radiuseventmanager"
+	"github.com/praction-networks/common/events/models/subscriberevent"
+	"github.com/praction-networks/common/events/models/tenantevent"
+	"github.com/praction-networks/common/events/models/tenantuserevent"
+	"github.com/praction-networks/common/events/models/ticketevent"
+	"github.com/praction-networks/common/events/models/venueevent"
+)
+
+// Register adds every catalogued subject to r. Subjects left out are listed
+// in Unregistered.
+func Register(r *events.SchemaRegistry) {
+	registerTenant(r)
+	registerTenantUser(r)
+	registerOLT(r)
+	registerInventory(r)
+	registerSubscriber(r)
+	registerPlan(r)
+	registerTicket(r)
+	registerVenue(r)
+	registerBilling(r)
+	registerLicense(r)
+	registerGlobal(r)
+}
+
+// Unregistered lists the subjects Register leaves out, with the reason. A
+// subject without a payload type in events/models belongs here until one
+// is added; the catalog test fails on a subject that is in neither.
+var Unregistered = map[events.Subject]string{
+	events.KYCGatewayCreatedSubject: "no payload model",
+	events.KYCGatewayUpdateSubject:  "no payload model",
+	events.KYCGatewayDeleteSubject:  "no payload model",
+
+	events.OLTManagerSyncStartedSubject:          "no payload model",
+	events.OLTManagerSyncCompletedSubject:        "no payload model",
+	events.OLTManagerSyncFailedSubject:           "no payload model",
+	events.OLTManagerSyncPhaseStartedSubject:     "no payload model",
+	events.OLTManagerSyncPhaseProgressSubject:    "no payload model",
+	events.OLTManagerSyncPhaseCompletedSubject:   "no payload model",
+	events.OLTManagerSyncPhaseFailedSubject:      "no payload model",
+	events.OLTManagerCapabilityDetectedSubject:   "no payload model",
+	events.OLTManagerONTDiscoveredSubject:        "no payload model",
+	events.OLTManagerONTUpdatedSubject:           "no payload model",
+	events.OLTManagerONTDeletedSubject:           "no payload model",
+	events.OLTManagerONTRegisteredSubject:        "no payload model",
+	events.OLTManagerONTActivatedSubject:         "no payload model",
+	events.OLTManagerServicePortCreatedSubject:   "no payload model",
+	events.OLTManagerServicePortUpdatedSubject:   "no payload model",
+	events.OLTManagerServicePortDeletedSubject:   "no payload model",
+	events.OLTManagerSPReconcileStartedSubject:   "no payload model",
+	events.OLTManagerSPReconcileCompletedSubject: "no payload model",
+	events.OLTManagerONTStateTransitionSubject:   "no payload model",
+	events.OLTManagerAlarmReconciledSubject:      "no payload model",
+	events.OLTManagerHealthChangedSubject:        "no payload model",
+
+	events.InventoryStockTransferredSubject: "no payload model",
+	events.InventoryStockAdjustedSubject:    "no payload model",
+	events.InventoryStockProvisionedSubject: "no payload model",
+	events.InventoryStockLowSubject:         "no payload model",
+	events.InventoryInwardCreatedSubject:    "no payload model",
+	events.InventoryInwardPostedSubject:     "no payload model",
+
+	events.HotspotDeviceAddedSubject:   "no payload model",
+	events.HotspotDeviceRemovedSubject: "no payload model",
+
+	events.BillingPaymentCompletedSubject:    "no payload model",
+	events.BillingPaymentFailedSubject:       "no payload model",
+	events.BillingInvoiceCreatedSubject:      "no payload model",
+	events.BillingCreditNoteIssuedSubject:    "no payload model",
+	events.BillingDebitNoteIssuedSubject:     "no payload model",
+	events.BillingDunningReminderSubject:     "no payload model",
+	events.BillingDunningWarningSubject:      "no payload model",
+	events.BillingDunningSuspensionSubject:   "no payload model",
+	events.BillingDunningTerminationSubject:  "no payload model",
+	events.BillingInvoiceUpdatedSubject:      "no payload model",
+	events.BillingPaymentUpdatedSubject:      "no payload model",
+	events.BillingSubscriptionCreatedSubject: "no payload model",
+	events.BillingSubscriptionUpdatedSubject: "no payload model",
+	events.BillingCommissionCreatedSubject:   "no payload model",
+	events.BillingCommissionUpdatedSubject:   "no payload model",
+	events.BillingTransactionCreatedSubject:  "no payload model",
+	events.BillingTransactionUpdatedSubject:  "no payload model",
+	events.BillingReceiptCreatedSubject:      "no payload model",
+	events.BillingReceiptUpdatedSubject:      "no payload model",
+	events.BillingRefundCreatedSubject:       "no payload model",
+	events.BillingRefundUpdatedSubject:       "no payload model",
+
+	events.UserNotifcationVerifiedSubject:       "no payload model",
+	events.UserNotifcationExpiredSubject:        "no payload model",
+	events.UserNotifcationFailedSubject:         "no payload model",
+	events.UserPushNotificationSentSubject:      "no payload model",
+	events.UserPushNotificationDeliveredSubject: "no payload model",
+	events.UserPushNotificationFailedSubject:    "no payload model",
+	events.UserPushNotificationOpenedSubject:    "no payload model",
+
+	// The audit publisher sends audit.AuditEvent as the message body, not
+	// as the Data of an events.Event
+	events.AuditUserActionSubject:       "bare audit.AuditEvent body",
+	events.AuditAuthActionSubject:       "bare audit.AuditEvent body",
+	events.AuditTenantActionSubject:     "bare audit.AuditEvent body",
+	events.AuditSubscriberActionSubject: "bare audit.AuditEvent body",
+	events.AuditPlanActionSubject:       "bare audit.AuditEvent body",
+	events.AuditInventoryActionSubject:  "bare audit.AuditEvent body",
+	events.AuditTicketActionSubject:     "bare audit.AuditEvent body",
+	events.AuditSystemActionSubject:     "bare audit.AuditEvent body",
+
+	events.SyncBillingValidatePlanAssignment: "request-reply, not a stream event",
+}
+
+func registerTenant(r *events.SchemaRegistry) {
+	events.RegisterSchema[tenantevent.TenantInsertEventModel](r, events.TenantCreatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantUpdateEventModel](r, events.TenantUpdatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantDeleteEventModel](r, events.TenantDeletedSubject, 1)
+
+	// Providers
+	events.RegisterSchema[tenantevent.AppMessengerInsertEventModel](r, events.AppMessengerCreateSubject, 1)
+	events.RegisterSchema[tenantevent.AppMessengerUpdateEventModel](r, events.AppMessengerUpdateSubject, 1)
+	events.RegisterSchema[tenantevent.AppMessengerDeleteEventModel](r, events.AppMessengerDeleteSubject, 1)
+	events.RegisterSchema[tenantevent.CDNProviderInsertEventModel](r, events.CDNProviderCreatedSubject, 1)
+	events.RegisterSchema[tenantevent.CDNProviderUpdateEventModel](r, events.CDNProviderUpdateSubject, 1)
+	events.RegisterSchema[tenantevent.CDNProviderDeleteEventModel](r, events.CDNProviderDeleteSubject, 1)
+	events.RegisterSchema[tenantevent.PaymentGatewayInsertEventModel](r, events.PaymentGatewayCreatedSubject, 1)
+	events.RegisterSchema[tenantevent.PaymentGatewayUpdateEventModel](r, events.PaymentGatewayUpdateSubject, 1)
+	events.RegisterSchema[tenantevent.PaymentGatewayDeleteEventModel](r, events.PaymentGatewayDeleteSubject, 1)
+	events.RegisterSchema[tenantevent.RadiusProviderInsertEventModel](r, events.ExternalRadiusCreatedSubject, 1)
+	events.RegisterSchema[tenantevent.RadiusProviderUpdateEventModel](r, events.ExternalRadiusUpdateSubject, 1)
+	events.RegisterSchema[tenantevent.RadiusProviderDeleteEventModel](r, events.ExternalRadiusDeleteSubject, 1)
+	events.RegisterSchema[tenantevent.TenantSMSProviderInsertEventModel](r, events.SMSProviderCreatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantSMSProviderUpdateEventModel](r, events.SMSProviderUpdatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantSMSProviderDeleteEventModel](r, events.SMSProviderDeletedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantMailProviderInsertEventModel](r, events.MailProviderCreatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantMailProviderUpdateEventModel](r, events.MailProviderUpdatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantMailProviderDeleteEventModel](r, events.MailProviderDeletedSubject, 1)
+
+	// Network devices
+	events.RegisterSchema[tenantevent.DeviceInsertEventModel](r, events.DeviceCreatedSubject, 1)
+	events.RegisterSchema[tenantevent.DeviceUpdateEventModel](r, events.DeviceUpdatedSubject, 1)
+	events.RegisterSchema[tenantevent.DeviceDeleteEventModel](r, events.DeviceDeletedSubject, 1)
+	events.RegisterSchema[tenantevent.OLTInsertEventModel](r, events.OLTCreatedSubject, 1)
+	events.RegisterSchema[tenantevent.OLTUpdateEventModel](r, events.OLTUpdatedSubject, 1)
+	events.RegisterSchema[tenantevent.OLTDeleteEventModel](r, events.OLTDeletedSubject, 1)
+
+	// Provider bindings
+	events.RegisterSchema[tenantevent.TenantKYCProviderBindingInsertEventModel](r, events.TenantKYCProviderBindingCreatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantKYCProviderBindingUpdateEventModel](r, events.TenantKYCProviderBindingUpdatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantKYCProviderBindingDeleteEventModel](r, events.TenantKYCProviderBindingDeletedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantAppMessagingBindingInsertEventModel](r, events.TenantAppMessagingBindingCreatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantAppMessagingBindingUpdateEventModel](r, events.TenantAppMessagingBindingUpdatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantAppMessagingBindingDeleteEventModel](r, events.TenantAppMessagingBindingDeletedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantCDNProviderBindingInsertEventModel](r, events.TenantCDNProviderBindingCreatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantCDNProviderBindingUpdateEventModel](r, events.TenantCDNProviderBindingUpdatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantCDNProviderBindingDeleteEventModel](r, events.TenantCDNProviderBindingDeletedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantStorageProviderBindingInsertEventModel](r, events.TenantStorageProviderBindingCreatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantStorageProviderBindingUpdateEventModel](r, events.TenantStorageProviderBindingUpdatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantStorageProviderBindingDeleteEventModel](r, events.TenantStorageProviderBindingDeletedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantESignProviderBindingInsertEventModel](r, events.TenantESignProviderBindingCreatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantESignProviderBindingUpdateEventModel](r, events.TenantESignProviderBindingUpdatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantESignProviderBindingDeleteEventModel](r, events.TenantESignProviderBindingDeletedSubject, 1)
+
+	// Branding
+	events.RegisterSchema[tenantevent.TenantBrandingInsertEventModel](r, events.TenantBrandingCreatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantBrandingUpdateEventModel](r, events.TenantBrandingUpdatedSubject, 1)
+	events.RegisterSchema[tenantevent.TenantBrandingDeleteEventModel](r, events.TenantBrandingDeletedSubject, 1)
+}
+
+func registerTenantUser(r *events.SchemaRegistry) {
+	events.RegisterSchema[tenantuserevent.TenantUserCreateEvent](r, events.TenantUserCreatedSubject, 1)
+	events.RegisterSchema[tenantuserevent.TenantUserUpdateEvent](r, events.TenantUserUpdatedSubject, 1)
+	events.RegisterSchema[tenantuserevent.TenantUserDeleteEvent](r, events.TenantUserDeletedSubject, 1)
+	events.RegisterSchema[tenantuserevent.TenantUserPasswordSetEvent](r, events.TenantUserPasswordSetSubject, 1)
+	events.RegisterSchema[tenantuserevent.TenantUserPreferencesUpdatedEvent](r, events.TenantUserPreferencesUpdatedSubject, 1)
+	events.RegisterSchema[tenantuserevent.DeptTeamCreateEvent](r, events.DeptTeamCreatedSubject, 1)
+	events.RegisterSchema[tenantuserevent.DeptTeamUpdateEvent](r, events.DeptTeamUpdatedSubject, 1)
+	events.RegisterSchema[tenantuserevent.DeptTeamDeleteEvent](r, events.DeptTeamDeletedSubject, 1)
+	events.RegisterSchema[tenantuserevent.OrgUnitCreateEvent](r, events.OrgUnitCreatedSubject, 1)
+	events.RegisterSchema[tenantuserevent.OrgUnitUpdateEvent](r, events.OrgUnitUpdatedSubject, 1)
+	events.RegisterSchema[tenantuserevent.OrgUnitDeleteEvent](r, events.OrgUnitDeletedSubject, 1)
+
+	// Roles and sessions
+	events.RegisterSchema[authevent.RoleCreateEventModel](r, events.TenantUserRoleCreatedSubject, 1)
+	events.RegisterSchema[authevent.RoleUpdateEventModel](r, events.TenantUserRoleUpdatedSubject, 1)
+	events.RegisterSchema[authevent.RoleDeleteEventModel](r, events.TenantUserRoleDeletedSubject, 1)
+	events.RegisterSchema[authevent.AuthSessionRevokedEvent](r, events.AuthSessionRevokedSubject, 1)
+}
+
+func registerOLT(r *events.SchemaRegistry) {
+	events.RegisterSchema[oltevent.ONTDownEvent](r, events.OLTEventONTDownSubject, 1)
+	events.RegisterSchema[oltevent.ONTUpEvent](r, events.OLTEventONTUpSubject, 1)
+	events.RegisterSchema[oltevent.DyingGaspEvent](r, events.OLTEventDyingGaspSubject, 1)
+	events.RegisterSchema[oltevent.ONTDownEvent](r, events.OLTEventONTDeactivatedSubject, 1) // cause manual_deactivate
+	events.RegisterSchema[oltevent.LinkChangeEvent](r, events.OLTEventLinkDownSubject, 1)
+	events.RegisterSchema[oltevent.LinkChangeEvent](r, events.OLTEventLinkUpSubject, 1)
+	events.RegisterSchema[oltevent.LOSEvent](r, events.OLTEventLOSSubject, 1)
+	events.RegisterSchema[oltevent.LOSEvent](r, events.OLTEventLOSRecoveredSubject, 1)
+	events.RegisterSchema[oltevent.AlarmEvent](r, events.OLTEventAlarmActiveSubject, 1)
+	events.RegisterSchema[oltevent.AlarmEvent](r, events.OLTEventAlarmClearedSubject, 1)
+	events.RegisterSchema[oltevent.ColdStartEvent](r, events.OLTEventColdStartSubject, 1)
+	events.RegisterSchema[oltevent.AuthFailureEvent](r, events.OLTEventAuthFailureSubject, 1)
+	events.RegisterSchema[oltevent.UnknownTrapEvent](r, events.OLTEventTrapUnknownSubject, 1)
+}
+
+func registerInventory(r *events.SchemaRegistry) {
+	events.RegisterSchema[inventoryevent.DeviceInsertEventModel](r, events.InventoryDeviceCreatedSubject, 1)
+	events.RegisterSchema[inventoryevent.DeviceUpdateEventModel](r, events.InventoryDeviceUpdatedSubject, 1)
+	events.RegisterSchema[inventoryevent.DeviceDeleteEventModel](r, events.InventoryDeviceDeletedSubject, 1)
+
+	// Asset lifecycle
+	events.RegisterSchema[inventoryevent.AssetCreatedEvent](r, events.InventoryAssetCreatedSubject, 1)
+	events.RegisterSchema[inventoryevent.AssetTenantAssignedEvent](r, events.InventoryAssetTenantAssignedSubject, 1)
+	events.RegisterSchema[inventoryevent.AssetConditionChangedEvent](r, events.InventoryAssetConditionChangedSubject, 1)
+	events.RegisterSchema[inventoryevent.AssetAssignedEvent](r, events.InventoryAssetAssignedSubject, 1)
+	events.RegisterSchema[inventoryevent.AssetAssignmentFailedEvent](r, events.InventoryAssetAssignmentFailedSubject, 1)
+	events.RegisterSchema[inventoryevent.AssetInstalledEvent](r, events.InventoryAssetInstalledSubject, 1)
+	events.RegisterSchema[inventoryevent.AssetReturnedEvent](r, events.InventoryAssetReturnedSubject, 1)
+	events.RegisterSchema[inventoryevent.AssetFaultyEvent](r, events.InventoryAssetFaultySubject, 1)
+	events.RegisterSchema[inventoryevent.AssetRMAEvent](r, events.InventoryAssetRMASubject, 1)
+	events.RegisterSchema[inventoryevent.AssetScrappedEvent](r, events.InventoryAssetScrappedSubject, 1)
+}
+
+func registerSubscriber(r *events.SchemaRegistry) {
+	events.RegisterSchema[subscriberevent.SubscriberCreatedEvent](r, events.SubscriberCreatedSubject, 1)
+	events.RegisterSchema[subscriberevent.SubscriberUpdatedEvent](r, events.SubscriberUpdatedSubject, 1)
+	events.RegisterSchema[subscriberevent.SubscriberDeletedEvent](r, events.SubscriberDeletedSubject, 1)
+
+	// Broadband and CPE assignment
+	events.RegisterSchema[subscriberevent.BroadbandSubscriptionCreatedEvent](r, events.BroadbandSubscriptionCreatedSubject, 1)
+	events.RegisterSchema[subscriberevent.BroadbandSubscriptionUpdatedEvent](r, events.BroadbandSubscriptionUpdatedSubject, 1)
+	events.RegisterSchema[subscriberevent.BroadbandSubscriptionDeletedEvent](r, events.BroadbandSubscriptionDeletedSubject, 1)
+	events.RegisterSchema[subscriberevent.BroadbandCpeRequestedEvent](r, events.SubscriberCpeRequestedSubject, 1)
+	events.RegisterSchema[subscriberevent.BroadbandCpeReleasedEvent](r, events.SubscriberCpeReleasedSubject, 1)
+
+	// Hotspot
+	events.RegisterSchema[subscriberevent.HotspotProfileCreatedEvent](r, events.HotspotProfileCreatedSubject, 1)
+	events.RegisterSchema[subscriberevent.HotspotProfileUpdatedEvent](r, events.HotspotProfileUpdatedSubject, 1)
+	events.RegisterSchema[subscriberevent.HotspotProfileDeletedEvent](r, events.HotspotProfileDeletedSubject, 1)
+
+	// Field and form configuration
+	events.RegisterSchema[subscriberevent.FieldConfigCreatedEvent](r, events.FieldConfigCreatedSubject, 1)
+	events.RegisterSchema[subscriberevent.FieldConfigUpdatedEvent](r, events.FieldConfigUpdatedSubject, 1)
+	events.RegisterSchema[subscriberevent.FieldConfigDeletedEvent](r, events.FieldConfigDeletedSubject, 1)
+	events.RegisterSchema[subscriberevent.FormConfigCreatedEvent](r, events.FormConfigCreatedSubject, 1)
+	events.RegisterSchema[subscriberevent.FormConfigUpdatedEvent](r, events.FormConfigUpdatedSubject, 1)
+	events.RegisterSchema[subscriberevent.FormConfigDeletedEvent](r, events.FormConfigDeletedSubject, 1)
+
+	// Vouchers
+	events.RegisterSchema[subscriberevent.VoucherCreatedEvent](r, events.VoucherCreatedSubject, 1)
+	events.RegisterSchema[subscriberevent.VoucherUpdatedEvent](r, events.VoucherUpdatedSubject, 1)
+	events.RegisterSchema[subscriberevent.VoucherDeletedEvent](r, events.VoucherDeletedSubject, 1)
+
+	// RADIUS accounting
+	events.RegisterSchema[radiuseventmanager.RadAcctSessionStartEvent](r, events.RadiusAccountingRadAcctSessionStartSubject, 1)
+	events.RegisterSchema[radiuseventmanager.RadAcctSessionUpdateEvent](r, events.RadiusAccountingRadAcctSessionUpdateSubject, 1)
+	events.RegisterSchema[radiuseventmanager.RadAcctSessionEndEvent](r, events.RadiusAccountingRadAcctSessionEndSubject, 1)
+
+	// Captive portal
+	events.RegisterSchema[captiveportalservice.GuestHotspotSubscriberCreatedEvent](r, events.GuestHotspotSubscriberCreatedSubject, 1)
+	events.RegisterSchema[captiveportalservice.GuestHotspotSubscriberUpdatedEvent](r, events.GuestHotspotSubscriberUpdatedSubject, 1)
+	events.RegisterSchema[captiveportalservice.GuestHotspotSubscriberValidityExtendedEvent](r, events.GuestHotspotSubscriberValidityExtendedSubject, 1)
+	events.RegisterSchema[captiveportalservice.GuestHotspotDeviceAddedEvent](r, events.GuestHotspotDeviceAddedSubject, 1)
+	events.RegisterSchema[captiveportalevent.VoucherDetailsEvent](r, events.VoucherDetailsSubject, 1)
+}
+
+func registerPlan(r *events.SchemaRegistry) {
+	events.RegisterSchema[planservice.PlanCreatedEvent](r, events.PlanCreatedSubject, 1)
+	events.RegisterSchema[planservice.PlanUpdateEvent](r, events.PlanUpdatedSubject, 1)
+	events.RegisterSchema[planservice.PlanDeletedEvent](r, events.PlanDeletedSubject, 1)
+	events.RegisterSchema[planservice.PriceBookCreatedEvent](r, events.PriceBookCreatedSubject, 1)
+	events.RegisterSchema[planservice.PriceBookUpdatedEvent](r, events.PriceBookUpdatedSubject, 1)
+	events.RegisterSchema[planservice.PriceBookDeletedEvent](r, events.PriceBookDeletedSubject, 1)
+	events.RegisterSchema[planservice.PromotionCreatedEvent](r, events.PromotionCreatedSubject, 1)
+	events.RegisterSchema[planservice.PromotionUpdatedEvent](r, events.PromotionUpdatedSubject, 1)
+	events.RegisterSchema[planservice.PromotionDeletedEvent](r, events.PromotionDeletedSubject, 1)
+	events.RegisterSchema[planservice.CouponCreatedEvent](r, events.CouponCreatedSubject, 1)
+	events.RegisterSchema[planservice.CouponUpdatedEvent](r, events.CouponUpdatedSubject, 1)
+	events.RegisterSchema[planservice.CouponDeletedEvent](r, events.CouponDeletedSubject, 1)
+	events.RegisterSchema[planservice.ProductTemplateEvent](r, events.ProductCreatedSubject, 1)
+	events.RegisterSchema[planservice.ProductTemplateEvent](r, events.ProductUpdatedSubject, 1)
+	events.RegisterSchema[planservice.ProductTemplateEvent](r, events.ProductDeletedSubject, 1)
+}
+
+func registerTicket(r *events.SchemaRegistry) {
+	events.RegisterSchema[ticketevent.TicketCreatedEvent](r, events.TicketCreatedSubject, 1)
+	events.RegisterSchema[ticketevent.TicketUpdatedEvent](r, events.TicketUpdatedSubject, 1)
+	events.RegisterSchema[ticketevent.TicketAssignedEvent](r, events.TicketAssignedSubject, 1)
+	events.RegisterSchema[ticketevent.TicketStatusChangedEvent](r, events.TicketStatusChangedSubject, 1)
+	events.RegisterSchema[ticketevent.TicketResolvedEvent](r, events.TicketResolvedSubject, 1)
+	events.RegisterSchema[ticketevent.TicketClosedEvent](r, events.TicketClosedSubject, 1)
+	events.RegisterSchema[ticketevent.TicketEscalatedEvent](r, events.TicketEscalatedSubject, 1)
+	events.RegisterSchema[ticketevent.TicketReopenedEvent](r, events.TicketReopenedSubject, 1)
+	events.RegisterSchema[ticketevent.TicketMergedEvent](r, events.TicketMergedSubject, 1)
+	events.RegisterSchema[ticketevent.TicketSplitEvent](r, events.TicketSplitSubject, 1)
+	events.RegisterSchema[ticketevent.TicketCommentAddedEvent](r, events.TicketCommentAddedSubject, 1)
+	events.RegisterSchema[ticketevent.TicketMessageAddedEvent](r, events.TicketMessageAddedSubject, 1)
+	events.RegisterSchema[ticketevent.CustomerRepliedEvent](r, events.TicketCustomerRepliedSubject, 1)
+	events.RegisterSchema[ticketevent.TicketAttachmentAddedEvent](r, events.TicketAttachmentAddedSubject, 1)
+	events.RegisterSchema[ticketevent.SLABreachedEvent](r, events.TicketSLABreachedSubject, 1)
+	events.RegisterSchema[ticketevent.SLAStateChangedEvent](r, events.TicketSLAChangedSubject, 1)
+	events.RegisterSchema[ticketevent.AppointmentScheduledEvent](r, events.TicketAppointmentScheduledSubject, 1)
+	events.RegisterSchema[ticketevent.AppointmentStatusChangedEvent](r, events.TicketAppointmentStatusChangedSubject, 1)
+	events.RegisterSchema[ticketevent.ChecklistCreatedEvent](r, events.TicketChecklistCreatedSubject, 1)
+	events.RegisterSchema[ticketevent.ChecklistItemCompletedEvent](r, events.TicketChecklistItemCompletedSubject, 1)
+	events.RegisterSchema[ticketevent.AssignmentRequestedEvent](r, events.TicketAssignmentRequestedSubject, 1)
+	events.RegisterSchema[ticketevent.AssignmentApprovedEvent](r, events.TicketAssignmentApprovedSubject, 1)
+	events.RegisterSchema[ticketevent.AssignmentDeniedEvent](r, events.TicketAssignmentDeniedSubject, 1)
+	events.RegisterSchema[ticketevent.TechnicianStatusUpdateEvent](r, events.TicketTechnicianStatusUpdateSubject, 1)
+	events.RegisterSchema[ticketevent.TicketClassifiedEvent](r, events.TicketClassifiedSubject, 1)
+	events.RegisterSchema[ticketevent.TicketAutoLinkedEvent](r, events.TicketAutoLinkedSubject, 1)
+	events.RegisterSchema[ticketevent.TicketCreatedFromEmailEvent](r, events.TicketCreatedFromEmailSubject, 1)
+}
+
+func registerVenue(r *events.SchemaRegistry) {
+	events.RegisterSchema[venueevent.OrderEventModel](r, events.VenueOrderCreatedSubject, 1)
+	events.RegisterSchema[venueevent.OrderEventModel](r, events.VenueOrderUpdatedSubject, 1)
+	events.RegisterSchema[venueevent.OrderEventModel](r, events.VenueOrderDeletedSubject, 1)
+	events.RegisterSchema[venueevent.MenuEventModel](r, events.VenueMenuCreatedSubject, 1)
+	events.RegisterSchema[venueevent.MenuEventModel](r, events.VenueMenuUpdatedSubject, 1)
+	events.RegisterSchema[venueevent.MenuEventModel](r, events.VenueMenuDeletedSubject, 1)
+}
+
+func registerBilling(r *events.SchemaRegistry) {
+	events.RegisterSchema[billingservice.PriceBookCDCEvent](r, events.BillingPriceBookCreatedSubject, 1)
+	events.RegisterSchema[billingservice.PriceBookCDCEvent](r, events.BillingPriceBookUpdatedSubject, 1)
+	events.RegisterSchema[billingservice.PriceBookCDCEvent](r, events.BillingPriceBookDeletedSubject, 1)
+}
+
+func registerLicense(r *events.SchemaRegistry) {
+	// Lifecycle
+	events.RegisterSchema[licenseevent.IssuedEvent](r, events.LicenseIssuedSubject, 1)
+	events.RegisterSchema[licenseevent.EntitlementChangedEvent](r, events.LicenseEntitlementChangedSubject, 1)
+	events.RegisterSchema[licenseevent.SuspendedEvent](r, events.LicenseSuspendedSubject, 1)
+	events.RegisterSchema[licenseevent.ReinstatedEvent](r, events.LicenseReinstatedSubject, 1)
+	events.RegisterSchema[licenseevent.RenewedEvent](r, events.LicenseRenewedSubject, 1)
+	events.RegisterSchema[licenseevent.ExpiredEvent](r, events.LicenseExpiredSubject, 1)
+	events.RegisterSchema[licenseevent.TerminatedEvent](r, events.LicenseTerminatedSubject, 1)
+
+	// Tokens
+	events.RegisterSchema[licenseevent.TokenTopupEvent](r, events.LicenseTokenTopupSubject, 1)
+	events.RegisterSchema[licenseevent.TokenConsumedEvent](r, events.LicenseTokenConsumedSubject, 1)
+	events.RegisterSchema[licenseevent.TokenLowBalanceEvent](r, events.LicenseTokenLowBalanceSubject, 1)
+	events.RegisterSchema[licenseevent.TokenExhaustedEvent](r, events.LicenseTokenExhaustedSubject, 1)
+
+	// Installations
+	events.RegisterSchema[licenseevent.InstallationEnrolledEvent](r, events.LicenseInstallationEnrolledSubject, 1)
+	events.RegisterSchema[licenseevent.InstallationOfflineEvent](r, events.LicenseInstallationOfflineSubject, 1)
+	events.RegisterSchema[licenseevent.InstallationRecoveredEvent](r, events.LicenseInstallationRecoveredSubject, 1)
+	events.RegisterSchema[licenseevent.InstallationDecommissionedEvent](r, events.LicenseInstallationDecommissionedSubject, 1)
+	events.RegisterSchema[licenseevent.JWSRevokedEvent](r, events.LicenseJWSRevokedSubject, 1)
+	events.RegisterSchema[licenseevent.TierExceededEvent](r, events.LicenseTierExceededSubject, 1)
+	events.RegisterSchema[licenseevent.TierRecoveredEvent](r, events.LicenseTierRecoveredSubject, 1)
+}
+
+func registerGlobal(r *events.SchemaRegistry) {
+	events.RegisterSchema[global.NotificationModel](r, events.UserNotifcationSentSubject, 1)
+}
@@ -0,0 +1,101 @@
+package catalog
+
+import (
+	"bytes"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/praction-networks/common/events"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/schemas.snapshot.json")
+
+const snapshotPath = "testdata/schemas.snapshot.json"
+
+func TestSchemaSnapshot(t *testing.T) {
+	r := events.NewSchemaRegistry()
+	Register(r)
+
+	if *update {
+		var buf bytes.Buffer
+		if err := r.WriteSnapshot(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(snapshotPath, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	f, err := os.Open(snapshotPath)
+	if err != nil {
+		t.Fatalf("open snapshot (run with -update to create it): %v", err)
+	}
+	defer f.Close()
+	if err := r.CheckSnapshot(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// subjectConstants returns the Subject constants declared in the events
+// package, by name.
+func subjectConstants(t *testing.T) map[string]events.Subject {
+	t.Helper()
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, "..", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]events.Subject{}
+	for _, f := range pkgs["events"].Files {
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				vs := spec.(*ast.ValueSpec)
+				if typ, ok := vs.Type.(*ast.Ident); !ok || typ.Name != "Subject" {
+					continue
+				}
+				for i, name := range vs.Names {
+					lit, ok := vs.Values[i].(*ast.BasicLit)
+					if !ok || lit.Kind != token.STRING {
+						t.Fatalf("subject %s is not a string literal", name.Name)
+					}
+					value, _ := strconv.Unquote(lit.Value)
+					out[name.Name] = events.Subject(value)
+				}
+			}
+		}
+	}
+	return out
+}
+
+func TestEverySubjectCatalogued(t *testing.T) {
+	r := events.NewSchemaRegistry()
+	Register(r)
+
+	subjects := subjectConstants(t)
+	if len(subjects) < len(r.Subjects()) {
+		t.Fatalf("found %d subject constants, fewer than the %d registered", len(subjects), len(r.Subjects()))
+	}
+	for name, subject := range subjects {
+		_, registered := r.Lookup(subject)
+		_, listed := Unregistered[subject]
+		switch {
+		case registered && listed:
+			t.Errorf("%s (%s) is registered and listed in Unregistered", name, subject)
+		case !registered && !listed:
+			t.Errorf("%s (%s) has no payload schema; register it or list it in Unregistered", name, subject)
+		}
+	}
+}
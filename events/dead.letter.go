@@ -0,0 +1,364 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/praction-networks/common/logger"
+	"github.com/praction-networks/common/metrics"
+)
+
+// Dead-letter reasons recorded in the Dlq-Reason header and the
+// nats_dead_lettered_total metric.
+const (
+	DeadLetterReasonPoison     = "poison"
+	DeadLetterReasonMaxDeliver = "max_deliver"
+)
+
+// Headers added to every dead-lettered message. The original headers
+// (including the event envelope) are preserved alongside them.
+const (
+	HeaderDLQOriginalStream   = "Dlq-Original-Stream"
+	HeaderDLQOriginalSubject  = "Dlq-Original-Subject"
+	HeaderDLQOriginalSequence = "Dlq-Original-Sequence"
+	HeaderDLQConsumer         = "Dlq-Consumer"
+	HeaderDLQNumDelivered     = "Dlq-Num-Delivered"
+	HeaderDLQReason           = "Dlq-Reason"
+	HeaderDLQError            = "Dlq-Error"
+	HeaderDLQFailedAt         = "Dlq-Failed-At"
+)
+
+// DeadLetterStreamName returns the DLQ stream paired with stream,
+// e.g. TenantStream -> TenantStream_DLQ.
+func DeadLetterStreamName(stream StreamName) StreamName {
+	return stream + "_DLQ"
+}
+
+// DeadLetterSubject returns the DLQ subject a message originally published on
+// subject is stored under, e.g. "dlq.TenantStream.tenant.created".
+func DeadLetterSubject(stream StreamName, subject Subject) Subject {
+	return Subject("dlq." + string(stream) + "." + string(subject))
+}
+
+// DeadLetter is one entry read back from a DLQ stream.
+type DeadLetter struct {
+	// Sequence is the entry's sequence in the DLQ stream; pass it to
+	// Get / Delete / Redrive.
+	Sequence uint64
+
+	Stream           StreamName
+	Subject          Subject
+	OriginalSequence uint64
+	Consumer         string
+	NumDelivered     uint64
+	Reason           string
+	Error            string
+	FailedAt         time.Time
+
+	// Header holds the original message headers plus the Dlq-* headers.
+	Header nats.Header
+	Data   []byte
+}
+
+// DeadLetterQueue owns the DLQ stream for one source stream: it receives
+// poison and max-delivery messages from Listeners and offers the operator API
+// to list, inspect, purge and re-drive them.
+type DeadLetterQueue struct {
+	Stream        StreamName
+	StreamManager *JsStreamManager
+
+	// DLQ stream settings used by EnsureStream.
+	MaxAge   time.Duration
+	Replicas int
+	Storage  jetstream.StorageType
+}
+
+// NewDeadLetterQueue creates a DLQ for stream with sane defaults
+// (file storage, one replica, 14 day retention).
+func NewDeadLetterQueue(stream StreamName, streamManager *JsStreamManager) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		Stream:        stream,
+		StreamManager: streamManager,
+		MaxAge:        14 * 24 * time.Hour,
+		Replicas:      1,
+		Storage:       jetstream.FileStorage,
+	}
+}
+
+// Name returns the DLQ stream name.
+func (q *DeadLetterQueue) Name() StreamName {
+	return DeadLetterStreamName(q.Stream)
+}
+
+func (q *DeadLetterQueue) subjectFilter() string {
+	return "dlq." + string(q.Stream) + ".>"
+}
+
+// EnsureStream creates or updates the DLQ stream. Call once at boot.
+func (q *DeadLetterQueue) EnsureStream(ctx context.Context) error {
+	return q.StreamManager.CreateOrUpdateStream(ctx, StreamConfig{
+		Name:        q.Name(),
+		Description: fmt.Sprintf("Dead-letter stream for %s", q.Stream),
+		Subjects:    []Subject{Subject(q.subjectFilter())},
+		Retention:   jetstream.LimitsPolicy,
+		MaxAge:      q.MaxAge,
+		Storage:     q.Storage,
+		Replicas:    q.Replicas,
+		Discard:     jetstream.DiscardOld,
+		Duplicates:  2 * time.Minute,
+	})
+}
+
+// Add dead-letters a message. meta may be nil when the delivery metadata is
+// unavailable. The DLQ write is deduplicated per consumer and original stream
+// sequence, so the Listener and the max-delivery advisory watcher may both
+// report the same delivery safely, while each consumer that gives up on a
+// message gets its own entry.
+func (q *DeadLetterQueue) Add(ctx context.Context, subject string, header nats.Header, data []byte, meta *jetstream.MsgMetadata, reason string, cause error) error {
+	h := nats.Header{}
+	for k, v := range header {
+		// The original Msg-Id would collide with the source stream's id
+		// on re-drive; the DLQ write gets its own below.
+		if k == nats.MsgIdHdr {
+			continue
+		}
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set(HeaderDLQOriginalStream, string(q.Stream))
+	h.Set(HeaderDLQOriginalSubject, subject)
+	h.Set(HeaderDLQReason, reason)
+	h.Set(HeaderDLQFailedAt, time.Now().UTC().Format(time.RFC3339Nano))
+	if cause != nil {
+		h.Set(HeaderDLQError, cause.Error())
+	}
+
+	var jsOpts []jetstream.PublishOpt
+	jsOpts = append(jsOpts, jetstream.WithExpectStream(string(q.Name())))
+	if meta != nil {
+		h.Set(HeaderDLQOriginalSequence, strconv.FormatUint(meta.Sequence.Stream, 10))
+		h.Set(HeaderDLQConsumer, meta.Consumer)
+		h.Set(HeaderDLQNumDelivered, strconv.FormatUint(meta.NumDelivered, 10))
+		jsOpts = append(jsOpts, jetstream.WithMsgID(fmt.Sprintf("dlq:%s:%s:%d", q.Stream, meta.Consumer, meta.Sequence.Stream)))
+	}
+
+	msg := &nats.Msg{
+		Subject: string(DeadLetterSubject(q.Stream, Subject(subject))),
+		Header:  h,
+		Data:    data,
+	}
+	if _, err := q.StreamManager.JsClient.PublishMsg(ctx, msg, jsOpts...); err != nil {
+		metrics.RecordNATSDeadLetterFailure(string(q.Stream), subject, err)
+		logger.Error("Failed to dead-letter message", err, logger.KeyStream, string(q.Stream), logger.KeySubject, subject, "reason", reason)
+		return fmt.Errorf("failed to dead-letter message on %s: %w", subject, err)
+	}
+	metrics.RecordNATSDeadLettered(string(q.Stream), subject, reason)
+	logger.Warn("Message dead-lettered", logger.KeyStream, string(q.Stream), logger.KeySubject, subject, "reason", reason)
+	return nil
+}
+
+// List returns up to limit entries starting at DLQ sequence fromSeq
+// (0 = from the beginning). Use the last entry's Sequence+1 to page.
+func (q *DeadLetterQueue) List(ctx context.Context, fromSeq uint64, limit int) ([]DeadLetter, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	if fromSeq == 0 {
+		fromSeq = 1
+	}
+	stream, err := q.StreamManager.JsClient.Stream(ctx, string(q.Name()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch stream %s: %w", q.Name(), err)
+	}
+
+	out := make([]DeadLetter, 0, limit)
+	seq := fromSeq
+	for len(out) < limit {
+		raw, err := stream.GetMsg(ctx, seq, jetstream.WithGetMsgSubject(q.subjectFilter()))
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			break
+		}
+		if err != nil {
+			return out, fmt.Errorf("failed to read %s at seq %d: %w", q.Name(), seq, err)
+		}
+		out = append(out, deadLetterFromRaw(raw))
+		seq = raw.Sequence + 1
+	}
+	return out, nil
+}
+
+// Get returns the DLQ entry at seq.
+func (q *DeadLetterQueue) Get(ctx context.Context, seq uint64) (*DeadLetter, error) {
+	stream, err := q.StreamManager.JsClient.Stream(ctx, string(q.Name()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch stream %s: %w", q.Name(), err)
+	}
+	raw, err := stream.GetMsg(ctx, seq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s at seq %d: %w", q.Name(), seq, err)
+	}
+	dl := deadLetterFromRaw(raw)
+	return &dl, nil
+}
+
+// Delete removes a single DLQ entry.
+func (q *DeadLetterQueue) Delete(ctx context.Context, seq uint64) error {
+	stream, err := q.StreamManager.JsClient.Stream(ctx, string(q.Name()))
+	if err != nil {
+		return fmt.Errorf("failed to fetch stream %s: %w", q.Name(), err)
+	}
+	if err := stream.DeleteMsg(ctx, seq); err != nil {
+		return fmt.Errorf("failed to delete %s seq %d: %w", q.Name(), seq, err)
+	}
+	return nil
+}
+
+// Purge removes DLQ entries whose original subject is subject, or every
+// entry when subject is empty.
+func (q *DeadLetterQueue) Purge(ctx context.Context, subject Subject) error {
+	stream, err := q.StreamManager.JsClient.Stream(ctx, string(q.Name()))
+	if err != nil {
+		return fmt.Errorf("failed to fetch stream %s: %w", q.Name(), err)
+	}
+	var opts []jetstream.StreamPurgeOpt
+	if subject != "" {
+		opts = append(opts, jetstream.WithPurgeSubject(string(DeadLetterSubject(q.Stream, subject))))
+	}
+	if err := stream.Purge(ctx, opts...); err != nil {
+		return fmt.Errorf("failed to purge %s: %w", q.Name(), err)
+	}
+	logger.Info("Dead-letter stream purged", logger.KeyStream, string(q.Name()), logger.KeySubject, string(subject))
+	return nil
+}
+
+// Redrive republishes the DLQ entry at seq to its origin subject and removes
+// it from the DLQ. The Dlq-* headers are stripped; the envelope headers are
+// kept so the re-driven event carries its original ID and correlation.
+func (q *DeadLetterQueue) Redrive(ctx context.Context, seq uint64) error {
+	dl, err := q.Get(ctx, seq)
+	if err != nil {
+		return err
+	}
+	h := nats.Header{}
+	for k, v := range dl.Header {
+		if strings.HasPrefix(k, "Dlq-") {
+			continue
+		}
+		h[k] = v
+	}
+	msg := &nats.Msg{Subject: string(dl.Subject), Header: h, Data: dl.Data}
+	if _, err := q.StreamManager.JsClient.PublishMsg(ctx, msg,
+		jetstream.WithExpectStream(string(q.Stream)),
+		jetstream.WithMsgID(fmt.Sprintf("redrive:%s:%d", q.Stream, seq)),
+	); err != nil {
+		metrics.RecordNATSFailure(string(q.Stream), string(dl.Subject), err)
+		return fmt.Errorf("failed to re-drive %s seq %d: %w", q.Name(), seq, err)
+	}
+	metrics.RecordNATSDeadLetterRedriven(string(q.Stream), string(dl.Subject))
+	logger.Info("Dead-letter entry re-driven", logger.KeyStream, string(q.Stream), logger.KeySubject, string(dl.Subject), logger.KeySequence, seq)
+	return q.Delete(ctx, seq)
+}
+
+// RedriveAll re-drives every entry whose original subject is subject (every
+// entry when empty). It stops at the first failure and returns the number of
+// entries re-driven so far.
+func (q *DeadLetterQueue) RedriveAll(ctx context.Context, subject Subject) (int, error) {
+	redriven := 0
+	var from uint64
+	for {
+		page, err := q.List(ctx, from, 100)
+		if err != nil {
+			return redriven, err
+		}
+		if len(page) == 0 {
+			return redriven, nil
+		}
+		for _, dl := range page {
+			if subject != "" && dl.Subject != subject {
+				continue
+			}
+			if err := q.Redrive(ctx, dl.Sequence); err != nil {
+				return redriven, err
+			}
+			redriven++
+		}
+		from = page[len(page)-1].Sequence + 1
+	}
+}
+
+func deadLetterFromRaw(raw *jetstream.RawStreamMsg) DeadLetter {
+	dl := DeadLetter{
+		Sequence: raw.Sequence,
+		Stream:   StreamName(raw.Header.Get(HeaderDLQOriginalStream)),
+		Subject:  Subject(raw.Header.Get(HeaderDLQOriginalSubject)),
+		Consumer: raw.Header.Get(HeaderDLQConsumer),
+		Reason:   raw.Header.Get(HeaderDLQReason),
+		Error:    raw.Header.Get(HeaderDLQError),
+		Header:   raw.Header,
+		Data:     raw.Data,
+		FailedAt: raw.Time,
+	}
+	dl.OriginalSequence, _ = strconv.ParseUint(raw.Header.Get(HeaderDLQOriginalSequence), 10, 64)
+	dl.NumDelivered, _ = strconv.ParseUint(raw.Header.Get(HeaderDLQNumDelivered), 10, 64)
+	if t, err := time.Parse(time.RFC3339Nano, raw.Header.Get(HeaderDLQFailedAt)); err == nil {
+		dl.FailedAt = t
+	}
+	return dl
+}
+
+// maxDeliveriesAdvisory is the payload of
+// $JS.EVENT.ADVISORY.CONSUMER.MAX_DELIVERIES.<stream>.<consumer>.
+type maxDeliveriesAdvisory struct {
+	Stream     string `json:"stream"`
+	Consumer   string `json:"consumer"`
+	StreamSeq  uint64 `json:"stream_seq"`
+	Deliveries uint64 `json:"deliveries"`
+}
+
+// watchMaxDeliveries dead-letters messages the server gives up on after
+// MaxDeliver attempts without the handler seeing a final failure (typically
+// AckWait expiry on a hung handler). The caller unsubscribes the returned
+// subscription (nil when no core NATS connection is available).
+func (l *Listener) watchMaxDeliveries(ctx context.Context) *nats.Subscription {
+	nc := l.StreamManager.JsClient.Conn()
+	if nc == nil {
+		return nil
+	}
+	subject := fmt.Sprintf("$JS.EVENT.ADVISORY.CONSUMER.MAX_DELIVERIES.%s.%s", l.StreamName, l.Durable)
+	sub, err := nc.Subscribe(subject, func(m *nats.Msg) {
+		var adv maxDeliveriesAdvisory
+		if err := json.Unmarshal(m.Data, &adv); err != nil {
+			logger.Warn("Invalid max-deliveries advisory", err, logger.KeyStream, string(l.StreamName))
+			return
+		}
+		stream, err := l.StreamManager.JsClient.Stream(ctx, adv.Stream)
+		if err != nil {
+			logger.Error("Failed to fetch stream for max-deliveries advisory", err, logger.KeyStream, adv.Stream)
+			return
+		}
+		raw, err := stream.GetMsg(ctx, adv.StreamSeq)
+		if err != nil {
+			logger.Error("Failed to load message for max-deliveries advisory", err, logger.KeyStream, adv.Stream, logger.KeySequence, adv.StreamSeq)
+			return
+		}
+		meta := &jetstream.MsgMetadata{
+			Sequence:     jetstream.SequencePair{Stream: adv.StreamSeq},
+			NumDelivered: adv.Deliveries,
+			Stream:       adv.Stream,
+			Consumer:     adv.Consumer,
+		}
+		_ = l.DeadLetter.Add(ctx, raw.Subject, raw.Header, raw.Data, meta, DeadLetterReasonMaxDeliver,
+			fmt.Errorf("max deliveries (%d) exceeded without ack", adv.Deliveries))
+	})
+	if err != nil {
+		logger.Error("Failed to subscribe to max-deliveries advisories", err, logger.KeyStream, string(l.StreamName))
+		return nil
+	}
+	return sub
+}
@@ -0,0 +1,189 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/praction-networks/common/events"
+	"github.com/praction-networks/common/events/eventstest"
+)
+
+type dlqTenant struct {
+	Name string `json:"name"`
+}
+
+func newDeadLetterQueue(t *testing.T, js *eventstest.JetStream) *events.DeadLetterQueue {
+	t.Helper()
+	js.AddStreams(t, events.TenantStream)
+	dlq := events.NewDeadLetterQueue(events.TenantStream, js.StreamManager())
+	if err := dlq.EnsureStream(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return dlq
+}
+
+// deadLetters lists the DLQ keyed by reason.
+func deadLetters(t *testing.T, dlq *events.DeadLetterQueue) map[string]events.DeadLetter {
+	t.Helper()
+	list, err := dlq.List(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]events.DeadLetter{}
+	for _, dl := range list {
+		out[dl.Reason] = dl
+	}
+	if len(out) != len(list) {
+		t.Fatalf("dead letters = %+v", list)
+	}
+	return out
+}
+
+func TestListenerDeadLettersAndRedrive(t *testing.T) {
+	initLogger(t)
+	ctx := context.Background()
+	js := eventstest.New()
+	js.MaxRedeliveryDelay = time.Millisecond
+	dlq := newDeadLetterQueue(t, js)
+
+	var fixed atomic.Bool
+	l := events.NewListener(events.TenantStream, "test", jetstream.DeliverAllPolicy, jetstream.AckExplicitPolicy,
+		30*time.Second, nil, nil, js.StreamManager(),
+		func(context.Context, events.Event[json.RawMessage]) error {
+			if !fixed.Load() {
+				return errors.New("always")
+			}
+			return nil
+		})
+	l.MaxDeliver = 2
+	l.DeadLetter = dlq
+	js.StartListener(t, l)
+
+	pub := events.NewPublisher[dlqTenant](events.TenantStream, events.TenantCreatedSubject, js.StreamManager(), true, nil)
+	if _, err := pub.PublishPreferred(events.WithCorrelationID(ctx, "corr-1"), dlqTenant{Name: "acme"}, "tenant-1"); err != nil {
+		t.Fatal(err)
+	}
+	original := js.AssertPublished(t, events.TenantCreatedSubject, 1)[0]
+	poisonSeq := js.InjectRaw(t, events.TenantUpdatedSubject, []byte("{not json"), nil)
+	js.WaitIdle(t, events.TenantStream, "test")
+
+	entries := deadLetters(t, dlq)
+	failed, poison := entries[events.DeadLetterReasonMaxDeliver], entries[events.DeadLetterReasonPoison]
+	if len(entries) != 2 {
+		t.Fatalf("dead letters = %+v", entries)
+	}
+	if failed.Stream != events.TenantStream || failed.Subject != events.TenantCreatedSubject ||
+		failed.OriginalSequence != original.Sequence || failed.Consumer != "test" ||
+		failed.NumDelivered != 2 || failed.Error != "always" || string(failed.Data) != string(original.Data) {
+		t.Fatalf("max_deliver entry = %+v", failed)
+	}
+	if poison.Subject != events.TenantUpdatedSubject || poison.OriginalSequence != poisonSeq || string(poison.Data) != "{not json" {
+		t.Fatalf("poison entry = %+v", poison)
+	}
+
+	// The envelope headers survive; the source Msg-Id is replaced by the
+	// DLQ's own so a re-drive isn't deduplicated against the original.
+	for _, k := range []string{events.HeaderEventID, events.HeaderCorrelationID} {
+		if got := failed.Header.Get(k); got == "" || got != original.Header.Get(k) {
+			t.Fatalf("header %s = %q, want %q", k, got, original.Header.Get(k))
+		}
+	}
+	wantDLQMsgID := fmt.Sprintf("dlq:%s:test:%d", events.TenantStream, original.Sequence)
+	if got := failed.Header.Get(nats.MsgIdHdr); got != wantDLQMsgID {
+		t.Fatalf("dead letter Msg-Id = %q, want %q", got, wantDLQMsgID)
+	}
+
+	// Reporting the same delivery again (e.g. from the advisory watcher)
+	// doesn't add a second entry.
+	meta := &jetstream.MsgMetadata{Sequence: jetstream.SequencePair{Stream: original.Sequence}, Consumer: "test", NumDelivered: 2}
+	if err := dlq.Add(ctx, string(original.Subject), original.Header, original.Data, meta, events.DeadLetterReasonMaxDeliver, errors.New("always")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(deadLetters(t, dlq)); n != 2 {
+		t.Fatalf("%d dead letters after a repeated Add, want 2", n)
+	}
+
+	fixed.Store(true)
+	if err := dlq.Redrive(ctx, failed.Sequence); err != nil {
+		t.Fatal(err)
+	}
+	redriven := js.AssertPublished(t, events.TenantCreatedSubject, 2)[1]
+	if want := fmt.Sprintf("redrive:%s:%d", events.TenantStream, failed.Sequence); redriven.MsgID != want {
+		t.Fatalf("redrive MsgID = %q, want %q", redriven.MsgID, want)
+	}
+	for k := range redriven.Header {
+		if strings.HasPrefix(k, "Dlq-") {
+			t.Fatalf("redriven message kept %s", k)
+		}
+	}
+	if redriven.Header.Get(events.HeaderEventID) != original.Header.Get(events.HeaderEventID) || string(redriven.Data) != string(original.Data) {
+		t.Fatalf("redriven = %+v, original = %+v", redriven, original)
+	}
+	for _, d := range js.WaitIdle(t, events.TenantStream, "test") {
+		if d.Sequence == redriven.Sequence && !d.Acked {
+			t.Fatalf("redriven delivery = %+v", d)
+		}
+	}
+	if entries := deadLetters(t, dlq); len(entries) != 1 || entries[events.DeadLetterReasonPoison].Sequence != poison.Sequence {
+		t.Fatalf("dead letters after redrive = %+v", entries)
+	}
+}
+
+func TestDeadLetterRedriveDedupAndPurge(t *testing.T) {
+	initLogger(t)
+	ctx := context.Background()
+	js := eventstest.New()
+	dlq := newDeadLetterQueue(t, js)
+
+	// Another consumer giving up on the same message gets its own entry.
+	for i, subject := range []events.Subject{events.TenantCreatedSubject, events.TenantUpdatedSubject, events.TenantCreatedSubject} {
+		meta := &jetstream.MsgMetadata{Sequence: jetstream.SequencePair{Stream: uint64(i%2 + 1)}, Consumer: "test", NumDelivered: 1}
+		if i == 2 {
+			meta.Consumer = "billing"
+		}
+		if err := dlq.Add(ctx, string(subject), nil, []byte(`{}`), meta, events.DeadLetterReasonPoison, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := dlq.List(ctx, 0, 0)
+	if err != nil || len(list) != 3 || list[2].Consumer != "billing" || list[2].OriginalSequence != list[0].OriginalSequence {
+		t.Fatalf("List = %+v, %v", list, err)
+	}
+
+	// An earlier Redrive published the entry but failed to delete it; the
+	// retry must not publish it twice.
+	_, err = js.PublishMsg(ctx, &nats.Msg{Subject: string(events.TenantCreatedSubject), Data: list[0].Data},
+		jetstream.WithMsgID(fmt.Sprintf("redrive:%s:%d", events.TenantStream, list[0].Sequence)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dlq.Redrive(ctx, list[0].Sequence); err != nil {
+		t.Fatal(err)
+	}
+	js.AssertPublished(t, events.TenantCreatedSubject, 1)
+	if _, err := dlq.Get(ctx, list[0].Sequence); !errors.Is(err, jetstream.ErrMsgNotFound) {
+		t.Fatalf("Get after redrive: %v", err)
+	}
+
+	// Purging another subject leaves the entry alone.
+	if err := dlq.Purge(ctx, events.TenantCreatedSubject); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := dlq.List(ctx, 0, 0); len(left) != 1 || left[0].Subject != events.TenantUpdatedSubject {
+		t.Fatalf("after purging %s: %+v", events.TenantCreatedSubject, left)
+	}
+	if err := dlq.Purge(ctx, events.TenantUpdatedSubject); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := dlq.List(ctx, 0, 0); len(left) != 0 {
+		t.Fatalf("after purge: %+v", left)
+	}
+}
@@ -0,0 +1,198 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/praction-networks/common/helpers"
+)
+
+// NATS header names mirroring the Envelope fields. The body stays the source
+// of truth; headers exist so routers, the nats CLI and dead-letter tooling can
+// read the metadata without decoding JSON.
+const (
+	HeaderEventID       = "Event-Id"
+	HeaderOccurredAt    = "Event-Occurred-At"
+	HeaderProducer      = "Event-Producer"
+	HeaderTenantID      = "Event-Tenant-Id"
+	HeaderCorrelationID = "Event-Correlation-Id"
+	HeaderCausationID   = "Event-Causation-Id"
+	HeaderSchemaVersion = "Event-Schema-Version"
+	HeaderActor         = "Event-Actor"
+)
+
+// DefaultSchemaVersion is stamped on events whose publisher does not declare
+// a schema version.
+const DefaultSchemaVersion = 1
+
+type envelopeContextKey struct{ name string }
+
+var (
+	correlationIDKey = &envelopeContextKey{"correlation_id"}
+	causationIDKey   = &envelopeContextKey{"causation_id"}
+	envelopeKey      = &envelopeContextKey{"envelope"}
+)
+
+// WithCorrelationID returns a ctx carrying an explicit correlation ID. Events
+// published with this ctx use it instead of the request ID.
+func WithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return context.WithValue(ctx, correlationIDKey, correlationID)
+}
+
+// WithCausationID returns a ctx carrying an explicit causation ID.
+func WithCausationID(ctx context.Context, causationID string) context.Context {
+	return context.WithValue(ctx, causationIDKey, causationID)
+}
+
+// ContextWithEnvelope returns a ctx derived from an inbound event so that any
+// event published while handling it continues the same correlation chain and
+// records the inbound event as its cause. Listener calls this before invoking
+// OnMessageFunc.
+func ContextWithEnvelope(ctx context.Context, env Envelope) context.Context {
+	ctx = context.WithValue(ctx, envelopeKey, env)
+	if env.CorrelationID != "" {
+		ctx = WithCorrelationID(ctx, env.CorrelationID)
+	}
+	if env.ID != "" {
+		ctx = WithCausationID(ctx, env.ID)
+	}
+	return ctx
+}
+
+// EnvelopeFromContext returns the envelope of the event currently being
+// handled, if any.
+func EnvelopeFromContext(ctx context.Context) (Envelope, bool) {
+	env, ok := ctx.Value(envelopeKey).(Envelope)
+	return env, ok
+}
+
+// GetCorrelationID returns the correlation ID for ctx: an explicit one set via
+// WithCorrelationID / ContextWithEnvelope, else the HTTP request ID.
+func GetCorrelationID(ctx context.Context) string {
+	if id, ok := ctx.Value(correlationIDKey).(string); ok && id != "" {
+		return id
+	}
+	return helpers.GetRequestID(ctx)
+}
+
+// GetCausationID returns the causation ID for ctx, or "" when unset.
+func GetCausationID(ctx context.Context) string {
+	if id, ok := ctx.Value(causationIDKey).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// newEnvelope builds the envelope for an outgoing event. Explicit values in
+// override win; anything left empty is filled from ctx and the environment.
+func newEnvelope(ctx context.Context, producer string, schemaVersion int, override Envelope) Envelope {
+	env := override
+	if env.ID == "" {
+		env.ID = uuid.New().String()
+	}
+	if env.OccurredAt.IsZero() {
+		env.OccurredAt = time.Now().UTC()
+	}
+	if env.Producer == "" {
+		env.Producer = producer
+	}
+	if env.Producer == "" {
+		env.Producer = os.Getenv("SERVICE_NAME")
+	}
+	if env.TenantID == "" {
+		env.TenantID = helpers.GetTenantID(ctx)
+	}
+	if env.CorrelationID == "" {
+		env.CorrelationID = GetCorrelationID(ctx)
+	}
+	if env.CorrelationID == "" {
+		// First event of a chain correlates with itself.
+		env.CorrelationID = env.ID
+	}
+	if env.CausationID == "" {
+		env.CausationID = GetCausationID(ctx)
+	}
+	if env.SchemaVersion == 0 {
+		env.SchemaVersion = schemaVersion
+	}
+	if env.SchemaVersion == 0 {
+		env.SchemaVersion = DefaultSchemaVersion
+	}
+	if env.Actor == "" {
+		env.Actor = helpers.GetUserID(ctx)
+	}
+	return env
+}
+
+// Header returns the envelope as NATS message headers. Empty fields are
+// skipped.
+func (e Envelope) Header() nats.Header {
+	h := nats.Header{}
+	set := func(k, v string) {
+		if v != "" {
+			h.Set(k, v)
+		}
+	}
+	set(HeaderEventID, e.ID)
+	if !e.OccurredAt.IsZero() {
+		h.Set(HeaderOccurredAt, e.OccurredAt.UTC().Format(time.RFC3339Nano))
+	}
+	set(HeaderProducer, e.Producer)
+	set(HeaderTenantID, e.TenantID)
+	set(HeaderCorrelationID, e.CorrelationID)
+	set(HeaderCausationID, e.CausationID)
+	if e.SchemaVersion != 0 {
+		h.Set(HeaderSchemaVersion, strconv.Itoa(e.SchemaVersion))
+	}
+	set(HeaderActor, e.Actor)
+	return h
+}
+
+// mergeHeader fills any empty envelope field from NATS headers. Used by the
+// Listener so events whose metadata only travelled in headers (or legacy
+// bare payloads republished by newer tooling) still expose it to handlers.
+func (e *Envelope) mergeHeader(h nats.Header) {
+	if h == nil {
+		return
+	}
+	fill := func(dst *string, k string) {
+		if *dst == "" {
+			*dst = h.Get(k)
+		}
+	}
+	fill(&e.ID, HeaderEventID)
+	if e.ID == "" {
+		e.ID = h.Get(nats.MsgIdHdr)
+	}
+	if e.OccurredAt.IsZero() {
+		if t, err := time.Parse(time.RFC3339Nano, h.Get(HeaderOccurredAt)); err == nil {
+			e.OccurredAt = t
+		}
+	}
+	fill(&e.Producer, HeaderProducer)
+	fill(&e.TenantID, HeaderTenantID)
+	fill(&e.CorrelationID, HeaderCorrelationID)
+	fill(&e.CausationID, HeaderCausationID)
+	if e.SchemaVersion == 0 {
+		if v, err := strconv.Atoi(h.Get(HeaderSchemaVersion)); err == nil {
+			e.SchemaVersion = v
+		}
+	}
+	fill(&e.Actor, HeaderActor)
+}
+
+// envelopeHeaderFromPayload extracts the envelope from a marshalled Event
+// payload and returns it as headers. Used when republishing stored payloads
+// (fallback replay) so headers survive the round-trip through Mongo.
+func envelopeHeaderFromPayload(payload []byte) nats.Header {
+	var env Envelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return nats.Header{}
+	}
+	return env.Header()
+}
@@ -0,0 +1,81 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/praction-networks/common/helpers"
+)
+
+func TestEventLegacyPayloadDecodes(t *testing.T) {
+	var ev Event[json.RawMessage]
+	if err := json.Unmarshal([]byte(`{"subject":"tenant.created","data":{"id":"t1"}}`), &ev); err != nil {
+		t.Fatalf("decode legacy payload: %v", err)
+	}
+	if ev.Subject != TenantCreatedSubject || string(ev.Data) != `{"id":"t1"}` {
+		t.Fatalf("unexpected legacy decode: %+v", ev)
+	}
+	if ev.ID != "" || !ev.OccurredAt.IsZero() {
+		t.Fatalf("legacy payload should have an empty envelope, got %+v", ev.Envelope)
+	}
+}
+
+func TestEventZeroEnvelopeKeepsLegacyShape(t *testing.T) {
+	b, err := json.Marshal(Event[map[string]string]{Subject: "a.b", Data: map[string]string{"k": "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"subject":"a.b","data":{"k":"v"}}`; got != want {
+		t.Fatalf("zero envelope changed wire shape: got %s want %s", got, want)
+	}
+}
+
+func TestNewEnvelopeFromContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), helpers.TenantIDKey, "tenant-1")
+	ctx = context.WithValue(ctx, helpers.UserIDKey, "user-1")
+	ctx = context.WithValue(ctx, helpers.RequestIDKey, "req-1")
+
+	env := newEnvelope(ctx, "svc", 0, Envelope{})
+	if env.ID == "" || env.OccurredAt.IsZero() {
+		t.Fatalf("expected generated id and timestamp, got %+v", env)
+	}
+	if env.TenantID != "tenant-1" || env.Actor != "user-1" || env.CorrelationID != "req-1" {
+		t.Fatalf("context not applied: %+v", env)
+	}
+	if env.Producer != "svc" || env.SchemaVersion != DefaultSchemaVersion {
+		t.Fatalf("publisher defaults not applied: %+v", env)
+	}
+
+	// Publishing from inside a handler continues the chain.
+	child := newEnvelope(ContextWithEnvelope(ctx, env), "svc", 2, Envelope{})
+	if child.CorrelationID != "req-1" || child.CausationID != env.ID || child.SchemaVersion != 2 {
+		t.Fatalf("chain not continued: %+v", child)
+	}
+}
+
+func TestEnvelopeHeaderRoundTrip(t *testing.T) {
+	in := Envelope{
+		ID:            "e1",
+		OccurredAt:    time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC),
+		Producer:      "svc",
+		TenantID:      "t1",
+		CorrelationID: "c1",
+		CausationID:   "k1",
+		SchemaVersion: 3,
+		Actor:         "u1",
+	}
+	var out Envelope
+	out.mergeHeader(in.Header())
+	if out != in {
+		t.Fatalf("header round trip mismatch:\n got %+v\nwant %+v", out, in)
+	}
+
+	// Body values win over headers.
+	body := Envelope{ID: "body"}
+	body.mergeHeader(in.Header())
+	if body.ID != "body" || body.TenantID != "t1" {
+		t.Fatalf("merge should only fill empty fields, got %+v", body)
+	}
+}
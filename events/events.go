@@ -1,6 +1,51 @@
 package events
 
+import "time"
+
+// Event is the JSON payload carried on every JetStream message published by
+// Publisher[T]. Subject and Data are the historical shape; the embedded
+// Envelope adds the standard headers (event ID, occurredAt, tenant,
+// correlation, …). Every envelope field is omitted when empty, so bare
+// {"subject","data"} payloads from older producers still decode cleanly and
+// older consumers simply ignore the extra keys.
 type Event[T any] struct {
 	Subject Subject `json:"subject"`
 	Data    T       `json:"data"`
+
+	Envelope
+}
+
+// Envelope is the standard metadata attached to every event. It replaces the
+// per-domain header structs (ticketevent.EventHeaders, oltevent.EventEnvelope,
+// …) for new producers. Publisher fills it from the request context; the same
+// values are mirrored into NATS message headers (see Header* constants) so
+// tooling can route or inspect messages without decoding the body.
+type Envelope struct {
+	// ID is a unique identifier for this event instance (UUID v4).
+	ID string `json:"id,omitempty"`
+
+	// OccurredAt is when the producer created the event.
+	OccurredAt time.Time `json:"occurredAt,omitzero"`
+
+	// Producer is the originating service (SERVICE_NAME by default).
+	Producer string `json:"producer,omitempty"`
+
+	// TenantID is the tenant context the event belongs to.
+	TenantID string `json:"tenantId,omitempty"`
+
+	// CorrelationID ties together every event emitted for one logical
+	// operation. Defaults to the inbound request ID, or to the ID of the
+	// first event in the chain when there is no request.
+	CorrelationID string `json:"correlationId,omitempty"`
+
+	// CausationID is the ID of the event (or request) that directly
+	// caused this one. Set automatically when publishing from inside a
+	// Listener handler.
+	CausationID string `json:"causationId,omitempty"`
+
+	// SchemaVersion is the version of the Data shape. Defaults to 1.
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+
+	// Actor is the user (or system principal) that triggered the event.
+	Actor string `json:"actor,omitempty"`
 }
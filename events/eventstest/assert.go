@@ -0,0 +1,119 @@
+package eventstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/praction-networks/common/events"
+)
+
+// Published returns the messages stored by publishes to subject (wildcards
+// allowed), in publish order. Deduplicated publishes are not included.
+func (js *JetStream) Published(subject events.Subject) []Message {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	var out []Message
+	for _, m := range js.published {
+		if subjectMatches(string(subject), m.Subject) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// AssertPublished fails t unless exactly n messages were published to
+// subject, and returns them.
+func (js *JetStream) AssertPublished(t testing.TB, subject events.Subject, n int) []Message {
+	t.Helper()
+	msgs := js.Published(subject)
+	if len(msgs) != n {
+		t.Fatalf("eventstest: %d messages published to %s, want %d", len(msgs), subject, n)
+	}
+	return msgs
+}
+
+// Reset forgets what was published so far; stream contents are kept.
+func (js *JetStream) Reset() {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.published = nil
+}
+
+// DecodePublished decodes every message published to subject as an
+// events.Event[T].
+func DecodePublished[T any](t testing.TB, js *JetStream, subject events.Subject) []events.Event[T] {
+	t.Helper()
+	msgs := js.Published(subject)
+	out := make([]events.Event[T], len(msgs))
+	for i, m := range msgs {
+		if err := json.Unmarshal(m.Data, &out[i]); err != nil {
+			t.Fatalf("eventstest: decode %s #%d: %v", m.Subject, m.Sequence, err)
+		}
+	}
+	return out
+}
+
+// UpdateGoldenEnv names the environment variable that makes the golden
+// helpers rewrite their files instead of comparing against them.
+const UpdateGoldenEnv = "EVENTSTEST_UPDATE"
+
+// volatileEnvelopeFields differ on every publish and are masked by
+// GoldenEvent.
+var volatileEnvelopeFields = []string{"id", "occurredAt", "producer", "correlationId", "causationId"}
+
+// GoldenJSON compares data, indented, against testdata/<name>.golden. Run
+// the test with EVENTSTEST_UPDATE=1 to (re)write the file.
+func GoldenJSON(t testing.TB, name string, data []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, bytes.TrimSpace(data), "", "  "); err != nil {
+		t.Fatalf("eventstest: golden %s: %v", name, err)
+	}
+	buf.WriteByte('\n')
+
+	path := filepath.Join("testdata", name+".golden")
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("eventstest: %v", err)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatalf("eventstest: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("eventstest: read golden file (run with %s=1 to create it): %v", UpdateGoldenEnv, err)
+	}
+	if !bytes.Equal(want, buf.Bytes()) {
+		t.Errorf("eventstest: %s differs from golden file %s (run with %s=1 to update)\n got: %s\nwant: %s",
+			name, path, UpdateGoldenEnv, buf.Bytes(), want)
+	}
+}
+
+// GoldenEvent compares a published event against testdata/<name>.golden
+// like GoldenJSON, after replacing the envelope fields that change on every
+// publish (id, occurredAt, producer, correlationId, causationId) with
+// placeholders.
+func GoldenEvent(t testing.TB, name string, m Message) {
+	t.Helper()
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(m.Data, &doc); err != nil {
+		t.Fatalf("eventstest: golden %s: %v", name, err)
+	}
+	for _, field := range volatileEnvelopeFields {
+		if _, ok := doc[field]; ok {
+			doc[field] = json.RawMessage(`"<` + field + `>"`)
+		}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) // keep the placeholders readable
+	if err := enc.Encode(doc); err != nil {
+		t.Fatalf("eventstest: golden %s: %v", name, err)
+	}
+	GoldenJSON(t, name, buf.Bytes())
+}
@@ -0,0 +1,413 @@
+package eventstest
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Delivery is what happened to one stream message on a consumer.
+type Delivery struct {
+	Sequence   uint64
+	Subject    string
+	Deliveries int
+	Naks       int
+	InProgress int
+	Acked      bool
+	Termed     bool
+	Dropped    bool // MaxDeliver reached without an ack
+}
+
+// Settled reports whether the message needs no further delivery.
+func (d Delivery) Settled() bool { return d.Acked || d.Termed || d.Dropped }
+
+// Consumer is a fake pull jetstream.Consumer: durable, or ordered (see
+// Stream.OrderedConsumer).
+type Consumer struct {
+	jetstream.Consumer // unsupported methods panic
+
+	s         *Stream
+	name      string
+	cfg       jetstream.ConsumerConfig
+	created   time.Time
+	ephemeral bool // removed from the stream once its last subscription stops
+
+	nextSeq       uint64 // next stream sequence to consider
+	consumerSeq   uint64
+	lastDelivered uint64
+	lastActive    time.Time
+	outstanding   map[uint64]bool      // delivered, not settled or NAKed
+	redeliver     map[uint64]time.Time // NAKed, due time
+	deliveries    map[uint64]*Delivery
+	wake          chan struct{} // closed and replaced on every change
+	subs          map[*consumeContext]bool
+}
+
+func newConsumer(s *Stream, name string, cfg jetstream.ConsumerConfig) *Consumer {
+	c := &Consumer{
+		s:           s,
+		name:        name,
+		cfg:         cfg,
+		created:     s.js.now(),
+		outstanding: map[uint64]bool{},
+		redeliver:   map[uint64]time.Time{},
+		deliveries:  map[uint64]*Delivery{},
+		wake:        make(chan struct{}),
+		subs:        map[*consumeContext]bool{},
+	}
+	switch cfg.DeliverPolicy {
+	case jetstream.DeliverNewPolicy:
+		c.nextSeq = s.lastSeq + 1
+	case jetstream.DeliverLastPolicy:
+		c.nextSeq = max(s.lastSeq, 1)
+	case jetstream.DeliverByStartSequencePolicy:
+		c.nextSeq = cfg.OptStartSeq
+	case jetstream.DeliverByStartTimePolicy:
+		c.nextSeq = s.lastSeq + 1
+		for _, m := range s.msgs {
+			if cfg.OptStartTime != nil && !m.Time.Before(*cfg.OptStartTime) {
+				c.nextSeq = m.Sequence
+				break
+			}
+		}
+	default:
+		c.nextSeq = 1
+	}
+	return c
+}
+
+func (c *Consumer) matches(subject string) bool {
+	if c.cfg.FilterSubject != "" {
+		return subjectMatches(c.cfg.FilterSubject, subject)
+	}
+	if len(c.cfg.FilterSubjects) == 0 {
+		return true
+	}
+	for _, f := range c.cfg.FilterSubjects {
+		if subjectMatches(f, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Consumer) notifyLocked() {
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
+
+func (c *Consumer) numPendingLocked(after uint64) uint64 {
+	var n uint64
+	for _, m := range c.s.msgs {
+		if m.Sequence > after && m.Sequence >= c.nextSeq && c.matches(m.Subject) {
+			n++
+		}
+	}
+	return n
+}
+
+// nextLocked picks the next message to deliver: due redeliveries first, then
+// new messages. When there is none, wait is how long until a redelivery is
+// due (0: wait for a change).
+func (c *Consumer) nextLocked() (msg *fakeMsg, wait time.Duration) {
+	now := c.s.js.now()
+	var seq uint64
+	for s, due := range c.redeliver {
+		if !due.After(now) {
+			if seq == 0 || s < seq {
+				seq = s
+			}
+		} else if d := due.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+	if seq != 0 {
+		delete(c.redeliver, seq)
+	} else if c.cfg.MaxAckPending <= 0 || len(c.outstanding) < c.cfg.MaxAckPending {
+		for _, m := range c.s.msgs {
+			if m.Sequence < c.nextSeq {
+				continue
+			}
+			c.nextSeq = m.Sequence + 1
+			if c.matches(m.Subject) {
+				seq = m.Sequence
+				break
+			}
+		}
+	}
+	if seq == 0 {
+		return nil, wait
+	}
+	m := c.s.msgLocked(seq)
+	if m == nil { // deleted while waiting for redelivery
+		return c.nextLocked()
+	}
+
+	d := c.deliveries[seq]
+	if d == nil {
+		d = &Delivery{Sequence: seq, Subject: m.Subject}
+		c.deliveries[seq] = d
+	}
+	d.Deliveries++
+	c.consumerSeq++
+	c.lastDelivered = max(c.lastDelivered, seq)
+	c.lastActive = now
+	if c.cfg.AckPolicy == jetstream.AckNonePolicy {
+		d.Acked = true // settled on delivery
+	} else {
+		c.outstanding[seq] = true
+	}
+	return &fakeMsg{c: c, m: m, meta: jetstream.MsgMetadata{
+		Sequence:     jetstream.SequencePair{Consumer: c.consumerSeq, Stream: seq},
+		NumDelivered: uint64(d.Deliveries),
+		NumPending:   c.numPendingLocked(seq),
+		Timestamp:    m.Time,
+		Stream:       c.s.cfg.Name,
+		Consumer:     c.name,
+	}}, 0
+}
+
+// settleLocked records an ack/nak/term of a delivered message.
+func (c *Consumer) settleLocked(seq uint64, fn func(d *Delivery)) error {
+	d := c.deliveries[seq]
+	if d == nil || !c.outstanding[seq] {
+		return jetstream.ErrMsgAlreadyAckd
+	}
+	delete(c.outstanding, seq)
+	fn(d)
+	c.notifyLocked()
+	return nil
+}
+
+func (c *Consumer) stopAllLocked() {
+	for cc := range c.subs {
+		cc.stopLocked()
+	}
+}
+
+// Consume implements jetstream.Consumer. The handler runs on one goroutine
+// per ConsumeContext, as with a real pull consumer; options are ignored.
+func (c *Consumer) Consume(handler jetstream.MessageHandler, _ ...jetstream.PullConsumeOpt) (jetstream.ConsumeContext, error) {
+	cc := c.subscribe()
+	go func() {
+		defer close(cc.closed)
+		for {
+			msg := cc.next()
+			if msg == nil {
+				return
+			}
+			handler(msg)
+		}
+	}()
+	return cc, nil
+}
+
+// Messages implements jetstream.Consumer; options are ignored.
+func (c *Consumer) Messages(...jetstream.PullMessagesOpt) (jetstream.MessagesContext, error) {
+	return &messagesContext{c.subscribe()}, nil
+}
+
+func (c *Consumer) subscribe() *consumeContext {
+	cc := &consumeContext{c: c, done: make(chan struct{}), closed: make(chan struct{})}
+	c.s.js.mu.Lock()
+	c.subs[cc] = true
+	c.s.js.mu.Unlock()
+	return cc
+}
+
+type consumeContext struct {
+	c       *Consumer
+	done    chan struct{}
+	closed  chan struct{}
+	stopped bool
+}
+
+// next blocks until a message is due and delivers it, or returns nil once
+// cc is stopped.
+func (cc *consumeContext) next() *fakeMsg {
+	c := cc.c
+	for {
+		c.s.js.mu.Lock()
+		if cc.stopped {
+			c.s.js.mu.Unlock()
+			return nil
+		}
+		msg, wait := c.nextLocked()
+		wake := c.wake
+		c.s.js.mu.Unlock()
+
+		if msg != nil {
+			return msg
+		}
+		var timer *time.Timer
+		var due <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			due = timer.C
+		}
+		select {
+		case <-cc.done:
+		case <-wake:
+		case <-due:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (cc *consumeContext) stopLocked() {
+	if cc.stopped {
+		return
+	}
+	cc.stopped = true
+	close(cc.done)
+	delete(cc.c.subs, cc)
+	if cc.c.ephemeral && len(cc.c.subs) == 0 {
+		delete(cc.c.s.consumers, cc.c.name)
+	}
+}
+
+func (cc *consumeContext) Stop() {
+	cc.c.s.js.mu.Lock()
+	defer cc.c.s.js.mu.Unlock()
+	cc.stopLocked()
+}
+
+func (cc *consumeContext) Drain()                  { cc.Stop() }
+func (cc *consumeContext) Closed() <-chan struct{} { return cc.closed }
+
+// messagesContext is the iterator returned by Consumer.Messages.
+type messagesContext struct {
+	*consumeContext
+}
+
+// Next implements jetstream.MessagesContext.
+func (m *messagesContext) Next() (jetstream.Msg, error) {
+	if msg := m.next(); msg != nil {
+		return msg, nil
+	}
+	return nil, jetstream.ErrMsgIteratorClosed
+}
+
+func (c *Consumer) infoLocked() *jetstream.ConsumerInfo {
+	lastActive := c.lastActive
+	floor := c.lastDelivered
+	redelivered := 0
+	for seq := range c.outstanding {
+		floor = min(floor, seq-1)
+		if c.deliveries[seq].Deliveries > 1 {
+			redelivered++
+		}
+	}
+	for seq := range c.redeliver {
+		floor = min(floor, seq-1)
+		redelivered++
+	}
+	info := &jetstream.ConsumerInfo{
+		Stream:         c.s.cfg.Name,
+		Name:           c.name,
+		Created:        c.created,
+		Config:         c.cfg,
+		Delivered:      jetstream.SequenceInfo{Consumer: c.consumerSeq, Stream: c.lastDelivered},
+		AckFloor:       jetstream.SequenceInfo{Stream: floor},
+		NumAckPending:  len(c.outstanding) + len(c.redeliver),
+		NumRedelivered: redelivered,
+		NumPending:     c.numPendingLocked(0),
+		TimeStamp:      c.s.js.now(),
+	}
+	if !lastActive.IsZero() {
+		info.Delivered.Last = &lastActive
+	}
+	return info
+}
+
+// Info implements jetstream.Consumer.
+func (c *Consumer) Info(context.Context) (*jetstream.ConsumerInfo, error) {
+	c.s.js.mu.Lock()
+	defer c.s.js.mu.Unlock()
+	return c.infoLocked(), nil
+}
+
+// CachedInfo implements jetstream.Consumer.
+func (c *Consumer) CachedInfo() *jetstream.ConsumerInfo {
+	c.s.js.mu.Lock()
+	defer c.s.js.mu.Unlock()
+	return c.infoLocked()
+}
+
+// Deliveries returns what happened to every message delivered so far, by
+// stream sequence.
+func (c *Consumer) Deliveries() []Delivery {
+	c.s.js.mu.Lock()
+	defer c.s.js.mu.Unlock()
+	out := make([]Delivery, 0, len(c.deliveries))
+	for _, d := range c.deliveries {
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Sequence < out[j].Sequence })
+	return out
+}
+
+// Idle reports whether every matching message was delivered and settled.
+func (c *Consumer) Idle() bool {
+	c.s.js.mu.Lock()
+	defer c.s.js.mu.Unlock()
+	return len(c.outstanding) == 0 && len(c.redeliver) == 0 && c.numPendingLocked(0) == 0
+}
+
+// fakeMsg is a delivered message.
+type fakeMsg struct {
+	c    *Consumer
+	m    *Message
+	meta jetstream.MsgMetadata
+}
+
+func (f *fakeMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	meta := f.meta
+	return &meta, nil
+}
+func (f *fakeMsg) Data() []byte         { return f.m.Data }
+func (f *fakeMsg) Headers() nats.Header { return f.m.Header }
+func (f *fakeMsg) Subject() string      { return f.m.Subject }
+func (f *fakeMsg) Reply() string        { return "" }
+
+func (f *fakeMsg) settle(fn func(d *Delivery)) error {
+	f.c.s.js.mu.Lock()
+	defer f.c.s.js.mu.Unlock()
+	return f.c.settleLocked(f.meta.Sequence.Stream, fn)
+}
+
+func (f *fakeMsg) Ack() error { return f.settle(func(d *Delivery) { d.Acked = true }) }
+
+func (f *fakeMsg) DoubleAck(context.Context) error { return f.Ack() }
+
+func (f *fakeMsg) Nak() error { return f.NakWithDelay(0) }
+
+func (f *fakeMsg) NakWithDelay(delay time.Duration) error {
+	js := f.c.s.js
+	return f.settle(func(d *Delivery) {
+		d.Naks++
+		if f.c.cfg.MaxDeliver > 0 && d.Deliveries >= f.c.cfg.MaxDeliver {
+			d.Dropped = true
+			return
+		}
+		f.c.redeliver[d.Sequence] = js.now().Add(min(delay, js.MaxRedeliveryDelay))
+	})
+}
+
+func (f *fakeMsg) InProgress() error {
+	f.c.s.js.mu.Lock()
+	defer f.c.s.js.mu.Unlock()
+	if d := f.c.deliveries[f.meta.Sequence.Stream]; d != nil {
+		d.InProgress++
+	}
+	return nil
+}
+
+func (f *fakeMsg) Term() error { return f.settle(func(d *Delivery) { d.Termed = true }) }
+
+func (f *fakeMsg) TermWithReason(string) error { return f.Term() }
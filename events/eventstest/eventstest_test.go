@@ -0,0 +1,356 @@
+package eventstest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/praction-networks/common/events"
+	"github.com/praction-networks/common/helpers"
+	"github.com/praction-networks/common/logger"
+)
+
+// TestMain initializes the logger the events package logs through.
+func TestMain(m *testing.M) {
+	if err := logger.InitializeLogger(logger.LoggerConfig{LogLevel: "error"}); err != nil {
+		panic("test logger init: " + err.Error())
+	}
+	os.Exit(m.Run())
+}
+
+type tenant struct {
+	Name string `json:"name"`
+}
+
+func TestPublisherDedupAndGolden(t *testing.T) {
+	js := New()
+	js.AddStreams(t, events.TenantStream)
+	pub := events.NewPublisher[tenant](events.TenantStream, events.TenantCreatedSubject, js.StreamManager(), true, nil)
+
+	ctx := events.WithCorrelationID(context.Background(), "corr-1")
+	for range 2 {
+		if _, err := pub.PublishPreferred(ctx, tenant{Name: "acme"}, "tenant-1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msgs := js.AssertPublished(t, events.TenantCreatedSubject, 1)
+	if msgs[0].MsgID != "tenant-1" || msgs[0].Header.Get(jetstream.MsgIDHeader) != "tenant-1" {
+		t.Fatalf("msg id = %q", msgs[0].MsgID)
+	}
+	got := DecodePublished[tenant](t, js, events.TenantCreatedSubject)
+	if got[0].Data.Name != "acme" || got[0].CorrelationID != "corr-1" {
+		t.Fatalf("decoded %+v", got[0])
+	}
+	GoldenEvent(t, "tenant.created", msgs[0])
+}
+
+func TestPublishFailureInjection(t *testing.T) {
+	js := New()
+	js.AddStreams(t, events.TenantStream)
+	pub := events.NewPublisher[tenant](events.TenantStream, events.TenantCreatedSubject, js.StreamManager(), false, nil)
+
+	boom := errors.New("boom")
+	js.FailPublish(events.TenantCreatedSubject, boom, 1)
+	if _, err := pub.PublishBestEffort(context.Background(), tenant{Name: "a"}); !errors.Is(err, boom) {
+		t.Fatalf("err = %v, want boom", err)
+	}
+	if _, err := pub.PublishBestEffort(context.Background(), tenant{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	js.AssertPublished(t, events.TenantCreatedSubject, 1)
+
+	if _, err := js.Publish(context.Background(), "no.such.subject", nil); !errors.Is(err, jetstream.ErrNoStreamResponse) {
+		t.Fatalf("err = %v, want ErrNoStreamResponse", err)
+	}
+}
+
+func TestListenerRedeliveryAndPoison(t *testing.T) {
+	js := New()
+	js.AddStreams(t, events.TenantStream)
+
+	var mu sync.Mutex
+	calls := map[string]int{}
+	var poisoned []string
+	l := events.NewListener(events.TenantStream, "test", jetstream.DeliverAllPolicy, jetstream.AckExplicitPolicy,
+		30*time.Second, nil, nil, js.StreamManager(),
+		func(ctx context.Context, msg events.Event[json.RawMessage]) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls[msg.ID]++
+			switch {
+			case msg.ID == "flaky" && calls[msg.ID] == 1:
+				return errors.New("transient")
+			case msg.ID == "broken":
+				return errors.New("always")
+			}
+			return nil
+		})
+	l.MaxDeliver = 3
+	l.PoisonHandler = func(_ context.Context, subject string, _ []byte, _ *jetstream.MsgMetadata) {
+		mu.Lock()
+		defer mu.Unlock()
+		poisoned = append(poisoned, subject)
+	}
+
+	okSeq := Inject(t, js, events.TenantCreatedSubject, tenant{Name: "a"}, events.Envelope{ID: "ok"})
+	js.StartListener(t, l)
+	flakySeq := Inject(t, js, events.TenantCreatedSubject, tenant{Name: "b"}, events.Envelope{ID: "flaky"})
+	brokenSeq := Inject(t, js, events.TenantCreatedSubject, tenant{Name: "c"}, events.Envelope{ID: "broken"})
+	poisonSeq := js.InjectRaw(t, events.TenantUpdatedSubject, []byte("{not json"), nil)
+
+	deliveries := js.WaitIdle(t, events.TenantStream, "test")
+	want := map[uint64]Delivery{
+		okSeq:     {Sequence: okSeq, Subject: string(events.TenantCreatedSubject), Deliveries: 1, Acked: true},
+		flakySeq:  {Sequence: flakySeq, Subject: string(events.TenantCreatedSubject), Deliveries: 2, Naks: 1, Acked: true},
+		brokenSeq: {Sequence: brokenSeq, Subject: string(events.TenantCreatedSubject), Deliveries: 3, Naks: 3, Dropped: true},
+		poisonSeq: {Sequence: poisonSeq, Subject: string(events.TenantUpdatedSubject), Deliveries: 1, Acked: true},
+	}
+	if len(deliveries) != len(want) {
+		t.Fatalf("deliveries = %+v", deliveries)
+	}
+	for _, d := range deliveries {
+		d.InProgress = 0
+		if d != want[d.Sequence] {
+			t.Errorf("delivery %d = %+v, want %+v", d.Sequence, d, want[d.Sequence])
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(poisoned) != 1 || poisoned[0] != string(events.TenantUpdatedSubject) {
+		t.Fatalf("poisoned = %v", poisoned)
+	}
+	if calls["broken"] != 3 {
+		t.Fatalf("broken handled %d times, want 3", calls["broken"])
+	}
+}
+
+func TestListenerBreakerPausesFetching(t *testing.T) {
+	js := New()
+	js.AddStreams(t, events.TenantStream)
+
+	var mu sync.Mutex
+	down := true
+	calls := map[string]int{}
+	l := events.NewListener(events.TenantStream, "test", jetstream.DeliverAllPolicy, jetstream.AckExplicitPolicy,
+		30*time.Second, nil, nil, js.StreamManager(),
+		func(ctx context.Context, msg events.Event[json.RawMessage]) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls[msg.ID]++
+			if down {
+				return errors.New("dependency down")
+			}
+			return nil
+		})
+	l.CircuitBreaker = &events.CircuitBreakerConfig{MinRequests: 2, OpenTimeout: 200 * time.Millisecond, HalfOpenSuccesses: 1}
+
+	js.StartListener(t, l)
+	tripSeq := Inject(t, js, events.TenantCreatedSubject, tenant{Name: "a"}, events.Envelope{ID: "trip"})
+	deadline := time.Now().Add(DefaultWaitTimeout)
+	for {
+		mu.Lock()
+		n := calls["trip"]
+		mu.Unlock()
+		if n >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("breaker did not trip")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Open: nothing is fetched, so nothing spends a delivery
+	heldSeq := Inject(t, js, events.TenantCreatedSubject, tenant{Name: "b"}, events.Envelope{ID: "held"})
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	if calls["trip"] != 2 || calls["held"] != 0 {
+		t.Fatalf("calls while open = %v", calls)
+	}
+	down = false
+	mu.Unlock()
+	for _, d := range js.DurableConsumer(t, events.TenantStream, "test").Deliveries() {
+		if d.Sequence == heldSeq {
+			t.Fatalf("held message delivered while open: %+v", d)
+		}
+	}
+
+	// Half-open probe succeeds, the breaker closes and the backlog drains
+	deliveries := js.WaitIdle(t, events.TenantStream, "test")
+	want := map[uint64]Delivery{
+		tripSeq: {Sequence: tripSeq, Subject: string(events.TenantCreatedSubject), Deliveries: 3, Naks: 2, Acked: true},
+		heldSeq: {Sequence: heldSeq, Subject: string(events.TenantCreatedSubject), Deliveries: 1, Acked: true},
+	}
+	if len(deliveries) != len(want) {
+		t.Fatalf("deliveries = %+v", deliveries)
+	}
+	for _, d := range deliveries {
+		d.InProgress = 0
+		if d != want[d.Sequence] {
+			t.Errorf("delivery %d = %+v, want %+v", d.Sequence, d, want[d.Sequence])
+		}
+	}
+}
+
+func TestEnvelopeSurvivesPublishAndListen(t *testing.T) {
+	js := New()
+	js.AddStreams(t, events.TenantStream)
+	pub := events.NewPublisher[tenant](events.TenantStream, events.TenantCreatedSubject, js.StreamManager(), true, nil)
+	pub.Producer = "tenant-service"
+	pub.SchemaVersion = 2
+
+	type handled struct{ event, fromCtx events.Envelope }
+	var mu sync.Mutex
+	var got []handled
+	l := events.NewListener(events.TenantStream, "test", jetstream.DeliverAllPolicy, jetstream.AckExplicitPolicy,
+		30*time.Second, nil, nil, js.StreamManager(),
+		func(ctx context.Context, msg events.Event[json.RawMessage]) error {
+			env, _ := events.EnvelopeFromContext(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, handled{msg.Envelope, env})
+			return nil
+		})
+	js.StartListener(t, l)
+
+	ctx := context.WithValue(context.Background(), helpers.TenantIDKey, "tenant-1")
+	ctx = context.WithValue(ctx, helpers.UserIDKey, "user-1")
+	ctx = events.WithCorrelationID(ctx, "corr-1")
+	if _, err := pub.PublishPreferred(ctx, tenant{Name: "acme"}, "tenant-1"); err != nil {
+		t.Fatal(err)
+	}
+	published := js.AssertPublished(t, events.TenantCreatedSubject, 1)[0]
+	want := DecodePublished[tenant](t, js, events.TenantCreatedSubject)[0].Envelope
+	if want.ID == "" || want.Producer != "tenant-service" || want.SchemaVersion != 2 ||
+		want.TenantID != "tenant-1" || want.Actor != "user-1" || want.CorrelationID != "corr-1" {
+		t.Fatalf("published envelope = %+v", want)
+	}
+
+	// A producer that only sets the headers (pre-envelope body) is read
+	// back the same way.
+	header := nats.Header{}
+	for k, v := range published.Header {
+		if k != jetstream.MsgIDHeader {
+			header[k] = v
+		}
+	}
+	js.InjectRaw(t, events.TenantCreatedSubject, []byte(`{"subject":"tenant.created","data":{"name":"legacy"}}`), header)
+	js.WaitIdle(t, events.TenantStream, "test")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 2 {
+		t.Fatalf("handled %d events, want 2", len(got))
+	}
+	for i, h := range got {
+		for _, env := range []events.Envelope{h.event, h.fromCtx} {
+			if !env.OccurredAt.Equal(want.OccurredAt) {
+				t.Fatalf("event %d occurredAt = %v, want %v", i, env.OccurredAt, want.OccurredAt)
+			}
+			env.OccurredAt = want.OccurredAt
+			if env != want {
+				t.Fatalf("event %d envelope = %+v, want %+v", i, env, want)
+			}
+		}
+	}
+}
+
+func TestConsumerInfoAndDeliverPolicies(t *testing.T) {
+	js := New()
+	s := js.AddStream(t, jetstream.StreamConfig{Name: "S", Subjects: []string{"s.>"}})
+	for _, subj := range []string{"s.a", "s.b", "s.a"} {
+		if _, err := js.Publish(context.Background(), subj, []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := s.CreateOrUpdateConsumer(context.Background(), jetstream.ConsumerConfig{Durable: "a", FilterSubject: "s.a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := c.Info(context.Background())
+	if info.NumPending != 2 {
+		t.Fatalf("pending = %d, want 2", info.NumPending)
+	}
+
+	last, _ := s.CreateOrUpdateConsumer(context.Background(), jetstream.ConsumerConfig{Durable: "last", DeliverPolicy: jetstream.DeliverLastPolicy})
+	info, _ = last.Info(context.Background())
+	if info.NumPending != 1 {
+		t.Fatalf("last pending = %d, want 1", info.NumPending)
+	}
+
+	got := make(chan jetstream.Msg, 2)
+	cc, err := c.Consume(func(m jetstream.Msg) { got <- m })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Stop()
+	first := <-got
+	second := <-got
+	if err := first.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Ack(); !errors.Is(err, jetstream.ErrMsgAlreadyAckd) {
+		t.Fatalf("double ack err = %v", err)
+	}
+	info, _ = c.Info(context.Background())
+	if info.NumAckPending != 1 || info.AckFloor.Stream != 2 || info.Delivered.Stream != 3 {
+		t.Fatalf("info = %+v", info)
+	}
+	_ = second.Ack()
+	if !c.(*Consumer).Idle() {
+		t.Fatal("consumer not idle after acking everything")
+	}
+}
+
+func TestSubjectMatching(t *testing.T) {
+	cases := []struct {
+		pattern, subject string
+		want             bool
+	}{
+		{"a.b", "a.b", true},
+		{"a.*", "a.b", true},
+		{"a.*", "a.b.c", false},
+		{"a.>", "a.b.c", true},
+		{"a.>", "a", false},
+		{"*.b", "a.c", false},
+	}
+	for _, c := range cases {
+		if got := subjectMatches(c.pattern, c.subject); got != c.want {
+			t.Errorf("subjectMatches(%q, %q) = %v", c.pattern, c.subject, got)
+		}
+	}
+	if !subjectsOverlap("a.*", "a.>") || subjectsOverlap("a.b", "a.c") {
+		t.Fatal("subjectsOverlap")
+	}
+}
+
+func TestConsumerFilterValidation(t *testing.T) {
+	js := New()
+	s, err := js.CreateStream(context.Background(), jetstream.StreamConfig{Name: "S", Subjects: []string{"a.>"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		cfg  jetstream.ConsumerConfig
+		want error
+	}{
+		{jetstream.ConsumerConfig{Durable: "c", FilterSubjects: []string{"a.b.c", "a.b.>"}}, jetstream.ErrOverlappingFilterSubjects},
+		{jetstream.ConsumerConfig{Durable: "c", FilterSubjects: []string{"a.*.c", "a.b.*"}}, jetstream.ErrOverlappingFilterSubjects},
+		{jetstream.ConsumerConfig{Durable: "c", FilterSubject: "a.b", FilterSubjects: []string{"a.c"}}, jetstream.ErrDuplicateFilterSubjects},
+		{jetstream.ConsumerConfig{Durable: "c", FilterSubjects: []string{"a.b", "a.c.>"}}, nil},
+	}
+	for _, c := range cases {
+		if _, err := s.CreateOrUpdateConsumer(context.Background(), c.cfg); !errors.Is(err, c.want) {
+			t.Errorf("%v: err = %v, want %v", c.cfg.FilterSubjects, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+// Package eventstest is an in-memory JetStream for testing code built on
+// events.Publisher, events.Listener and events.JsStreamManager without a NATS
+// server.
+//
+//	js := eventstest.New()
+//	js.AddStreams(t, events.PlanStream)
+//	pub := events.NewPublisher[Plan](events.PlanStream, events.PlanCreatedSubject, js.StreamManager(), true, nil)
+//	...
+//	js.AssertPublished(t, events.PlanCreatedSubject, 1)
+//
+// It keeps the semantics those packages rely on: subject routing to streams,
+// Nats-Msg-Id deduplication, expected-stream checks, durable pull consumers
+// with deliver policies, filters, Ack/Nak/NakWithDelay/Term, MaxDeliver and
+// MaxAckPending, and ordered consumers. AckWait expiry, clustering and the core NATS connection
+// (Conn returns nil) are not simulated; calling an unsupported method
+// panics. For core NATS request/reply, connect to a Server instead.
+//
+// The events package logs through the common logger, so initialize it in
+// TestMain as a service would at startup.
+package eventstest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/praction-networks/common/events"
+)
+
+// Message is a message stored in a fake stream.
+type Message struct {
+	Stream   string
+	Sequence uint64
+	Subject  string
+	Data     []byte
+	Header   nats.Header
+	MsgID    string
+	Time     time.Time
+}
+
+// JetStream is a fake jetstream.JetStream.
+type JetStream struct {
+	jetstream.JetStream // unsupported methods panic
+
+	// MaxRedeliveryDelay caps NakWithDelay so tests don't wait out
+	// production backoffs. 0 redelivers immediately.
+	MaxRedeliveryDelay time.Duration
+
+	mu        sync.Mutex
+	streams   map[string]*Stream
+	published []Message
+	failures  []*publishFailure
+	now       func() time.Time
+}
+
+type publishFailure struct {
+	subject string
+	err     error
+	times   int // < 0: forever
+}
+
+var errNotSupported = errors.New("eventstest: not supported by the fake JetStream")
+
+// New creates an empty fake JetStream.
+func New() *JetStream {
+	return &JetStream{streams: map[string]*Stream{}, now: time.Now}
+}
+
+// StreamManager returns an events.JsStreamManager backed by js.
+func (js *JetStream) StreamManager() *events.JsStreamManager {
+	return events.NewStreamManager(js)
+}
+
+// AddStreams creates streams from the events.Streams registry.
+func (js *JetStream) AddStreams(t testing.TB, names ...events.StreamName) {
+	t.Helper()
+	for _, name := range names {
+		meta, ok := events.Streams[name]
+		if !ok {
+			t.Fatalf("eventstest: stream %s is not in events.Streams", name)
+		}
+		if err := js.StreamManager().CreateOrUpdateStream(context.Background(), meta.StreamConfig()); err != nil {
+			t.Fatalf("eventstest: %v", err)
+		}
+	}
+}
+
+// AddStream creates a stream from a raw config.
+func (js *JetStream) AddStream(t testing.TB, cfg jetstream.StreamConfig) *Stream {
+	t.Helper()
+	if _, err := js.CreateOrUpdateStream(context.Background(), cfg); err != nil {
+		t.Fatalf("eventstest: %v", err)
+	}
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	return js.streams[cfg.Name]
+}
+
+// FailPublish makes the next times publishes to subject (wildcards allowed)
+// fail with err; times < 0 fails them until ClearFailures.
+func (js *JetStream) FailPublish(subject events.Subject, err error, times int) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.failures = append(js.failures, &publishFailure{subject: string(subject), err: err, times: times})
+}
+
+// ClearFailures removes every FailPublish rule.
+func (js *JetStream) ClearFailures() {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.failures = nil
+}
+
+// Conn returns nil: there is no core NATS connection.
+func (js *JetStream) Conn() *nats.Conn { return nil }
+
+// CreateStream implements jetstream.StreamManager.
+func (js *JetStream) CreateStream(ctx context.Context, cfg jetstream.StreamConfig) (jetstream.Stream, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if _, ok := js.streams[cfg.Name]; ok {
+		return nil, jetstream.ErrStreamNameAlreadyInUse
+	}
+	return js.putStreamLocked(cfg)
+}
+
+// CreateOrUpdateStream implements jetstream.StreamManager.
+func (js *JetStream) CreateOrUpdateStream(ctx context.Context, cfg jetstream.StreamConfig) (jetstream.Stream, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	return js.putStreamLocked(cfg)
+}
+
+// UpdateStream implements jetstream.StreamManager.
+func (js *JetStream) UpdateStream(ctx context.Context, cfg jetstream.StreamConfig) (jetstream.Stream, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if _, ok := js.streams[cfg.Name]; !ok {
+		return nil, jetstream.ErrStreamNotFound
+	}
+	return js.putStreamLocked(cfg)
+}
+
+func (js *JetStream) putStreamLocked(cfg jetstream.StreamConfig) (jetstream.Stream, error) {
+	if cfg.Name == "" {
+		return nil, jetstream.ErrStreamNameRequired
+	}
+	for name, s := range js.streams {
+		if name == cfg.Name {
+			continue
+		}
+		for _, a := range cfg.Subjects {
+			for _, b := range s.cfg.Subjects {
+				if subjectsOverlap(a, b) {
+					return nil, fmt.Errorf("eventstest: subjects overlap with stream %s", name)
+				}
+			}
+		}
+	}
+	if cfg.Duplicates == 0 {
+		cfg.Duplicates = 2 * time.Minute
+	}
+	if s, ok := js.streams[cfg.Name]; ok {
+		s.cfg = cfg
+		return s, nil
+	}
+	s := &Stream{js: js, cfg: cfg, created: js.now(), consumers: map[string]*Consumer{}, msgIDs: map[string]dedupEntry{}}
+	js.streams[cfg.Name] = s
+	return s, nil
+}
+
+// Stream implements jetstream.StreamManager.
+func (js *JetStream) Stream(ctx context.Context, name string) (jetstream.Stream, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	s, ok := js.streams[name]
+	if !ok {
+		return nil, jetstream.ErrStreamNotFound
+	}
+	return s, nil
+}
+
+// StreamNameBySubject implements jetstream.StreamManager.
+func (js *JetStream) StreamNameBySubject(ctx context.Context, subject string) (string, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if s := js.streamForSubjectLocked(subject); s != nil {
+		return s.cfg.Name, nil
+	}
+	return "", jetstream.ErrStreamNotFound
+}
+
+// DeleteStream implements jetstream.StreamManager.
+func (js *JetStream) DeleteStream(ctx context.Context, name string) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	s, ok := js.streams[name]
+	if !ok {
+		return jetstream.ErrStreamNotFound
+	}
+	for _, c := range s.consumers {
+		c.stopAllLocked()
+	}
+	delete(js.streams, name)
+	return nil
+}
+
+// ListStreams implements jetstream.StreamManager.
+func (js *JetStream) ListStreams(ctx context.Context, _ ...jetstream.StreamListOpt) jetstream.StreamInfoLister {
+	js.mu.Lock()
+	names := make([]string, 0, len(js.streams))
+	for name := range js.streams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ch := make(chan *jetstream.StreamInfo, len(names))
+	for _, name := range names {
+		ch <- js.streams[name].infoLocked()
+	}
+	js.mu.Unlock()
+	close(ch)
+	return streamLister{ch: ch}
+}
+
+type streamLister struct{ ch chan *jetstream.StreamInfo }
+
+func (l streamLister) Info() <-chan *jetstream.StreamInfo { return l.ch }
+func (l streamLister) Err() error                         { return nil }
+
+// CreateOrUpdateConsumer implements jetstream.StreamConsumerManager.
+func (js *JetStream) CreateOrUpdateConsumer(ctx context.Context, stream string, cfg jetstream.ConsumerConfig) (jetstream.Consumer, error) {
+	s, err := js.Stream(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+	return s.CreateOrUpdateConsumer(ctx, cfg)
+}
+
+// Consumer implements jetstream.StreamConsumerManager.
+func (js *JetStream) Consumer(ctx context.Context, stream, name string) (jetstream.Consumer, error) {
+	s, err := js.Stream(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+	return s.Consumer(ctx, name)
+}
+
+// OrderedConsumer implements jetstream.JetStream; see Stream.OrderedConsumer.
+func (js *JetStream) OrderedConsumer(ctx context.Context, stream string, cfg jetstream.OrderedConsumerConfig) (jetstream.Consumer, error) {
+	s, err := js.Stream(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+	return s.OrderedConsumer(ctx, cfg)
+}
+
+// DeleteConsumer implements jetstream.StreamConsumerManager.
+func (js *JetStream) DeleteConsumer(ctx context.Context, stream, name string) error {
+	s, err := js.Stream(ctx, stream)
+	if err != nil {
+		return err
+	}
+	return s.DeleteConsumer(ctx, name)
+}
+
+// Publish implements jetstream.Publisher.
+func (js *JetStream) Publish(ctx context.Context, subject string, data []byte, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	return js.PublishMsg(ctx, &nats.Msg{Subject: subject, Data: data}, opts...)
+}
+
+// PublishMsg implements jetstream.Publisher: the message is stored in the
+// stream owning its subject, honouring WithMsgID deduplication and
+// WithExpectStream.
+func (js *JetStream) PublishMsg(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	msgID, expectStream := publishOptions(opts)
+	if msgID == "" && msg.Header != nil {
+		msgID = msg.Header.Get(jetstream.MsgIDHeader)
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if err := js.injectedFailureLocked(msg.Subject); err != nil {
+		return nil, err
+	}
+	s := js.streamForSubjectLocked(msg.Subject)
+	if s == nil {
+		return nil, jetstream.ErrNoStreamResponse
+	}
+	if expectStream != "" && expectStream != s.cfg.Name {
+		return nil, fmt.Errorf("eventstest: expected stream %s, subject %s is in %s", expectStream, msg.Subject, s.cfg.Name)
+	}
+	ack, stored := s.storeLocked(msg, msgID)
+	if stored != nil {
+		js.published = append(js.published, *stored)
+	}
+	return ack, nil
+}
+
+// PublishAsync implements jetstream.Publisher; the publish completes before
+// it returns.
+func (js *JetStream) PublishAsync(subject string, data []byte, opts ...jetstream.PublishOpt) (jetstream.PubAckFuture, error) {
+	return js.PublishMsgAsync(&nats.Msg{Subject: subject, Data: data}, opts...)
+}
+
+// PublishMsgAsync implements jetstream.Publisher; the publish completes
+// before it returns.
+func (js *JetStream) PublishMsgAsync(msg *nats.Msg, opts ...jetstream.PublishOpt) (jetstream.PubAckFuture, error) {
+	f := &pubAckFuture{msg: msg, ok: make(chan *jetstream.PubAck, 1), err: make(chan error, 1)}
+	ack, err := js.PublishMsg(context.Background(), msg, opts...)
+	if err != nil {
+		f.err <- err
+	} else {
+		f.ok <- ack
+	}
+	return f, nil
+}
+
+// PublishAsyncPending implements jetstream.Publisher.
+func (js *JetStream) PublishAsyncPending() int { return 0 }
+
+// PublishAsyncComplete implements jetstream.Publisher.
+func (js *JetStream) PublishAsyncComplete() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+type pubAckFuture struct {
+	msg *nats.Msg
+	ok  chan *jetstream.PubAck
+	err chan error
+}
+
+func (f *pubAckFuture) Ok() <-chan *jetstream.PubAck { return f.ok }
+func (f *pubAckFuture) Err() <-chan error            { return f.err }
+func (f *pubAckFuture) Msg() *nats.Msg               { return f.msg }
+
+func (js *JetStream) injectedFailureLocked(subject string) error {
+	for i, f := range js.failures {
+		if !subjectMatches(f.subject, subject) {
+			continue
+		}
+		if f.times > 0 {
+			f.times--
+			if f.times == 0 {
+				js.failures = append(js.failures[:i:i], js.failures[i+1:]...)
+			}
+		}
+		return f.err
+	}
+	return nil
+}
+
+func (js *JetStream) streamForSubjectLocked(subject string) *Stream {
+	for _, s := range js.streams {
+		for _, pattern := range s.cfg.Subjects {
+			if subjectMatches(pattern, subject) {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
+// publishOptions reads the message ID and expected stream out of publish
+// options. Their fields are unexported, so each option is applied to a fresh
+// options value and the fields are read back by reflection.
+func publishOptions(opts []jetstream.PublishOpt) (msgID, expectStream string) {
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		fn := reflect.ValueOf(opt)
+		arg := reflect.New(fn.Type().In(0).Elem())
+		fn.Call([]reflect.Value{arg})
+		v := arg.Elem()
+		if f := v.FieldByName("id"); f.IsValid() && f.String() != "" {
+			msgID = f.String()
+		}
+		if f := v.FieldByName("stream"); f.IsValid() && f.String() != "" {
+			expectStream = f.String()
+		}
+	}
+	return msgID, expectStream
+}
+
+// getMsgSubject reads WithGetMsgSubject out of get options (see
+// publishOptions).
+func getMsgSubject(opts []jetstream.GetMsgOpt) string {
+	subject := ""
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		fn := reflect.ValueOf(opt)
+		arg := reflect.New(fn.Type().In(0).Elem())
+		fn.Call([]reflect.Value{arg})
+		if f := arg.Elem().FieldByName("NextFor"); f.IsValid() && f.String() != "" {
+			subject = f.String()
+		}
+	}
+	return subject
+}
+
+// subjectMatches reports whether subject matches a NATS pattern with * and >
+// wildcards.
+func subjectMatches(pattern, subject string) bool {
+	p := strings.Split(pattern, ".")
+	s := strings.Split(subject, ".")
+	for i, tok := range p {
+		if tok == ">" {
+			return len(s) > i
+		}
+		if i >= len(s) || (tok != "*" && tok != s[i]) {
+			return false
+		}
+	}
+	return len(p) == len(s)
+}
+
+// subjectsOverlap reports whether some subject matches both patterns.
+func subjectsOverlap(a, b string) bool {
+	at, bt := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(at) && i < len(bt); i++ {
+		if at[i] == ">" || bt[i] == ">" {
+			return true
+		}
+		if at[i] != "*" && bt[i] != "*" && at[i] != bt[i] {
+			return false
+		}
+	}
+	return len(at) == len(bt)
+}
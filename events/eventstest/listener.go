@@ -0,0 +1,118 @@
+package eventstest
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/praction-networks/common/events"
+)
+
+// DefaultWaitTimeout bounds StartListener and WaitIdle.
+const DefaultWaitTimeout = 5 * time.Second
+
+// StartListener runs l.Listen in the background until the test ends and
+// returns once its consumer is consuming. l.StreamManager must be backed by
+// js.
+func (js *JetStream) StartListener(t testing.TB, l *events.Listener) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- l.Listen(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	deadline := time.Now().Add(DefaultWaitTimeout)
+	for {
+		select {
+		case err := <-done:
+			t.Fatalf("eventstest: listener %s exited: %v", l.Durable, err)
+		default:
+		}
+		if c := js.consumer(string(l.StreamName), l.Durable); c != nil && c.consuming() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("eventstest: listener %s did not start consuming", l.Durable)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Inject publishes data wrapped in an events.Event on subject, bypassing
+// Publisher, and returns its stream sequence. env fills the envelope; a
+// missing ID is generated.
+func Inject[T any](t testing.TB, js *JetStream, subject events.Subject, data T, env events.Envelope) uint64 {
+	t.Helper()
+	if env.ID == "" {
+		env.ID = uuid.NewString()
+	}
+	if env.OccurredAt.IsZero() {
+		env.OccurredAt = js.now().UTC()
+	}
+	raw, err := json.Marshal(events.Event[T]{Subject: subject, Data: data, Envelope: env})
+	if err != nil {
+		t.Fatalf("eventstest: %v", err)
+	}
+	return js.InjectRaw(t, subject, raw, nil)
+}
+
+// InjectRaw stores a crafted payload (e.g. a poison message) on subject and
+// returns its stream sequence.
+func (js *JetStream) InjectRaw(t testing.TB, subject events.Subject, data []byte, header nats.Header) uint64 {
+	t.Helper()
+	ack, err := js.PublishMsg(context.Background(), &nats.Msg{Subject: string(subject), Data: data, Header: header})
+	if err != nil {
+		t.Fatalf("eventstest: inject %s: %v", subject, err)
+	}
+	return ack.Sequence
+}
+
+// DurableConsumer returns the durable consumer on stream, failing t when
+// there is none.
+func (js *JetStream) DurableConsumer(t testing.TB, stream events.StreamName, durable string) *Consumer {
+	t.Helper()
+	c := js.consumer(string(stream), durable)
+	if c == nil {
+		t.Fatalf("eventstest: no consumer %s on stream %s", durable, stream)
+	}
+	return c
+}
+
+// WaitIdle waits until the durable consumer has delivered and settled every
+// matching message (acked, termed or dropped after MaxDeliver) and returns
+// what happened to each of them.
+func (js *JetStream) WaitIdle(t testing.TB, stream events.StreamName, durable string) []Delivery {
+	t.Helper()
+	c := js.DurableConsumer(t, stream, durable)
+	deadline := time.Now().Add(DefaultWaitTimeout)
+	for !c.Idle() {
+		if time.Now().After(deadline) {
+			t.Fatalf("eventstest: consumer %s on stream %s not idle after %s: %+v",
+				durable, stream, DefaultWaitTimeout, c.Deliveries())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return c.Deliveries()
+}
+
+func (js *JetStream) consumer(stream, durable string) *Consumer {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	s, ok := js.streams[stream]
+	if !ok {
+		return nil
+	}
+	return s.consumers[durable]
+}
+
+func (c *Consumer) consuming() bool {
+	c.s.js.mu.Lock()
+	defer c.s.js.mu.Unlock()
+	return len(c.subs) > 0
+}
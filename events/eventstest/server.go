@@ -0,0 +1,373 @@
+package eventstest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/praction-networks/common/events"
+)
+
+// Server is an in-process core NATS server for code that needs a real
+// *nats.Conn, such as events.Requester and events.Responder:
+//
+//	srv := eventstest.NewServer(t)
+//	resp := events.NewResponder(srv.Connect(t), subject, "q", handler)
+//	req := events.NewRequester[Req, Resp](srv.Connect(t), subject)
+//
+// It speaks enough of the client protocol for publish/subscribe and
+// request/reply: SUB (with queue groups), UNSUB, PUB, HPUB, PING/PONG and
+// "no responders" replies. JetStream, auth, TLS and clustering are not
+// supported.
+type Server struct {
+	ln net.Listener
+
+	mu        sync.Mutex
+	subs      []*serverSub
+	published map[string]int
+	queueRR   map[string]int
+	clients   map[*serverClient]struct{}
+	closed    bool
+}
+
+type serverSub struct {
+	client  *serverClient
+	subject string
+	queue   string
+	sid     string
+	limit   int // auto-unsubscribe after limit messages; 0 = none
+	sent    int
+}
+
+type serverClient struct {
+	conn         net.Conn
+	noResponders bool
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+}
+
+// NewServer starts a server on a loopback port, stopped when the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("eventstest: %v", err)
+	}
+	s := &Server{
+		ln:        ln,
+		published: map[string]int{},
+		queueRR:   map[string]int{},
+		clients:   map[*serverClient]struct{}{},
+	}
+	go s.accept()
+	t.Cleanup(s.close)
+	return s
+}
+
+// URL is the address to nats.Connect to.
+func (s *Server) URL() string {
+	return "nats://" + s.ln.Addr().String()
+}
+
+// Connect opens a connection to the server, closed when the test ends.
+func (s *Server) Connect(t testing.TB, opts ...nats.Option) *nats.Conn {
+	t.Helper()
+	nc, err := nats.Connect(s.URL(), append([]nats.Option{nats.NoReconnect()}, opts...)...)
+	if err != nil {
+		t.Fatalf("eventstest: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+// Published returns how many messages were published on subject,
+// including requests nobody answered.
+func (s *Server) Published(subject events.Subject) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.published[string(subject)]
+}
+
+func (s *Server) close() {
+	s.mu.Lock()
+	s.closed = true
+	clients := s.clients
+	s.clients = map[*serverClient]struct{}{}
+	s.mu.Unlock()
+	_ = s.ln.Close()
+	for c := range clients {
+		_ = c.conn.Close()
+	}
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &serverClient{conn: conn, w: bufio.NewWriter(conn)}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.clients[c] = struct{}{}
+		s.mu.Unlock()
+		go s.serve(c)
+	}
+}
+
+func (s *Server) serve(c *serverClient) {
+	defer s.disconnect(c)
+
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	info, _ := json.Marshal(map[string]any{
+		"server_id":   "eventstest",
+		"server_name": "eventstest",
+		"version":     "2.10.0",
+		"proto":       1,
+		"host":        host,
+		"port":        portNum,
+		"headers":     true,
+		"max_payload": 1 << 20,
+	})
+	if c.send("INFO "+string(info)+"\r\n", nil) != nil {
+		return
+	}
+
+	r := bufio.NewReader(c.conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(strings.TrimRight(line, "\r\n"))
+		if len(args) == 0 {
+			continue
+		}
+		switch op := strings.ToUpper(args[0]); op {
+		case "CONNECT":
+			var opts struct {
+				NoResponders bool `json:"no_responders"`
+			}
+			_ = json.Unmarshal([]byte(strings.TrimSpace(line[len(args[0]):])), &opts)
+			c.noResponders = opts.NoResponders
+		case "PING":
+			err = c.send("PONG\r\n", nil)
+		case "PONG":
+		case "SUB":
+			err = s.subscribe(c, args[1:])
+		case "UNSUB":
+			err = s.unsubscribe(c, args[1:])
+		case "PUB", "HPUB":
+			err = s.publish(c, r, op == "HPUB", args[1:])
+		default:
+			err = fmt.Errorf("unknown operation %q", op)
+		}
+		if err != nil {
+			_ = c.send(fmt.Sprintf("-ERR '%v'\r\n", err), nil)
+			return
+		}
+	}
+}
+
+func (s *Server) disconnect(c *serverClient) {
+	s.mu.Lock()
+	delete(s.clients, c)
+	kept := s.subs[:0]
+	for _, sub := range s.subs {
+		if sub.client != c {
+			kept = append(kept, sub)
+		}
+	}
+	s.subs = kept
+	s.mu.Unlock()
+	_ = c.conn.Close()
+}
+
+// subscribe handles SUB <subject> [queue] <sid>.
+func (s *Server) subscribe(c *serverClient, args []string) error {
+	sub := &serverSub{client: c}
+	switch len(args) {
+	case 2:
+		sub.subject, sub.sid = args[0], args[1]
+	case 3:
+		sub.subject, sub.queue, sub.sid = args[0], args[1], args[2]
+	default:
+		return fmt.Errorf("malformed SUB")
+	}
+	s.mu.Lock()
+	s.subs = append(s.subs, sub)
+	s.mu.Unlock()
+	return nil
+}
+
+// unsubscribe handles UNSUB <sid> [max].
+func (s *Server) unsubscribe(c *serverClient, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("malformed UNSUB")
+	}
+	limit := 0
+	if len(args) > 1 {
+		limit, _ = strconv.Atoi(args[1])
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, sub := range s.subs {
+		if sub.client != c || sub.sid != args[0] {
+			continue
+		}
+		if limit > 0 && sub.sent < limit {
+			sub.limit = limit
+			return nil
+		}
+		s.subs = append(s.subs[:i], s.subs[i+1:]...)
+		return nil
+	}
+	return nil
+}
+
+// publish handles PUB <subject> [reply] <size> and
+// HPUB <subject> [reply] <header size> <total size>.
+func (s *Server) publish(c *serverClient, r *bufio.Reader, headers bool, args []string) error {
+	sizes := 1
+	if headers {
+		sizes = 2
+	}
+	if len(args) != sizes+1 && len(args) != sizes+2 {
+		return fmt.Errorf("malformed PUB")
+	}
+	subject, reply := args[0], ""
+	if len(args) == sizes+2 {
+		reply = args[1]
+	}
+	total, err := strconv.Atoi(args[len(args)-1])
+	if err != nil {
+		return fmt.Errorf("malformed PUB size: %w", err)
+	}
+	hdrSize := 0
+	if headers {
+		if hdrSize, err = strconv.Atoi(args[len(args)-2]); err != nil || hdrSize > total {
+			return fmt.Errorf("malformed HPUB header size")
+		}
+	}
+	payload := make([]byte, total+2)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return err
+	}
+	payload = payload[:total]
+
+	s.mu.Lock()
+	s.published[subject]++
+	targets := s.routeLocked(subject)
+	s.mu.Unlock()
+
+	if len(targets) == 0 && reply != "" && c.noResponders {
+		// What nats-server sends a request nobody subscribes to.
+		status := []byte("NATS/1.0 503\r\n\r\n")
+		s.mu.Lock()
+		replyTo := s.routeLocked(reply)
+		s.mu.Unlock()
+		for _, sub := range replyTo {
+			_ = sub.client.deliver(reply, sub.sid, "", status, len(status))
+		}
+		return nil
+	}
+	for _, sub := range targets {
+		if headers {
+			_ = sub.client.deliver(subject, sub.sid, reply, payload, hdrSize)
+		} else {
+			_ = sub.client.deliver(subject, sub.sid, reply, payload, -1)
+		}
+	}
+	return nil
+}
+
+// routeLocked picks the subscriptions a message on subject goes to: every
+// plain subscription and one member of each queue group, round robin.
+func (s *Server) routeLocked(subject string) []*serverSub {
+	var out []*serverSub
+	groups := map[string][]*serverSub{}
+	var groupOrder []string
+	for _, sub := range s.subs {
+		if !subjectMatches(sub.subject, subject) {
+			continue
+		}
+		if sub.queue == "" {
+			out = append(out, sub)
+			continue
+		}
+		key := sub.subject + " " + sub.queue
+		if _, ok := groups[key]; !ok {
+			groupOrder = append(groupOrder, key)
+		}
+		groups[key] = append(groups[key], sub)
+	}
+	for _, key := range groupOrder {
+		members := groups[key]
+		out = append(out, members[s.queueRR[key]%len(members)])
+		s.queueRR[key]++
+	}
+
+	kept := s.subs[:0]
+	for _, sub := range s.subs {
+		for _, o := range out {
+			if o == sub {
+				sub.sent++
+			}
+		}
+		if sub.limit == 0 || sub.sent < sub.limit {
+			kept = append(kept, sub)
+		}
+	}
+	s.subs = kept
+	return out
+}
+
+// deliver sends MSG, or HMSG when hdrSize >= 0.
+func (c *serverClient) deliver(subject, sid, reply string, payload []byte, hdrSize int) error {
+	var b strings.Builder
+	if hdrSize >= 0 {
+		b.WriteString("HMSG " + subject + " " + sid + " ")
+	} else {
+		b.WriteString("MSG " + subject + " " + sid + " ")
+	}
+	if reply != "" {
+		b.WriteString(reply + " ")
+	}
+	if hdrSize >= 0 {
+		b.WriteString(strconv.Itoa(hdrSize) + " ")
+	}
+	b.WriteString(strconv.Itoa(len(payload)) + "\r\n")
+	return c.send(b.String(), payload)
+}
+
+func (c *serverClient) send(line string, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(DefaultWaitTimeout))
+	if _, err := c.w.WriteString(line); err != nil {
+		return err
+	}
+	if payload != nil {
+		if _, err := c.w.Write(payload); err != nil {
+			return err
+		}
+		if _, err := c.w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return c.w.Flush()
+}
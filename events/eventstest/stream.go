@@ -0,0 +1,294 @@
+package eventstest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Stream is a fake jetstream.Stream.
+type Stream struct {
+	jetstream.Stream // unsupported methods panic
+
+	js        *JetStream
+	cfg       jetstream.StreamConfig
+	created   time.Time
+	msgs      []*Message
+	lastSeq   uint64
+	msgIDs    map[string]dedupEntry
+	consumers map[string]*Consumer
+	ordered   int // ordered consumers created, for their names
+}
+
+type dedupEntry struct {
+	seq uint64
+	at  time.Time
+}
+
+// storeLocked appends msg unless msgID was seen within the Duplicates
+// window. stored is nil for a duplicate.
+func (s *Stream) storeLocked(msg *nats.Msg, msgID string) (ack *jetstream.PubAck, stored *Message) {
+	now := s.js.now()
+	if msgID != "" {
+		if prev, ok := s.msgIDs[msgID]; ok && now.Sub(prev.at) < s.cfg.Duplicates {
+			return &jetstream.PubAck{Stream: s.cfg.Name, Sequence: prev.seq, Duplicate: true}, nil
+		}
+	}
+
+	header := nats.Header{}
+	for k, v := range msg.Header {
+		header[k] = append([]string(nil), v...)
+	}
+	if msgID != "" {
+		header.Set(jetstream.MsgIDHeader, msgID)
+	}
+	s.lastSeq++
+	m := &Message{
+		Stream:   s.cfg.Name,
+		Sequence: s.lastSeq,
+		Subject:  msg.Subject,
+		Data:     append([]byte(nil), msg.Data...),
+		Header:   header,
+		MsgID:    msgID,
+		Time:     now,
+	}
+	s.msgs = append(s.msgs, m)
+	if msgID != "" {
+		s.msgIDs[msgID] = dedupEntry{seq: m.Sequence, at: now}
+	}
+	if s.cfg.MaxMsgs > 0 && int64(len(s.msgs)) > s.cfg.MaxMsgs {
+		s.msgs = s.msgs[1:]
+	}
+	for _, c := range s.consumers {
+		c.notifyLocked()
+	}
+	return &jetstream.PubAck{Stream: s.cfg.Name, Sequence: m.Sequence}, m
+}
+
+func (s *Stream) msgLocked(seq uint64) *Message {
+	for _, m := range s.msgs {
+		if m.Sequence == seq {
+			return m
+		}
+	}
+	return nil
+}
+
+func (s *Stream) infoLocked() *jetstream.StreamInfo {
+	state := jetstream.StreamState{Msgs: uint64(len(s.msgs)), LastSeq: s.lastSeq, Consumers: len(s.consumers)}
+	if len(s.msgs) > 0 {
+		state.FirstSeq = s.msgs[0].Sequence
+		state.FirstTime = s.msgs[0].Time
+		state.LastTime = s.msgs[len(s.msgs)-1].Time
+		for _, m := range s.msgs {
+			state.Bytes += uint64(len(m.Data))
+		}
+	} else {
+		state.FirstSeq = s.lastSeq + 1
+	}
+	return &jetstream.StreamInfo{Config: s.cfg, Created: s.created, State: state, TimeStamp: s.js.now()}
+}
+
+// Messages returns a copy of the stored messages.
+func (s *Stream) Messages() []Message {
+	s.js.mu.Lock()
+	defer s.js.mu.Unlock()
+	out := make([]Message, len(s.msgs))
+	for i, m := range s.msgs {
+		out[i] = *m
+	}
+	return out
+}
+
+// Info implements jetstream.Stream.
+func (s *Stream) Info(ctx context.Context, _ ...jetstream.StreamInfoOpt) (*jetstream.StreamInfo, error) {
+	s.js.mu.Lock()
+	defer s.js.mu.Unlock()
+	return s.infoLocked(), nil
+}
+
+// CachedInfo implements jetstream.Stream.
+func (s *Stream) CachedInfo() *jetstream.StreamInfo {
+	s.js.mu.Lock()
+	defer s.js.mu.Unlock()
+	return s.infoLocked()
+}
+
+// GetMsg implements jetstream.Stream, including WithGetMsgSubject.
+func (s *Stream) GetMsg(ctx context.Context, seq uint64, opts ...jetstream.GetMsgOpt) (*jetstream.RawStreamMsg, error) {
+	subject := getMsgSubject(opts)
+	s.js.mu.Lock()
+	defer s.js.mu.Unlock()
+	for _, m := range s.msgs {
+		if m.Sequence < seq {
+			continue
+		}
+		if subject == "" && m.Sequence != seq {
+			break
+		}
+		if subject == "" || subjectMatches(subject, m.Subject) {
+			return rawMsg(m), nil
+		}
+	}
+	return nil, jetstream.ErrMsgNotFound
+}
+
+// GetLastMsgForSubject implements jetstream.Stream.
+func (s *Stream) GetLastMsgForSubject(ctx context.Context, subject string) (*jetstream.RawStreamMsg, error) {
+	s.js.mu.Lock()
+	defer s.js.mu.Unlock()
+	for i := len(s.msgs) - 1; i >= 0; i-- {
+		if subjectMatches(subject, s.msgs[i].Subject) {
+			return rawMsg(s.msgs[i]), nil
+		}
+	}
+	return nil, jetstream.ErrMsgNotFound
+}
+
+func rawMsg(m *Message) *jetstream.RawStreamMsg {
+	return &jetstream.RawStreamMsg{Subject: m.Subject, Sequence: m.Sequence, Header: m.Header, Data: m.Data, Time: m.Time}
+}
+
+// DeleteMsg implements jetstream.Stream.
+func (s *Stream) DeleteMsg(ctx context.Context, seq uint64) error {
+	s.js.mu.Lock()
+	defer s.js.mu.Unlock()
+	for i, m := range s.msgs {
+		if m.Sequence == seq {
+			s.msgs = append(s.msgs[:i:i], s.msgs[i+1:]...)
+			return nil
+		}
+	}
+	return jetstream.ErrMsgNotFound
+}
+
+// SecureDeleteMsg implements jetstream.Stream.
+func (s *Stream) SecureDeleteMsg(ctx context.Context, seq uint64) error {
+	return s.DeleteMsg(ctx, seq)
+}
+
+// Purge implements jetstream.Stream, including WithPurgeSubject.
+func (s *Stream) Purge(ctx context.Context, opts ...jetstream.StreamPurgeOpt) error {
+	var req jetstream.StreamPurgeRequest
+	for _, opt := range opts {
+		if err := opt(&req); err != nil {
+			return err
+		}
+	}
+	s.js.mu.Lock()
+	defer s.js.mu.Unlock()
+	kept := s.msgs[:0]
+	for _, m := range s.msgs {
+		if req.Subject != "" && !subjectMatches(req.Subject, m.Subject) {
+			kept = append(kept, m)
+		}
+	}
+	s.msgs = kept
+	return nil
+}
+
+// CreateOrUpdateConsumer implements jetstream.ConsumerManager. Only durable
+// pull consumers are supported. Filter subjects are validated like the
+// server does: FilterSubject and FilterSubjects are exclusive and
+// FilterSubjects may not overlap.
+func (s *Stream) CreateOrUpdateConsumer(ctx context.Context, cfg jetstream.ConsumerConfig) (jetstream.Consumer, error) {
+	name := cfg.Durable
+	if name == "" {
+		name = cfg.Name
+	}
+	if name == "" {
+		return nil, errNotSupported
+	}
+	if err := validateFilterSubjects(cfg); err != nil {
+		return nil, err
+	}
+	s.js.mu.Lock()
+	defer s.js.mu.Unlock()
+	if c, ok := s.consumers[name]; ok {
+		c.cfg = cfg
+		return c, nil
+	}
+	c := newConsumer(s, name, cfg)
+	s.consumers[name] = c
+	return c, nil
+}
+
+func validateFilterSubjects(cfg jetstream.ConsumerConfig) error {
+	if cfg.FilterSubject != "" && len(cfg.FilterSubjects) > 0 {
+		return jetstream.ErrDuplicateFilterSubjects
+	}
+	for i, a := range cfg.FilterSubjects {
+		if a == "" {
+			return jetstream.ErrEmptyFilter
+		}
+		for _, b := range cfg.FilterSubjects[i+1:] {
+			if subjectsOverlap(a, b) {
+				return jetstream.ErrOverlappingFilterSubjects
+			}
+		}
+	}
+	return nil
+}
+
+// OrderedConsumer implements jetstream.Stream. The consumer is ephemeral
+// and needs no acks; it is removed once its Messages or Consume
+// subscriptions stop. Resets after missed messages, HeadersOnly and
+// InactiveThreshold are not simulated.
+func (s *Stream) OrderedConsumer(ctx context.Context, cfg jetstream.OrderedConsumerConfig) (jetstream.Consumer, error) {
+	ccfg := jetstream.ConsumerConfig{
+		FilterSubjects: cfg.FilterSubjects,
+		DeliverPolicy:  cfg.DeliverPolicy,
+		OptStartSeq:    cfg.OptStartSeq,
+		OptStartTime:   cfg.OptStartTime,
+		AckPolicy:      jetstream.AckNonePolicy,
+		MaxDeliver:     1,
+	}
+	if err := validateFilterSubjects(ccfg); err != nil {
+		return nil, err
+	}
+	s.js.mu.Lock()
+	defer s.js.mu.Unlock()
+	s.ordered++
+	ccfg.Name = fmt.Sprintf("ordered_%d", s.ordered)
+	c := newConsumer(s, ccfg.Name, ccfg)
+	c.ephemeral = true
+	s.consumers[ccfg.Name] = c
+	return c, nil
+}
+
+// CreateConsumer implements jetstream.ConsumerManager.
+func (s *Stream) CreateConsumer(ctx context.Context, cfg jetstream.ConsumerConfig) (jetstream.Consumer, error) {
+	return s.CreateOrUpdateConsumer(ctx, cfg)
+}
+
+// UpdateConsumer implements jetstream.ConsumerManager.
+func (s *Stream) UpdateConsumer(ctx context.Context, cfg jetstream.ConsumerConfig) (jetstream.Consumer, error) {
+	return s.CreateOrUpdateConsumer(ctx, cfg)
+}
+
+// Consumer implements jetstream.ConsumerManager.
+func (s *Stream) Consumer(ctx context.Context, name string) (jetstream.Consumer, error) {
+	s.js.mu.Lock()
+	defer s.js.mu.Unlock()
+	c, ok := s.consumers[name]
+	if !ok {
+		return nil, jetstream.ErrConsumerNotFound
+	}
+	return c, nil
+}
+
+// DeleteConsumer implements jetstream.ConsumerManager.
+func (s *Stream) DeleteConsumer(ctx context.Context, name string) error {
+	s.js.mu.Lock()
+	defer s.js.mu.Unlock()
+	c, ok := s.consumers[name]
+	if !ok {
+		return jetstream.ErrConsumerNotFound
+	}
+	c.stopAllLocked()
+	delete(s.consumers, name)
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/praction-networks/common/logger"
 	"go.mongodb.org/mongo-driver/bson"
@@ -24,7 +25,8 @@ func ReplayFallbackOnce(ctx context.Context, jsm *JsStreamManager, coll *mongo.C
 		limit = 100
 	}
 
-	cur, err := coll.Find(ctx, bson.M{}, mopt.Find().
+	// Parked documents are left for FallbackReplayer.Unpark / an operator.
+	cur, err := coll.Find(ctx, bson.M{FailedNATSFieldParked: bson.M{"$ne": true}}, mopt.Find().
 		SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "attempts", Value: 1}}).
 		SetLimit(int64(limit)))
 	if err != nil {
@@ -44,18 +46,7 @@ func ReplayFallbackOnce(ctx context.Context, jsm *JsStreamManager, coll *mongo.C
 			continue
 		}
 
-		// MsgID is the part after the first '|'
-		msgID := d.ID
-		if i := strings.IndexByte(d.ID, '|'); i >= 0 && i+1 < len(d.ID) {
-			msgID = d.ID[i+1:]
-		}
-
-		jsOpts := []jetstream.PublishOpt{
-			jetstream.WithMsgID(msgID),               // idempotent store on retries
-			jetstream.WithExpectStream(d.StreamName), // safety: ensure correct stream
-		}
-
-		if ack, perr := jsm.JsClient.Publish(ctx, d.Subject, d.Payload, jsOpts...); perr == nil {
+		if ack, perr := publishStoredEvent(ctx, jsm, d); perr == nil {
 			// success (Duplicate=true is also success)
 			if _, derr := coll.DeleteOne(ctx, bson.M{"_id": d.ID}); derr != nil {
 				logger.Error("fallback delete failed after success", derr, "id", d.ID)
@@ -85,3 +76,27 @@ func ReplayFallbackOnce(ctx context.Context, jsm *JsStreamManager, coll *mongo.C
 	}
 	return
 }
+
+// publishStoredEvent republishes a FailedNATSEvent-shaped document (fallback
+// or outbox) with its stored MsgID for dedup and the envelope headers rebuilt
+// from the payload.
+func publishStoredEvent(ctx context.Context, jsm *JsStreamManager, d FailedNATSEvent) (*jetstream.PubAck, error) {
+	msgID := d.MsgID
+	if msgID == "" {
+		msgID = storedMsgID(d.ID)
+	}
+	jsOpts := []jetstream.PublishOpt{
+		jetstream.WithMsgID(msgID),               // idempotent store on retries
+		jetstream.WithExpectStream(d.StreamName), // safety: ensure correct stream
+	}
+	msg := &nats.Msg{Subject: d.Subject, Data: d.Payload, Header: envelopeHeaderFromPayload(d.Payload)}
+	return jsm.JsClient.PublishMsg(ctx, msg, jsOpts...)
+}
+
+// storedMsgID returns the MsgID part of a "<Stream>|<MsgID>" document _id.
+func storedMsgID(docID string) string {
+	if i := strings.IndexByte(docID, '|'); i >= 0 && i+1 < len(docID) {
+		return docID[i+1:]
+	}
+	return docID
+}
@@ -5,13 +5,19 @@ import (
 )
 
 const (
-	FailedNATSFieldID         = "_id"
-	FailedNATSFieldStreamName = "streamName"
-	FailedNATSFieldSubject    = "subject"
-	FailedNATSFieldPayload    = "payload"
-	FailedNATSFieldAttempts   = "attempts"
-	FailedNATSFieldTimestamp  = "timestamp"
-	FailedNATSFieldLastError  = "lastError"
+	FailedNATSFieldID          = "_id"
+	FailedNATSFieldStreamName  = "streamName"
+	FailedNATSFieldSubject     = "subject"
+	FailedNATSFieldPayload     = "payload"
+	FailedNATSFieldAttempts    = "attempts"
+	FailedNATSFieldTimestamp   = "timestamp"
+	FailedNATSFieldLastError   = "lastError"
+	FailedNATSFieldNextAttempt = "nextAttemptAt"
+	FailedNATSFieldParked      = "parked"
+	FailedNATSFieldParkedAt    = "parkedAt"
+	FailedNATSFieldReplays     = "replayAttempts"
+	FailedNATSFieldMsgID       = "msgId"
+	FailedNATSFieldSeq         = "seq"
 )
 
 type FailedNATSEvent struct {
@@ -22,4 +28,17 @@ type FailedNATSEvent struct {
 	Attempts   int       `bson:"attempts" json:"attempts"`
 	Timestamp  time.Time `bson:"timestamp" json:"timestamp"`
 	LastError  string    `bson:"lastError,omitempty" json:"lastError,omitempty"`
+
+	// Set by FallbackReplayer: its own failed attempts (Attempts also
+	// counts the publisher's in-process retries), when the document is next
+	// due, and whether it has been parked after exhausting its attempts.
+	ReplayAttempts int       `bson:"replayAttempts,omitempty" json:"replayAttempts,omitempty"`
+	NextAttemptAt  time.Time `bson:"nextAttemptAt,omitempty" json:"nextAttemptAt,omitzero"`
+	Parked         bool      `bson:"parked,omitempty" json:"parked,omitempty"`
+	ParkedAt       time.Time `bson:"parkedAt,omitempty" json:"parkedAt,omitzero"`
+
+	// Set on outbox documents: the MsgID to publish with when it isn't the
+	// one in ID, and the order OutboxRelay relays in.
+	MsgID string `bson:"msgId,omitempty" json:"msgId,omitempty"`
+	Seq   int64  `bson:"seq,omitempty" json:"seq,omitempty"`
 }
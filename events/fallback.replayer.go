@@ -0,0 +1,235 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/praction-networks/common/lease"
+	"github.com/praction-networks/common/logger"
+	"github.com/praction-networks/common/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopt "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FallbackReplayer continuously republishes documents from a Publisher's
+// FallbackStorage collection. It replaces per-service ticker loops around
+// ReplayFallbackOnce:
+//
+//   - only the lease holder replays (lease.RunAsLeader), so replicas don't
+//     race on the same documents;
+//   - each document waits BaseBackoff·2^(replays-1) (capped at MaxBackoff)
+//     before its next attempt, tracked in nextAttemptAt, where replays
+//     counts this replayer's failures only (replayAttempts), not the
+//     publisher's in-process retries already in attempts;
+//   - after MaxAttempts failed replays a document is parked and left for an
+//     operator (see Unpark);
+//   - backlog size and oldest-pending age are exported as gauges.
+type FallbackReplayer struct {
+	Collection    *mongo.Collection
+	StreamManager *JsStreamManager
+	Leaser        lease.Leaser
+
+	LeaseKey    string        // default "fallback-replayer:<collection>"
+	HolderID    string        // default hostname
+	LeaseTTL    time.Duration // default 30s
+	Interval    time.Duration // pass cadence; default 5s
+	BatchSize   int           // default 100
+	MaxAttempts int           // park after this many failed replays; default 20
+	BaseBackoff time.Duration // default 1s
+	MaxBackoff  time.Duration // default 10m
+}
+
+// NewFallbackReplayer creates a replayer with sane defaults.
+func NewFallbackReplayer(coll *mongo.Collection, streamManager *JsStreamManager, leaser lease.Leaser) *FallbackReplayer {
+	holder, _ := os.Hostname()
+	return &FallbackReplayer{
+		Collection:    coll,
+		StreamManager: streamManager,
+		Leaser:        leaser,
+		LeaseKey:      "fallback-replayer:" + coll.Name(),
+		HolderID:      holder,
+		LeaseTTL:      30 * time.Second,
+		Interval:      5 * time.Second,
+		BatchSize:     100,
+		MaxAttempts:   20,
+		BaseBackoff:   time.Second,
+		MaxBackoff:    10 * time.Minute,
+	}
+}
+
+// Run blocks until ctx is cancelled. While this replica holds the lease it
+// replays due documents every Interval; otherwise it retries the lease on
+// the same cadence.
+func (r *FallbackReplayer) Run(ctx context.Context) error {
+	name := "fallback-replayer:" + r.Collection.Name()
+	logger.GoroutineStarted(name)
+	defer logger.GoroutineStopped(name, nil)
+
+	for {
+		_, err := lease.RunAsLeader(ctx, r.Leaser, r.LeaseKey, r.HolderID, r.LeaseTTL, 0, r.loop)
+		if err != nil && ctx.Err() == nil {
+			logger.Warn("Fallback replayer leader run ended with error", err, "collection", r.Collection.Name())
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.Interval):
+		}
+	}
+}
+
+func (r *FallbackReplayer) loop(ctx context.Context) error {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		if _, _, _, _, err := r.ReplayOnce(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("Fallback replay pass failed", err, "collection", r.Collection.Name())
+		}
+		if err := r.CollectBacklog(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("Fallback backlog collection failed", err, "collection", r.Collection.Name())
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// dueFilter selects unparked documents whose backoff has elapsed. Documents
+// written by Publisher have no nextAttemptAt yet and are due immediately.
+func dueFilter(now time.Time) bson.M {
+	return bson.M{
+		FailedNATSFieldParked: bson.M{"$ne": true},
+		"$or": bson.A{
+			bson.M{FailedNATSFieldNextAttempt: bson.M{"$exists": false}},
+			bson.M{FailedNATSFieldNextAttempt: bson.M{"$lte": now}},
+		},
+	}
+}
+
+// ReplayOnce runs a single pass over up to BatchSize due documents.
+func (r *FallbackReplayer) ReplayOnce(ctx context.Context) (processed, ok, failed, parked int, err error) {
+	limit := r.BatchSize
+	if limit <= 0 {
+		limit = 100
+	}
+	now := time.Now()
+	cur, err := r.Collection.Find(ctx, dueFilter(now), mopt.Find().
+		SetSort(bson.D{{Key: FailedNATSFieldTimestamp, Value: 1}}).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return 0, 0, 0, 0, fmt.Errorf("failed to read fallback collection: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	collName := r.Collection.Name()
+	for cur.Next(ctx) {
+		processed++
+
+		var d FailedNATSEvent
+		if derr := cur.Decode(&d); derr != nil {
+			logger.Error("fallback decode failed", derr)
+			failed++
+			continue
+		}
+
+		if _, perr := publishStoredEvent(ctx, r.StreamManager, d); perr == nil {
+			if _, derr := r.Collection.DeleteOne(ctx, bson.M{FailedNATSFieldID: d.ID}); derr != nil {
+				logger.Error("fallback delete failed after success", derr, "id", d.ID)
+			}
+			metrics.RecordNATSPublished(d.StreamName, d.Subject)
+			metrics.RecordNATSFallbackReplay(collName, "ok")
+			ok++
+			continue
+		} else {
+			metrics.RecordNATSFailure(d.StreamName, d.Subject, perr)
+			replays := d.ReplayAttempts + 1
+			set := bson.M{
+				FailedNATSFieldLastError:   perr.Error(),
+				FailedNATSFieldNextAttempt: now.Add(r.backoff(replays)),
+			}
+			park := r.MaxAttempts > 0 && replays >= r.MaxAttempts
+			if park {
+				set[FailedNATSFieldParked] = true
+				set[FailedNATSFieldParkedAt] = now
+			}
+			if _, uerr := r.Collection.UpdateByID(ctx, d.ID, bson.M{
+				"$set": set,
+				"$inc": bson.M{FailedNATSFieldAttempts: 1, FailedNATSFieldReplays: 1},
+			}); uerr != nil {
+				logger.Error("fallback update failed", uerr, "id", d.ID)
+			}
+			if park {
+				logger.Error("Fallback event parked after max attempts", perr,
+					"id", d.ID, "subject", d.Subject, "attempts", d.Attempts+1, "replays", replays)
+				metrics.RecordNATSFallbackReplay(collName, "parked")
+				parked++
+				continue
+			}
+			metrics.RecordNATSFallbackReplay(collName, "failed")
+			failed++
+		}
+	}
+	return processed, ok, failed, parked, cur.Err()
+}
+
+// backoff returns the wait before the next attempt after attempts failed
+// replays.
+func (r *FallbackReplayer) backoff(attempts int) time.Duration {
+	// Cap the exponent so the shift in nextBackoff can't overflow.
+	if attempts > 30 {
+		attempts = 30
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+	return nextBackoff(r.BaseBackoff, r.MaxBackoff, 0, attempts)
+}
+
+// CollectBacklog refreshes the backlog gauges for the collection.
+func (r *FallbackReplayer) CollectBacklog(ctx context.Context) error {
+	pending, err := r.Collection.CountDocuments(ctx, bson.M{FailedNATSFieldParked: bson.M{"$ne": true}})
+	if err != nil {
+		return fmt.Errorf("failed to count pending fallback events: %w", err)
+	}
+	parked, err := r.Collection.CountDocuments(ctx, bson.M{FailedNATSFieldParked: true})
+	if err != nil {
+		return fmt.Errorf("failed to count parked fallback events: %w", err)
+	}
+
+	var oldestAge time.Duration
+	var oldest FailedNATSEvent
+	err = r.Collection.FindOne(ctx, bson.M{FailedNATSFieldParked: bson.M{"$ne": true}},
+		mopt.FindOne().SetSort(bson.D{{Key: FailedNATSFieldTimestamp, Value: 1}}).
+			SetProjection(bson.M{FailedNATSFieldTimestamp: 1})).Decode(&oldest)
+	switch {
+	case err == nil:
+		oldestAge = time.Since(oldest.Timestamp)
+	case err != mongo.ErrNoDocuments:
+		return fmt.Errorf("failed to read oldest fallback event: %w", err)
+	}
+
+	metrics.SetNATSFallbackBacklog(r.Collection.Name(), pending, parked, oldestAge)
+	return nil
+}
+
+// Unpark returns a parked document to the replay queue with a fresh attempt
+// budget. An empty id unparks every parked document.
+func (r *FallbackReplayer) Unpark(ctx context.Context, id string) (int64, error) {
+	filter := bson.M{FailedNATSFieldParked: true}
+	if id != "" {
+		filter[FailedNATSFieldID] = id
+	}
+	res, err := r.Collection.UpdateMany(ctx, filter, bson.M{
+		"$set":   bson.M{FailedNATSFieldReplays: 0},
+		"$unset": bson.M{FailedNATSFieldParked: "", FailedNATSFieldParkedAt: "", FailedNATSFieldNextAttempt: ""},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to unpark fallback events: %w", err)
+	}
+	return res.ModifiedCount, nil
+}
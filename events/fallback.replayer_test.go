@@ -0,0 +1,26 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFallbackReplayerBackoff(t *testing.T) {
+	r := &FallbackReplayer{BaseBackoff: time.Second, MaxBackoff: time.Minute}
+	cases := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{7, time.Minute},
+		{1000, time.Minute}, // exponent capped, no overflow
+	}
+	for _, c := range cases {
+		if got := r.backoff(c.attempts); got != c.want {
+			t.Errorf("backoff(%d) = %v, want %v", c.attempts, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package events
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/praction-networks/common/logger"
+	"github.com/praction-networks/common/metrics"
+)
+
+// ClaimStatus is the outcome of IdempotencyStore.Claim.
+type ClaimStatus int
+
+const (
+	// ClaimAcquired means the caller owns the key and should run the handler.
+	ClaimAcquired ClaimStatus = iota
+	// ClaimDuplicate means the key was already processed successfully.
+	ClaimDuplicate
+	// ClaimInFlight means another delivery holds an unexpired claim on the
+	// key, e.g. a redelivery after AckWait while the first is still running.
+	ClaimInFlight
+)
+
+// IdempotencyStore records which messages a durable consumer has processed.
+// Implementations live in sub-packages (mongoidempotency, redisidempotency)
+// so the events package doesn't pull in a Redis client.
+//
+// A key moves through two states: claimed (processing, short TTL so a crashed
+// handler's claim expires) and completed (long TTL, the dedup window).
+type IdempotencyStore interface {
+	// Claim marks key as processing for claimTTL unless it is already
+	// completed or claimed by someone else. An expired claim may be taken
+	// over.
+	Claim(ctx context.Context, key string, claimTTL time.Duration) (ClaimStatus, error)
+
+	// Complete marks key processed and keeps it for ttl.
+	Complete(ctx context.Context, key string, ttl time.Duration) error
+
+	// Release drops a claim after a failed attempt so the redelivery runs.
+	Release(ctx context.Context, key string) error
+}
+
+// DefaultIdempotencyTTL is how long processed message IDs are remembered when
+// Listener.IdempotencyTTL is unset.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// idempotencyKey identifies a message for the listener's durable: the
+// envelope's Event-Id when present (so a fallback replay of an already
+// consumed event is caught too), the stream sequence otherwise. Not the
+// Nats-Msg-Id: for DeliveryGuaranteed that is a content hash, shared by
+// two legitimate events with the same payload.
+func (l *Listener) idempotencyKey(headers nats.Header, meta *jetstream.MsgMetadata) string {
+	prefix := string(l.StreamName) + ":" + l.Durable + ":"
+	if id := headers.Get(HeaderEventID); id != "" {
+		return prefix + "event:" + id
+	}
+	return prefix + "seq:" + strconv.FormatUint(meta.Sequence.Stream, 10)
+}
+
+// claimTTL bounds how long a crashed handler's claim blocks redeliveries.
+func (l *Listener) claimTTL() time.Duration {
+	ttl := 2 * l.AckWait
+	if ttl < time.Minute {
+		ttl = time.Minute
+	}
+	return ttl
+}
+
+// claimMessage runs the Idempotency guard. It returns the claimed key and
+// true when the handler should run; otherwise the message has already been
+// settled (acked as a duplicate, or NAKed while in flight elsewhere or when
+// the store is unavailable).
+func (l *Listener) claimMessage(ctx context.Context, msg jetstream.Msg, meta *jetstream.MsgMetadata) (string, bool) {
+	if l.Idempotency == nil {
+		return "", true
+	}
+	key := l.idempotencyKey(msg.Headers(), meta)
+	status, err := l.Idempotency.Claim(ctx, key, l.claimTTL())
+	if err != nil {
+		// Fail closed: running without the guard would defeat it.
+		l.logHandlerOutcome("idempotency", msg.Subject(), meta.Sequence.Stream, 0, err, "idempotencyKey", key)
+		l.nakWithPolicy(msg, meta)
+		return "", false
+	}
+	switch status {
+	case ClaimDuplicate:
+		metrics.RecordNATSDuplicateSuppressed(string(l.StreamName), l.Durable, msg.Subject())
+		logger.Debug("Skipping already processed message",
+			logger.KeyStream, string(l.StreamName), logger.KeySubject, msg.Subject(),
+			logger.KeySequence, meta.Sequence.Stream, "idempotencyKey", key)
+		_ = msg.Ack()
+		return "", false
+	case ClaimInFlight:
+		l.nakWithPolicy(msg, meta)
+		return "", false
+	}
+	return key, true
+}
+
+// completeMessage records a successful handler run.
+func (l *Listener) completeMessage(ctx context.Context, key string) {
+	if l.Idempotency == nil || key == "" {
+		return
+	}
+	ttl := l.IdempotencyTTL
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	if err := l.Idempotency.Complete(ctx, key, ttl); err != nil {
+		logger.Warn("Failed to record processed message", err,
+			logger.KeyStream, string(l.StreamName), "idempotencyKey", key)
+	}
+}
+
+// releaseMessage drops the claim after a failed handler run.
+func (l *Listener) releaseMessage(ctx context.Context, key string) {
+	if l.Idempotency == nil || key == "" {
+		return
+	}
+	if err := l.Idempotency.Release(ctx, key); err != nil {
+		logger.Warn("Failed to release idempotency claim", err,
+			logger.KeyStream, string(l.StreamName), "idempotencyKey", key)
+	}
+}
@@ -0,0 +1,403 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/praction-networks/common/logger"
+	"github.com/praction-networks/common/metrics"
+)
+
+// BreakerState is the state of a Listener's circuit breaker.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // handling normally
+	BreakerOpen                         // fetching paused
+	BreakerHalfOpen                     // probing with single messages
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreakerConfig configures Listener.CircuitBreaker. When a dependency
+// of the handler is down every message fails and is NAKed, which only burns
+// deliveries and floods the logs. The breaker trips once FailureRate of the
+// handler outcomes in Window failed, stops fetching for OpenTimeout, then
+// lets single probe messages through until HalfOpenSuccesses of them in a
+// row succeed.
+type CircuitBreakerConfig struct {
+	FailureRate       float64       // default 0.5
+	MinRequests       int           // outcomes in Window before the rate counts; default 20
+	Window            time.Duration // default 30s
+	OpenTimeout       time.Duration // default 30s
+	HalfOpenSuccesses int           // default 3
+
+	// IsFailure decides which handler errors count against the breaker.
+	// Default: every error except ErrPoisonMessage, which is about the
+	// payload, not the dependency.
+	IsFailure func(err error) bool
+}
+
+func (c CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if c.FailureRate <= 0 || c.FailureRate > 1 {
+		c.FailureRate = 0.5
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 20
+	}
+	if c.Window <= 0 {
+		c.Window = 30 * time.Second
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = 30 * time.Second
+	}
+	if c.HalfOpenSuccesses <= 0 {
+		c.HalfOpenSuccesses = 3
+	}
+	if c.IsFailure == nil {
+		c.IsFailure = func(err error) bool { return !errors.Is(err, ErrPoisonMessage) }
+	}
+	return c
+}
+
+const breakerBuckets = 10
+
+type breakerBucket struct {
+	slot          int64
+	total, failed int
+}
+
+// circuitBreaker tracks handler outcomes in a rolling window of buckets.
+type circuitBreaker struct {
+	cfg      CircuitBreakerConfig
+	now      func() time.Time
+	onChange func(from, to BreakerState, failureRate float64)
+
+	mu             sync.Mutex
+	state          BreakerState
+	openUntil      time.Time
+	probing        bool
+	probeSuccesses int
+	buckets        [breakerBuckets]breakerBucket
+	changed        chan struct{} // closed when a held-back message may retry
+}
+
+func newCircuitBreaker(cfg CircuitBreakerConfig, onChange func(from, to BreakerState, failureRate float64)) *circuitBreaker {
+	return &circuitBreaker{cfg: cfg.withDefaults(), now: time.Now, onChange: onChange}
+}
+
+func (b *circuitBreaker) slot(t time.Time) int64 {
+	return t.UnixNano() / int64(b.cfg.Window/breakerBuckets)
+}
+
+// failureRate returns the outcomes and failure rate within the window.
+func (b *circuitBreaker) failureRate(now time.Time) (total int, rate float64) {
+	cur := b.slot(now)
+	failed := 0
+	for _, bk := range b.buckets {
+		if cur-bk.slot < breakerBuckets {
+			total += bk.total
+			failed += bk.failed
+		}
+	}
+	if total == 0 {
+		return 0, 0
+	}
+	return total, float64(failed) / float64(total)
+}
+
+// setState must be called with mu held; it returns the notification to send
+// once mu is released.
+func (b *circuitBreaker) setState(to BreakerState, rate float64) func() {
+	from := b.state
+	b.state = to
+	b.probing = false
+	b.probeSuccesses = 0
+	switch to {
+	case BreakerOpen:
+		b.openUntil = b.now().Add(b.cfg.OpenTimeout)
+	case BreakerClosed:
+		b.buckets = [breakerBuckets]breakerBucket{}
+	}
+	b.signalLocked()
+	if b.onChange == nil || from == to {
+		return func() {}
+	}
+	return func() { b.onChange(from, to, rate) }
+}
+
+// allow reports whether a message may be handled now. When it may not,
+// changed is closed once it is worth asking again. In half-open state one
+// probe is let through at a time; the caller must report its outcome with
+// record.
+func (b *circuitBreaker) allow() (ok bool, changed <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		return false, b.changedLocked()
+	case BreakerHalfOpen:
+		if b.probing {
+			return false, b.changedLocked()
+		}
+		b.probing = true
+	}
+	return true, nil
+}
+
+func (b *circuitBreaker) changedLocked() <-chan struct{} {
+	if b.changed == nil {
+		b.changed = make(chan struct{})
+	}
+	return b.changed
+}
+
+// signalLocked wakes the messages waiting in allow.
+func (b *circuitBreaker) signalLocked() {
+	if b.changed != nil {
+		close(b.changed)
+		b.changed = nil
+	}
+}
+
+// record reports the outcome of a message let through by allow.
+func (b *circuitBreaker) record(err error) {
+	failed := err != nil && b.cfg.IsFailure(err)
+
+	b.mu.Lock()
+	notify := func() {}
+	switch b.state {
+	case BreakerClosed:
+		now := b.now()
+		slot := b.slot(now)
+		bk := &b.buckets[slot%breakerBuckets]
+		if bk.slot != slot {
+			*bk = breakerBucket{slot: slot}
+		}
+		bk.total++
+		if failed {
+			bk.failed++
+		}
+		if total, rate := b.failureRate(now); total >= b.cfg.MinRequests && rate >= b.cfg.FailureRate {
+			notify = b.setState(BreakerOpen, rate)
+		}
+	case BreakerHalfOpen:
+		b.probing = false
+		b.signalLocked()
+		if failed {
+			notify = b.setState(BreakerOpen, 1)
+			break
+		}
+		b.probeSuccesses++
+		if b.probeSuccesses >= b.cfg.HalfOpenSuccesses {
+			notify = b.setState(BreakerClosed, 0)
+		}
+	}
+	b.mu.Unlock()
+	notify()
+}
+
+// halfOpen moves an open breaker to half-open once OpenTimeout has passed.
+func (b *circuitBreaker) halfOpen() {
+	b.mu.Lock()
+	notify := func() {}
+	if b.state == BreakerOpen && !b.now().Before(b.openUntil) {
+		notify = b.setState(BreakerHalfOpen, 0)
+	}
+	b.mu.Unlock()
+	notify()
+}
+
+// State returns the current state.
+func (b *circuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// breakerConsume keeps a Consume subscription running in step with the
+// breaker: stopped while open, fetching one message at a time while
+// half-open and normally while closed. A subscription being replaced is
+// drained rather than stopped, so the messages it already buffered reach
+// the handler and wait there for the breaker instead of sitting out
+// AckWait.
+type breakerConsume struct {
+	l        *Listener
+	consumer jetstream.Consumer
+	handler  jetstream.MessageHandler
+
+	mu      sync.Mutex
+	cc      jetstream.ConsumeContext
+	timer   *time.Timer
+	stopped bool
+	closed  <-chan struct{} // of the subscription drained by Drain
+}
+
+// consumeWithBreaker starts consuming and wires l's breaker to pause and
+// resume the subscription. Stop ends it.
+func (l *Listener) consumeWithBreaker(consumer jetstream.Consumer, handler jetstream.MessageHandler) (*breakerConsume, error) {
+	bc := &breakerConsume{l: l, consumer: consumer, handler: handler}
+	l.breaker = newCircuitBreaker(*l.CircuitBreaker, bc.onChange)
+	metrics.SetNATSCircuitBreakerState(string(l.StreamName), l.Durable, int(BreakerClosed))
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if err := bc.startLocked(); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+func (bc *breakerConsume) startLocked(opts ...jetstream.PullConsumeOpt) error {
+	cc, err := bc.consumer.Consume(bc.handler, opts...)
+	if err != nil {
+		return err
+	}
+	bc.cc = cc
+	return nil
+}
+
+func (bc *breakerConsume) onChange(from, to BreakerState, failureRate float64) {
+	l := bc.l
+	streamName := string(l.StreamName)
+	metrics.SetNATSCircuitBreakerState(streamName, l.Durable, int(to))
+	metrics.RecordNATSCircuitBreakerTransition(streamName, l.Durable, from.String(), to.String())
+
+	args := []interface{}{
+		logger.KeyStream, streamName, "consumer", l.Durable,
+		"from", from.String(), "to", to.String(),
+	}
+	if to == BreakerOpen {
+		logger.Warn("Listener circuit breaker opened, pausing consumption",
+			append(args, "failureRate", failureRate, "openFor", l.breaker.cfg.OpenTimeout.String())...)
+	} else {
+		logger.Info("Listener circuit breaker state changed", args...)
+	}
+
+	bc.apply(to)
+}
+
+// apply drains the current subscription and starts the one for state to.
+func (bc *breakerConsume) apply(to BreakerState) {
+	l := bc.l
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if bc.stopped {
+		return
+	}
+	if bc.cc != nil {
+		bc.cc.Drain()
+		bc.cc = nil
+	}
+	var err error
+	switch to {
+	case BreakerOpen:
+		bc.timer = time.AfterFunc(l.breaker.cfg.OpenTimeout, l.breaker.halfOpen)
+	case BreakerHalfOpen:
+		err = bc.startLocked(jetstream.PullMaxMessages(1))
+	case BreakerClosed:
+		err = bc.startLocked()
+	}
+	if err != nil {
+		// Try again after another OpenTimeout, in whatever state the breaker is then
+		logger.Error("Failed to resume consumption after circuit breaker change", err,
+			logger.KeyStream, string(l.StreamName), "consumer", l.Durable, "state", to.String())
+		bc.timer = time.AfterFunc(l.breaker.cfg.OpenTimeout, func() { bc.apply(l.breaker.State()) })
+	}
+}
+
+// Stop stops consumption for good.
+func (bc *breakerConsume) Stop() {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.stopped = true
+	if bc.timer != nil {
+		bc.timer.Stop()
+	}
+	if bc.cc != nil {
+		bc.cc.Stop()
+		bc.cc = nil
+	}
+}
+
+// Drain stops consumption for good once the running subscription has
+// handed its buffered messages to the handler; see Closed.
+func (bc *breakerConsume) Drain() {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.stopped = true
+	if bc.timer != nil {
+		bc.timer.Stop()
+	}
+	if bc.cc != nil {
+		bc.cc.Drain()
+		bc.closed = bc.cc.Closed()
+		bc.cc = nil
+	}
+}
+
+// Closed is closed once a drained subscription is done; straight away
+// when the breaker had consumption paused.
+func (bc *breakerConsume) Closed() <-chan struct{} {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if bc.closed == nil {
+		return closedChan
+	}
+	return bc.closed
+}
+
+// breakerAllow gates a message on the breaker. A held-back message waits
+// here, kept alive by the caller's InProgress ticks, until the breaker lets
+// it through; no delivery (MaxDeliver) is spent on it. The wait ends early
+// when the listener stops, drains or ctx is done: the message is then NAKed
+// for another instance and breakerAllow reports false.
+func (l *Listener) breakerAllow(ctx context.Context, msg jetstream.Msg, meta *jetstream.MsgMetadata, idemKey string) bool {
+	if l.breaker == nil {
+		return true
+	}
+	ok, changed := l.breaker.allow()
+	if ok {
+		return true
+	}
+	logger.Debug("Circuit breaker holding message back",
+		logger.KeyStream, string(l.StreamName), logger.KeySubject, msg.Subject(),
+		logger.KeySequence, meta.Sequence, "state", l.breaker.State().String())
+	stopCh, draining := l.stopChan(), l.inflight.stopping()
+	for {
+		select {
+		case <-changed:
+			if ok, changed = l.breaker.allow(); ok {
+				return true
+			}
+			continue
+		case <-stopCh:
+		case <-draining:
+		case <-ctx.Done():
+		}
+		l.releaseMessage(ctx, idemKey)
+		_ = msg.Nak()
+		return false
+	}
+}
+
+// breakerRecord reports a handler outcome to the breaker.
+func (l *Listener) breakerRecord(err error) {
+	if l.breaker != nil {
+		l.breaker.record(err)
+	}
+}
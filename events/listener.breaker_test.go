@@ -0,0 +1,109 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var transitions []string
+	b := newCircuitBreaker(CircuitBreakerConfig{
+		FailureRate:       0.5,
+		MinRequests:       4,
+		Window:            10 * time.Second,
+		OpenTimeout:       time.Minute,
+		HalfOpenSuccesses: 2,
+	}, func(from, to BreakerState, _ float64) {
+		transitions = append(transitions, from.String()+"->"+to.String())
+	})
+	b.now = func() time.Time { return now }
+	down := errors.New("mongo unavailable")
+
+	// Poison errors and a failure rate below MinRequests don't trip it
+	b.record(fmt.Errorf("%w: bad json", ErrPoisonMessage))
+	b.record(down)
+	b.record(down)
+	if b.State() != BreakerClosed {
+		t.Fatalf("state = %s, want closed below MinRequests", b.State())
+	}
+	b.record(down)
+	if b.State() != BreakerOpen {
+		t.Fatalf("state = %s, want open at 3/4 failures", b.State())
+	}
+	ok, changed := b.allow()
+	if ok || changed == nil {
+		t.Fatalf("allow while open = %v, %v; want false and a channel to wait on", ok, changed)
+	}
+
+	// Too early: stays open
+	now = now.Add(30 * time.Second)
+	b.halfOpen()
+	if b.State() != BreakerOpen {
+		t.Fatalf("state = %s, want open before OpenTimeout", b.State())
+	}
+
+	// Half-open lets one probe through at a time; a failed probe reopens
+	select {
+	case <-changed:
+		t.Fatal("waiters woken while still open")
+	default:
+	}
+	now = now.Add(30 * time.Second)
+	b.halfOpen()
+	<-changed
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("first probe not allowed")
+	}
+	ok, changed = b.allow()
+	if ok {
+		t.Fatal("second concurrent probe allowed")
+	}
+	b.record(down)
+	<-changed // the waiting message asks again, and is held back again
+	if b.State() != BreakerOpen {
+		t.Fatalf("state = %s, want open after failed probe", b.State())
+	}
+
+	// HalfOpenSuccesses good probes close it
+	now = now.Add(time.Minute)
+	b.halfOpen()
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.allow(); !ok {
+			t.Fatalf("probe %d not allowed", i)
+		}
+		b.record(nil)
+	}
+	if b.State() != BreakerClosed {
+		t.Fatalf("state = %s, want closed after good probes", b.State())
+	}
+
+	want := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+	if fmt.Sprint(transitions) != fmt.Sprint(want) {
+		t.Fatalf("transitions = %v, want %v", transitions, want)
+	}
+}
+
+func TestCircuitBreakerWindowExpires(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker(CircuitBreakerConfig{MinRequests: 4, Window: 10 * time.Second}, nil)
+	b.now = func() time.Time { return now }
+	down := errors.New("kyc provider down")
+
+	b.record(down)
+	b.record(down)
+	b.record(down)
+	now = now.Add(11 * time.Second) // earlier failures fall out of the window
+	b.record(down)
+	if b.State() != BreakerClosed {
+		t.Fatalf("state = %s, want closed once old failures expired", b.State())
+	}
+	b.record(nil)
+	b.record(down)
+	b.record(down)
+	if b.State() != BreakerOpen {
+		t.Fatalf("state = %s, want open at 3/4 failures in window", b.State())
+	}
+}
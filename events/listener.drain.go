@@ -0,0 +1,201 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/praction-networks/common/logger"
+)
+
+// inflightMessages tracks the messages a Listener's handlers are working on
+// so Drain can wait for them. Once draining, begin refuses new messages.
+type inflightMessages struct {
+	mu       sync.Mutex
+	draining bool
+	msgs     map[jetstream.Msg]struct{}
+	idle     chan struct{} // closed when draining and msgs is empty
+	quit     chan struct{} // closed when draining starts
+}
+
+func (f *inflightMessages) begin(msg jetstream.Msg) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.draining {
+		return false
+	}
+	if f.msgs == nil {
+		f.msgs = map[jetstream.Msg]struct{}{}
+	}
+	f.msgs[msg] = struct{}{}
+	return true
+}
+
+func (f *inflightMessages) end(msg jetstream.Msg) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.msgs, msg)
+	if f.draining && len(f.msgs) == 0 {
+		f.closeIdleLocked()
+	}
+}
+
+// drain refuses new messages and returns a channel closed once the current
+// ones are done.
+func (f *inflightMessages) drain() <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.draining {
+		f.draining = true
+		f.idle = make(chan struct{})
+		close(f.quitLocked())
+	}
+	if len(f.msgs) == 0 {
+		f.closeIdleLocked()
+	}
+	return f.idle
+}
+
+// stopping returns a channel closed once draining starts, for handlers
+// waiting on something other than their own work.
+func (f *inflightMessages) stopping() <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.quitLocked()
+}
+
+func (f *inflightMessages) quitLocked() chan struct{} {
+	if f.quit == nil {
+		f.quit = make(chan struct{})
+	}
+	return f.quit
+}
+
+// abandon returns the messages still in flight and forgets them.
+func (f *inflightMessages) abandon() []jetstream.Msg {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]jetstream.Msg, 0, len(f.msgs))
+	for msg := range f.msgs {
+		out = append(out, msg)
+	}
+	f.msgs = nil
+	f.closeIdleLocked()
+	return out
+}
+
+func (f *inflightMessages) closeIdleLocked() {
+	select {
+	case <-f.idle:
+	default:
+		close(f.idle)
+	}
+}
+
+// subscription is a Listener's running Consume: the jetstream
+// ConsumeContext, or the breakerConsume managing one.
+type subscription interface {
+	Stop()
+	Drain()
+	Closed() <-chan struct{}
+}
+
+// setSubscription records the running subscription for Drain. A listener
+// that is already draining drains it straight away.
+func (l *Listener) setSubscription(sub subscription) {
+	l.mu.Lock()
+	l.sub = sub
+	l.mu.Unlock()
+	if l.isDraining() {
+		sub.Drain()
+	}
+}
+
+func (l *Listener) isDraining() bool {
+	l.inflight.mu.Lock()
+	defer l.inflight.mu.Unlock()
+	return l.inflight.draining
+}
+
+// stopChan returns the channel closed by Stop and Drain, creating it for
+// listeners built without NewListener.
+func (l *Listener) stopChan() chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopCh == nil {
+		l.stopCh = make(chan struct{})
+	}
+	return l.stopCh
+}
+
+func (l *Listener) closeStopChan() {
+	ch := l.stopChan()
+	l.stopOnce.Do(func() { close(ch) })
+}
+
+// Drain shuts the listener down gracefully: it stops fetching, waits for the
+// handlers already running until ctx is done, then NAKs whatever is still in
+// flight with no delay so another instance picks it up instead of waiting
+// out AckWait. Messages that were fetched but not yet handed to a handler
+// (buffered by the subscription, or queued for a Concurrency worker) are
+// NAKed straight away: the subscription is drained, not stopped, so they
+// still reach the handler, which hands them back. Listen returns once Drain
+// is done. The returned error reports the messages that had to be NAKed.
+//
+// Call it alongside http.Server.Shutdown with the same deadline, or use a
+// ListenerGroup.
+func (l *Listener) Drain(ctx context.Context) error {
+	start := time.Now()
+	component := "nats-listener:" + l.Durable
+	logger.Info("Draining listener", logger.KeyStream, string(l.StreamName), "consumer", l.Durable)
+
+	idle := l.inflight.drain()
+	l.mu.Lock()
+	sub := l.sub
+	l.mu.Unlock()
+	flushed := closedChan
+	if sub != nil {
+		sub.Drain()
+		flushed = sub.Closed()
+	}
+
+	var err error
+	select {
+	case <-flushed:
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			err = l.abandonInflight(ctx)
+		}
+	case <-ctx.Done():
+		err = l.abandonInflight(ctx)
+	}
+	l.closeStopChan()
+
+	logger.GoroutineStopped(component, err,
+		logger.KeyStream, string(l.StreamName), "consumer", l.Durable,
+		logger.KeyDurationMs, time.Since(start).Milliseconds())
+	return err
+}
+
+// abandonInflight NAKs the messages Drain ran out of time waiting for.
+func (l *Listener) abandonInflight(ctx context.Context) error {
+	abandoned := l.inflight.abandon()
+	for _, msg := range abandoned {
+		_ = msg.Nak()
+	}
+	if len(abandoned) == 0 {
+		return nil
+	}
+	return fmt.Errorf("drain listener %s: NAKed %d in-flight messages: %w", l.Durable, len(abandoned), ctx.Err())
+}
+
+// closedChan stands in for the Closed channel of a subscription that never
+// started.
+var closedChan = func() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
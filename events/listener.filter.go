@@ -0,0 +1,239 @@
+package events
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/praction-networks/common/logger"
+	"github.com/praction-networks/common/metrics"
+)
+
+// FilterMessage is what a MessageFilter sees: the decoded event, its envelope
+// already filled from the headers.
+type FilterMessage struct {
+	Subject string
+	Header  nats.Header
+	Event   *Event[json.RawMessage]
+}
+
+// MessageFilter is a client-side stage on Listener.Filters. A message must
+// match every filter to reach OnMessageFunc; the others are acked and
+// skipped, and counted per filter (nats_consumer_filtered_total and
+// Listener.FilterSkips). High skip counts for a filter are a hint to move it
+// to the subject level, where the server does the filtering.
+type MessageFilter struct {
+	// Name labels the filter in metrics and logs.
+	Name string
+
+	// Match reports whether the listener should handle the message.
+	Match func(m *FilterMessage) bool
+
+	// Subjects, when set, is the same filter expressed as subject patterns.
+	// A listener without FilterSubject/FilterSubjects uses them as the
+	// consumer's server-side filter, so non-matching messages are never
+	// delivered at all.
+	Subjects []Subject
+}
+
+// FilterBySubjects keeps messages whose subject matches one of the patterns
+// (* and > wildcards allowed), e.g. "olt.event.alarm.*". It is applied by
+// the server when the listener has no subject filter of its own.
+func FilterBySubjects(subjects ...Subject) MessageFilter {
+	return MessageFilter{
+		Name: "subject",
+		Match: func(m *FilterMessage) bool {
+			return slices.ContainsFunc(subjects, func(s Subject) bool { return subjectMatches(string(s), m.Subject) })
+		},
+		Subjects: subjects,
+	}
+}
+
+// FilterTenants keeps messages for the given tenants: the envelope tenant
+// ID, else the data's "tenantId" or any of its "tenantIds".
+func FilterTenants(tenantIDs ...string) MessageFilter {
+	return MessageFilter{
+		Name: "tenant",
+		Match: func(m *FilterMessage) bool {
+			if m.Event.TenantID != "" {
+				return slices.Contains(tenantIDs, m.Event.TenantID)
+			}
+			for _, field := range []string{"tenantId", "tenantIds"} {
+				for _, v := range dataFieldValues(m.Event.Data, field) {
+					if slices.Contains(tenantIDs, v) {
+						return true
+					}
+				}
+			}
+			return false
+		},
+	}
+}
+
+// FilterSchemaVersions keeps messages whose producer schema version (before
+// upcasting) is within [min, max]; max 0 means no upper bound.
+func FilterSchemaVersions(min, max int) MessageFilter {
+	return MessageFilter{
+		Name: "schema_version",
+		Match: func(m *FilterMessage) bool {
+			v := m.Event.SchemaVersion
+			if v == 0 {
+				v = DefaultSchemaVersion
+			}
+			return v >= min && (max == 0 || v <= max)
+		},
+	}
+}
+
+// FilterHeader keeps messages whose header name has one of values.
+func FilterHeader(name string, values ...string) MessageFilter {
+	return MessageFilter{
+		Name: "header:" + name,
+		Match: func(m *FilterMessage) bool {
+			return slices.Contains(values, m.Header.Get(name))
+		},
+	}
+}
+
+// FilterDataField keeps messages whose data field at a dotted path
+// ("severity", "ont.serial") is one of values. A string or number field is
+// compared as text; for an array any element may match.
+func FilterDataField(path string, values ...string) MessageFilter {
+	return MessageFilter{
+		Name: "data:" + path,
+		Match: func(m *FilterMessage) bool {
+			for _, v := range dataFieldValues(m.Event.Data, path) {
+				if slices.Contains(values, v) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// FilterSeverity keeps messages whose data "severity" is one of severities,
+// e.g. oltevent.AlarmSeverityCritical.
+func FilterSeverity(severities ...string) MessageFilter {
+	f := FilterDataField("severity", severities...)
+	f.Name = "severity"
+	return f
+}
+
+// FilterFunc wraps an arbitrary predicate.
+func FilterFunc(name string, match func(m *FilterMessage) bool) MessageFilter {
+	return MessageFilter{Name: name, Match: match}
+}
+
+// dataFieldValues returns the string and number values at a dotted path in
+// data, flattening one level of array.
+func dataFieldValues(data json.RawMessage, path string) []string {
+	raw := dataField(data, strings.Split(path, "."))
+	if raw == nil {
+		return nil
+	}
+	if v, ok := scalarString(raw); ok {
+		return []string{v}
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil
+	}
+	var out []string
+	for _, item := range items {
+		if v, ok := scalarString(item); ok {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// dataField walks a dotted path through JSON objects.
+func dataField(raw json.RawMessage, parts []string) json.RawMessage {
+	for _, p := range parts {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return nil
+		}
+		if raw = obj[p]; raw == nil {
+			return nil
+		}
+	}
+	return raw
+}
+
+// scalarString renders a JSON string or number as text.
+func scalarString(raw json.RawMessage) (string, bool) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, true
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n.String(), true
+	}
+	return "", false
+}
+
+// serverFilterSubjects returns the consumer filter to derive from Filters
+// when the listener sets no subject filter itself.
+func (l *Listener) serverFilterSubjects() []Subject {
+	if l.FilterSubject != nil || len(l.FilterSubjects) > 0 {
+		return nil
+	}
+	for _, f := range l.Filters {
+		if len(f.Subjects) > 0 {
+			return f.Subjects
+		}
+	}
+	return nil
+}
+
+// filterSkips counts skipped messages per filter name.
+type filterSkips struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+// filter runs Filters on event and returns the name of the first filter it
+// fails, or "" when the message should be handled.
+func (l *Listener) filter(subject string, header nats.Header, event *Event[json.RawMessage]) string {
+	if len(l.Filters) == 0 {
+		return ""
+	}
+	m := &FilterMessage{Subject: subject, Header: header, Event: event}
+	for _, f := range l.Filters {
+		if f.Match != nil && !f.Match(m) {
+			name := f.Name
+			if name == "" {
+				name = "filter"
+			}
+			l.filterSkips.mu.Lock()
+			if l.filterSkips.counts == nil {
+				l.filterSkips.counts = map[string]uint64{}
+			}
+			l.filterSkips.counts[name]++
+			l.filterSkips.mu.Unlock()
+			metrics.RecordNATSConsumerFiltered(string(l.StreamName), l.Durable, name, subject)
+			logger.Debug("Skipping message filtered out by listener",
+				logger.KeyStream, string(l.StreamName), logger.KeySubject, subject,
+				"consumer", l.Durable, "filter", name, "eventId", event.ID)
+			return name
+		}
+	}
+	return ""
+}
+
+// FilterSkips returns how many messages each filter skipped since the
+// listener started, by filter name.
+func (l *Listener) FilterSkips() map[string]uint64 {
+	l.filterSkips.mu.Lock()
+	defer l.filterSkips.mu.Unlock()
+	out := make(map[string]uint64, len(l.filterSkips.counts))
+	for name, n := range l.filterSkips.counts {
+		out[name] = n
+	}
+	return out
+}
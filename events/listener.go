@@ -3,7 +3,9 @@ package events
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go/jetstream"
@@ -29,9 +31,15 @@ type Listener struct {
 	Backoff        []time.Duration // used for AckWait-expiry redelivery; we also use it to drive NakWithDelay
 	InProgressTick time.Duration   // how often to call msg.InProgress() while handler runs
 
-	// Optional: if we detect unrecoverable payload issues (e.g., JSON syntax), handle and drop
+	// Optional: if we detect unrecoverable payload issues (e.g., JSON syntax, or a
+	// handler returning ErrPoisonMessage such as a typed decode failure), handle and drop
 	PoisonHandler func(ctx context.Context, subject string, raw []byte, meta *jetstream.MsgMetadata)
 
+	// DeadLetter, when set, receives poison messages and messages on their
+	// final delivery (MaxDeliver > 0) instead of letting them disappear.
+	// PoisonHandler still runs first when both are configured.
+	DeadLetter *DeadLetterQueue
+
 	// HandlerName is an optional stable identifier for the handler attached to
 	// this listener (e.g. "tenant.created"). When set it is included in every
 	// per-message log so operators can attribute outcomes without parsing the
@@ -43,7 +51,53 @@ type Listener struct {
 	// the cluster-wide flip to Debug is staged in a later phase.
 	VerboseSuccessLog bool
 
-	stopCh chan struct{}
+	// Concurrency > 1 handles messages on that many workers instead of one
+	// at a time. Messages are sharded by OrderingKey, so messages sharing a
+	// key are still handled in delivery order. 0 or 1 keeps sequential
+	// processing.
+	Concurrency int
+
+	// OrderingKey picks the shard for a message (see KeyBySubject,
+	// KeyByDataField, ...). Nil or an empty key falls back to the subject.
+	OrderingKey OrderingKeyFunc
+
+	// ShardQueueSize bounds the messages buffered per shard. Default is
+	// MaxAckPending/Concurrency, so buffered plus in-flight messages never
+	// exceed what the server lets the consumer have outstanding.
+	ShardQueueSize int
+
+	// Idempotency, when set, records processed messages per durable and
+	// acks redeliveries and replays of already processed messages without
+	// calling OnMessageFunc (see IdempotencyStore).
+	Idempotency IdempotencyStore
+
+	// IdempotencyTTL is how long processed messages are remembered.
+	// Default DefaultIdempotencyTTL.
+	IdempotencyTTL time.Duration
+
+	// Upcasters, when set, brings older payload versions up to the latest
+	// shape before validation and dispatch.
+	Upcasters *UpcasterRegistry
+
+	// Schemas, when set, validates Data against the subject's registered
+	// schema before dispatch; violations are treated as poison.
+	Schemas *SchemaRegistry
+
+	// CircuitBreaker, when set, pauses consumption while handler failures
+	// exceed its failure rate (see CircuitBreakerConfig).
+	CircuitBreaker *CircuitBreakerConfig
+
+	// Filters, when set, skip (ack without handling) messages that don't
+	// match all of them; see MessageFilter.
+	Filters []MessageFilter
+
+	breaker     *circuitBreaker
+	filterSkips filterSkips
+	inflight    inflightMessages
+	mu          sync.Mutex
+	sub         subscription
+	stopOnce    sync.Once
+	stopCh      chan struct{}
 }
 
 // NewListener with sane defaults
@@ -98,6 +152,9 @@ const (
 
 // Listen initializes the consumer and starts message processing.
 func (l *Listener) Listen(ctx context.Context) error {
+	// Report the consumer (as not yet listening) in ConsumersStats
+	l.StreamManager.trackConsumer(l.StreamName, l.Durable, false)
+
 	// Ensure stream exists
 	stream, err := waitForStream(ctx, l.StreamManager, string(l.StreamName))
 	if err != nil {
@@ -112,7 +169,8 @@ func (l *Listener) Listen(ctx context.Context) error {
 
 	// For DeliverAllPolicy, always delete and recreate existing consumers to ensure
 	// we replay ALL messages from the beginning, including seed events that may have been
-	// published before the service started. Since handlers are idempotent, replaying is safe.
+	// published before the service started. Replaying relies on idempotent handlers; set
+	// Idempotency to have already processed messages skipped instead of re-handled.
 	// This ensures notification-service and tenant-user-service catch up on seed tenant events.
 	if err := l.deleteExistingConsumerIfNeeded(ctx, stream); err != nil {
 		logger.Warn("Failed to delete existing consumer, will try to update",
@@ -129,19 +187,47 @@ func (l *Listener) Listen(ctx context.Context) error {
 		return fmt.Errorf("failed to create or update consumer: %w", err)
 	}
 
-	sub, err := consumer.Consume(func(msg jetstream.Msg) {
+	var shards *shardPool
+	if l.Concurrency > 1 {
+		shards = l.newShardPool(ctx)
+		defer shards.stop()
+	}
+
+	stopCh := l.stopChan()
+	handler := func(msg jetstream.Msg) {
 		select {
-		case <-l.stopCh:
+		case <-stopCh:
 			logger.Info("Listener stopped, skipping message processing", "StreamName", l.StreamName)
+			_ = msg.Nak() // let another instance have it now rather than after AckWait
 			return
 		default:
+			if shards != nil {
+				shards.dispatch(ctx, msg)
+				return
+			}
 			l.processMessage(ctx, msg)
 		}
-	})
+	}
+	var sub subscription
+	if l.CircuitBreaker != nil {
+		sub, err = l.consumeWithBreaker(consumer, handler)
+	} else {
+		sub, err = consumer.Consume(handler)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to subscribe to subject: %w", err)
 	}
 	defer sub.Stop()
+	l.setSubscription(sub)
+	l.StreamManager.trackConsumer(l.StreamName, l.Durable, true)
+	defer l.StreamManager.trackConsumer(l.StreamName, l.Durable, false)
+
+	// Catch messages the server drops after MaxDeliver AckWait expiries
+	if l.DeadLetter != nil && l.MaxDeliver > 0 {
+		if advSub := l.watchMaxDeliveries(ctx); advSub != nil {
+			defer func() { _ = advSub.Unsubscribe() }()
+		}
+	}
 
 	logger.Info("Listening to subject(s)", "Stream", l.StreamName, "FilterSubjects", l.FilterSubjects, "FilterSubject", l.FilterSubject)
 
@@ -149,9 +235,12 @@ func (l *Listener) Listen(ctx context.Context) error {
 	select {
 	case <-ctx.Done():
 		logger.Info("Context cancelled, stopping listener")
-	case <-l.stopCh:
+	case <-stopCh:
 		logger.Info("Stop signal received, stopping listener")
 	}
+	if skips := l.FilterSkips(); len(skips) > 0 {
+		logger.Info("Listener filters skipped messages", logger.KeyStream, string(l.StreamName), "consumer", l.Durable, "skips", skips)
+	}
 	return nil
 }
 
@@ -191,6 +280,47 @@ func (l *Listener) logHandlerOutcome(stage, subject string, sequence interface{}
 }
 
 func (l *Listener) processMessage(ctx context.Context, msg jetstream.Msg) {
+	l.handleMessage(ctx, msg, l.keepInProgress(msg, nil))
+}
+
+// keepInProgress calls msg.InProgress every InProgressTick until the returned
+// stop func is called (or abort closes) so AckWait doesn't expire while the
+// message waits for or runs in its handler. stop is idempotent.
+func (l *Listener) keepInProgress(msg jetstream.Msg, abort <-chan struct{}) (stop func()) {
+	if l.InProgressTick <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	var once sync.Once
+	ticker := time.NewTicker(l.InProgressTick)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-abort:
+				return
+			case <-ticker.C:
+				_ = msg.InProgress()
+			}
+		}
+	}()
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// handleMessage decodes, dispatches and acks one message. stop ends its
+// InProgress keep-alive and is called before the message is settled.
+func (l *Listener) handleMessage(ctx context.Context, msg jetstream.Msg, stop func()) {
+	defer stop()
+
+	// a draining listener hands messages it hasn't started back right away
+	if !l.inflight.begin(msg) {
+		stop()
+		_ = msg.Nak()
+		return
+	}
+	defer l.inflight.end(msg)
 	subject := msg.Subject()
 	streamName := string(l.StreamName)
 
@@ -201,67 +331,112 @@ func (l *Listener) processMessage(ctx context.Context, msg jetstream.Msg) {
 
 	meta, err := msg.Metadata()
 	if err != nil {
+		stop()
 		l.logHandlerOutcome("metadata", subject, 0, 0, err)
 		metrics.RecordNATSFailure(streamName, subject, err)
 		_ = msg.Nak()
 		return
 	}
 
-	// keep long handlers alive
-	done := make(chan struct{})
-	var ticker *time.Ticker
-	if l.InProgressTick > 0 {
-		ticker = time.NewTicker(l.InProgressTick)
-		defer ticker.Stop()
-		go func() {
-			for {
-				select {
-				case <-done:
-					return
-				case <-ticker.C:
-					_ = msg.InProgress()
-				}
-			}
-		}()
-	}
-
 	// decode
 	var event Event[json.RawMessage]
 	if err := json.Unmarshal(msg.Data(), &event); err != nil {
-		close(done)
+		stop()
 		l.logHandlerOutcome("decode", subject, meta.Sequence, 0, err)
 		metrics.RecordNATSFailure(streamName, subject, err)
+		l.handlePoison(ctx, msg, meta, err)
+		return
+	}
+
+	// envelope: headers fill anything the body lacked (legacy bare
+	// {subject,data} payloads), the stream timestamp stands in for
+	// occurredAt, and the handler ctx continues the correlation chain.
+	event.Envelope.mergeHeader(msg.Headers())
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = meta.Timestamp
+	}
+	handlerCtx := ContextWithEnvelope(ctx, event.Envelope)
 
-		// Poison: let a hook capture & persist, then Ack to stop redelivery loop.
-		if l.PoisonHandler != nil {
-			func() { // protect against panics in the hook
-				defer func() { _ = recover() }()
-				l.PoisonHandler(ctx, subject, msg.Data(), meta)
-			}()
+	// skip messages this listener doesn't care about
+	if l.filter(subject, msg.Headers(), &event) != "" {
+		stop()
+		_ = msg.Ack()
+		return
+	}
+
+	// bring older payload versions up to the latest shape
+	if l.Upcasters != nil {
+		if err := l.upcast(&event); err != nil {
+			stop()
+			l.logHandlerOutcome("upcast", subject, meta.Sequence, 0, err)
+			metrics.RecordNATSFailure(streamName, subject, err)
+			l.handlePoison(ctx, msg, meta, err)
+			return
+		}
+		handlerCtx = ContextWithEnvelope(ctx, event.Envelope)
+	}
+
+	// validate against the registered payload schema
+	if l.Schemas != nil {
+		if err := l.Schemas.Validate(event.Subject, event.Data); err != nil {
+			stop()
+			l.logHandlerOutcome("validate", subject, meta.Sequence, 0, err)
+			metrics.RecordNATSFailure(streamName, subject, err)
+			l.handlePoison(ctx, msg, meta, err)
+			return
 		}
-		_ = msg.Ack() // acknowledge so it won't be redelivered forever
+	}
+
+	// skip messages this durable already processed
+	idemKey, ok := l.claimMessage(ctx, msg, meta)
+	if !ok {
+		return
+	}
+
+	// hold the message back while the circuit breaker is open
+	if !l.breakerAllow(ctx, msg, meta, idemKey) {
+		stop()
 		return
 	}
 
 	// handle
-	if err := l.OnMessageFunc(ctx, event); err != nil {
-		close(done)
-		l.logHandlerOutcome("handle", subject, meta.Sequence, time.Since(start), err)
+	err = l.OnMessageFunc(handlerCtx, event)
+	l.breakerRecord(err)
+	if err != nil {
+		stop()
+		l.releaseMessage(ctx, idemKey)
+		l.logHandlerOutcome("handle", subject, meta.Sequence, time.Since(start), err,
+			"eventId", event.ID, "correlationId", event.CorrelationID)
 		metrics.RecordNATSFailure(streamName, subject, err)
 
+		// Typed handlers report undecodable payloads as poison
+		if errors.Is(err, ErrPoisonMessage) {
+			l.handlePoison(ctx, msg, meta, err)
+			return
+		}
+
+		// Final delivery: park it in the DLQ rather than let it vanish
+		if l.DeadLetter != nil && l.MaxDeliver > 0 && meta.NumDelivered >= uint64(l.MaxDeliver) {
+			if dlqErr := l.DeadLetter.Add(ctx, subject, msg.Headers(), msg.Data(), meta, DeadLetterReasonMaxDeliver, err); dlqErr == nil {
+				_ = msg.Term()
+				return
+			}
+		}
+
 		// Use NakWithDelay to control cadence (BackOff is only for AckWait timeouts)
 		l.nakWithPolicy(msg, meta)
 		return
 	}
 
 	// ack
+	l.completeMessage(ctx, idemKey)
 	if err := msg.Ack(); err != nil {
-		close(done)
+		stop()
 		l.logHandlerOutcome("ack", subject, meta.Sequence, time.Since(start), err)
 		metrics.RecordNATSFailure(streamName, subject, err)
 		return
 	}
-	close(done)
+	stop()
 
 	// record success (consider renaming to "consumed" in your metrics)
 	metrics.RecordNATSPublished(streamName, subject)
@@ -272,6 +447,44 @@ func (l *Listener) processMessage(ctx context.Context, msg jetstream.Msg) {
 	l.logHandlerOutcome("success", subject, meta.Sequence, duration, nil)
 }
 
+// upcast runs the Upcasters chain on event, updating Data and SchemaVersion.
+func (l *Listener) upcast(event *Event[json.RawMessage]) error {
+	from := event.SchemaVersion
+	if from == 0 {
+		from = DefaultSchemaVersion
+	}
+	data, to, err := l.Upcasters.Upcast(event.Subject, from, event.Data)
+	if err != nil || to == from {
+		return err
+	}
+	event.Data = data
+	event.SchemaVersion = to
+	metrics.RecordNATSUpcast(string(l.StreamName), string(event.Subject), from, to)
+	logger.Debug("Upcast event payload",
+		logger.KeyStream, string(l.StreamName), logger.KeySubject, string(event.Subject),
+		"eventId", event.ID, "fromVersion", from, "toVersion", to)
+	return nil
+}
+
+// handlePoison lets PoisonHandler capture & persist the message and writes it
+// to the DLQ, then Acks it to stop the redelivery loop. If the DLQ write
+// fails the message is NAKed instead so it isn't lost.
+func (l *Listener) handlePoison(ctx context.Context, msg jetstream.Msg, meta *jetstream.MsgMetadata, cause error) {
+	if l.PoisonHandler != nil {
+		func() { // protect against panics in the hook
+			defer func() { _ = recover() }()
+			l.PoisonHandler(ctx, msg.Subject(), msg.Data(), meta)
+		}()
+	}
+	if l.DeadLetter != nil {
+		if err := l.DeadLetter.Add(ctx, msg.Subject(), msg.Headers(), msg.Data(), meta, DeadLetterReasonPoison, cause); err != nil {
+			l.nakWithPolicy(msg, meta)
+			return
+		}
+	}
+	_ = msg.Ack() // acknowledge so it won't be redelivered forever
+}
+
 func (l *Listener) nakWithPolicy(msg jetstream.Msg, meta *jetstream.MsgMetadata) {
 	// choose a delay based on delivery count
 	var delay time.Duration
@@ -292,10 +505,10 @@ func (l *Listener) nakWithPolicy(msg jetstream.Msg, meta *jetstream.MsgMetadata)
 	}
 }
 
-// Stop gracefully stops the listener.
+// Stop stops the listener without waiting for in-flight handlers; see Drain.
 func (l *Listener) Stop(ctx context.Context) error {
 	logger.Info("Stopping listener", "StreamName", l.StreamName)
-	close(l.stopCh)
+	l.closeStopChan()
 	return nil
 }
 
@@ -374,6 +587,10 @@ func (l *Listener) createConsumerWithRetry(ctx context.Context, stream jetstream
 			subjects[i] = string(s)
 		}
 		cc.FilterSubjects = subjects
+	} else if subjects := l.serverFilterSubjects(); len(subjects) > 0 {
+		for _, s := range subjects {
+			cc.FilterSubjects = append(cc.FilterSubjects, string(s))
+		}
 	}
 
 	var lastErr error
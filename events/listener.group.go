@@ -0,0 +1,137 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/praction-networks/common/logger"
+)
+
+// DefaultDrainTimeout bounds ListenerGroup.Run's drain on shutdown.
+const DefaultDrainTimeout = 25 * time.Second
+
+// ListenerGroup starts and drains every Listener of a service together.
+//
+//	group := events.NewListenerGroup("tenant-service", tenantListener, planListener)
+//	if err := group.Start(ctx); err != nil { ... }
+//	...
+//	<-shutdown
+//	drainCtx, cancel := context.WithTimeout(context.Background(), 25*time.Second)
+//	defer cancel()
+//	_ = httpServer.Shutdown(drainCtx)
+//	_ = group.Drain(drainCtx)
+type ListenerGroup struct {
+	Name      string
+	Listeners []*Listener
+
+	// DrainTimeout bounds the drain Run performs when its ctx ends.
+	// Default DefaultDrainTimeout.
+	DrainTimeout time.Duration
+
+	mu      sync.Mutex
+	started bool
+	wg      sync.WaitGroup
+	errs    []error
+}
+
+// NewListenerGroup groups listeners under a name used in logs.
+func NewListenerGroup(name string, listeners ...*Listener) *ListenerGroup {
+	return &ListenerGroup{Name: name, Listeners: listeners, DrainTimeout: DefaultDrainTimeout}
+}
+
+// Start runs every listener's Listen in its own goroutine and returns. ctx
+// is passed to the handlers; cancel it only after Drain, or the handlers of
+// in-flight messages see a cancelled context.
+func (g *ListenerGroup) Start(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.started {
+		return fmt.Errorf("listener group %s already started", g.Name)
+	}
+	g.started = true
+
+	for _, l := range g.Listeners {
+		g.wg.Add(1)
+		go func(l *Listener) {
+			defer g.wg.Done()
+			component := "nats-listener:" + l.Durable
+			logger.GoroutineStarted(component, logger.KeyStream, string(l.StreamName), "group", g.Name)
+			if err := l.Listen(ctx); err != nil {
+				// Drain logs the clean stop; only a failed Listen is logged here
+				logger.GoroutineStopped(component, err, logger.KeyStream, string(l.StreamName), "group", g.Name)
+				g.mu.Lock()
+				g.errs = append(g.errs, fmt.Errorf("listener %s: %w", l.Durable, err))
+				g.mu.Unlock()
+			}
+		}(l)
+	}
+	return nil
+}
+
+// Drain drains every listener concurrently within ctx and waits for their
+// Listen calls to return. It returns the drain and Listen errors joined.
+func (g *ListenerGroup) Drain(ctx context.Context) error {
+	start := time.Now()
+	logger.Info("Draining listener group", "group", g.Name, "listeners", len(g.Listeners))
+
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	for _, l := range g.Listeners {
+		wg.Add(1)
+		go func(l *Listener) {
+			defer wg.Done()
+			if err := l.Drain(ctx); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(l)
+	}
+	wg.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("listener group %s: listeners still running: %w", g.Name, ctx.Err()))
+	}
+
+	g.mu.Lock()
+	errs = append(errs, g.errs...)
+	g.mu.Unlock()
+
+	err := errors.Join(errs...)
+	if err != nil {
+		logger.Warn("Listener group drained with errors", "group", g.Name,
+			logger.KeyDurationMs, time.Since(start).Milliseconds(), err)
+	} else {
+		logger.Info("Listener group drained", "group", g.Name,
+			logger.KeyDurationMs, time.Since(start).Milliseconds())
+	}
+	return err
+}
+
+// Run starts the group, blocks until ctx is done, then drains within
+// DrainTimeout. Handlers keep an uncancelled context while draining.
+func (g *ListenerGroup) Run(ctx context.Context) error {
+	if err := g.Start(context.WithoutCancel(ctx)); err != nil {
+		return err
+	}
+	<-ctx.Done()
+
+	timeout := g.DrainTimeout
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+	drainCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	return g.Drain(drainCtx)
+}
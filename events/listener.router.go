@@ -0,0 +1,239 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/praction-networks/common/logger"
+)
+
+// ErrPoisonMessage marks a message that can never be processed (bad JSON,
+// wrong payload shape). A handler returning an error wrapping it makes the
+// Listener hand the raw message to PoisonHandler and Ack it instead of
+// NAKing it into an endless redelivery loop.
+var ErrPoisonMessage = errors.New("poison message")
+
+// TypedHandler handles an event whose Data has already been decoded into T.
+type TypedHandler[T any] func(ctx context.Context, event Event[T]) error
+
+// Router dispatches the messages of one durable consumer to per-subject typed
+// handlers. Register handlers with Handle, then pass Router.OnMessage as the
+// Listener's OnMessageFunc (or use NewRouterListener, which also derives
+// FilterSubjects from the registered subjects).
+//
+// Subjects may use NATS wildcards ("olt.event.*", "olt.event.>"). Exact
+// matches win; wildcard routes are tried in registration order.
+type Router struct {
+	mu     sync.RWMutex
+	exact  map[Subject]rawHandler
+	routes []route
+
+	// Fallback handles subjects with no registered route. When nil such
+	// messages are logged and acked.
+	Fallback func(ctx context.Context, event Event[json.RawMessage]) error
+}
+
+type rawHandler func(ctx context.Context, event Event[json.RawMessage]) error
+
+type route struct {
+	pattern Subject
+	handler rawHandler
+}
+
+// NewRouter creates an empty Router.
+func NewRouter() *Router {
+	return &Router{exact: map[Subject]rawHandler{}}
+}
+
+// Handle registers fn for subject, decoding Data into T before dispatch.
+// Decode failures are reported as ErrPoisonMessage so the Listener routes the
+// message to its PoisonHandler. Registering the same subject twice replaces
+// the earlier handler.
+func Handle[T any](r *Router, subject Subject, fn TypedHandler[T]) {
+	h := func(ctx context.Context, raw Event[json.RawMessage]) error {
+		typed, err := DecodeEvent[T](raw)
+		if err != nil {
+			return err
+		}
+		return fn(ctx, typed)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if isWildcardSubject(subject) {
+		for i := range r.routes {
+			if r.routes[i].pattern == subject {
+				r.routes[i].handler = h
+				return
+			}
+		}
+		r.routes = append(r.routes, route{pattern: subject, handler: h})
+		return
+	}
+	r.exact[subject] = h
+}
+
+// Subjects returns the registered subjects as Listener.FilterSubjects:
+// JetStream rejects overlapping filter subjects, so a subject another
+// registered wildcard already covers ("olt.event.ont.down" under
+// "olt.event.>") is left out. Wildcards that only partially overlap
+// ("a.*.c" and "a.b.*") can't be expressed; register a covering pattern
+// instead.
+func (r *Router) Subjects() []Subject {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Subject, 0, len(r.exact)+len(r.routes))
+	for s := range r.exact {
+		if !r.coveredLocked(s, -1) {
+			out = append(out, s)
+		}
+	}
+	// Stable order so restarts don't churn the consumer config.
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	for i, rt := range r.routes {
+		if !r.coveredLocked(rt.pattern, i) {
+			out = append(out, rt.pattern)
+		}
+	}
+	return out
+}
+
+// coveredLocked reports whether a wildcard route other than routes[self]
+// matches every subject s does. Of two equivalent patterns the earlier one
+// is kept.
+func (r *Router) coveredLocked(s Subject, self int) bool {
+	for i, rt := range r.routes {
+		if i == self || !subjectCovers(string(rt.pattern), string(s)) {
+			continue
+		}
+		if self < 0 || !subjectCovers(string(s), string(rt.pattern)) || i < self {
+			return true
+		}
+	}
+	return false
+}
+
+// OnMessage dispatches event to the handler registered for its subject. It
+// has the Listener.OnMessageFunc signature.
+func (r *Router) OnMessage(ctx context.Context, event Event[json.RawMessage]) error {
+	if h := r.lookup(event.Subject); h != nil {
+		return h(ctx, event)
+	}
+	if r.Fallback != nil {
+		return r.Fallback(ctx, event)
+	}
+	logger.Warn("No handler registered for subject, acking", logger.KeySubject, string(event.Subject))
+	return nil
+}
+
+func (r *Router) lookup(subject Subject) rawHandler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if h, ok := r.exact[subject]; ok {
+		return h
+	}
+	for _, rt := range r.routes {
+		if subjectMatches(string(rt.pattern), string(subject)) {
+			return rt.handler
+		}
+	}
+	return nil
+}
+
+// DecodeEvent converts a raw event into a typed one. The returned error wraps
+// ErrPoisonMessage when Data does not decode into T.
+func DecodeEvent[T any](raw Event[json.RawMessage]) (Event[T], error) {
+	out := Event[T]{Subject: raw.Subject, Envelope: raw.Envelope}
+	if len(raw.Data) == 0 {
+		return out, fmt.Errorf("%w: empty data for subject %s", ErrPoisonMessage, raw.Subject)
+	}
+	if err := json.Unmarshal(raw.Data, &out.Data); err != nil {
+		return out, fmt.Errorf("%w: decode %s: %v", ErrPoisonMessage, raw.Subject, err)
+	}
+	return out, nil
+}
+
+// NewTypedListener creates a Listener whose handler receives Data already
+// decoded into T. Every subject in filterSubjects shares the same handler.
+func NewTypedListener[T any](
+	streamName StreamName,
+	durable string,
+	deliverPolicy jetstream.DeliverPolicy,
+	ackPolicy jetstream.AckPolicy,
+	ackWait time.Duration,
+	filterSubjects []Subject,
+	streamManager *JsStreamManager,
+	fn TypedHandler[T],
+) *Listener {
+	router := NewRouter()
+	for _, s := range filterSubjects {
+		Handle(router, s, fn)
+	}
+	return NewRouterListener(streamName, durable, deliverPolicy, ackPolicy, ackWait, streamManager, router)
+}
+
+// NewRouterListener creates a single durable Listener that dispatches every
+// subject registered on router to its own typed handler. FilterSubjects is
+// derived from the router.
+func NewRouterListener(
+	streamName StreamName,
+	durable string,
+	deliverPolicy jetstream.DeliverPolicy,
+	ackPolicy jetstream.AckPolicy,
+	ackWait time.Duration,
+	streamManager *JsStreamManager,
+	router *Router,
+) *Listener {
+	return NewListener(streamName, durable, deliverPolicy, ackPolicy, ackWait,
+		nil, router.Subjects(), streamManager, router.OnMessage)
+}
+
+func isWildcardSubject(s Subject) bool {
+	return strings.ContainsAny(string(s), "*>")
+}
+
+// subjectMatches reports whether subject matches a NATS subject pattern,
+// honouring the "*" (one token) and ">" (one or more trailing tokens)
+// wildcards.
+func subjectMatches(pattern, subject string) bool {
+	pt := strings.Split(pattern, ".")
+	st := strings.Split(subject, ".")
+	for i, p := range pt {
+		if p == ">" {
+			return len(st) > i
+		}
+		if i >= len(st) {
+			return false
+		}
+		if p != "*" && p != st[i] {
+			return false
+		}
+	}
+	return len(pt) == len(st)
+}
+
+// subjectCovers reports whether every subject matching sub also matches
+// pattern; both may contain wildcards.
+func subjectCovers(pattern, sub string) bool {
+	pt := strings.Split(pattern, ".")
+	st := strings.Split(sub, ".")
+	for i, p := range pt {
+		if p == ">" {
+			return len(st) > i
+		}
+		if i >= len(st) || st[i] == ">" {
+			return false
+		}
+		if p != "*" && p != st[i] {
+			return false
+		}
+	}
+	return len(pt) == len(st)
+}
@@ -0,0 +1,89 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type routerTestPayload struct {
+	ID string `json:"id"`
+}
+
+func TestRouterDispatchesTypedHandlers(t *testing.T) {
+	r := NewRouter()
+	var gotExact, gotWildcard string
+	Handle(r, "olt.event.ont.down", func(_ context.Context, ev Event[routerTestPayload]) error {
+		gotExact = ev.Data.ID
+		return nil
+	})
+	Handle(r, "olt.event.>", func(_ context.Context, ev Event[routerTestPayload]) error {
+		gotWildcard = ev.Data.ID
+		return nil
+	})
+
+	raw := func(subject Subject, data string) Event[json.RawMessage] {
+		return Event[json.RawMessage]{Subject: subject, Data: json.RawMessage(data), Envelope: Envelope{ID: "e1"}}
+	}
+	if err := r.OnMessage(context.Background(), raw("olt.event.ont.down", `{"id":"a"}`)); err != nil {
+		t.Fatalf("exact dispatch: %v", err)
+	}
+	if err := r.OnMessage(context.Background(), raw("olt.event.link.up", `{"id":"b"}`)); err != nil {
+		t.Fatalf("wildcard dispatch: %v", err)
+	}
+	if gotExact != "a" || gotWildcard != "b" {
+		t.Fatalf("unexpected dispatch: exact=%q wildcard=%q", gotExact, gotWildcard)
+	}
+
+	err := r.OnMessage(context.Background(), raw("olt.event.ont.down", `{"id":`))
+	if !errors.Is(err, ErrPoisonMessage) {
+		t.Fatalf("expected ErrPoisonMessage for bad payload, got %v", err)
+	}
+
+	// The exact subject is covered by the wildcard; JetStream rejects
+	// overlapping filter subjects.
+	want := []Subject{"olt.event.>"}
+	if got := r.Subjects(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Subjects() = %v, want %v", got, want)
+	}
+}
+
+func TestRouterSubjectsDropCovered(t *testing.T) {
+	r := NewRouter()
+	noop := func(context.Context, Event[routerTestPayload]) error { return nil }
+	for _, s := range []Subject{"b.x", "a.b.c", "a.*.c", "a.>", "b.*", "b.*", "c.d"} {
+		Handle(r, s, noop)
+	}
+	want := []Subject{"c.d", "a.>", "b.*"}
+	if got := r.Subjects(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Subjects() = %v, want %v", got, want)
+	}
+	for i, a := range want {
+		for _, b := range want[i+1:] {
+			if subjectsOverlap(string(a), string(b)) {
+				t.Fatalf("%s overlaps %s", a, b)
+			}
+		}
+	}
+}
+
+func TestSubjectMatches(t *testing.T) {
+	cases := []struct {
+		pattern, subject string
+		want             bool
+	}{
+		{"a.b.c", "a.b.c", true},
+		{"a.*.c", "a.b.c", true},
+		{"a.*", "a.b.c", false},
+		{"a.>", "a.b.c", true},
+		{"a.>", "a", false},
+		{"a.b", "a.b.c", false},
+	}
+	for _, c := range cases {
+		if got := subjectMatches(c.pattern, c.subject); got != c.want {
+			t.Errorf("subjectMatches(%q, %q) = %v, want %v", c.pattern, c.subject, got, c.want)
+		}
+	}
+}
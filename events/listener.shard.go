@@ -0,0 +1,168 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/praction-networks/common/logger"
+)
+
+// OrderingKeyFunc returns the key whose messages must be handled in order
+// when Listener.Concurrency > 1 (a subscriber ID, an ONT serial, a tenant).
+type OrderingKeyFunc func(msg jetstream.Msg) string
+
+// KeyBySubject orders messages per subject.
+func KeyBySubject() OrderingKeyFunc {
+	return func(msg jetstream.Msg) string { return msg.Subject() }
+}
+
+// KeyBySubjectToken orders messages by the i-th dot-separated subject token
+// (0-based), e.g. the serial in "olt.event.<serial>".
+func KeyBySubjectToken(i int) OrderingKeyFunc {
+	return func(msg jetstream.Msg) string {
+		tokens := strings.Split(msg.Subject(), ".")
+		if i < 0 || i >= len(tokens) {
+			return ""
+		}
+		return tokens[i]
+	}
+}
+
+// KeyByHeader orders messages by a NATS header value.
+func KeyByHeader(name string) OrderingKeyFunc {
+	return func(msg jetstream.Msg) string { return msg.Headers().Get(name) }
+}
+
+// KeyByTenant orders messages per tenant, read from the envelope header.
+func KeyByTenant() OrderingKeyFunc {
+	return KeyByHeader(HeaderTenantID)
+}
+
+// KeyByDataField orders messages by a field of the event's data, addressed
+// by a dotted path ("subscriberId", "ont.serial"). Only string and number
+// values are used as keys.
+func KeyByDataField(path string) OrderingKeyFunc {
+	parts := strings.Split(path, ".")
+	return func(msg jetstream.Msg) string {
+		var envelope struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(msg.Data(), &envelope); err != nil {
+			return ""
+		}
+		raw := dataField(envelope.Data, parts)
+		if raw == nil {
+			return ""
+		}
+		s, _ := scalarString(raw)
+		return s
+	}
+}
+
+// shardPool runs Listener.Concurrency workers, each owning one queue.
+// A key always hashes to the same queue, which keeps per-key order; the
+// Consume callback blocks when that queue is full, which is the backpressure
+// that keeps client-side buffering within MaxAckPending.
+//
+// Each message's InProgress keep-alive starts when it is queued, not when
+// its worker picks it up, so a message waiting behind a slow one for the
+// same key doesn't hit AckWait and get redelivered out of order.
+//
+// Ordering holds for the happy path. A NAKed message is redelivered after
+// its backoff, by which time later messages for the same key may have been
+// handled; handlers that need strict order must still check versions.
+type shardPool struct {
+	l      *Listener
+	queues []chan shardItem
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+type shardItem struct {
+	msg  jetstream.Msg
+	stop func()
+}
+
+func (l *Listener) newShardPool(ctx context.Context) *shardPool {
+	size := l.ShardQueueSize
+	if size <= 0 {
+		size = l.MaxAckPending / l.Concurrency
+	}
+	if size <= 0 {
+		size = 1
+	}
+
+	p := &shardPool{
+		l:      l,
+		queues: make([]chan shardItem, l.Concurrency),
+		quit:   make(chan struct{}),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan shardItem, size)
+		p.wg.Add(1)
+		go p.work(ctx, p.queues[i])
+	}
+	logger.Info("Listener processing concurrently",
+		logger.KeyStream, string(l.StreamName), "consumer", l.Durable,
+		"workers", l.Concurrency, "queueSize", size)
+	return p
+}
+
+func (p *shardPool) key(msg jetstream.Msg) string {
+	if p.l.OrderingKey != nil {
+		if k := p.l.OrderingKey(msg); k != "" {
+			return k
+		}
+	}
+	return msg.Subject()
+}
+
+func (p *shardPool) shardFor(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// dispatch queues msg on its key's shard, blocking while the shard is full.
+// If the pool stops first the message is left unacked for redelivery.
+func (p *shardPool) dispatch(ctx context.Context, msg jetstream.Msg) {
+	item := shardItem{msg: msg, stop: p.l.keepInProgress(msg, p.quit)}
+	select {
+	case p.queues[p.shardFor(p.key(msg))] <- item:
+	case <-p.quit:
+		item.stop()
+	case <-ctx.Done():
+		item.stop()
+	}
+}
+
+func (p *shardPool) work(ctx context.Context, queue chan shardItem) {
+	defer p.wg.Done()
+	for {
+		select {
+		case item := <-queue:
+			p.l.handleMessage(ctx, item.msg, item.stop)
+		case <-p.quit:
+			// Finish what was already queued so it isn't left to AckWait.
+			for {
+				select {
+				case item := <-queue:
+					p.l.handleMessage(ctx, item.msg, item.stop)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// stop closes the pool and waits for the workers to finish queued messages.
+// Call it after the consume subscription has stopped.
+func (p *shardPool) stop() {
+	close(p.quit)
+	p.wg.Wait()
+}
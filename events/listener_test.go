@@ -2,7 +2,10 @@ package events
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"strings"
@@ -10,6 +13,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/praction-networks/common/logger"
 )
 
@@ -82,3 +87,448 @@ func TestLogHandlerOutcomeVerboseToggle(t *testing.T) {
 		t.Errorf("expected error line for handler=h3 with stage=handle; got:\n%s", out)
 	}
 }
+
+// fakeMsg implements the parts of jetstream.Msg the Listener uses.
+type fakeMsg struct {
+	jetstream.Msg
+	subject string
+	data    []byte
+	header  nats.Header
+	seq     uint64
+
+	mu    sync.Mutex
+	acked bool
+	naked bool
+}
+
+func (m *fakeMsg) Subject() string      { return m.subject }
+func (m *fakeMsg) Data() []byte         { return m.data }
+func (m *fakeMsg) Headers() nats.Header { return m.header }
+func (m *fakeMsg) InProgress() error    { return nil }
+func (m *fakeMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{Sequence: jetstream.SequencePair{Stream: m.seq}, NumDelivered: 1}, nil
+}
+func (m *fakeMsg) Ack() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.acked = true
+	return nil
+}
+func (m *fakeMsg) Nak() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.naked = true
+	return nil
+}
+
+func newFakeEventMsg(t *testing.T, subject string, seq uint64, data any) *fakeMsg {
+	t.Helper()
+	raw, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(Event[json.RawMessage]{Subject: Subject(subject), Data: raw})
+	return &fakeMsg{subject: subject, data: body, header: nats.Header{}, seq: seq}
+}
+
+func TestShardPoolKeepsPerKeyOrder(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string][]int{}
+	l := NewListener("TEST", "test", jetstream.DeliverNewPolicy, jetstream.AckExplicitPolicy, 0, nil, nil, nil,
+		func(ctx context.Context, e Event[json.RawMessage]) error {
+			var d struct {
+				Key string `json:"key"`
+				N   int    `json:"n"`
+			}
+			_ = json.Unmarshal(e.Data, &d)
+			mu.Lock()
+			seen[d.Key] = append(seen[d.Key], d.N)
+			mu.Unlock()
+			return nil
+		})
+	l.Concurrency = 4
+	l.OrderingKey = KeyByDataField("key")
+
+	ctx := context.Background()
+	pool := l.newShardPool(ctx)
+	var msgs []*fakeMsg
+	for n := 0; n < 50; n++ {
+		for k := 0; k < 5; k++ {
+			m := newFakeEventMsg(t, "test.event", uint64(len(msgs)+1), map[string]any{"key": fmt.Sprintf("k%d", k), "n": n})
+			msgs = append(msgs, m)
+			pool.dispatch(ctx, m)
+		}
+	}
+	pool.stop()
+
+	for _, m := range msgs {
+		if !m.acked {
+			t.Fatalf("message %d not acked", m.seq)
+		}
+	}
+	for k, ns := range seen {
+		if len(ns) != 50 {
+			t.Fatalf("%s: got %d messages", k, len(ns))
+		}
+		for i, n := range ns {
+			if n != i {
+				t.Fatalf("%s handled out of order: %v", k, ns)
+			}
+		}
+	}
+}
+
+func TestOrderingKeyFuncs(t *testing.T) {
+	m := newFakeEventMsg(t, "olt.event.ABC123", 1, map[string]any{"ont": map[string]any{"serial": "S1"}, "count": 7})
+	m.header.Set(HeaderTenantID, "t1")
+
+	cases := map[string]OrderingKeyFunc{
+		"olt.event.ABC123": KeyBySubject(),
+		"ABC123":           KeyBySubjectToken(2),
+		"t1":               KeyByTenant(),
+		"S1":               KeyByDataField("ont.serial"),
+		"7":                KeyByDataField("count"),
+		"":                 KeyByDataField("missing"),
+	}
+	for want, fn := range cases {
+		if got := fn(m); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func (m *fakeMsg) NakWithDelay(time.Duration) error { return m.Nak() }
+
+type memIdempotencyStore struct {
+	mu    sync.Mutex
+	state map[string]string
+}
+
+func (s *memIdempotencyStore) Claim(_ context.Context, key string, _ time.Duration) (ClaimStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch s.state[key] {
+	case "done":
+		return ClaimDuplicate, nil
+	case "processing":
+		return ClaimInFlight, nil
+	}
+	s.state[key] = "processing"
+	return ClaimAcquired, nil
+}
+
+func (s *memIdempotencyStore) Complete(_ context.Context, key string, _ time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state[key] = "done"
+	return nil
+}
+
+func (s *memIdempotencyStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state[key] == "processing" {
+		delete(s.state, key)
+	}
+	return nil
+}
+
+func TestListenerIdempotencySkipsDuplicates(t *testing.T) {
+	calls := 0
+	fail := true
+	l := NewListener("TEST", "dur", jetstream.DeliverAllPolicy, jetstream.AckExplicitPolicy, 0, nil, nil, nil,
+		func(ctx context.Context, e Event[json.RawMessage]) error {
+			calls++
+			if fail {
+				return errors.New("transient")
+			}
+			return nil
+		})
+	store := &memIdempotencyStore{state: map[string]string{}}
+	l.Idempotency = store
+	ctx := context.Background()
+
+	// Failed attempt releases the claim so the redelivery runs.
+	first := newFakeEventMsg(t, "test.event", 7, map[string]any{})
+	l.processMessage(ctx, first)
+	if !first.naked || len(store.state) != 0 {
+		t.Fatalf("failed attempt: naked=%v state=%v", first.naked, store.state)
+	}
+
+	fail = false
+	redelivery := newFakeEventMsg(t, "test.event", 7, map[string]any{})
+	l.processMessage(ctx, redelivery)
+	if !redelivery.acked || store.state["TEST:dur:seq:7"] != "done" {
+		t.Fatalf("redelivery: acked=%v state=%v", redelivery.acked, store.state)
+	}
+
+	replay := newFakeEventMsg(t, "test.event", 7, map[string]any{})
+	l.processMessage(ctx, replay)
+	if !replay.acked || calls != 2 {
+		t.Fatalf("replay should be acked without calling the handler: acked=%v calls=%d", replay.acked, calls)
+	}
+
+	// The envelope ID wins over the sequence.
+	withID := newFakeEventMsg(t, "test.event", 8, map[string]any{})
+	withID.header.Set(HeaderEventID, "abc")
+	l.processMessage(ctx, withID)
+	if store.state["TEST:dur:event:abc"] != "done" {
+		t.Fatalf("expected event-id key, got %v", store.state)
+	}
+
+	// Identical payloads share a content-hash Nats-Msg-Id but are distinct
+	// events.
+	for i, id := range []string{"e1", "e2"} {
+		same := newFakeEventMsg(t, "test.event", uint64(9+i), map[string]any{})
+		same.header.Set(nats.MsgIdHdr, "content-hash")
+		same.header.Set(HeaderEventID, id)
+		l.processMessage(ctx, same)
+	}
+	if calls != 5 {
+		t.Fatalf("identical events: handler calls = %d, want 5", calls)
+	}
+}
+
+func TestListenerUpcastsBeforeDispatch(t *testing.T) {
+	type v1 struct {
+		Name string `json:"name"`
+	}
+	type v2 struct {
+		First string `json:"first"`
+		Last  string `json:"last"`
+	}
+	type v3 struct {
+		First string `json:"first"`
+		Last  string `json:"last"`
+		Plan  string `json:"plan"`
+	}
+	reg := NewUpcasterRegistry()
+	RegisterUpcast(reg, "test.event", 1, func(in v1) (v2, error) {
+		first, last, _ := strings.Cut(in.Name, " ")
+		return v2{First: first, Last: last}, nil
+	})
+	RegisterUpcast(reg, "test.event", 2, func(in v2) (v3, error) {
+		return v3{First: in.First, Last: in.Last, Plan: "basic"}, nil
+	})
+	if got := reg.Latest("test.event", 1); got != 3 {
+		t.Fatalf("Latest = %d, want 3", got)
+	}
+
+	var got Event[v3]
+	l := NewListener("TEST", "dur", jetstream.DeliverNewPolicy, jetstream.AckExplicitPolicy, 0, nil, nil, nil,
+		func(ctx context.Context, e Event[json.RawMessage]) error {
+			var err error
+			got, err = DecodeEvent[v3](e)
+			return err
+		})
+	l.Upcasters = reg
+
+	// Legacy message without an envelope counts as v1.
+	msg := newFakeEventMsg(t, "test.event", 1, v1{Name: "Ada Lovelace"})
+	l.processMessage(context.Background(), msg)
+	if !msg.acked || got.SchemaVersion != 3 || got.Data != (v3{First: "Ada", Last: "Lovelace", Plan: "basic"}) {
+		t.Fatalf("unexpected upcast result: acked=%v event=%+v", msg.acked, got)
+	}
+}
+
+func TestListenerDrainWaitsForInFlight(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	calls := 0
+	l := NewListener("TEST", "dur", jetstream.DeliverNewPolicy, jetstream.AckExplicitPolicy, 0, nil, nil, nil,
+		func(ctx context.Context, e Event[json.RawMessage]) error {
+			calls++
+			started <- struct{}{}
+			<-release
+			return nil
+		})
+
+	msg := newFakeEventMsg(t, "test.event", 1, map[string]any{})
+	done := make(chan struct{})
+	go func() {
+		l.processMessage(context.Background(), msg)
+		close(done)
+	}()
+	<-started
+
+	drained := make(chan error, 1)
+	go func() { drained <- l.Drain(context.Background()) }()
+	select {
+	case err := <-drained:
+		t.Fatalf("Drain returned before the handler finished: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-drained; err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	<-done
+	if !msg.acked || msg.naked {
+		t.Fatalf("in-flight message: acked=%v naked=%v", msg.acked, msg.naked)
+	}
+
+	// Messages reaching a drained listener go straight back.
+	late := newFakeEventMsg(t, "test.event", 2, map[string]any{})
+	l.processMessage(context.Background(), late)
+	if !late.naked || calls != 1 {
+		t.Fatalf("late message: naked=%v calls=%d", late.naked, calls)
+	}
+	select {
+	case <-l.stopChan():
+	default:
+		t.Fatal("Drain should stop Listen")
+	}
+}
+
+// bufferedSubscription stands in for a ConsumeContext holding fetched
+// messages: Drain hands them to the listener, Stop would drop them.
+type bufferedSubscription struct {
+	l        *Listener
+	buffered []*fakeMsg
+	stopped  bool
+	closed   chan struct{}
+}
+
+func (s *bufferedSubscription) Stop() { s.stopped = true }
+
+func (s *bufferedSubscription) Drain() {
+	go func() {
+		for _, msg := range s.buffered {
+			s.l.processMessage(context.Background(), msg)
+		}
+		close(s.closed)
+	}()
+}
+
+func (s *bufferedSubscription) Closed() <-chan struct{} { return s.closed }
+
+func TestListenerDrainNaksBufferedMessages(t *testing.T) {
+	calls := 0
+	l := NewListener("TEST", "dur", jetstream.DeliverNewPolicy, jetstream.AckExplicitPolicy, 0, nil, nil, nil,
+		func(ctx context.Context, e Event[json.RawMessage]) error {
+			calls++
+			return nil
+		})
+	sub := &bufferedSubscription{l: l, closed: make(chan struct{})}
+	for i := range 3 {
+		sub.buffered = append(sub.buffered, newFakeEventMsg(t, "test.event", uint64(i+1), map[string]any{}))
+	}
+	l.setSubscription(sub)
+
+	if err := l.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if sub.stopped {
+		t.Fatal("subscription stopped, dropping its buffered messages")
+	}
+	for _, msg := range sub.buffered {
+		if !msg.naked || msg.acked {
+			t.Fatalf("buffered message %d: naked=%v acked=%v", msg.seq, msg.naked, msg.acked)
+		}
+	}
+	if calls != 0 {
+		t.Fatalf("handler calls = %d, want 0", calls)
+	}
+}
+
+func TestListenerDrainNaksAfterDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	l := &Listener{StreamName: "TEST", Durable: "dur",
+		OnMessageFunc: func(ctx context.Context, e Event[json.RawMessage]) error {
+			close(started)
+			<-release
+			return nil
+		}}
+
+	msg := newFakeEventMsg(t, "test.event", 1, map[string]any{})
+	go l.processMessage(context.Background(), msg)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Drain err = %v, want deadline exceeded", err)
+	}
+	msg.mu.Lock()
+	defer msg.mu.Unlock()
+	if !msg.naked {
+		t.Fatal("message still in flight at the deadline should be NAKed")
+	}
+}
+
+func TestListenerGroupDrainsAll(t *testing.T) {
+	a := &Listener{StreamName: "TEST", Durable: "a"}
+	b := &Listener{StreamName: "TEST", Durable: "b"}
+	g := NewListenerGroup("svc", a, b)
+	if err := g.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	for _, l := range g.Listeners {
+		if !l.isDraining() {
+			t.Fatalf("listener %s not drained", l.Durable)
+		}
+	}
+}
+
+func TestListenerFiltersSkipAndCount(t *testing.T) {
+	var handled []uint64
+	l := NewListener("TEST", "dur", jetstream.DeliverNewPolicy, jetstream.AckExplicitPolicy, 0, nil, nil, nil,
+		func(ctx context.Context, e Event[json.RawMessage]) error {
+			var d struct {
+				Seq uint64 `json:"seq"`
+			}
+			_ = json.Unmarshal(e.Data, &d)
+			handled = append(handled, d.Seq)
+			return nil
+		})
+	l.Filters = []MessageFilter{
+		FilterTenants("t1"),
+		FilterSeverity("critical", "major"),
+		FilterSchemaVersions(1, 2),
+	}
+
+	msgs := []*fakeMsg{
+		newFakeEventMsg(t, "olt.event.alarm.active", 1, map[string]any{"seq": 1, "tenantIds": []string{"t0", "t1"}, "severity": "critical"}),
+		newFakeEventMsg(t, "olt.event.alarm.active", 2, map[string]any{"seq": 2, "tenantIds": []string{"t2"}, "severity": "critical"}),
+		newFakeEventMsg(t, "olt.event.alarm.active", 3, map[string]any{"seq": 3, "tenantIds": []string{"t1"}, "severity": "minor"}),
+	}
+	// The envelope tenant wins over the data.
+	enveloped := newFakeEventMsg(t, "olt.event.alarm.active", 4, map[string]any{"seq": 4, "tenantIds": []string{"t2"}, "severity": "major"})
+	enveloped.header.Set(HeaderTenantID, "t1")
+	newer := newFakeEventMsg(t, "olt.event.alarm.active", 5, map[string]any{"seq": 5, "tenantId": "t1", "severity": "major"})
+	newer.header.Set(HeaderSchemaVersion, "3")
+	msgs = append(msgs, enveloped, newer)
+
+	for _, m := range msgs {
+		l.processMessage(context.Background(), m)
+		if !m.acked {
+			t.Fatalf("message %d not acked", m.seq)
+		}
+	}
+	if fmt.Sprint(handled) != "[1 4]" {
+		t.Fatalf("handled = %v, want [1 4]", handled)
+	}
+	skips := l.FilterSkips()
+	if skips["tenant"] != 1 || skips["severity"] != 1 || skips["schema_version"] != 1 {
+		t.Fatalf("skips = %v", skips)
+	}
+}
+
+func TestListenerServerFilterSubjects(t *testing.T) {
+	l := &Listener{Filters: []MessageFilter{FilterTenants("t1"), FilterBySubjects("olt.event.alarm.*")}}
+	if got := l.serverFilterSubjects(); len(got) != 1 || got[0] != "olt.event.alarm.*" {
+		t.Fatalf("serverFilterSubjects = %v", got)
+	}
+	m := &FilterMessage{Subject: "olt.event.los", Event: &Event[json.RawMessage]{}}
+	if l.Filters[1].Match(m) {
+		t.Fatal("subject filter should not match olt.event.los")
+	}
+
+	explicit := Subject("olt.event.>")
+	l.FilterSubject = &explicit
+	if got := l.serverFilterSubjects(); got != nil {
+		t.Fatalf("explicit FilterSubject should win, got %v", got)
+	}
+}
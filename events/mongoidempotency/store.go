@@ -0,0 +1,138 @@
+// Package mongoidempotency provides a Mongo-backed events.IdempotencyStore.
+//
+// Storage shape — one document per processed (or in-flight) message:
+//
+//	{
+//	  _id:       "<stream>:<durable>:<msg id or seq>",
+//	  state:     "processing" | "done",
+//	  expiresAt: <ISO timestamp>,
+//	  updatedAt: <ISO timestamp>,
+//	}
+//
+// Claim relies on the unique _id: the insert wins for exactly one delivery,
+// and an expired document is taken over with a single conditional update.
+// EnsureIndexes installs a TTL index on expiresAt for collection hygiene;
+// correctness comes from the expiresAt filters, not the TTL monitor.
+package mongoidempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/praction-networks/common/events"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	stateProcessing = "processing"
+	stateDone       = "done"
+)
+
+// ErrNoCollection is returned when the store was constructed without a
+// collection.
+var ErrNoCollection = errors.New("mongoidempotency: no collection configured")
+
+// Store implements events.IdempotencyStore against a Mongo collection.
+type Store struct {
+	coll *mongo.Collection
+}
+
+// New constructs a Mongo-backed store.
+func New(coll *mongo.Collection) *Store {
+	return &Store{coll: coll}
+}
+
+// EnsureIndexes installs the TTL index on expiresAt. Idempotent; run at
+// service startup.
+func (s *Store) EnsureIndexes(ctx context.Context) error {
+	if s == nil || s.coll == nil {
+		return ErrNoCollection
+	}
+	_, err := s.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0).SetName("expiresAt_ttl"),
+	})
+	return err
+}
+
+// Claim inserts a processing document for key. On a duplicate key it takes
+// over an expired document, or reports whether the live one is done or still
+// in flight.
+func (s *Store) Claim(ctx context.Context, key string, claimTTL time.Duration) (events.ClaimStatus, error) {
+	if s == nil || s.coll == nil {
+		return events.ClaimAcquired, ErrNoCollection
+	}
+	now := time.Now().UTC()
+	_, err := s.coll.InsertOne(ctx, bson.M{
+		"_id":       key,
+		"state":     stateProcessing,
+		"expiresAt": now.Add(claimTTL),
+		"updatedAt": now,
+	})
+	if err == nil {
+		return events.ClaimAcquired, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return events.ClaimAcquired, err
+	}
+
+	res, err := s.coll.UpdateOne(ctx,
+		bson.M{"_id": key, "expiresAt": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{
+			"state":     stateProcessing,
+			"expiresAt": now.Add(claimTTL),
+			"updatedAt": now,
+		}},
+	)
+	if err != nil {
+		return events.ClaimAcquired, err
+	}
+	if res.MatchedCount > 0 {
+		return events.ClaimAcquired, nil
+	}
+
+	var doc struct {
+		State string `bson:"state"`
+	}
+	if err := s.coll.FindOne(ctx, bson.M{"_id": key}).Decode(&doc); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// Released between our insert and read; let the redelivery retry.
+			return events.ClaimInFlight, nil
+		}
+		return events.ClaimAcquired, err
+	}
+	if doc.State == stateDone {
+		return events.ClaimDuplicate, nil
+	}
+	return events.ClaimInFlight, nil
+}
+
+// Complete marks key done for ttl.
+func (s *Store) Complete(ctx context.Context, key string, ttl time.Duration) error {
+	if s == nil || s.coll == nil {
+		return ErrNoCollection
+	}
+	now := time.Now().UTC()
+	_, err := s.coll.UpdateOne(ctx,
+		bson.M{"_id": key},
+		bson.M{"$set": bson.M{
+			"state":     stateDone,
+			"expiresAt": now.Add(ttl),
+			"updatedAt": now,
+		}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// Release deletes an in-flight claim. Completed documents are left alone.
+func (s *Store) Release(ctx context.Context, key string) error {
+	if s == nil || s.coll == nil {
+		return ErrNoCollection
+	}
+	_, err := s.coll.DeleteOne(ctx, bson.M{"_id": key, "state": stateProcessing})
+	return err
+}
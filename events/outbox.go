@@ -0,0 +1,261 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/praction-networks/common/lease"
+	"github.com/praction-networks/common/logger"
+	"github.com/praction-networks/common/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopt "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrOutboxNotConfigured is returned when an outbox publish is requested
+	// on a Publisher without an Outbox collection.
+	ErrOutboxNotConfigured = errors.New("events: publisher outbox collection not configured")
+
+	// ErrOutboxNoSession is returned when an outbox publish is attempted with
+	// a ctx that is not a mongo.SessionContext; without a session the event
+	// write would not be atomic with the caller's document write.
+	ErrOutboxNoSession = errors.New("events: outbox publish requires a mongo session context")
+)
+
+// OutboxRelay drains a transactional outbox collection to JetStream. Only one
+// replica drains at a time (lease.RunAsLeader) and documents are published in
+// the order they were written (see nextOutboxSeq). A document that fails to
+// publish is retried after BaseBackoff·2^(attempts-1), capped at MaxBackoff,
+// and parked after MaxAttempts failures (see Unpark); the documents behind it
+// go on, so one poison row can't hold up the outbox, at the price of being
+// overtaken. Each publish carries the stored MsgID, so a relay that crashes
+// between publish and delete re-publishes harmlessly.
+type OutboxRelay struct {
+	Collection    *mongo.Collection
+	StreamManager *JsStreamManager
+	Leaser        lease.Leaser
+
+	LeaseKey     string        // default "outbox-relay:<collection>"
+	HolderID     string        // default hostname
+	LeaseTTL     time.Duration // default 30s
+	PollInterval time.Duration // default 1s
+	BatchSize    int           // default 100
+	MaxAttempts  int           // park after this many failures; default 20
+	BaseBackoff  time.Duration // default 1s
+	MaxBackoff   time.Duration // default 10m
+}
+
+// NewOutboxRelay creates a relay with default lease and polling settings.
+func NewOutboxRelay(coll *mongo.Collection, streamManager *JsStreamManager, leaser lease.Leaser) *OutboxRelay {
+	holder, _ := os.Hostname()
+	return &OutboxRelay{
+		Collection:    coll,
+		StreamManager: streamManager,
+		Leaser:        leaser,
+		LeaseKey:      "outbox-relay:" + coll.Name(),
+		HolderID:      holder,
+		LeaseTTL:      30 * time.Second,
+		PollInterval:  time.Second,
+		BatchSize:     100,
+		MaxAttempts:   20,
+		BaseBackoff:   time.Second,
+		MaxBackoff:    10 * time.Minute,
+	}
+}
+
+// EnsureIndexes creates the outbox indexes. Call once at boot.
+func (r *OutboxRelay) EnsureIndexes(ctx context.Context) error {
+	if err := EnsureFallbackIndexes(ctx, r.Collection); err != nil {
+		return err
+	}
+	_, err := r.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: FailedNATSFieldSeq, Value: 1}, {Key: FailedNATSFieldID, Value: 1}},
+		Options: mopt.Index().SetName("seq_idx"),
+	})
+	return err
+}
+
+// outboxClock holds the last outbox sequence number issued.
+var outboxClock atomic.Int64
+
+// nextOutboxSeq returns the next outbox sequence number: the wall clock in
+// nanoseconds, bumped past the previous number so the events one process
+// writes (and so every event of one transaction) relay in the order they
+// were written, even within one millisecond. Across replicas the order
+// follows the wall clock.
+func nextOutboxSeq() int64 {
+	for {
+		last := outboxClock.Load()
+		next := max(time.Now().UnixNano(), last+1)
+		if outboxClock.CompareAndSwap(last, next) {
+			return next
+		}
+	}
+}
+
+// Run blocks until ctx is cancelled, draining the outbox whenever this
+// replica holds the lease and retrying the lease on PollInterval otherwise.
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	name := "outbox-relay:" + r.Collection.Name()
+	logger.GoroutineStarted(name)
+	defer logger.GoroutineStopped(name, nil)
+
+	for {
+		_, err := lease.RunAsLeader(ctx, r.Leaser, r.LeaseKey, r.HolderID, r.LeaseTTL, 0, r.drainLoop)
+		if err != nil && ctx.Err() == nil {
+			logger.Warn("Outbox relay leader run ended with error", err, "collection", r.Collection.Name())
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.PollInterval):
+		}
+	}
+}
+
+func (r *OutboxRelay) drainLoop(ctx context.Context) error {
+	for {
+		n, err := r.DrainOnce(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			logger.Warn("Outbox drain failed, will retry", err, "collection", r.Collection.Name())
+		}
+		// Keep going while there is backlog; idle-poll otherwise.
+		if n > 0 && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.PollInterval):
+		}
+	}
+}
+
+// DrainOnce publishes up to BatchSize due outbox documents in order and
+// deletes each one after its ack. A document that fails is backed off or
+// parked and the batch goes on. It returns how many were published and, if
+// any failed, the first failure.
+func (r *OutboxRelay) DrainOnce(ctx context.Context) (int, error) {
+	batch := r.BatchSize
+	if batch <= 0 {
+		batch = 100
+	}
+	now := time.Now()
+	cur, err := r.Collection.Find(ctx, dueFilter(now), mopt.Find().
+		SetSort(bson.D{{Key: FailedNATSFieldSeq, Value: 1}, {Key: FailedNATSFieldID, Value: 1}}).
+		SetLimit(int64(batch)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	published := 0
+	var firstErr error
+	for cur.Next(ctx) {
+		var d FailedNATSEvent
+		if err := cur.Decode(&d); err != nil {
+			// Undecodable: retrying won't help, park it straight away.
+			id, _ := cur.Current.Lookup(FailedNATSFieldID).StringValueOK()
+			r.retryLater(ctx, FailedNATSEvent{ID: id}, now, err, true)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to decode outbox document %s: %w", id, err)
+			}
+			continue
+		}
+
+		ack, perr := publishStoredEvent(ctx, r.StreamManager, d)
+		if perr != nil {
+			metrics.RecordNATSFailure(d.StreamName, d.Subject, perr)
+			r.retryLater(ctx, d, now, perr, false)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to relay outbox event %s: %w", d.ID, perr)
+			}
+			continue
+		}
+		metrics.RecordNATSPublished(d.StreamName, d.Subject)
+
+		if _, derr := r.Collection.DeleteOne(ctx, bson.M{FailedNATSFieldID: d.ID}); derr != nil {
+			// Re-publish on the next pass is deduplicated by MsgID.
+			logger.Error("outbox delete failed after publish", derr, "id", d.ID)
+			return published, fmt.Errorf("failed to delete outbox event %s: %w", d.ID, derr)
+		}
+		published++
+		logger.Debug("relayed from outbox",
+			"stream", ack.Stream, "seq", ack.Sequence, "duplicate", ack.Duplicate,
+			"subject", d.Subject, "id", d.ID)
+	}
+	if err := cur.Err(); err != nil {
+		return published, err
+	}
+	return published, firstErr
+}
+
+// retryLater records a failed attempt on d, backing it off or, after
+// MaxAttempts failures or when giveUp is set, parking it. Seq is the
+// ordering key, so it stays.
+func (r *OutboxRelay) retryLater(ctx context.Context, d FailedNATSEvent, now time.Time, cause error, giveUp bool) {
+	attempts := d.Attempts + 1
+	set := bson.M{
+		FailedNATSFieldLastError:   cause.Error(),
+		FailedNATSFieldNextAttempt: now.Add(r.backoff(attempts)),
+	}
+	park := giveUp || (r.MaxAttempts > 0 && attempts >= r.MaxAttempts)
+	if park {
+		set[FailedNATSFieldParked] = true
+		set[FailedNATSFieldParkedAt] = now
+	}
+	if _, err := r.Collection.UpdateByID(ctx, d.ID, bson.M{
+		"$set": set,
+		"$inc": bson.M{FailedNATSFieldAttempts: 1},
+	}); err != nil {
+		logger.Error("outbox update failed", err, "id", d.ID)
+	}
+	if park {
+		logger.Error("Outbox event parked", cause,
+			"id", d.ID, logger.KeySubject, d.Subject, "attempts", attempts)
+		return
+	}
+	logger.Warn("Failed to relay outbox event, will retry", cause,
+		"id", d.ID, logger.KeySubject, d.Subject, "attempts", attempts)
+}
+
+// backoff returns the wait before the next attempt after attempts failures.
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	// Cap the exponent so the shift in nextBackoff can't overflow.
+	if attempts > 30 {
+		attempts = 30
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+	base := r.BaseBackoff
+	if base <= 0 {
+		base = time.Second
+	}
+	return nextBackoff(base, r.MaxBackoff, 0, attempts)
+}
+
+// Unpark returns a parked outbox document to the relay with a fresh attempt
+// budget. An empty id unparks every parked document.
+func (r *OutboxRelay) Unpark(ctx context.Context, id string) (int64, error) {
+	filter := bson.M{FailedNATSFieldParked: true}
+	if id != "" {
+		filter[FailedNATSFieldID] = id
+	}
+	res, err := r.Collection.UpdateMany(ctx, filter, bson.M{
+		"$set":   bson.M{FailedNATSFieldAttempts: 0},
+		"$unset": bson.M{FailedNATSFieldParked: "", FailedNATSFieldParkedAt: "", FailedNATSFieldNextAttempt: ""},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to unpark outbox events: %w", err)
+	}
+	return res.ModifiedCount, nil
+}
@@ -0,0 +1,267 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/praction-networks/common/events"
+	"github.com/praction-networks/common/events/eventstest"
+	"github.com/praction-networks/common/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// initLogger initializes the logger the events package logs through when
+// no earlier test has. Not a TestMain: TestLogHandlerOutcomeVerboseToggle
+// captures the output of the first initialization.
+func initLogger(t *testing.T) {
+	t.Helper()
+	if err := logger.InitializeLogger(logger.LoggerConfig{LogLevel: "error"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type outboxPayload struct {
+	PlanID string `json:"planId"`
+}
+
+// outboxUpsert is the part of an enqueueOutbox update command the tests
+// look at.
+type outboxUpsert struct {
+	Updates []struct {
+		Q struct {
+			ID string `bson:"_id"`
+		} `bson:"q"`
+		U struct {
+			SetOnInsert events.FailedNATSEvent `bson:"$setOnInsert"`
+		} `bson:"u"`
+		Upsert bool `bson:"upsert"`
+	} `bson:"updates"`
+}
+
+func TestOutboxEnqueueKeysOnEnvelopeID(t *testing.T) {
+	initLogger(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("enqueue", func(mt *mtest.T) {
+		pub := events.NewPublisher[outboxPayload](events.PlanStream, events.PlanCreatedSubject, nil, true, nil)
+		pub.Outbox = mt.Coll
+
+		// Outside a transaction the write would not be atomic.
+		_, err := pub.PublishWithOptions(context.Background(), outboxPayload{PlanID: "p1"}, events.PublishOptions{Outbox: true})
+		if !errors.Is(err, events.ErrOutboxNoSession) {
+			t.Fatalf("without session: %v", err)
+		}
+
+		sess, err := mt.Client.StartSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sess.EndSession(context.Background())
+		err = mongo.WithSession(context.Background(), sess, func(sc mongo.SessionContext) error {
+			// Two identical events and one with a caller MsgID.
+			for _, msgID := range []string{"", "", "order-1"} {
+				mt.AddMockResponses(mtest.CreateSuccessResponse())
+				if err := pub.PublishOutbox(sc, outboxPayload{PlanID: "p1"}, msgID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		started := mt.GetAllStartedEvents()
+		if len(started) != 3 {
+			t.Fatalf("got %d commands, want 3", len(started))
+		}
+		ids := map[string]bool{}
+		var lastSeq int64
+		for i, ev := range started {
+			var cmd outboxUpsert
+			if err := bson.Unmarshal(ev.Command, &cmd); err != nil {
+				t.Fatal(err)
+			}
+			u := cmd.Updates[0]
+			doc := u.U.SetOnInsert
+			if !u.Upsert || ids[u.Q.ID] {
+				t.Fatalf("update %d: upsert=%v, id %q reused", i, u.Upsert, u.Q.ID)
+			}
+			ids[u.Q.ID] = true
+
+			var event events.Event[outboxPayload]
+			if err := json.Unmarshal(doc.Payload, &event); err != nil {
+				t.Fatal(err)
+			}
+			if u.Q.ID != string(events.PlanStream)+"|"+event.ID {
+				t.Fatalf("update %d: _id %q, envelope ID %q", i, u.Q.ID, event.ID)
+			}
+			if doc.Seq <= lastSeq {
+				t.Fatalf("update %d: seq %d after %d", i, doc.Seq, lastSeq)
+			}
+			lastSeq = doc.Seq
+
+			wantMsgID := ""
+			if i == 2 {
+				wantMsgID = "order-1"
+			}
+			if doc.MsgID != wantMsgID {
+				t.Fatalf("update %d: msgId %q, want %q", i, doc.MsgID, wantMsgID)
+			}
+		}
+	})
+}
+
+func outboxDoc(id, msgID string, seq int64) bson.D {
+	doc := bson.D{
+		{Key: events.FailedNATSFieldID, Value: string(events.PlanStream) + "|" + id},
+		{Key: events.FailedNATSFieldStreamName, Value: string(events.PlanStream)},
+		{Key: events.FailedNATSFieldSubject, Value: string(events.PlanCreatedSubject)},
+		{Key: events.FailedNATSFieldPayload, Value: []byte(`{"subject":"plan.created","data":{"planId":"` + id + `"}}`)},
+		{Key: events.FailedNATSFieldSeq, Value: seq},
+	}
+	if msgID != "" {
+		doc = append(doc, bson.E{Key: events.FailedNATSFieldMsgID, Value: msgID})
+	}
+	return doc
+}
+
+func TestOutboxRelayDrainsInSeqOrder(t *testing.T) {
+	initLogger(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("drain", func(mt *mtest.T) {
+		js := eventstest.New()
+		js.AddStreams(t, events.PlanStream)
+		relay := &events.OutboxRelay{Collection: mt.Coll, StreamManager: js.StreamManager(), BatchSize: 10}
+		ns := "db." + mt.Coll.Name()
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+				outboxDoc("e1", "", 1), outboxDoc("e2", "order-1", 2), outboxDoc("e3", "", 3)),
+			mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(),
+		)
+		n, err := relay.DrainOnce(context.Background())
+		if err != nil || n != 3 {
+			t.Fatalf("DrainOnce = %d, %v", n, err)
+		}
+
+		find := mt.GetStartedEvent()
+		sort, _ := find.Command.Lookup("sort").Document().Elements()
+		if len(sort) != 2 || sort[0].Key() != events.FailedNATSFieldSeq || sort[1].Key() != events.FailedNATSFieldID {
+			t.Fatalf("sort = %v", find.Command.Lookup("sort"))
+		}
+
+		var got []string
+		for _, m := range js.Published(events.PlanCreatedSubject) {
+			got = append(got, m.MsgID)
+		}
+		want := []string{"e1", "order-1", "e3"}
+		if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+			t.Fatalf("published MsgIDs %v, want %v", got, want)
+		}
+		for _, id := range []string{"e1", "e2", "e3"} {
+			del := mt.GetStartedEvent()
+			q := del.Command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q", "_id")
+			if del.CommandName != "delete" || q.StringValue() != string(events.PlanStream)+"|"+id {
+				t.Fatalf("expected delete of %s, got %s %v", id, del.CommandName, del.Command)
+			}
+		}
+
+		// A failure backs e4 off; e5 goes on rather than wait behind it.
+		mt.ClearEvents()
+		js.FailPublish(events.PlanCreatedSubject, errors.New("nats down"), 1)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, outboxDoc("e4", "", 4), outboxDoc("e5", "", 5)),
+			matched(1),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+		before := time.Now()
+		if n, err := relay.DrainOnce(context.Background()); err == nil || n != 1 {
+			t.Fatalf("DrainOnce after failure = %d, %v", n, err)
+		}
+		find = mt.GetStartedEvent()
+		if parked := find.Command.Lookup("filter", events.FailedNATSFieldParked, "$ne"); !parked.Boolean() {
+			t.Fatalf("expected a find of unparked documents, got %v", find.Command)
+		}
+		u := lastFallbackUpdate(t, mt).Updates[0]
+		next, ok := u.U.Set[events.FailedNATSFieldNextAttempt].(primitive.DateTime)
+		if u.Q[events.FailedNATSFieldID] != string(events.PlanStream)+"|e4" || u.U.Inc[events.FailedNATSFieldAttempts] != int32(1) ||
+			!ok || next.Time().Before(before.Truncate(time.Millisecond)) || u.U.Set[events.FailedNATSFieldParked] != nil {
+			t.Fatalf("e4 update = %+v", u)
+		}
+		if del := mt.GetStartedEvent(); del == nil || del.CommandName != "delete" {
+			t.Fatalf("expected delete of e5, got %v", del)
+		}
+		if got := js.Published(events.PlanCreatedSubject); len(got) != 4 || got[3].MsgID != "e5" {
+			t.Fatalf("published %+v", got)
+		}
+	})
+}
+
+func TestOutboxRelayParksPoisonRow(t *testing.T) {
+	initLogger(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("poison", func(mt *mtest.T) {
+		js := eventstest.New()
+		js.AddStreams(t, events.PlanStream)
+		relay := events.NewOutboxRelay(mt.Coll, js.StreamManager(), nil)
+		relay.MaxAttempts = 3
+		ns := "db." + mt.Coll.Name()
+
+		// The poison row names a stream its subject isn't in, so it fails
+		// every time; this is its last attempt. The undecodable row is
+		// parked on sight. The good rows behind them are relayed.
+		poison := outboxDoc("e1", "", 1)
+		poison[1].Value = string(events.TenantStream)
+		poison = append(poison, bson.E{Key: events.FailedNATSFieldAttempts, Value: 2})
+		undecodable := bson.D{{Key: events.FailedNATSFieldID, Value: string(events.PlanStream) + "|e2"}, {Key: events.FailedNATSFieldSeq, Value: "two"}}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, poison, undecodable, outboxDoc("e3", "", 3), outboxDoc("e4", "", 4)),
+			matched(1),
+			matched(1),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+		n, err := relay.DrainOnce(context.Background())
+		if n != 2 || err == nil || !strings.Contains(err.Error(), "|e1") {
+			t.Fatalf("DrainOnce = %d, %v", n, err)
+		}
+		if got := js.Published(events.PlanCreatedSubject); len(got) != 2 || got[0].MsgID != "e3" || got[1].MsgID != "e4" {
+			t.Fatalf("published %+v", got)
+		}
+
+		mt.GetStartedEvent() // find
+		for _, id := range []string{"e1", "e2"} {
+			u := lastFallbackUpdate(t, mt).Updates[0]
+			if u.Q[events.FailedNATSFieldID] != string(events.PlanStream)+"|"+id || u.U.Set[events.FailedNATSFieldParked] != true ||
+				u.U.Set[events.FailedNATSFieldParkedAt] == nil || u.U.Set[events.FailedNATSFieldLastError] == "" {
+				t.Fatalf("%s update = %+v", id, u)
+			}
+		}
+		for _, id := range []string{"e3", "e4"} {
+			del := mt.GetStartedEvent()
+			q := del.Command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q", "_id")
+			if del.CommandName != "delete" || q.StringValue() != string(events.PlanStream)+"|"+id {
+				t.Fatalf("expected delete of %s, got %s %v", id, del.CommandName, del.Command)
+			}
+		}
+
+		mt.AddMockResponses(matched(1))
+		if n, err := relay.Unpark(context.Background(), string(events.PlanStream)+"|e1"); err != nil || n != 1 {
+			t.Fatalf("Unpark = %d, %v", n, err)
+		}
+		u := lastFallbackUpdate(t, mt).Updates[0]
+		if u.Q[events.FailedNATSFieldParked] != true || u.U.Set[events.FailedNATSFieldAttempts] != int32(0) {
+			t.Fatalf("unpark update = %+v", u)
+		}
+		if _, ok := u.U.Unset[events.FailedNATSFieldParked]; !ok {
+			t.Fatalf("unpark $unset = %v", u.U.Unset)
+		}
+	})
+}
@@ -0,0 +1,258 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/praction-networks/common/logger"
+	"github.com/praction-networks/common/metrics"
+)
+
+// Batch defaults, see PublishOptions.BatchWindow / BatchAckTimeout.
+const (
+	defaultBatchWindow     = 256
+	defaultBatchAckTimeout = 10 * time.Second
+)
+
+// BatchResult is the outcome of one PublishBatch item; results are returned
+// in the same order as the items.
+type BatchResult struct {
+	MsgID          string
+	Ack            *jetstream.PubAck
+	Err            error
+	Attempts       int
+	FallbackStored bool // Err is set, but the event is queued for replay
+}
+
+// batchItem is the prepared wire form of one batch element.
+type batchItem struct {
+	msgID   string
+	payload []byte
+	header  nats.Header
+}
+
+// PublishBatch publishes items with JetStream async publish, keeping at most
+// BatchWindow publishes awaiting an ack at a time. It follows the same
+// delivery-guarantee defaults as PublishWithOptions; retries are done in
+// rounds that re-send only the items that failed, and only items still
+// failing after FallbackAfterAttempts go to FallbackStorage.
+//
+// MsgID and Envelope.ID in opts are per-message, so they are ignored here:
+// with dedup each item's MsgID is derived from its content, otherwise its
+// envelope ID is used. The returned error is non-nil when any item failed;
+// inspect the results for which.
+func (p *Publisher[T]) PublishBatch(ctx context.Context, items []T, userOpts PublishOptions) ([]BatchResult, error) {
+	start := time.Now()
+	var success bool
+	defer func() {
+		metrics.NATSPublishDuration.
+			WithLabelValues(string(p.Stream), string(p.Subject), strconv.FormatBool(success)).
+			Observe(time.Since(start).Seconds())
+	}()
+
+	results := make([]BatchResult, len(items))
+	if len(items) == 0 {
+		return results, nil
+	}
+	userOpts.MsgID = ""
+	userOpts.Envelope.ID = ""
+
+	if userOpts.Outbox {
+		return p.publishBatchOutbox(ctx, items, userOpts, results)
+	}
+	if err := p.verifyStream(ctx); err != nil {
+		return nil, err
+	}
+
+	opts := userOpts.withDefaults(&Publisher[any]{
+		Stream:          p.Stream,
+		Subject:         p.Subject,
+		StreamManager:   p.StreamManager,
+		EnableDedup:     p.EnableDedup,
+		FallbackStorage: p.FallbackStorage,
+	})
+	dedup := opts.EnableDedup != nil && *opts.EnableDedup
+
+	jsOpts := []jetstream.PublishOpt{}
+	if opts.UseExpectStream != nil && *opts.UseExpectStream {
+		expectStream := opts.ExpectStreamName
+		if expectStream == "" {
+			expectStream = string(p.Stream)
+		}
+		jsOpts = append(jsOpts, jetstream.WithExpectStream(expectStream))
+	}
+
+	// Prepare every item up front; marshal/size failures are final.
+	prepared := make([]batchItem, len(items))
+	pending := make([]int, 0, len(items))
+	for i, data := range items {
+		env := newEnvelope(ctx, p.Producer, p.SchemaVersion, userOpts.Envelope)
+		payload, err := json.Marshal(Event[T]{Subject: p.Subject, Data: data, Envelope: env})
+		if err != nil {
+			results[i].Err = fmt.Errorf("failed to marshal event: %w", err)
+			continue
+		}
+		if opts.MaxMsgSize > 0 && len(payload) > opts.MaxMsgSize {
+			results[i].Err = fmt.Errorf("payload too large: %d > %d", len(payload), opts.MaxMsgSize)
+			continue
+		}
+		msgID := env.ID
+		if dedup {
+			if msgID, err = p.contentMsgID(data); err != nil {
+				results[i].Err = err
+				continue
+			}
+		}
+		prepared[i] = batchItem{msgID: msgID, payload: payload, header: env.Header()}
+		results[i].MsgID = msgID
+		pending = append(pending, i)
+	}
+
+	attempts := 1
+	if opts.RetryEnabled {
+		attempts = opts.RetryAttempts
+	}
+	for attempt := 1; attempt <= attempts && len(pending) > 0; attempt++ {
+		p.publishBatchRound(ctx, prepared, pending, results, jsOpts, dedup, opts)
+
+		retry := pending[:0]
+		for _, i := range pending {
+			results[i].Attempts = attempt
+			if results[i].Err != nil {
+				retry = append(retry, i)
+			}
+		}
+		pending = retry
+
+		if len(pending) == 0 || attempt == attempts || ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-time.After(nextBackoff(opts.BaseBackoff, opts.MaxBackoff, opts.Jitter, attempt)):
+		case <-ctx.Done():
+		}
+	}
+
+	// Fallback only the items that are still failing
+	fallback := p.FallbackStorage != nil && opts.FallbackEnabled != nil && *opts.FallbackEnabled
+	stored := 0
+	for _, i := range pending {
+		if fallback && results[i].Attempts >= opts.FallbackAfterAttempts {
+			if err := p.storeFallback(ctx, prepared[i].msgID, prepared[i].payload, results[i].Attempts, results[i].Err); err == nil {
+				results[i].FallbackStored = true
+				stored++
+			}
+		}
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	logger.Info("Published batch",
+		logger.KeyStream, string(p.Stream), logger.KeySubject, string(p.Subject),
+		"total", len(items), "failed", failed, "fallback", stored)
+	if failed > 0 {
+		return results, fmt.Errorf("failed to publish %d of %d events (%d queued for replay)", failed, len(items), stored)
+	}
+	success = true
+	return results, nil
+}
+
+// publishBatchRound async-publishes the pending items and waits for their
+// acks, writing each outcome into results.
+func (p *Publisher[T]) publishBatchRound(
+	ctx context.Context,
+	prepared []batchItem,
+	pending []int,
+	results []BatchResult,
+	jsOpts []jetstream.PublishOpt,
+	dedup bool,
+	opts PublishOptions,
+) {
+	window := opts.BatchWindow
+	if window <= 0 {
+		window = defaultBatchWindow
+	}
+	ackTimeout := opts.BatchAckTimeout
+	if ackTimeout <= 0 {
+		ackTimeout = defaultBatchAckTimeout
+	}
+	streamName, subject := string(p.Stream), string(p.Subject)
+
+	sem := make(chan struct{}, window)
+	var wg sync.WaitGroup
+	for _, i := range pending {
+		results[i].Err, results[i].Ack = nil, nil
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = fmt.Errorf("publish cancelled: %w", ctx.Err())
+			continue
+		}
+
+		item := prepared[i]
+		// PublishMsgAsync adds its own headers, so each attempt gets a copy.
+		header := nats.Header{}
+		for k, v := range item.header {
+			header[k] = append([]string(nil), v...)
+		}
+		itemOpts := jsOpts
+		if dedup {
+			itemOpts = append(append([]jetstream.PublishOpt{}, jsOpts...), jetstream.WithMsgID(item.msgID))
+		}
+		future, err := p.StreamManager.JsClient.PublishMsgAsync(&nats.Msg{Subject: subject, Data: item.payload, Header: header}, itemOpts...)
+		if err != nil {
+			<-sem
+			results[i].Err = fmt.Errorf("failed to publish event: %w", err)
+			metrics.RecordNATSFailure(streamName, subject, err)
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, future jetstream.PubAckFuture) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			timer := time.NewTimer(ackTimeout)
+			defer timer.Stop()
+			var err error
+			select {
+			case ack := <-future.Ok():
+				results[i].Ack = ack
+				metrics.RecordNATSPublished(streamName, subject)
+				return
+			case err = <-future.Err():
+			case <-timer.C:
+				err = errors.New("timed out waiting for publish ack")
+			case <-ctx.Done():
+				err = fmt.Errorf("publish cancelled: %w", ctx.Err())
+			}
+			results[i].Err = fmt.Errorf("failed to publish event: %w", err)
+			metrics.RecordNATSFailure(streamName, subject, err)
+		}(i, future)
+	}
+	wg.Wait()
+}
+
+// publishBatchOutbox writes every item to the transactional outbox. The first
+// failure stops the batch: the caller's transaction has to abort anyway.
+func (p *Publisher[T]) publishBatchOutbox(ctx context.Context, items []T, opts PublishOptions, results []BatchResult) ([]BatchResult, error) {
+	for i, data := range items {
+		if _, err := p.PublishWithOptions(ctx, data, opts); err != nil {
+			results[i].Err = err
+			return results, err
+		}
+		results[i].Attempts = 1
+	}
+	return results, nil
+}
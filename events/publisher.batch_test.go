@@ -0,0 +1,141 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/praction-networks/common/events"
+	"github.com/praction-networks/common/events/eventstest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+type batchPlan struct {
+	PlanID string `json:"planId"`
+}
+
+var batchPlans = []batchPlan{{PlanID: "p0"}, {PlanID: "p1"}, {PlanID: "p2"}, {PlanID: "p3"}}
+
+// publishedPlanIDs returns the plan IDs stored on PlanCreatedSubject, in
+// publish order.
+func publishedPlanIDs(t *testing.T, js *eventstest.JetStream) []string {
+	t.Helper()
+	var ids []string
+	for _, e := range eventstest.DecodePublished[batchPlan](t, js, events.PlanCreatedSubject) {
+		ids = append(ids, e.Data.PlanID)
+	}
+	return ids
+}
+
+func batchOptions(attempts int) events.PublishOptions {
+	return events.PublishOptions{
+		Guarantee:     events.DeliveryGuaranteed,
+		RetryEnabled:  true,
+		RetryAttempts: attempts,
+		BaseBackoff:   time.Millisecond,
+		MaxBackoff:    time.Millisecond,
+	}
+}
+
+func TestPublishBatchRetriesOnlyFailedItems(t *testing.T) {
+	initLogger(t)
+	js := eventstest.New()
+	js.AddStreams(t, events.PlanStream)
+	pub := events.NewPublisher[batchPlan](events.PlanStream, events.PlanCreatedSubject, js.StreamManager(), true, nil)
+
+	// The first publish of p0 fails; the retry round re-sends only p0, so
+	// it is stored after p1..p3.
+	js.FailPublish(events.PlanCreatedSubject, errors.New("nats down"), 1)
+	results, err := pub.PublishBatch(context.Background(), batchPlans, batchOptions(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := publishedPlanIDs(t, js); len(got) != 4 || got[0] != "p1" || got[3] != "p0" {
+		t.Fatalf("published %v", got)
+	}
+
+	// Results stay in item order, whatever order the acks came in.
+	published := js.Published(events.PlanCreatedSubject)
+	bySeq := map[uint64]eventstest.Message{}
+	for _, m := range published {
+		bySeq[m.Sequence] = m
+	}
+	for i, r := range results {
+		wantAttempts := 1
+		if i == 0 {
+			wantAttempts = 2
+		}
+		if r.Err != nil || r.Ack == nil || r.Attempts != wantAttempts {
+			t.Fatalf("result %d = %+v", i, r)
+		}
+		m := bySeq[r.Ack.Sequence]
+		var e events.Event[batchPlan]
+		if err := json.Unmarshal(m.Data, &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Data != batchPlans[i] || m.MsgID != r.MsgID {
+			t.Fatalf("result %d acked seq %d holding %+v (MsgID %q, result %q)", i, r.Ack.Sequence, e.Data, m.MsgID, r.MsgID)
+		}
+	}
+}
+
+func TestPublishBatchPartialFailureFallback(t *testing.T) {
+	initLogger(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("fallback", func(mt *mtest.T) {
+		js := eventstest.New()
+		js.AddStreams(t, events.PlanStream)
+		pub := events.NewPublisher[batchPlan](events.PlanStream, events.PlanCreatedSubject, js.StreamManager(), true, mt.Coll)
+
+		// A single round: p0 and p1 fail, p2 and p3 go through.
+		js.FailPublish(events.PlanCreatedSubject, errors.New("nats down"), 2)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 91, Message: "shutting down"}),
+		)
+		opts := batchOptions(1)
+		opts.FallbackEnabled = new(true)
+		opts.FallbackAfterAttempts = 1
+		results, err := pub.PublishBatch(context.Background(), batchPlans, opts)
+		if err == nil {
+			t.Fatal("expected an error for the failed items")
+		}
+		if got := publishedPlanIDs(t, js); len(got) != 2 || got[0] != "p2" || got[1] != "p3" {
+			t.Fatalf("published %v", got)
+		}
+
+		for i, r := range results {
+			failed := i < 2
+			if (r.Err != nil) != failed || (r.Ack == nil) != failed {
+				t.Fatalf("result %d = %+v", i, r)
+			}
+			if r.Attempts != 1 || r.FallbackStored != (i == 0) {
+				t.Fatalf("result %d = %+v", i, r)
+			}
+		}
+
+		// Only the failed items were upserted, keyed by their MsgIDs.
+		started := mt.GetAllStartedEvents()
+		if len(started) != 2 {
+			t.Fatalf("got %d fallback writes, want 2", len(started))
+		}
+		for i, ev := range started {
+			var cmd struct {
+				Updates []struct {
+					Q struct {
+						ID string `bson:"_id"`
+					} `bson:"q"`
+				} `bson:"updates"`
+			}
+			if err := bson.Unmarshal(ev.Command, &cmd); err != nil {
+				t.Fatal(err)
+			}
+			if want := string(events.PlanStream) + "|" + results[i].MsgID; cmd.Updates[0].Q.ID != want {
+				t.Fatalf("fallback %d _id = %q, want %q", i, cmd.Updates[0].Q.ID, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,217 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/praction-networks/common/events"
+	"github.com/praction-networks/common/events/eventstest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// fallbackUpdate is the part of a replayer update command the tests look at.
+type fallbackUpdate struct {
+	Updates []struct {
+		Q bson.M `bson:"q"`
+		U struct {
+			Set   bson.M `bson:"$set"`
+			Unset bson.M `bson:"$unset"`
+			Inc   bson.M `bson:"$inc"`
+		} `bson:"u"`
+		Multi bool `bson:"multi"`
+	} `bson:"updates"`
+}
+
+func lastFallbackUpdate(t *testing.T, mt *mtest.T) fallbackUpdate {
+	t.Helper()
+	ev := mt.GetStartedEvent()
+	if ev == nil || ev.CommandName != "update" {
+		t.Fatalf("expected an update, got %v", ev)
+	}
+	var cmd fallbackUpdate
+	if err := bson.Unmarshal(ev.Command, &cmd); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+// fallbackDoc is a document as Publisher.storeFallback leaves it after
+// attempts in-process tries, and replays failed passes of the replayer.
+func fallbackDoc(t *testing.T, msgID string, attempts, replays int) bson.D {
+	t.Helper()
+	payload, err := json.Marshal(events.Event[outboxPayload]{
+		Subject:  events.PlanCreatedSubject,
+		Data:     outboxPayload{PlanID: msgID},
+		Envelope: events.Envelope{ID: msgID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bson.D{
+		{Key: events.FailedNATSFieldID, Value: string(events.PlanStream) + "|" + msgID},
+		{Key: events.FailedNATSFieldStreamName, Value: string(events.PlanStream)},
+		{Key: events.FailedNATSFieldSubject, Value: string(events.PlanCreatedSubject)},
+		{Key: events.FailedNATSFieldPayload, Value: payload},
+		{Key: events.FailedNATSFieldAttempts, Value: attempts},
+		{Key: events.FailedNATSFieldReplays, Value: replays},
+		{Key: events.FailedNATSFieldTimestamp, Value: time.Now().UTC().Add(-time.Hour)},
+	}
+}
+
+func newFallbackReplayer(t *testing.T, mt *mtest.T) (*events.FallbackReplayer, *eventstest.JetStream) {
+	t.Helper()
+	js := eventstest.New()
+	js.AddStreams(t, events.PlanStream)
+	r := events.NewFallbackReplayer(mt.Coll, js.StreamManager(), nil)
+	r.MaxAttempts = 3
+	r.BaseBackoff = time.Minute
+	r.MaxBackoff = time.Hour
+	return r, js
+}
+
+func TestFallbackReplayerRepublishesThenDeletes(t *testing.T) {
+	initLogger(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("replay", func(mt *mtest.T) {
+		r, js := newFallbackReplayer(t, mt)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db."+mt.Coll.Name(), mtest.FirstBatch, fallbackDoc(t, "m1", 12, 0)),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+		processed, ok, failed, parked, err := r.ReplayOnce(context.Background())
+		if err != nil || processed != 1 || ok != 1 || failed != 0 || parked != 0 {
+			t.Fatalf("ReplayOnce = %d, %d, %d, %d, %v", processed, ok, failed, parked, err)
+		}
+		msgs := js.AssertPublished(t, events.PlanCreatedSubject, 1)
+		if msgs[0].MsgID != "m1" || msgs[0].Header.Get(events.HeaderEventID) != "m1" {
+			t.Fatalf("published %+v", msgs[0])
+		}
+
+		find := mt.GetStartedEvent()
+		if parked := find.Command.Lookup("filter", events.FailedNATSFieldParked, "$ne"); find.CommandName != "find" || !parked.Boolean() {
+			t.Fatalf("expected a find of unparked documents, got %v", find.Command)
+		}
+		del := mt.GetStartedEvent()
+		q := del.Command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q", "_id")
+		if del.CommandName != "delete" || q.StringValue() != string(events.PlanStream)+"|m1" {
+			t.Fatalf("expected delete of m1, got %v", del.Command)
+		}
+	})
+}
+
+func TestFallbackReplayerBacksOffThenParks(t *testing.T) {
+	initLogger(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("failures", func(mt *mtest.T) {
+		r, js := newFallbackReplayer(t, mt)
+		ns := "db." + mt.Coll.Name()
+
+		// The publisher already spent 12 attempts before storing the
+		// document; only the replayer's own failures count towards parking
+		// and the backoff.
+		for replays := range r.MaxAttempts {
+			js.FailPublish(events.PlanCreatedSubject, errors.New("nats down"), 1)
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, fallbackDoc(t, "m1", 12+replays, replays)),
+				matched(1),
+			)
+			mt.ClearEvents()
+			before := time.Now()
+			processed, _, failed, parked, err := r.ReplayOnce(context.Background())
+			if err != nil || processed != 1 {
+				t.Fatalf("ReplayOnce = %d, %v", processed, err)
+			}
+			mt.GetStartedEvent() // find
+			u := lastFallbackUpdate(t, mt).Updates[0]
+			if u.Q[events.FailedNATSFieldID] != string(events.PlanStream)+"|m1" ||
+				u.U.Inc[events.FailedNATSFieldAttempts] != int32(1) || u.U.Inc[events.FailedNATSFieldReplays] != int32(1) ||
+				u.U.Set[events.FailedNATSFieldLastError] != "nats down" {
+				t.Fatalf("update %d = %+v", replays, u)
+			}
+			wait := time.Minute << replays
+			next, ok := u.U.Set[events.FailedNATSFieldNextAttempt].(primitive.DateTime)
+			if !ok || next.Time().Before(before.Add(wait).Truncate(time.Millisecond)) || next.Time().After(time.Now().Add(wait)) {
+				t.Fatalf("update %d nextAttemptAt = %v, want now+%v", replays, u.U.Set[events.FailedNATSFieldNextAttempt], wait)
+			}
+
+			last := replays+1 == r.MaxAttempts
+			if _, set := u.U.Set[events.FailedNATSFieldParked]; set != last || failed != 1-parked || (parked == 1) != last {
+				t.Fatalf("update %d: failed %d, parked %d, $set %v", replays, failed, parked, u.U.Set)
+			}
+		}
+		js.AssertPublished(t, events.PlanCreatedSubject, 0)
+	})
+}
+
+func TestFallbackReplayerUnpark(t *testing.T) {
+	initLogger(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("unpark", func(mt *mtest.T) {
+		r, _ := newFallbackReplayer(t, mt)
+		for _, id := range []string{"PlanStream|m1", ""} {
+			mt.AddMockResponses(matched(2))
+			if n, err := r.Unpark(context.Background(), id); err != nil || n != 2 {
+				t.Fatalf("Unpark(%q) = %d, %v", id, n, err)
+			}
+			u := lastFallbackUpdate(t, mt).Updates[0]
+			gotID, filtered := u.Q[events.FailedNATSFieldID]
+			if u.Q[events.FailedNATSFieldParked] != true || !u.Multi || filtered != (id != "") || (filtered && gotID != id) {
+				t.Fatalf("Unpark(%q) filter = %v", id, u.Q)
+			}
+			// A fresh replay budget; the publisher's attempts are history.
+			if u.U.Set[events.FailedNATSFieldReplays] != int32(0) || len(u.U.Set) != 1 {
+				t.Fatalf("Unpark(%q) $set = %v", id, u.U.Set)
+			}
+			for _, f := range []string{events.FailedNATSFieldParked, events.FailedNATSFieldParkedAt, events.FailedNATSFieldNextAttempt} {
+				if _, ok := u.U.Unset[f]; !ok {
+					t.Fatalf("Unpark(%q) $unset = %v", id, u.U.Unset)
+				}
+			}
+		}
+	})
+}
+
+func TestFallbackReplayerCollectBacklog(t *testing.T) {
+	initLogger(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("backlog", func(mt *mtest.T) {
+		r, _ := newFallbackReplayer(t, mt)
+		ns := "db." + mt.Coll.Name()
+		count := func(n int) bson.D {
+			return mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "_id", Value: 1}, {Key: "n", Value: n}})
+		}
+
+		mt.AddMockResponses(count(3), count(1),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, fallbackDoc(t, "m1", 1, 0)))
+		if err := r.CollectBacklog(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		started := mt.GetAllStartedEvents()
+		if len(started) != 3 || started[0].CommandName != "aggregate" || started[1].CommandName != "aggregate" || started[2].CommandName != "find" {
+			t.Fatalf("commands = %v", started)
+		}
+		pending := started[0].Command.Lookup("pipeline").Array().Index(0).Value().Document().Lookup("$match", events.FailedNATSFieldParked, "$ne")
+		parked := started[1].Command.Lookup("pipeline").Array().Index(0).Value().Document().Lookup("$match", events.FailedNATSFieldParked)
+		if !pending.Boolean() || !parked.Boolean() {
+			t.Fatalf("count filters = %v, %v", started[0].Command, started[1].Command)
+		}
+		if sort := started[2].Command.Lookup("sort", events.FailedNATSFieldTimestamp); sort.Int32() != 1 {
+			t.Fatalf("oldest lookup = %v", started[2].Command)
+		}
+
+		// An empty queue isn't an error; a failed count is.
+		mt.AddMockResponses(count(0), count(0), mtest.CreateCursorResponse(0, ns, mtest.FirstBatch))
+		if err := r.CollectBacklog(context.Background()); err != nil {
+			t.Fatalf("empty backlog: %v", err)
+		}
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 91, Message: "shutting down"}))
+		if err := r.CollectBacklog(context.Background()); err == nil {
+			t.Fatal("expected the count error")
+		}
+	})
+}
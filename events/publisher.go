@@ -12,6 +12,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/praction-networks/common/logger"
 	"github.com/praction-networks/common/metrics"
@@ -28,6 +29,22 @@ type Publisher[T any] struct {
 	EnableDedup     bool
 	FallbackStorage *mongo.Collection
 
+	// Outbox is the transactional outbox collection used when
+	// PublishOptions.Outbox is set. Drained to JetStream by OutboxRelay.
+	Outbox *mongo.Collection
+
+	// Schedule is the collection PublishAt writes delayed events to.
+	// Released to JetStream by ScheduleDispatcher.
+	Schedule *mongo.Collection
+
+	// Producer is stamped on every event's envelope. Empty means the
+	// SERVICE_NAME environment variable.
+	Producer string
+
+	// SchemaVersion is stamped on every event's envelope. 0 means
+	// DefaultSchemaVersion.
+	SchemaVersion int
+
 	// streamVerified flips to true after the first successful
 	// StreamManager.Stream() round-trip. Subsequent publishes skip
 	// the existence check — JetStream's WithExpectStream guard at
@@ -138,6 +155,22 @@ type PublishOptions struct {
 
 	// Optional payload guard if your stream uses MaxMsgSize (defaults to 2MB)
 	MaxMsgSize int // 0 = use default (2MB)
+
+	// Outbox writes the event to Publisher.Outbox instead of JetStream.
+	// ctx must be a mongo.SessionContext inside the caller's transaction so
+	// the event commits (or aborts) together with the business write; an
+	// OutboxRelay publishes it afterwards. Retry/fallback options are
+	// ignored and the returned PubAck is nil.
+	Outbox bool
+
+	// Envelope overrides. Any field left empty is filled from ctx
+	// (tenant, actor, correlation/causation) and the publisher.
+	Envelope Envelope
+
+	// PublishBatch only: async publishes awaiting an ack at once (default
+	// 256), and how long each may wait for its ack (default 10s).
+	BatchWindow     int
+	BatchAckTimeout time.Duration
 }
 
 func (o *PublishOptions) withDefaults(pub *Publisher[any]) PublishOptions {
@@ -270,32 +303,17 @@ func (p *Publisher[T]) PublishWithOptions(ctx context.Context, data T, userOpts
 			Observe(time.Since(start).Seconds())
 	}()
 
-	// Ensure the stream exists (fail fast on config errors). Cached
-	// after the first successful round-trip — JetStream's
-	// WithExpectStream guard at publish time still enforces
-	// correctness on the hot path. The lookup runs on a detached
-	// ctx with a short budget so a cancelled caller ctx doesn't
-	// surface as "Stream not found" (which it isn't — it's the
-	// existence probe failing because *its* ctx died).
+	// Outbox writes skip the stream probe: they must not depend on NATS being up.
 	streamName := string(p.Stream)
-	if !p.streamVerified.Load() {
-		checkCtx, checkCancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
-		_, err := p.StreamManager.Stream(checkCtx, streamName)
-		checkCancel()
-		if err != nil {
-			metrics.RecordNATSFailure(streamName, string(p.Subject), err)
-			if errors.Is(err, jetstream.ErrStreamNotFound) {
-				logger.Error("Stream not found for subject", err, "Stream", p.Stream, "Subject", p.Subject)
-				return nil, fmt.Errorf("stream %s not found for subject %s: %w", p.Stream, p.Subject, err)
-			}
-			logger.Warn("Stream existence check failed", err, "Stream", p.Stream, "Subject", p.Subject)
-			return nil, fmt.Errorf("stream %s lookup failed for subject %s: %w", p.Stream, p.Subject, err)
+	if !userOpts.Outbox {
+		if err := p.verifyStream(ctx); err != nil {
+			return nil, err
 		}
-		p.streamVerified.Store(true)
 	}
 
-	// Prepare payload (carry your generic Event[T])
-	event := Event[T]{Subject: p.Subject, Data: data}
+	// Prepare payload (carry your generic Event[T] plus the envelope)
+	env := newEnvelope(ctx, p.Producer, p.SchemaVersion, userOpts.Envelope)
+	event := Event[T]{Subject: p.Subject, Data: data, Envelope: env}
 	payload, err := json.Marshal(event)
 	if err != nil {
 		metrics.RecordNATSFailure(streamName, string(p.Subject), err)
@@ -326,19 +344,39 @@ func (p *Publisher[T]) PublishWithOptions(ctx context.Context, data T, userOpts
 		jsOpts = append(jsOpts, jetstream.WithExpectStream(expectStream))
 	}
 
-	// Dedupe (generate MsgID from payload bytes if empty)
+	// Dedupe (generate MsgID from payload bytes if empty). The hash covers
+	// only {subject,data} — the envelope carries a fresh ID and timestamp on
+	// every call, which would otherwise defeat content-based dedup.
 	if opts.EnableDedup != nil && *opts.EnableDedup {
 		if opts.MsgID == "" {
-			sum := sha256.Sum256(payload)
-			trunc := sum[:16] // 16 bytes (32 hex chars) is plenty
-			opts.MsgID = fmt.Sprintf("%s:%s", p.Subject, hex.EncodeToString(trunc))
+			id, err := p.contentMsgID(data)
+			if err != nil {
+				return nil, err
+			}
+			opts.MsgID = id
 		}
 		jsOpts = append(jsOpts, jetstream.WithMsgID(opts.MsgID))
 	}
 
+	// Transactional outbox: persist inside the caller's session, relay later.
+	// Rows are keyed on the envelope ID, not a content hash, so identical
+	// events written in one transaction are all relayed.
+	if opts.Outbox {
+		if err := p.enqueueOutbox(ctx, payload, env.ID, userOpts.MsgID, env.OccurredAt); err != nil {
+			metrics.RecordNATSFailure(streamName, string(p.Subject), err)
+			logger.Error("Failed to write event to outbox", err, "subject", p.Subject, "eventID", env.ID)
+			return nil, err
+		}
+		success = true
+		return nil, nil
+	}
+
+	// Envelope mirrored into NATS headers
+	msg := &nats.Msg{Subject: string(p.Subject), Data: payload, Header: env.Header()}
+
 	// One-shot publish (no retry loop)
 	if !opts.RetryEnabled {
-		ack, err := p.StreamManager.JsClient.Publish(ctx, string(p.Subject), payload, jsOpts...)
+		ack, err := p.StreamManager.JsClient.PublishMsg(ctx, msg, jsOpts...)
 		if err != nil {
 			metrics.RecordNATSFailure(streamName, string(p.Subject), err)
 			logger.Error("Failed to publish event (no retry)", "subject", p.Subject, err)
@@ -357,7 +395,7 @@ func (p *Publisher[T]) PublishWithOptions(ctx context.Context, data T, userOpts
 
 	for attempt := 1; attempt <= attempts; attempt++ {
 		actualAttempts = attempt
-		ack, err := p.StreamManager.JsClient.Publish(ctx, string(p.Subject), payload, jsOpts...)
+		ack, err := p.StreamManager.JsClient.PublishMsg(ctx, msg, jsOpts...)
 		if err == nil {
 			success = true
 			metrics.RecordNATSPublished(streamName, string(p.Subject))
@@ -387,32 +425,7 @@ func (p *Publisher[T]) PublishWithOptions(ctx context.Context, data T, userOpts
 
 	// Fallback (idempotent upsert) after threshold (only when enabled)
 	if p.FallbackStorage != nil && opts.FallbackEnabled != nil && *opts.FallbackEnabled && actualAttempts >= opts.FallbackAfterAttempts {
-		docID := fmt.Sprintf("%s|%s", p.Stream, opts.MsgID)
-		lastErrStr := "publish failed"
-		if lastErr != nil {
-			lastErrStr = lastErr.Error()
-		}
-
-		filter := bson.M{"_id": docID}
-		update := bson.M{
-			"$setOnInsert": bson.M{
-				"streamName": string(p.Stream),
-				"subject":    string(p.Subject),
-				"payload":    payload, // keep original payload on first insert
-				"attempts":   0,
-			},
-			"$set": bson.M{
-				"timestamp": time.Now(), // last attempt time
-				"lastError": lastErrStr,
-			},
-			"$inc": bson.M{"attempts": actualAttempts}, // record how many we already tried here
-		}
-		_, ferr := p.FallbackStorage.UpdateOne(ctx, filter, update, mopt.Update().SetUpsert(true))
-		if ferr != nil {
-			logger.Error("Fallback upsert failed", ferr, "subject", p.Subject, "msgID", opts.MsgID)
-		} else {
-			logger.Warn("Fallback upserted", "subject", p.Subject, "msgID", opts.MsgID)
-		}
+		_ = p.storeFallback(ctx, opts.MsgID, payload, actualAttempts, lastErr)
 	}
 
 	if lastErr == nil {
@@ -421,6 +434,128 @@ func (p *Publisher[T]) PublishWithOptions(ctx context.Context, data T, userOpts
 	return nil, fmt.Errorf("failed to publish after %d attempts: %w", actualAttempts, lastErr)
 }
 
+// verifyStream ensures the stream exists (fail fast on config errors). Cached
+// after the first successful round-trip — JetStream's WithExpectStream guard
+// at publish time still enforces correctness on the hot path. The lookup runs
+// on a detached ctx with a short budget so a cancelled caller ctx doesn't
+// surface as "Stream not found" (which it isn't — it's the existence probe
+// failing because *its* ctx died).
+func (p *Publisher[T]) verifyStream(ctx context.Context) error {
+	if p.streamVerified.Load() {
+		return nil
+	}
+	streamName := string(p.Stream)
+	checkCtx, checkCancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	_, err := p.StreamManager.Stream(checkCtx, streamName)
+	checkCancel()
+	if err != nil {
+		metrics.RecordNATSFailure(streamName, string(p.Subject), err)
+		if errors.Is(err, jetstream.ErrStreamNotFound) {
+			logger.Error("Stream not found for subject", err, "Stream", p.Stream, "Subject", p.Subject)
+			return fmt.Errorf("stream %s not found for subject %s: %w", p.Stream, p.Subject, err)
+		}
+		logger.Warn("Stream existence check failed", err, "Stream", p.Stream, "Subject", p.Subject)
+		return fmt.Errorf("stream %s lookup failed for subject %s: %w", p.Stream, p.Subject, err)
+	}
+	p.streamVerified.Store(true)
+	return nil
+}
+
+// contentMsgID derives the dedup MsgID from the bare {subject,data} so the
+// same payload always maps to the same ID.
+func (p *Publisher[T]) contentMsgID(data T) (string, error) {
+	bare, err := json.Marshal(Event[T]{Subject: p.Subject, Data: data})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal event: %w", err)
+	}
+	sum := sha256.Sum256(bare)
+	trunc := sum[:16] // 16 bytes (32 hex chars) is plenty
+	return fmt.Sprintf("%s:%s", p.Subject, hex.EncodeToString(trunc)), nil
+}
+
+// storeFallback upserts a failed publish into FallbackStorage (idempotent on
+// "<Stream>|<MsgID>").
+func (p *Publisher[T]) storeFallback(ctx context.Context, msgID string, payload []byte, attempts int, lastErr error) error {
+	return StoreFallback(ctx, p.FallbackStorage, p.Stream, p.Subject, msgID, payload, attempts, lastErr)
+}
+
+// StoreFallback upserts a message that could not be published into a
+// fallback collection, where FallbackReplayer republishes it with msgID for
+// deduplication. The document is keyed "<stream>|<msgID>", so storing the
+// same message again only bumps its attempts.
+func StoreFallback(ctx context.Context, coll *mongo.Collection, stream StreamName, subject Subject, msgID string, payload []byte, attempts int, lastErr error) error {
+	docID := fmt.Sprintf("%s|%s", stream, msgID)
+	lastErrStr := "publish failed"
+	if lastErr != nil {
+		lastErrStr = lastErr.Error()
+	}
+
+	filter := bson.M{"_id": docID}
+	update := bson.M{
+		"$setOnInsert": bson.M{
+			"streamName": string(stream),
+			"subject":    string(subject),
+			"payload":    payload, // keep original payload on first insert
+		},
+		"$set": bson.M{
+			"timestamp": time.Now(), // last attempt time
+			"lastError": lastErrStr,
+		},
+		"$inc": bson.M{"attempts": attempts}, // record how many we already tried here
+	}
+	_, ferr := coll.UpdateOne(ctx, filter, update, mopt.Update().SetUpsert(true))
+	if ferr != nil {
+		logger.Error("Fallback upsert failed", ferr, "subject", subject, "msgID", msgID)
+		return ferr
+	}
+	logger.Warn("Fallback upserted", "subject", subject, "msgID", msgID)
+	return nil
+}
+
+// PublishOutbox writes the event to the transactional outbox inside the
+// caller's Mongo transaction (see PublishOptions.Outbox).
+func (p *Publisher[T]) PublishOutbox(sessCtx mongo.SessionContext, data T, msgID string) error {
+	_, err := p.PublishWithOptions(sessCtx, data, PublishOptions{
+		Guarantee: DeliveryGuaranteed,
+		MsgID:     msgID,
+		Outbox:    true,
+	})
+	return err
+}
+
+// enqueueOutbox upserts the event into the outbox collection using the
+// FailedNATSEvent shape, keyed "<Stream>|<eventID>". msgID, when the caller
+// set one, is what the relay publishes with; otherwise the event ID is. An
+// upsert rather than an insert keeps a re-published envelope from raising
+// a duplicate-key error, which would abort the caller's transaction.
+func (p *Publisher[T]) enqueueOutbox(ctx context.Context, payload []byte, eventID, msgID string, occurredAt time.Time) error {
+	if p.Outbox == nil {
+		return ErrOutboxNotConfigured
+	}
+	if mongo.SessionFromContext(ctx) == nil {
+		return ErrOutboxNoSession
+	}
+	doc := bson.M{
+		FailedNATSFieldStreamName: string(p.Stream),
+		FailedNATSFieldSubject:    string(p.Subject),
+		FailedNATSFieldPayload:    payload,
+		FailedNATSFieldAttempts:   0,
+		FailedNATSFieldTimestamp:  occurredAt,
+		FailedNATSFieldSeq:        nextOutboxSeq(),
+	}
+	if msgID != "" && msgID != eventID {
+		doc[FailedNATSFieldMsgID] = msgID
+	}
+	docID := fmt.Sprintf("%s|%s", p.Stream, eventID)
+	_, err := p.Outbox.UpdateOne(ctx, bson.M{FailedNATSFieldID: docID}, bson.M{
+		"$setOnInsert": doc,
+	}, mopt.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to write outbox event: %w", err)
+	}
+	return nil
+}
+
 // EnsureFallbackIndexes creates helpful indexes for the fallback collection.
 // Call once at boot if you use Mongo fallback.
 func EnsureFallbackIndexes(ctx context.Context, coll *mongo.Collection) error {
@@ -432,6 +567,11 @@ func EnsureFallbackIndexes(ctx context.Context, coll *mongo.Collection) error {
 			Keys:    bson.D{{Key: "timestamp", Value: 1}},
 			Options: mopt.Index().SetName("ts_idx"),
 		},
+		{
+			// FallbackReplayer due-document scan
+			Keys:    bson.D{{Key: "parked", Value: 1}, {Key: "nextAttemptAt", Value: 1}},
+			Options: mopt.Index().SetName("parked_next_attempt_idx"),
+		},
 		// _id is unique by default (we use "<Stream>|<MsgID>")
 	}
 	_, err := coll.Indexes().CreateMany(ctx, models)
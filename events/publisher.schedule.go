@@ -0,0 +1,125 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/praction-networks/common/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopt "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrScheduleNotConfigured is returned when a delayed publish is requested
+// on a Publisher without a Schedule collection.
+var ErrScheduleNotConfigured = errors.New("events: publisher schedule collection not configured")
+
+// PublishAt stores the event in Publisher.Schedule to be published at at by
+// ScheduleDispatcher, and returns the generated key for CancelScheduled and
+// Reschedule. The envelope is built now, so correlation and tenant come from
+// ctx and occurredAt is the scheduling time.
+func (p *Publisher[T]) PublishAt(ctx context.Context, data T, at time.Time) (string, error) {
+	key := uuid.New().String()
+	if err := p.PublishAtKey(ctx, key, data, at); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// PublishAtKey is PublishAt with a caller-chosen key, typically derived from
+// the entity (e.g. "offer-expiry:<offerID>"). Scheduling an existing key
+// replaces its payload and due time.
+func (p *Publisher[T]) PublishAtKey(ctx context.Context, key string, data T, at time.Time) error {
+	if p.Schedule == nil {
+		return ErrScheduleNotConfigured
+	}
+	if key == "" {
+		return errors.New("events: schedule key is required")
+	}
+	env := newEnvelope(ctx, p.Producer, p.SchemaVersion, Envelope{})
+	payload, err := json.Marshal(Event[T]{Subject: p.Subject, Data: data, Envelope: env})
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	now := time.Now().UTC()
+	_, err = p.Schedule.UpdateOne(ctx, bson.M{ScheduledFieldID: scheduledDocID(p.Stream, key)}, bson.M{
+		"$set": bson.M{
+			ScheduledFieldKey:        key,
+			ScheduledFieldStreamName: string(p.Stream),
+			ScheduledFieldSubject:    string(p.Subject),
+			ScheduledFieldPayload:    payload,
+			ScheduledFieldMsgID:      env.ID,
+			ScheduledFieldDueAt:      at.UTC(),
+			ScheduledFieldAttempts:   0,
+		},
+		"$setOnInsert": bson.M{ScheduledFieldCreatedAt: now},
+		"$unset":       bson.M{ScheduledFieldLastError: "", ScheduledFieldNextAttempt: ""},
+	}, mopt.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to schedule event %s: %w", key, err)
+	}
+	logger.Debug("Scheduled event",
+		logger.KeyStream, string(p.Stream), logger.KeySubject, string(p.Subject),
+		"key", key, "dueAt", at.UTC())
+	return nil
+}
+
+// CancelScheduled removes a scheduled event. It reports false when the key
+// is unknown or the event was already published.
+func (p *Publisher[T]) CancelScheduled(ctx context.Context, key string) (bool, error) {
+	if p.Schedule == nil {
+		return false, ErrScheduleNotConfigured
+	}
+	res, err := p.Schedule.DeleteOne(ctx, bson.M{ScheduledFieldID: scheduledDocID(p.Stream, key)})
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel scheduled event %s: %w", key, err)
+	}
+	return res.DeletedCount > 0, nil
+}
+
+// Reschedule moves a scheduled event to at, keeping its payload. It reports
+// false when the key is unknown or the event was already published.
+func (p *Publisher[T]) Reschedule(ctx context.Context, key string, at time.Time) (bool, error) {
+	if p.Schedule == nil {
+		return false, ErrScheduleNotConfigured
+	}
+	// A new MsgID keeps a dispatcher that is publishing the old due time
+	// from deleting the moved event (see ScheduleDispatcher.DispatchOnce).
+	res, err := p.Schedule.UpdateOne(ctx, bson.M{ScheduledFieldID: scheduledDocID(p.Stream, key)}, bson.M{
+		"$set": bson.M{
+			ScheduledFieldDueAt:    at.UTC(),
+			ScheduledFieldMsgID:    uuid.New().String(),
+			ScheduledFieldAttempts: 0,
+		},
+		"$unset": bson.M{ScheduledFieldLastError: "", ScheduledFieldNextAttempt: ""},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to reschedule event %s: %w", key, err)
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// GetScheduled returns a scheduled event, or nil when the key is unknown.
+func (p *Publisher[T]) GetScheduled(ctx context.Context, key string) (*ScheduledEvent, error) {
+	if p.Schedule == nil {
+		return nil, ErrScheduleNotConfigured
+	}
+	var d ScheduledEvent
+	err := p.Schedule.FindOne(ctx, bson.M{ScheduledFieldID: scheduledDocID(p.Stream, key)}).Decode(&d)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scheduled event %s: %w", key, err)
+	}
+	return &d, nil
+}
+
+func scheduledDocID(stream StreamName, key string) string {
+	return fmt.Sprintf("%s|%s", stream, key)
+}
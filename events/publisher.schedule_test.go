@@ -0,0 +1,193 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/praction-networks/common/events"
+	"github.com/praction-networks/common/events/eventstest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// scheduleUpdate is the part of a schedule update command the tests look at.
+type scheduleUpdate struct {
+	Updates []struct {
+		Q struct {
+			ID    string `bson:"_id"`
+			MsgID string `bson:"msgId"`
+		} `bson:"q"`
+		U struct {
+			Set   bson.M `bson:"$set"`
+			Unset bson.M `bson:"$unset"`
+			Inc   bson.M `bson:"$inc"`
+		} `bson:"u"`
+		Upsert bool `bson:"upsert"`
+	} `bson:"updates"`
+}
+
+func lastScheduleUpdate(t *testing.T, mt *mtest.T) scheduleUpdate {
+	t.Helper()
+	ev := mt.GetStartedEvent()
+	if ev == nil || ev.CommandName != "update" {
+		t.Fatalf("expected an update, got %v", ev)
+	}
+	var cmd scheduleUpdate
+	if err := bson.Unmarshal(ev.Command, &cmd); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func matched(n int) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: n})
+}
+
+func TestScheduleRescheduleAndCancelByKey(t *testing.T) {
+	initLogger(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("by key", func(mt *mtest.T) {
+		ctx := context.Background()
+		pub := events.NewPublisher[outboxPayload](events.PlanStream, events.PlanCreatedSubject, nil, true, nil)
+		pub.Schedule = mt.Coll
+		const key = "offer-expiry:42"
+		docID := string(events.PlanStream) + "|" + key
+
+		at := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+		mt.AddMockResponses(matched(1))
+		if err := pub.PublishAtKey(ctx, key, outboxPayload{PlanID: "p1"}, at); err != nil {
+			t.Fatal(err)
+		}
+		u := lastScheduleUpdate(t, mt).Updates[0]
+		scheduledMsgID, _ := u.U.Set[events.ScheduledFieldMsgID].(string)
+		if u.Q.ID != docID || !u.Upsert || scheduledMsgID == "" {
+			t.Fatalf("schedule update = %+v", u)
+		}
+
+		// Rescheduling swaps the MsgID so a dispatcher publishing the old due
+		// time can't delete the moved event, and the move isn't deduplicated
+		// against it.
+		moved := at.Add(time.Hour)
+		mt.AddMockResponses(matched(1))
+		if ok, err := pub.Reschedule(ctx, key, moved); err != nil || !ok {
+			t.Fatalf("Reschedule = %v, %v", ok, err)
+		}
+		u = lastScheduleUpdate(t, mt).Updates[0]
+		newMsgID, _ := u.U.Set[events.ScheduledFieldMsgID].(string)
+		if u.Q.ID != docID || u.Upsert || newMsgID == "" || newMsgID == scheduledMsgID {
+			t.Fatalf("reschedule update = %+v", u)
+		}
+		if due, ok := u.U.Set[events.ScheduledFieldDueAt].(primitive.DateTime); !ok || !due.Time().Equal(moved) || u.U.Set[events.ScheduledFieldAttempts] != int32(0) {
+			t.Fatalf("reschedule $set = %v", u.U.Set)
+		}
+		if _, ok := u.U.Unset[events.ScheduledFieldNextAttempt]; !ok {
+			t.Fatalf("reschedule $unset = %v", u.U.Unset)
+		}
+		mt.AddMockResponses(matched(0))
+		if ok, err := pub.Reschedule(ctx, "unknown", moved); err != nil || ok {
+			t.Fatalf("Reschedule unknown = %v, %v", ok, err)
+		}
+		mt.ClearEvents()
+
+		for _, want := range []bool{true, false} {
+			n := 0
+			if want {
+				n = 1
+			}
+			mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}))
+			if ok, err := pub.CancelScheduled(ctx, key); err != nil || ok != want {
+				t.Fatalf("CancelScheduled = %v, %v, want %v", ok, err, want)
+			}
+			del := mt.GetStartedEvent()
+			q := del.Command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q", "_id")
+			if del.CommandName != "delete" || q.StringValue() != docID {
+				t.Fatalf("expected delete of %s, got %v", docID, del.Command)
+			}
+		}
+	})
+}
+
+func scheduledDoc(t *testing.T, key, msgID string, due time.Time) bson.D {
+	t.Helper()
+	payload, err := json.Marshal(events.Event[outboxPayload]{
+		Subject:  events.PlanCreatedSubject,
+		Data:     outboxPayload{PlanID: key},
+		Envelope: events.Envelope{ID: msgID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bson.D{
+		{Key: events.ScheduledFieldID, Value: string(events.PlanStream) + "|" + key},
+		{Key: events.ScheduledFieldKey, Value: key},
+		{Key: events.ScheduledFieldStreamName, Value: string(events.PlanStream)},
+		{Key: events.ScheduledFieldSubject, Value: string(events.PlanCreatedSubject)},
+		{Key: events.ScheduledFieldPayload, Value: payload},
+		{Key: events.ScheduledFieldMsgID, Value: msgID},
+		{Key: events.ScheduledFieldDueAt, Value: due},
+	}
+}
+
+func TestScheduleDispatcherPublishesThenDeletes(t *testing.T) {
+	initLogger(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("dispatch", func(mt *mtest.T) {
+		ctx := context.Background()
+		js := eventstest.New()
+		js.AddStreams(t, events.PlanStream)
+		d := events.NewScheduleDispatcher(mt.Coll, js.StreamManager(), nil)
+		ns := "db." + mt.Coll.Name()
+		due := time.Now().UTC().Add(-time.Minute)
+
+		// k1 fails and is pushed back; k2 is published, then deleted only
+		// if its MsgID is still the one published.
+		js.FailPublish(events.PlanCreatedSubject, errors.New("nats down"), 1)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, scheduledDoc(t, "k1", "m1", due), scheduledDoc(t, "k2", "m2", due)),
+			matched(1),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+		if n, err := d.DispatchOnce(ctx); err != nil || n != 2 {
+			t.Fatalf("DispatchOnce = %d, %v", n, err)
+		}
+		msgs := js.AssertPublished(t, events.PlanCreatedSubject, 1)
+		if msgs[0].MsgID != "m2" || msgs[0].Header.Get(events.HeaderEventID) != "m2" {
+			t.Fatalf("published %+v", msgs[0])
+		}
+
+		mt.GetStartedEvent() // find
+		u := lastScheduleUpdate(t, mt).Updates[0]
+		if u.Q.ID != string(events.PlanStream)+"|k1" || u.Q.MsgID != "m1" || u.U.Inc[events.ScheduledFieldAttempts] != int32(1) {
+			t.Fatalf("retry update = %+v", u)
+		}
+		if next, ok := u.U.Set[events.ScheduledFieldNextAttempt].(primitive.DateTime); !ok || !next.Time().After(due) {
+			t.Fatalf("retry $set = %v", u.U.Set)
+		}
+		del := mt.GetStartedEvent()
+		q := del.Command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q")
+		if del.CommandName != "delete" || q.Document().Lookup("_id").StringValue() != string(events.PlanStream)+"|k2" ||
+			q.Document().Lookup(events.ScheduledFieldMsgID).StringValue() != "m2" {
+			t.Fatalf("expected delete of k2 at m2, got %v", del.Command)
+		}
+
+		// A dispatcher that died before deleting k2 publishes it again
+		// harmlessly; once rescheduled under a new MsgID it is a new event.
+		for _, msgID := range []string{"m2", "m3"} {
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, scheduledDoc(t, "k2", msgID, due)),
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			)
+			if _, err := d.DispatchOnce(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		msgs = js.AssertPublished(t, events.PlanCreatedSubject, 2)
+		if msgs[1].MsgID != "m3" {
+			t.Fatalf("published %+v", msgs[1])
+		}
+	})
+}
@@ -0,0 +1,92 @@
+// Package redisidempotency provides a Redis-backed events.IdempotencyStore.
+// Kept in a sub-package so the events package stays free of the go-redis
+// dependency.
+//
+// Each key holds "processing" (with the claim TTL) or "done" (with the dedup
+// TTL). Claim is a single SET NX; Release is a Lua check-and-delete so a late
+// Release can never remove a key another delivery has since completed.
+package redisidempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/praction-networks/common/events"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	stateProcessing = "processing"
+	stateDone       = "done"
+)
+
+// ErrNoClient is returned when the store was constructed without a client.
+var ErrNoClient = errors.New("redisidempotency: no redis client configured")
+
+// releaseScript deletes the key only while it is still a processing claim.
+const releaseScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("DEL", KEYS[1])
+else
+    return 0
+end
+`
+
+// Store implements events.IdempotencyStore against a go-redis client.
+type Store struct {
+	client *redis.Client
+	prefix string
+}
+
+// New constructs a Redis-backed store. Keys are written as prefix+key; an
+// empty prefix defaults to "idem:".
+func New(client *redis.Client, prefix string) *Store {
+	if prefix == "" {
+		prefix = "idem:"
+	}
+	return &Store{client: client, prefix: prefix}
+}
+
+// Claim sets key to processing with SET NX. When the key exists its value
+// tells a completed message from one still in flight.
+func (s *Store) Claim(ctx context.Context, key string, claimTTL time.Duration) (events.ClaimStatus, error) {
+	if s == nil || s.client == nil {
+		return events.ClaimAcquired, ErrNoClient
+	}
+	ok, err := s.client.SetNX(ctx, s.prefix+key, stateProcessing, claimTTL).Result()
+	if err != nil {
+		return events.ClaimAcquired, err
+	}
+	if ok {
+		return events.ClaimAcquired, nil
+	}
+	state, err := s.client.Get(ctx, s.prefix+key).Result()
+	if errors.Is(err, redis.Nil) {
+		// Expired or released since SETNX; let the redelivery retry.
+		return events.ClaimInFlight, nil
+	}
+	if err != nil {
+		return events.ClaimAcquired, err
+	}
+	if state == stateDone {
+		return events.ClaimDuplicate, nil
+	}
+	return events.ClaimInFlight, nil
+}
+
+// Complete marks key done for ttl.
+func (s *Store) Complete(ctx context.Context, key string, ttl time.Duration) error {
+	if s == nil || s.client == nil {
+		return ErrNoClient
+	}
+	return s.client.Set(ctx, s.prefix+key, stateDone, ttl).Err()
+}
+
+// Release deletes an in-flight claim. Completed keys are left alone.
+func (s *Store) Release(ctx context.Context, key string) error {
+	if s == nil || s.client == nil {
+		return ErrNoClient
+	}
+	return s.client.Eval(ctx, releaseScript, []string{s.prefix + key}, stateProcessing).Err()
+}
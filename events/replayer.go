@@ -0,0 +1,240 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/praction-networks/common/logger"
+)
+
+// ReplayOptions selects what a Replayer reads.
+type ReplayOptions struct {
+	// Start point: FromSeq wins over FromTime; neither means the start of
+	// the stream.
+	FromSeq  uint64
+	FromTime time.Time
+
+	// End point: UntilSeq (inclusive) or UntilTime (exclusive). With neither,
+	// the replay stops at the stream's last sequence when Run started, so
+	// events published during the replay are left to the live listeners.
+	UntilSeq  uint64
+	UntilTime time.Time
+
+	FilterSubjects []Subject
+
+	// ContinueOnError counts handler and decode failures and moves on;
+	// by default the first failure stops the replay.
+	ContinueOnError bool
+
+	// Upcasters, when set, brings older payloads up to date first.
+	Upcasters *UpcasterRegistry
+
+	// OnProgress is called every ProgressEvery messages (default 1000) and
+	// once at the end.
+	OnProgress    func(ReplayProgress)
+	ProgressEvery int
+}
+
+// ReplayProgress reports how far a replay has got.
+type ReplayProgress struct {
+	Handled   int
+	Failed    int
+	LastSeq   uint64 // last stream sequence delivered
+	TargetSeq uint64 // sequence the replay stops at
+	Pending   uint64 // matching messages still ahead (server estimate)
+	Elapsed   time.Duration
+	Done      bool
+}
+
+// Replayer streams part of a stream through a typed handler using an
+// ephemeral ordered consumer. It never creates, deletes or moves a durable
+// consumer, so it is safe to run next to live listeners: rebuilding a
+// projection or reading events for an incident no longer means deleting the
+// service's consumer and replaying with DeliverAll.
+//
+// When the replayed subjects carry different payload types use
+// T = json.RawMessage and pass Router.OnMessage as the handler.
+type Replayer[T any] struct {
+	StreamName    StreamName
+	StreamManager *JsStreamManager
+	Handler       TypedHandler[T]
+	Options       ReplayOptions
+}
+
+// NewReplayer creates a replayer.
+func NewReplayer[T any](stream StreamName, streamManager *JsStreamManager, opts ReplayOptions, handler TypedHandler[T]) *Replayer[T] {
+	return &Replayer[T]{StreamName: stream, StreamManager: streamManager, Handler: handler, Options: opts}
+}
+
+// orderedConsumerConfig translates the options into the consumer config.
+func (o ReplayOptions) orderedConsumerConfig() jetstream.OrderedConsumerConfig {
+	cfg := jetstream.OrderedConsumerConfig{DeliverPolicy: jetstream.DeliverAllPolicy}
+	for _, s := range o.FilterSubjects {
+		cfg.FilterSubjects = append(cfg.FilterSubjects, string(s))
+	}
+	switch {
+	case o.FromSeq > 0:
+		cfg.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		cfg.OptStartSeq = o.FromSeq
+	case !o.FromTime.IsZero():
+		from := o.FromTime
+		cfg.DeliverPolicy = jetstream.DeliverByStartTimePolicy
+		cfg.OptStartTime = &from
+	}
+	return cfg
+}
+
+// Run replays until the end point, ctx cancellation or (unless
+// ContinueOnError) the first failure, and returns the final progress.
+func (r *Replayer[T]) Run(ctx context.Context) (ReplayProgress, error) {
+	start := time.Now()
+	opts := r.Options
+	every := opts.ProgressEvery
+	if every <= 0 {
+		every = 1000
+	}
+	progress := ReplayProgress{}
+	report := func(done bool) {
+		progress.Elapsed = time.Since(start)
+		progress.Done = done
+		if opts.OnProgress != nil {
+			opts.OnProgress(progress)
+		}
+		logger.Info("Replay progress",
+			logger.KeyStream, string(r.StreamName), "handled", progress.Handled, "failed", progress.Failed,
+			"lastSeq", progress.LastSeq, "targetSeq", progress.TargetSeq, "pending", progress.Pending, "done", done)
+	}
+
+	stream, err := r.StreamManager.JsClient.Stream(ctx, string(r.StreamName))
+	if err != nil {
+		return progress, fmt.Errorf("failed to get stream %s: %w", r.StreamName, err)
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return progress, fmt.Errorf("failed to get stream info %s: %w", r.StreamName, err)
+	}
+	progress.TargetSeq = info.State.LastSeq
+	if opts.UntilSeq > 0 && opts.UntilSeq < progress.TargetSeq {
+		progress.TargetSeq = opts.UntilSeq
+	}
+	if progress.TargetSeq == 0 || (opts.FromSeq > 0 && opts.FromSeq > progress.TargetSeq) {
+		report(true)
+		return progress, nil
+	}
+
+	cons, err := stream.OrderedConsumer(ctx, opts.orderedConsumerConfig())
+	if err != nil {
+		return progress, fmt.Errorf("failed to create replay consumer on %s: %w", r.StreamName, err)
+	}
+	iter, err := cons.Messages()
+	if err != nil {
+		return progress, fmt.Errorf("failed to start replay on %s: %w", r.StreamName, err)
+	}
+	defer iter.Stop()
+
+	// Nothing matches the filter: Next would block forever.
+	if ci, err := cons.Info(ctx); err == nil && ci.NumPending == 0 {
+		report(true)
+		return progress, nil
+	}
+
+	// Next has no ctx; unblock it on cancellation.
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			iter.Stop()
+		case <-stopped:
+		}
+	}()
+
+	logger.Info("Replay started", logger.KeyStream, string(r.StreamName),
+		"fromSeq", opts.FromSeq, "fromTime", opts.FromTime, "targetSeq", progress.TargetSeq, "filterSubjects", opts.FilterSubjects)
+
+	for {
+		// Next may still hand out buffered messages after cancellation.
+		if ctx.Err() != nil {
+			report(false)
+			return progress, ctx.Err()
+		}
+		msg, err := iter.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				report(false)
+				return progress, ctx.Err()
+			}
+			if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+				report(false)
+				return progress, fmt.Errorf("replay on %s stopped early: %w", r.StreamName, err)
+			}
+			report(false)
+			return progress, fmt.Errorf("replay on %s failed: %w", r.StreamName, err)
+		}
+
+		meta, err := msg.Metadata()
+		if err != nil {
+			report(false)
+			return progress, fmt.Errorf("replay on %s: bad message metadata: %w", r.StreamName, err)
+		}
+		if meta.Sequence.Stream > progress.TargetSeq ||
+			(!opts.UntilTime.IsZero() && !meta.Timestamp.Before(opts.UntilTime)) {
+			report(true)
+			return progress, nil
+		}
+		progress.LastSeq = meta.Sequence.Stream
+		progress.Pending = meta.NumPending
+
+		if err := r.handle(ctx, msg, meta); err != nil {
+			progress.Failed++
+			logger.Warn("Replay handler failed", err, logger.KeyStream, string(r.StreamName),
+				logger.KeySubject, msg.Subject(), logger.KeySequence, meta.Sequence.Stream)
+			if !opts.ContinueOnError {
+				report(false)
+				return progress, fmt.Errorf("replay on %s failed at seq %d: %w", r.StreamName, meta.Sequence.Stream, err)
+			}
+		} else {
+			progress.Handled++
+		}
+
+		if meta.Sequence.Stream >= progress.TargetSeq || meta.NumPending == 0 {
+			report(true)
+			return progress, nil
+		}
+		if (progress.Handled+progress.Failed)%every == 0 {
+			report(false)
+		}
+	}
+}
+
+// handle decodes one message the same way Listener does and calls Handler.
+func (r *Replayer[T]) handle(ctx context.Context, msg jetstream.Msg, meta *jetstream.MsgMetadata) error {
+	var raw Event[json.RawMessage]
+	if err := json.Unmarshal(msg.Data(), &raw); err != nil {
+		return fmt.Errorf("%w: %v", ErrPoisonMessage, err)
+	}
+	raw.Envelope.mergeHeader(msg.Headers())
+	if raw.OccurredAt.IsZero() {
+		raw.OccurredAt = meta.Timestamp
+	}
+	if r.Options.Upcasters != nil {
+		from := raw.SchemaVersion
+		if from == 0 {
+			from = DefaultSchemaVersion
+		}
+		data, to, err := r.Options.Upcasters.Upcast(raw.Subject, from, raw.Data)
+		if err != nil {
+			return err
+		}
+		raw.Data, raw.SchemaVersion = data, to
+	}
+	event, err := DecodeEvent[T](raw)
+	if err != nil {
+		return err
+	}
+	return r.Handler(ContextWithEnvelope(ctx, event.Envelope), event)
+}
@@ -0,0 +1,145 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/praction-networks/common/events"
+	"github.com/praction-networks/common/events/eventstest"
+)
+
+// newReplayStream stores plans p1..pN on PlanCreatedSubject at sequences
+// 1..N.
+func newReplayStream(t *testing.T, n int) *eventstest.JetStream {
+	t.Helper()
+	js := eventstest.New()
+	js.AddStreams(t, events.PlanStream)
+	for i := 1; i <= n; i++ {
+		eventstest.Inject(t, js, events.PlanCreatedSubject, outboxPayload{PlanID: fmt.Sprintf("p%d", i)}, events.Envelope{})
+	}
+	return js
+}
+
+// replay runs a Replayer over PlanStream and returns the plan IDs handled.
+func replay(t *testing.T, ctx context.Context, js *eventstest.JetStream, opts events.ReplayOptions,
+	fail func(planID string) error) ([]string, events.ReplayProgress, error) {
+	t.Helper()
+	var handled []string
+	r := events.NewReplayer(events.PlanStream, js.StreamManager(), opts,
+		func(ctx context.Context, e events.Event[outboxPayload]) error {
+			if fail != nil {
+				if err := fail(e.Data.PlanID); err != nil {
+					return err
+				}
+			}
+			handled = append(handled, e.Data.PlanID)
+			return nil
+		})
+	progress, err := r.Run(ctx)
+
+	// The replay never leaves a consumer behind.
+	info, ierr := js.Stream(ctx, string(events.PlanStream))
+	if ierr != nil {
+		t.Fatal(ierr)
+	}
+	if n := info.CachedInfo().State.Consumers; n != 0 {
+		t.Fatalf("%d consumers left after the replay", n)
+	}
+	return handled, progress, err
+}
+
+func TestReplayerRunStopsAtEndPoint(t *testing.T) {
+	initLogger(t)
+	ctx := context.Background()
+	js := newReplayStream(t, 5)
+
+	got, progress, err := replay(t, ctx, js, events.ReplayOptions{FromSeq: 2, UntilSeq: 4}, nil)
+	if err != nil || fmt.Sprint(got) != "[p2 p3 p4]" {
+		t.Fatalf("FromSeq 2 UntilSeq 4: %v, %v", got, err)
+	}
+	if !progress.Done || progress.Handled != 3 || progress.LastSeq != 4 || progress.TargetSeq != 4 {
+		t.Fatalf("progress = %+v", progress)
+	}
+
+	// UntilTime is exclusive: p6 and p7 are stored after it.
+	until := time.Now()
+	time.Sleep(time.Millisecond)
+	eventstest.Inject(t, js, events.PlanCreatedSubject, outboxPayload{PlanID: "p6"}, events.Envelope{})
+	eventstest.Inject(t, js, events.PlanCreatedSubject, outboxPayload{PlanID: "p7"}, events.Envelope{})
+	got, progress, err = replay(t, ctx, js, events.ReplayOptions{FromSeq: 4, UntilTime: until}, nil)
+	if err != nil || fmt.Sprint(got) != "[p4 p5]" || !progress.Done || progress.LastSeq != 5 {
+		t.Fatalf("UntilTime: %v, %+v, %v", got, progress, err)
+	}
+
+	// Without an end point the replay stops at the last sequence when it
+	// started; events published meanwhile are left to the live listeners.
+	pub := events.NewPublisher[outboxPayload](events.PlanStream, events.PlanCreatedSubject, js.StreamManager(), true, nil)
+	var reports []events.ReplayProgress
+	opts := events.ReplayOptions{
+		FromSeq:       6,
+		ProgressEvery: 1,
+		OnProgress:    func(p events.ReplayProgress) { reports = append(reports, p) },
+	}
+	got, progress, err = replay(t, ctx, js, opts, func(planID string) error {
+		_, err := pub.PublishPreferred(ctx, outboxPayload{PlanID: planID + "-again"}, "")
+		return err
+	})
+	if err != nil || fmt.Sprint(got) != "[p6 p7]" || progress.TargetSeq != 7 {
+		t.Fatalf("live tail: %v, %+v, %v", got, progress, err)
+	}
+	if len(reports) != 2 || reports[0].Done || !reports[1].Done || reports[1].Handled != 2 {
+		t.Fatalf("progress reports = %+v", reports)
+	}
+}
+
+func TestReplayerRunFailures(t *testing.T) {
+	initLogger(t)
+	ctx := context.Background()
+	js := newReplayStream(t, 4)
+	poisonSeq := js.InjectRaw(t, events.PlanCreatedSubject, []byte("{not json"), nil)
+	eventstest.Inject(t, js, events.PlanCreatedSubject, outboxPayload{PlanID: "p6"}, events.Envelope{})
+	errDown := errors.New("db down")
+	failP2 := func(planID string) error {
+		if planID == "p2" {
+			return errDown
+		}
+		return nil
+	}
+
+	// By default the first failure stops the replay.
+	got, progress, err := replay(t, ctx, js, events.ReplayOptions{}, failP2)
+	if !errors.Is(err, errDown) || fmt.Sprint(got) != "[p1]" || progress.Failed != 1 || progress.LastSeq != 2 || progress.Done {
+		t.Fatalf("stop on error: %v, %+v, %v", got, progress, err)
+	}
+
+	// ContinueOnError counts handler and decode failures and moves on.
+	got, progress, err = replay(t, ctx, js, events.ReplayOptions{ContinueOnError: true}, failP2)
+	if err != nil || fmt.Sprint(got) != "[p1 p3 p4 p6]" || progress.Failed != 2 || progress.Handled != 4 || !progress.Done {
+		t.Fatalf("continue on error: %v, %+v, %v", got, progress, err)
+	}
+
+	_, _, err = replay(t, ctx, js, events.ReplayOptions{FromSeq: poisonSeq}, nil)
+	if !errors.Is(err, events.ErrPoisonMessage) {
+		t.Fatalf("poison message: %v", err)
+	}
+}
+
+func TestReplayerRunCancellation(t *testing.T) {
+	initLogger(t)
+	js := newReplayStream(t, 5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got, progress, err := replay(t, ctx, js, events.ReplayOptions{}, func(planID string) error {
+		if planID == "p2" {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || fmt.Sprint(got) != "[p1 p2]" || progress.LastSeq != 2 || progress.Done {
+		t.Fatalf("cancelled replay: %v, %+v, %v", got, progress, err)
+	}
+}
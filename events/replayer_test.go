@@ -0,0 +1,30 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestReplayOptionsOrderedConsumerConfig(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	cfg := ReplayOptions{}.orderedConsumerConfig()
+	if cfg.DeliverPolicy != jetstream.DeliverAllPolicy || cfg.OptStartSeq != 0 || cfg.OptStartTime != nil {
+		t.Fatalf("default config = %+v, want deliver all", cfg)
+	}
+
+	cfg = ReplayOptions{FromSeq: 42, FromTime: from, FilterSubjects: []Subject{"plan.created", "plan.>"}}.orderedConsumerConfig()
+	if cfg.DeliverPolicy != jetstream.DeliverByStartSequencePolicy || cfg.OptStartSeq != 42 || cfg.OptStartTime != nil {
+		t.Fatalf("FromSeq config = %+v, want start at sequence 42", cfg)
+	}
+	if len(cfg.FilterSubjects) != 2 || cfg.FilterSubjects[0] != "plan.created" || cfg.FilterSubjects[1] != "plan.>" {
+		t.Fatalf("FilterSubjects = %v", cfg.FilterSubjects)
+	}
+
+	cfg = ReplayOptions{FromTime: from}.orderedConsumerConfig()
+	if cfg.DeliverPolicy != jetstream.DeliverByStartTimePolicy || cfg.OptStartTime == nil || !cfg.OptStartTime.Equal(from) {
+		t.Fatalf("FromTime config = %+v, want start at %v", cfg, from)
+	}
+}
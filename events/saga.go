@@ -0,0 +1,518 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/praction-networks/common/lease"
+	"github.com/praction-networks/common/logger"
+	"github.com/praction-networks/common/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopt "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SagaStatus is the lifecycle state of a saga instance.
+type SagaStatus string
+
+const (
+	SagaRunning      SagaStatus = "running"      // waiting for the current step's reply
+	SagaCompleted    SagaStatus = "completed"    // every step succeeded
+	SagaCompensating SagaStatus = "compensating" // undoing completed steps
+	SagaCompensated  SagaStatus = "compensated"  // failed and fully undone
+	SagaFailed       SagaStatus = "failed"       // a compensation gave up; needs an operator
+)
+
+// Saga defaults.
+const (
+	DefaultSagaStepTimeout     = 5 * time.Minute
+	DefaultSagaCompensateRetry = 30 * time.Second
+	DefaultSagaCompensateTries = 10
+)
+
+var (
+	// ErrSagaConflict is returned when a saga instance was changed
+	// concurrently; the caller (usually a Listener, by NAKing) retries.
+	ErrSagaConflict = errors.New("events: saga instance modified concurrently")
+
+	// ErrSagaNotFound is returned by Get for an unknown saga ID.
+	ErrSagaNotFound = errors.New("events: saga instance not found")
+)
+
+// SagaStep is one step of a saga: a command, the replies that settle it and
+// how to undo it.
+type SagaStep[D any] struct {
+	Name string
+
+	// Command publishes the step's command. ctx carries the saga ID as the
+	// correlation ID, so replies published by the handling service (with its
+	// handler ctx) correlate back to this saga.
+	Command func(ctx context.Context, sagaID string, data D) error
+
+	// SuccessSubject completes the step; any FailureSubjects fail it. Both
+	// may use wildcards. A step without SuccessSubject completes as soon as
+	// its command is sent.
+	SuccessSubject  Subject
+	FailureSubjects []Subject
+
+	// OnReply, when set, folds the success reply into the saga data before
+	// the next step runs. An error fails the step.
+	OnReply func(ctx context.Context, data *D, reply Event[json.RawMessage]) error
+
+	// Timeout bounds the wait for a reply; default DefaultSagaStepTimeout.
+	// A timed-out step is compensated too, since its outcome is unknown, so
+	// compensations must be idempotent.
+	Timeout time.Duration
+
+	// Compensate publishes the events that undo the step.
+	Compensate func(ctx context.Context, sagaID string, data D) error
+}
+
+// SagaHistoryEntry records one thing that happened to a saga instance.
+type SagaHistoryEntry struct {
+	Step    string    `bson:"step" json:"step"`
+	Event   string    `bson:"event" json:"event"` // command, succeeded, failed, timeout, compensated, compensation_failed
+	Subject string    `bson:"subject,omitempty" json:"subject,omitempty"`
+	EventID string    `bson:"eventId,omitempty" json:"eventId,omitempty"`
+	Error   string    `bson:"error,omitempty" json:"error,omitempty"`
+	At      time.Time `bson:"at" json:"at"`
+}
+
+// SagaInstance is the persisted state of one run of a saga.
+type SagaInstance[D any] struct {
+	ID     string     `bson:"_id" json:"id"`
+	Saga   string     `bson:"saga" json:"saga"`
+	Status SagaStatus `bson:"status" json:"status"`
+	// Step is the step awaiting its reply while running, and the next step
+	// to compensate while compensating.
+	Step int `bson:"step" json:"step"`
+	Data D   `bson:"data" json:"data"`
+
+	// DeadlineAt is when the sweep acts next: the reply timeout while
+	// running, the compensation retry while compensating.
+	DeadlineAt          *time.Time         `bson:"deadlineAt,omitempty" json:"deadlineAt,omitempty"`
+	CompensateAttempts  int                `bson:"compensateAttempts,omitempty" json:"compensateAttempts,omitempty"`
+	FailureReason       string             `bson:"failureReason,omitempty" json:"failureReason,omitempty"`
+	ParentCorrelationID string             `bson:"parentCorrelationId,omitempty" json:"parentCorrelationId,omitempty"`
+	History             []SagaHistoryEntry `bson:"history" json:"history"`
+
+	Version   int64     `bson:"version" json:"version"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Saga coordinates a multi-service workflow as a sequence of steps whose
+// state lives in Collection. Start it from the triggering handler, feed it
+// replies with HandleReply (or Route), and keep Run going so timeouts fire:
+//
+//	saga := events.NewSaga[CpeSagaData]("cpe-assignment", coll, leaser,
+//		events.SagaStep[CpeSagaData]{
+//			Name:            "assign-asset",
+//			Command:         publishAssetAssign,
+//			SuccessSubject:  events.InventoryAssetAssignedSubject,
+//			FailureSubjects: []events.Subject{events.InventoryAssetAssignmentFailedSubject},
+//			Compensate:      publishAssetRelease,
+//		},
+//	)
+//
+// Replies are matched by correlation ID, which is the saga ID. Started from
+// an event handler, the saga ID is the triggering event's envelope ID, so a
+// redelivered trigger doesn't start the saga twice.
+type Saga[D any] struct {
+	Name       string
+	Steps      []SagaStep[D]
+	Collection *mongo.Collection
+	Leaser     lease.Leaser
+
+	LeaseKey           string        // default "saga:<name>"
+	HolderID           string        // default hostname
+	LeaseTTL           time.Duration // default 30s
+	SweepInterval      time.Duration // default 5s
+	CompensateRetry    time.Duration // default DefaultSagaCompensateRetry
+	CompensateAttempts int           // default DefaultSagaCompensateTries
+}
+
+// NewSaga creates a saga with default lease and sweep settings.
+func NewSaga[D any](name string, coll *mongo.Collection, leaser lease.Leaser, steps ...SagaStep[D]) *Saga[D] {
+	holder, _ := os.Hostname()
+	return &Saga[D]{
+		Name:               name,
+		Steps:              steps,
+		Collection:         coll,
+		Leaser:             leaser,
+		LeaseKey:           "saga:" + name,
+		HolderID:           holder,
+		LeaseTTL:           30 * time.Second,
+		SweepInterval:      5 * time.Second,
+		CompensateRetry:    DefaultSagaCompensateRetry,
+		CompensateAttempts: DefaultSagaCompensateTries,
+	}
+}
+
+// EnsureIndexes creates the sweep index. Call once at boot.
+func (s *Saga[D]) EnsureIndexes(ctx context.Context) error {
+	_, err := s.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "saga", Value: 1}, {Key: "status", Value: 1}, {Key: "deadlineAt", Value: 1}},
+		Options: mopt.Index().SetName("saga_status_deadline_idx"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create saga indexes: %w", err)
+	}
+	return nil
+}
+
+// ReplySubjects returns every reply subject of the saga, for a Listener's
+// FilterSubjects.
+func (s *Saga[D]) ReplySubjects() []Subject {
+	var out []Subject
+	seen := map[Subject]bool{}
+	add := func(subj Subject) {
+		if subj != "" && !seen[subj] {
+			seen[subj] = true
+			out = append(out, subj)
+		}
+	}
+	for _, step := range s.Steps {
+		add(step.SuccessSubject)
+		for _, f := range step.FailureSubjects {
+			add(f)
+		}
+	}
+	return out
+}
+
+// Route registers HandleReply on r for every reply subject.
+func (s *Saga[D]) Route(r *Router) {
+	for _, subj := range s.ReplySubjects() {
+		Handle(r, subj, s.HandleReply)
+	}
+}
+
+// Start creates a saga instance and sends the first step's command. The
+// saga ID is the envelope ID of the event being handled in ctx, or a new
+// UUID outside a handler. Starting an ID that already exists is a no-op.
+func (s *Saga[D]) Start(ctx context.Context, data D) (string, error) {
+	id := uuid.New().String()
+	if env, ok := EnvelopeFromContext(ctx); ok && env.ID != "" {
+		id = env.ID
+	}
+	return id, s.StartWithID(ctx, id, data)
+}
+
+// StartWithID is Start with an explicit saga ID.
+func (s *Saga[D]) StartWithID(ctx context.Context, id string, data D) error {
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("saga %s has no steps", s.Name)
+	}
+	now := time.Now().UTC()
+	inst := &SagaInstance[D]{
+		ID:                  id,
+		Saga:                s.Name,
+		Status:              SagaRunning,
+		Data:                data,
+		ParentCorrelationID: GetCorrelationID(ctx),
+		History:             []SagaHistoryEntry{},
+		CreatedAt:           now,
+		UpdatedAt:           now,
+	}
+	if _, err := s.Collection.InsertOne(ctx, inst); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			logger.Info("Saga already started", "saga", s.Name, "sagaId", id)
+			return nil
+		}
+		return fmt.Errorf("failed to start saga %s: %w", s.Name, err)
+	}
+	metrics.RecordSagaTransition(s.Name, string(SagaRunning))
+	logger.Info("Saga started", "saga", s.Name, "sagaId", id)
+	return s.runStep(ctx, inst)
+}
+
+// Get loads a saga instance.
+func (s *Saga[D]) Get(ctx context.Context, id string) (*SagaInstance[D], error) {
+	var inst SagaInstance[D]
+	err := s.Collection.FindOne(ctx, bson.M{"_id": id, "saga": s.Name}).Decode(&inst)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrSagaNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load saga %s: %w", id, err)
+	}
+	return &inst, nil
+}
+
+// HandleReply advances the saga the event correlates to. Events that belong
+// to no running instance of this saga, or that the current step doesn't
+// expect, are ignored.
+func (s *Saga[D]) HandleReply(ctx context.Context, event Event[json.RawMessage]) error {
+	if event.CorrelationID == "" {
+		return nil
+	}
+	inst, err := s.Get(ctx, event.CorrelationID)
+	if errors.Is(err, ErrSagaNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if inst.Status != SagaRunning {
+		logger.Debug("Ignoring reply for settled saga", "saga", s.Name, "sagaId", inst.ID,
+			"status", string(inst.Status), logger.KeySubject, string(event.Subject))
+		return nil
+	}
+	if inst.Step < 0 || inst.Step >= len(s.Steps) {
+		return s.failUnknownStep(ctx, inst)
+	}
+
+	step := s.Steps[inst.Step]
+	subject := string(event.Subject)
+	entry := SagaHistoryEntry{Step: step.Name, Subject: subject, EventID: event.ID, At: time.Now().UTC()}
+	for _, f := range step.FailureSubjects {
+		if subjectMatches(string(f), subject) {
+			entry.Event = "failed"
+			inst.History = append(inst.History, entry)
+			return s.compensate(ctx, inst, inst.Step-1, fmt.Sprintf("step %s failed: %s", step.Name, subject))
+		}
+	}
+	if step.SuccessSubject == "" || !subjectMatches(string(step.SuccessSubject), subject) {
+		return nil
+	}
+	if step.OnReply != nil {
+		if err := step.OnReply(ctx, &inst.Data, event); err != nil {
+			entry.Event, entry.Error = "failed", err.Error()
+			inst.History = append(inst.History, entry)
+			return s.compensate(ctx, inst, inst.Step-1, fmt.Sprintf("step %s reply rejected: %v", step.Name, err))
+		}
+	}
+	entry.Event = "succeeded"
+	inst.History = append(inst.History, entry)
+	inst.Step++
+	return s.runStep(ctx, inst)
+}
+
+// runStep sends the command of inst.Step, completing the saga past the last
+// step. State is saved before the command so a fast reply finds it.
+func (s *Saga[D]) runStep(ctx context.Context, inst *SagaInstance[D]) error {
+	for inst.Step < len(s.Steps) {
+		step := s.Steps[inst.Step]
+		now := time.Now().UTC()
+		inst.History = append(inst.History, SagaHistoryEntry{Step: step.Name, Event: "command", At: now})
+		inst.DeadlineAt = nil
+		if step.SuccessSubject != "" {
+			timeout := step.Timeout
+			if timeout <= 0 {
+				timeout = DefaultSagaStepTimeout
+			}
+			deadline := now.Add(timeout)
+			inst.DeadlineAt = &deadline
+		}
+		if err := s.save(ctx, inst); err != nil {
+			return err
+		}
+
+		if step.Command != nil {
+			if err := step.Command(WithCorrelationID(ctx, inst.ID), inst.ID, inst.Data); err != nil {
+				inst.History = append(inst.History, SagaHistoryEntry{Step: step.Name, Event: "failed", Error: err.Error(), At: time.Now().UTC()})
+				return s.compensate(ctx, inst, inst.Step-1, fmt.Sprintf("step %s command failed: %v", step.Name, err))
+			}
+		}
+		if step.SuccessSubject != "" {
+			return nil // wait for the reply
+		}
+		inst.Step++
+	}
+
+	inst.Status = SagaCompleted
+	inst.DeadlineAt = nil
+	if err := s.save(ctx, inst); err != nil {
+		return err
+	}
+	metrics.RecordSagaTransition(s.Name, string(SagaCompleted))
+	logger.Info("Saga completed", "saga", s.Name, "sagaId", inst.ID)
+	return nil
+}
+
+// compensate undoes steps from down to 0 in reverse order. A failing
+// compensation is retried by the sweep after CompensateRetry, up to
+// CompensateAttempts times, before the saga is marked failed.
+func (s *Saga[D]) compensate(ctx context.Context, inst *SagaInstance[D], from int, reason string) error {
+	if inst.Status != SagaCompensating {
+		inst.Status = SagaCompensating
+		inst.Step = from
+		inst.FailureReason = reason
+		inst.CompensateAttempts = 0
+		metrics.RecordSagaTransition(s.Name, string(SagaCompensating))
+		logger.Warn("Saga compensating", "saga", s.Name, "sagaId", inst.ID, "reason", reason)
+	}
+
+	for inst.Step >= 0 {
+		step := s.Steps[inst.Step]
+		if step.Compensate != nil {
+			if err := step.Compensate(WithCorrelationID(ctx, inst.ID), inst.ID, inst.Data); err != nil {
+				return s.compensationFailed(ctx, inst, step, err)
+			}
+			inst.History = append(inst.History, SagaHistoryEntry{Step: step.Name, Event: "compensated", At: time.Now().UTC()})
+		}
+		inst.Step--
+		inst.CompensateAttempts = 0
+	}
+
+	inst.Status = SagaCompensated
+	inst.Step = 0
+	inst.DeadlineAt = nil
+	if err := s.save(ctx, inst); err != nil {
+		return err
+	}
+	metrics.RecordSagaTransition(s.Name, string(SagaCompensated))
+	logger.Info("Saga compensated", "saga", s.Name, "sagaId", inst.ID, "reason", inst.FailureReason)
+	return nil
+}
+
+func (s *Saga[D]) compensationFailed(ctx context.Context, inst *SagaInstance[D], step SagaStep[D], cause error) error {
+	inst.CompensateAttempts++
+	inst.History = append(inst.History, SagaHistoryEntry{Step: step.Name, Event: "compensation_failed", Error: cause.Error(), At: time.Now().UTC()})
+
+	maxAttempts := s.CompensateAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultSagaCompensateTries
+	}
+	if inst.CompensateAttempts >= maxAttempts {
+		inst.Status = SagaFailed
+		inst.DeadlineAt = nil
+		if err := s.save(ctx, inst); err != nil {
+			return err
+		}
+		metrics.RecordSagaTransition(s.Name, string(SagaFailed))
+		logger.Error("Saga compensation gave up", cause, "saga", s.Name, "sagaId", inst.ID,
+			"step", step.Name, "attempts", inst.CompensateAttempts)
+		return nil
+	}
+
+	retry := s.CompensateRetry
+	if retry <= 0 {
+		retry = DefaultSagaCompensateRetry
+	}
+	next := time.Now().UTC().Add(retry)
+	inst.DeadlineAt = &next
+	if err := s.save(ctx, inst); err != nil {
+		return err
+	}
+	logger.Warn("Saga compensation failed, will retry", cause, "saga", s.Name, "sagaId", inst.ID,
+		"step", step.Name, "attempts", inst.CompensateAttempts)
+	return nil
+}
+
+// save writes inst if nobody changed it since it was loaded.
+func (s *Saga[D]) save(ctx context.Context, inst *SagaInstance[D]) error {
+	prev := inst.Version
+	inst.Version++
+	inst.UpdatedAt = time.Now().UTC()
+	res, err := s.Collection.ReplaceOne(ctx, bson.M{"_id": inst.ID, "version": prev}, inst)
+	if err != nil {
+		inst.Version = prev
+		return fmt.Errorf("failed to save saga %s: %w", inst.ID, err)
+	}
+	if res.MatchedCount == 0 {
+		inst.Version = prev
+		return fmt.Errorf("%w: %s", ErrSagaConflict, inst.ID)
+	}
+	return nil
+}
+
+// Run blocks until ctx is cancelled. While this replica holds the lease it
+// times out steps whose reply is overdue and retries failed compensations.
+func (s *Saga[D]) Run(ctx context.Context) error {
+	name := "saga:" + s.Name
+	logger.GoroutineStarted(name)
+	defer logger.GoroutineStopped(name, nil)
+
+	for {
+		_, err := lease.RunAsLeader(ctx, s.Leaser, s.LeaseKey, s.HolderID, s.LeaseTTL, 0, s.sweepLoop)
+		if err != nil && ctx.Err() == nil {
+			logger.Warn("Saga sweep leader run ended with error", err, "saga", s.Name)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(s.SweepInterval):
+		}
+	}
+}
+
+func (s *Saga[D]) sweepLoop(ctx context.Context) error {
+	ticker := time.NewTicker(s.SweepInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.SweepOnce(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("Saga sweep failed", err, "saga", s.Name)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// SweepOnce handles every instance whose deadline has passed and returns
+// how many it handled.
+func (s *Saga[D]) SweepOnce(ctx context.Context) (int, error) {
+	cur, err := s.Collection.Find(ctx, bson.M{
+		"saga":       s.Name,
+		"status":     bson.M{"$in": bson.A{SagaRunning, SagaCompensating}},
+		"deadlineAt": bson.M{"$lte": time.Now().UTC()},
+	}, mopt.Find().SetSort(bson.D{{Key: "deadlineAt", Value: 1}}).SetLimit(100))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read overdue sagas: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	handled := 0
+	for cur.Next(ctx) {
+		var inst SagaInstance[D]
+		if err := cur.Decode(&inst); err != nil {
+			logger.Error("saga decode failed", err, "saga", s.Name)
+			continue
+		}
+		var herr error
+		switch {
+		case inst.Step < 0 || inst.Step >= len(s.Steps):
+			herr = s.failUnknownStep(ctx, &inst)
+		case inst.Status == SagaRunning:
+			step := s.Steps[inst.Step]
+			inst.History = append(inst.History, SagaHistoryEntry{Step: step.Name, Event: "timeout", At: time.Now().UTC()})
+			herr = s.compensate(ctx, &inst, inst.Step, fmt.Sprintf("step %s timed out", step.Name))
+		default:
+			herr = s.compensate(ctx, &inst, inst.Step, inst.FailureReason)
+		}
+		if herr != nil {
+			if !errors.Is(herr, ErrSagaConflict) {
+				logger.Warn("Saga sweep could not advance instance", herr, "saga", s.Name, "sagaId", inst.ID)
+			}
+			continue
+		}
+		handled++
+	}
+	return handled, cur.Err()
+}
+
+// failUnknownStep marks inst failed when its step is not one of s.Steps,
+// e.g. after a deploy removed steps while it was in flight. Nothing can be
+// run or compensated for it, so it is left to an operator.
+func (s *Saga[D]) failUnknownStep(ctx context.Context, inst *SagaInstance[D]) error {
+	reason := fmt.Sprintf("step %d out of range, saga has %d steps", inst.Step, len(s.Steps))
+	inst.History = append(inst.History, SagaHistoryEntry{Event: "failed", Error: reason, At: time.Now().UTC()})
+	inst.Status = SagaFailed
+	inst.FailureReason = reason
+	inst.DeadlineAt = nil
+	if err := s.save(ctx, inst); err != nil {
+		return err
+	}
+	metrics.RecordSagaTransition(s.Name, string(SagaFailed))
+	logger.Error("Saga step out of range", errors.New(reason), "saga", s.Name, "sagaId", inst.ID)
+	return nil
+}
@@ -0,0 +1,348 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/praction-networks/common/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestSagaReplySubjects(t *testing.T) {
+	type data struct{ AssetID string }
+	s := NewSaga[data]("cpe-assignment", nil, nil,
+		SagaStep[data]{
+			Name:            "assign-asset",
+			SuccessSubject:  InventoryAssetAssignedSubject,
+			FailureSubjects: []Subject{InventoryAssetAssignmentFailedSubject},
+		},
+		SagaStep[data]{Name: "notify"}, // fire-and-forget
+		SagaStep[data]{
+			Name:            "activate",
+			SuccessSubject:  "cpe.activated",
+			FailureSubjects: []Subject{InventoryAssetAssignmentFailedSubject, "cpe.activation.*"},
+		},
+	)
+
+	want := []Subject{InventoryAssetAssignedSubject, InventoryAssetAssignmentFailedSubject, "cpe.activated", "cpe.activation.*"}
+	if got := s.ReplySubjects(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("ReplySubjects = %v, want %v", got, want)
+	}
+
+	r := NewRouter()
+	s.Route(r)
+	for _, subj := range []string{"inventory.asset.assigned", "cpe.activation.failed"} {
+		if r.lookup(Subject(subj)) == nil {
+			t.Errorf("no route for %s", subj)
+		}
+	}
+}
+
+// initTestLogger initializes the logger the saga logs through. It runs after
+// TestLogHandlerOutcomeVerboseToggle, which captures the first
+// initialization.
+func initTestLogger(t *testing.T) {
+	t.Helper()
+	if err := logger.InitializeLogger(logger.LoggerConfig{LogLevel: "error"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type sagaTestData struct {
+	Order string `bson:"order"`
+}
+
+// sagaRecorder records the commands and compensations a test saga sends.
+type sagaRecorder struct {
+	calls   []string
+	failing map[string]bool
+}
+
+func (r *sagaRecorder) send(name string) func(context.Context, string, sagaTestData) error {
+	return func(ctx context.Context, sagaID string, _ sagaTestData) error {
+		if GetCorrelationID(ctx) != sagaID {
+			return fmt.Errorf("%s: correlation %q, saga %q", name, GetCorrelationID(ctx), sagaID)
+		}
+		r.calls = append(r.calls, name)
+		if r.failing[name] {
+			return errors.New(name + " refused")
+		}
+		return nil
+	}
+}
+
+func newTestSaga(coll *mongo.Collection, r *sagaRecorder) *Saga[sagaTestData] {
+	step := func(name string) SagaStep[sagaTestData] {
+		return SagaStep[sagaTestData]{
+			Name:            name,
+			Command:         r.send(name),
+			SuccessSubject:  Subject(name + ".ok"),
+			FailureSubjects: []Subject{Subject(name + ".failed")},
+			Compensate:      r.send("undo-" + name),
+		}
+	}
+	return NewSaga[sagaTestData]("order", coll, nil, step("reserve"), step("charge"), step("ship"))
+}
+
+// mockSagaStore answers the saga's reads with the document it last wrote,
+// so a mock collection behaves like a single-document store.
+type mockSagaStore struct {
+	mt  *mtest.T
+	doc bson.D
+}
+
+// written records the document of the last insert or replace.
+func (m *mockSagaStore) written() SagaInstance[sagaTestData] {
+	m.mt.Helper()
+	for _, ev := range m.mt.GetAllStartedEvents() {
+		var raw bson.Raw
+		switch ev.CommandName {
+		case "insert":
+			raw = ev.Command.Lookup("documents", "0").Document()
+		case "update":
+			raw = ev.Command.Lookup("updates", "0", "u").Document()
+		default:
+			continue
+		}
+		m.doc = nil
+		if err := bson.Unmarshal(raw, &m.doc); err != nil {
+			m.mt.Fatal(err)
+		}
+	}
+	m.mt.ClearEvents()
+	var inst SagaInstance[sagaTestData]
+	b, _ := bson.Marshal(m.doc)
+	if err := bson.Unmarshal(b, &inst); err != nil {
+		m.mt.Fatal(err)
+	}
+	return inst
+}
+
+// reply delivers subject for saga id, answering its load with the stored
+// document and its saves with saved (replace success when matched).
+func (m *mockSagaStore) reply(s *Saga[sagaTestData], id string, subject Subject, saves ...bool) error {
+	m.mt.AddMockResponses(mtest.CreateCursorResponse(0, "db."+m.mt.Coll.Name(), mtest.FirstBatch, m.doc))
+	m.expectSaves(saves...)
+	event := Event[json.RawMessage]{Subject: subject, Envelope: Envelope{ID: "reply-" + string(subject), CorrelationID: id}}
+	return s.HandleReply(context.Background(), event)
+}
+
+func (m *mockSagaStore) expectSaves(matched ...bool) {
+	for _, ok := range matched {
+		n := 0
+		if ok {
+			n = 1
+		}
+		m.mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: n}))
+	}
+}
+
+func sagaEvents(inst SagaInstance[sagaTestData]) string {
+	var out []string
+	for _, h := range inst.History {
+		out = append(out, h.Step+":"+h.Event)
+	}
+	return strings.Join(out, " ")
+}
+
+func TestSagaAdvancesAndCompensates(t *testing.T) {
+	initTestLogger(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("complete", func(mt *mtest.T) {
+		r := &sagaRecorder{}
+		s := newTestSaga(mt.Coll, r)
+		store := &mockSagaStore{mt: mt}
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		store.expectSaves(true)
+		if err := s.StartWithID(context.Background(), "o1", sagaTestData{Order: "o1"}); err != nil {
+			t.Fatal(err)
+		}
+		if inst := store.written(); inst.Status != SagaRunning || inst.Step != 0 || inst.DeadlineAt == nil {
+			t.Fatalf("after start: %+v", inst)
+		}
+
+		// A reply the current step doesn't expect is ignored.
+		if err := store.reply(s, "o1", "charge.ok"); err != nil {
+			t.Fatal(err)
+		}
+		var inst SagaInstance[sagaTestData]
+		for _, subject := range []Subject{"reserve.ok", "charge.ok", "ship.ok"} {
+			if err := store.reply(s, "o1", subject, true); err != nil {
+				t.Fatal(err)
+			}
+			inst = store.written()
+		}
+		if inst.Status != SagaCompleted || inst.DeadlineAt != nil || inst.Version != 4 {
+			t.Fatalf("after last reply: %+v", inst)
+		}
+		if got := strings.Join(r.calls, " "); got != "reserve charge ship" {
+			t.Fatalf("calls = %s", got)
+		}
+		want := "reserve:command reserve:succeeded charge:command charge:succeeded ship:command ship:succeeded"
+		if got := sagaEvents(inst); got != want {
+			t.Fatalf("history = %s", got)
+		}
+	})
+
+	mt.Run("failure reply", func(mt *mtest.T) {
+		r := &sagaRecorder{}
+		s := newTestSaga(mt.Coll, r)
+		store := &mockSagaStore{mt: mt}
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		store.expectSaves(true)
+		if err := s.StartWithID(context.Background(), "o1", sagaTestData{}); err != nil {
+			t.Fatal(err)
+		}
+		store.written()
+		for _, subject := range []Subject{"reserve.ok", "charge.ok"} {
+			if err := store.reply(s, "o1", subject, true); err != nil {
+				t.Fatal(err)
+			}
+			store.written()
+		}
+
+		// ship failed: the completed steps are undone, latest first.
+		if err := store.reply(s, "o1", "ship.failed", true); err != nil {
+			t.Fatal(err)
+		}
+		inst := store.written()
+		if inst.Status != SagaCompensated || inst.FailureReason != "step ship failed: ship.failed" {
+			t.Fatalf("after failure: %+v", inst)
+		}
+		if got := strings.Join(r.calls, " "); got != "reserve charge ship undo-charge undo-reserve" {
+			t.Fatalf("calls = %s", got)
+		}
+	})
+
+	mt.Run("command failure", func(mt *mtest.T) {
+		r := &sagaRecorder{failing: map[string]bool{"charge": true}}
+		s := newTestSaga(mt.Coll, r)
+		store := &mockSagaStore{mt: mt}
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		store.expectSaves(true)
+		if err := s.StartWithID(context.Background(), "o1", sagaTestData{}); err != nil {
+			t.Fatal(err)
+		}
+		store.written()
+		// Saved before the command, then saved compensated.
+		if err := store.reply(s, "o1", "reserve.ok", true, true); err != nil {
+			t.Fatal(err)
+		}
+		inst := store.written()
+		if inst.Status != SagaCompensated || inst.FailureReason != "step charge command failed: charge refused" {
+			t.Fatalf("after command failure: %+v", inst)
+		}
+		if got := strings.Join(r.calls, " "); got != "reserve charge undo-reserve" {
+			t.Fatalf("calls = %s", got)
+		}
+		want := "reserve:command reserve:succeeded charge:command charge:failed reserve:compensated"
+		if got := sagaEvents(inst); got != want {
+			t.Fatalf("history = %s", got)
+		}
+	})
+}
+
+func TestSagaVersionConflict(t *testing.T) {
+	initTestLogger(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("conflict", func(mt *mtest.T) {
+		r := &sagaRecorder{}
+		s := newTestSaga(mt.Coll, r)
+		store := &mockSagaStore{mt: mt}
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		store.expectSaves(true)
+		if err := s.StartWithID(context.Background(), "o1", sagaTestData{}); err != nil {
+			t.Fatal(err)
+		}
+		store.written()
+
+		// Another replica saved first: the reply is refused and its command
+		// not sent, so the listener's redelivery retries it.
+		if err := store.reply(s, "o1", "reserve.ok", false); !errors.Is(err, ErrSagaConflict) {
+			t.Fatalf("err = %v, want ErrSagaConflict", err)
+		}
+		filter := mt.GetAllStartedEvents()[1].Command.Lookup("updates", "0", "q")
+		if v, ok := filter.Document().Lookup("version").AsInt64OK(); !ok || v != 1 {
+			t.Fatalf("replace filter = %v", filter)
+		}
+		mt.ClearEvents()
+		if err := store.reply(s, "o1", "reserve.ok", true); err != nil {
+			t.Fatal(err)
+		}
+		if inst := store.written(); inst.Step != 1 || inst.Version != 2 {
+			t.Fatalf("after retry: %+v", inst)
+		}
+		if got := strings.Join(r.calls, " "); got != "reserve charge" {
+			t.Fatalf("calls = %s", got)
+		}
+	})
+}
+
+func sagaDoc(t *testing.T, inst SagaInstance[sagaTestData]) bson.D {
+	t.Helper()
+	b, err := bson.Marshal(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestSagaSweepTimesOutAndFailsUnknownSteps(t *testing.T) {
+	initTestLogger(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("sweep", func(mt *mtest.T) {
+		r := &sagaRecorder{}
+		s := newTestSaga(mt.Coll, r)
+		past := time.Now().UTC().Add(-time.Minute)
+		overdue := SagaInstance[sagaTestData]{ID: "o1", Saga: "order", Status: SagaRunning, Step: 1, DeadlineAt: &past, Version: 2}
+		// Left behind by a deploy that dropped the saga's later steps.
+		stale := SagaInstance[sagaTestData]{ID: "o2", Saga: "order", Status: SagaRunning, Step: 5, DeadlineAt: &past, Version: 7}
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "db."+mt.Coll.Name(), mtest.FirstBatch, sagaDoc(t, overdue), sagaDoc(t, stale)),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+		n, err := s.SweepOnce(context.Background())
+		if err != nil || n != 2 {
+			t.Fatalf("SweepOnce = %d, %v", n, err)
+		}
+
+		// The timed-out step is undone too: its outcome is unknown.
+		if got := strings.Join(r.calls, " "); got != "undo-charge undo-reserve" {
+			t.Fatalf("calls = %s", got)
+		}
+		started := mt.GetAllStartedEvents()
+		if len(started) != 3 {
+			t.Fatalf("got %d commands, want find and two saves", len(started))
+		}
+		var saved []SagaInstance[sagaTestData]
+		for _, ev := range started[1:] {
+			var inst SagaInstance[sagaTestData]
+			if err := bson.Unmarshal(ev.Command.Lookup("updates", "0", "u").Document(), &inst); err != nil {
+				t.Fatal(err)
+			}
+			saved = append(saved, inst)
+		}
+		if saved[0].Status != SagaCompensated || saved[0].FailureReason != "step charge timed out" ||
+			sagaEvents(saved[0]) != "charge:timeout charge:compensated reserve:compensated" {
+			t.Fatalf("overdue instance saved as %+v", saved[0])
+		}
+		if saved[1].ID != "o2" || saved[1].Status != SagaFailed || saved[1].DeadlineAt != nil ||
+			saved[1].FailureReason != "step 5 out of range, saga has 3 steps" {
+			t.Fatalf("stale instance saved as %+v", saved[1])
+		}
+	})
+}
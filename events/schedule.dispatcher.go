@@ -0,0 +1,213 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/praction-networks/common/lease"
+	"github.com/praction-networks/common/logger"
+	"github.com/praction-networks/common/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mopt "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ScheduleDispatcher publishes due events from a Publisher's Schedule
+// collection (see Publisher.PublishAt). It replaces per-service cron loops
+// for expiry and reminder timers:
+//
+//   - only the lease holder dispatches (lease.RunAsLeader);
+//   - events are published in due order with their stored MsgID, so a
+//     dispatcher that crashes between publish and delete re-publishes
+//     harmlessly within the stream's dedup window;
+//   - a failed publish is retried after BaseBackoff·2^(attempts-1), capped
+//     at MaxBackoff, without holding up other due events.
+type ScheduleDispatcher struct {
+	Collection    *mongo.Collection
+	StreamManager *JsStreamManager
+	Leaser        lease.Leaser
+
+	LeaseKey     string        // default "schedule-dispatcher:<collection>"
+	HolderID     string        // default hostname
+	LeaseTTL     time.Duration // default 30s
+	PollInterval time.Duration // default 1s
+	BatchSize    int           // default 100
+	BaseBackoff  time.Duration // default 1s
+	MaxBackoff   time.Duration // default 5m
+}
+
+// NewScheduleDispatcher creates a dispatcher with sane defaults.
+func NewScheduleDispatcher(coll *mongo.Collection, streamManager *JsStreamManager, leaser lease.Leaser) *ScheduleDispatcher {
+	holder, _ := os.Hostname()
+	return &ScheduleDispatcher{
+		Collection:    coll,
+		StreamManager: streamManager,
+		Leaser:        leaser,
+		LeaseKey:      "schedule-dispatcher:" + coll.Name(),
+		HolderID:      holder,
+		LeaseTTL:      30 * time.Second,
+		PollInterval:  time.Second,
+		BatchSize:     100,
+		BaseBackoff:   time.Second,
+		MaxBackoff:    5 * time.Minute,
+	}
+}
+
+// EnsureIndexes creates the schedule indexes. Call once at boot.
+func (d *ScheduleDispatcher) EnsureIndexes(ctx context.Context) error {
+	return EnsureScheduleIndexes(ctx, d.Collection)
+}
+
+// EnsureScheduleIndexes creates the due-time index of a schedule collection.
+func EnsureScheduleIndexes(ctx context.Context, coll *mongo.Collection) error {
+	if coll == nil {
+		return nil
+	}
+	_, err := coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: ScheduledFieldDueAt, Value: 1}},
+			Options: mopt.Index().SetName("due_at_idx"),
+		},
+		// _id is unique by default ("<Stream>|<Key>")
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create schedule indexes: %w", err)
+	}
+	return nil
+}
+
+// Run blocks until ctx is cancelled, dispatching due events whenever this
+// replica holds the lease and retrying the lease on PollInterval otherwise.
+func (d *ScheduleDispatcher) Run(ctx context.Context) error {
+	name := "schedule-dispatcher:" + d.Collection.Name()
+	logger.GoroutineStarted(name)
+	defer logger.GoroutineStopped(name, nil)
+
+	for {
+		_, err := lease.RunAsLeader(ctx, d.Leaser, d.LeaseKey, d.HolderID, d.LeaseTTL, 0, d.loop)
+		if err != nil && ctx.Err() == nil {
+			logger.Warn("Schedule dispatcher leader run ended with error", err, "collection", d.Collection.Name())
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(d.PollInterval):
+		}
+	}
+}
+
+func (d *ScheduleDispatcher) loop(ctx context.Context) error {
+	for {
+		n, err := d.DispatchOnce(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			logger.Warn("Schedule dispatch pass failed", err, "collection", d.Collection.Name())
+		}
+		// Keep going while a full batch was due; idle-poll otherwise.
+		if err == nil && n >= d.batchSize() {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(d.PollInterval):
+		}
+	}
+}
+
+func (d *ScheduleDispatcher) batchSize() int {
+	if d.BatchSize <= 0 {
+		return 100
+	}
+	return d.BatchSize
+}
+
+// scheduleDueFilter selects events whose due time has come and whose retry
+// backoff, if any, has elapsed.
+func scheduleDueFilter(now time.Time) bson.M {
+	return bson.M{
+		ScheduledFieldDueAt: bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{ScheduledFieldNextAttempt: bson.M{"$exists": false}},
+			bson.M{ScheduledFieldNextAttempt: bson.M{"$lte": now}},
+		},
+	}
+}
+
+// DispatchOnce publishes up to BatchSize due events and returns how many
+// documents it looked at.
+func (d *ScheduleDispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+	cur, err := d.Collection.Find(ctx, scheduleDueFilter(now), mopt.Find().
+		SetSort(bson.D{{Key: ScheduledFieldDueAt, Value: 1}}).
+		SetLimit(int64(d.batchSize())))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read due scheduled events: %w", err)
+	}
+	defer cur.Close(ctx)
+
+	seen := 0
+	for cur.Next(ctx) {
+		seen++
+		var ev ScheduledEvent
+		if err := cur.Decode(&ev); err != nil {
+			logger.Error("scheduled event decode failed", err)
+			continue
+		}
+
+		ack, perr := publishStoredEvent(ctx, d.StreamManager, FailedNATSEvent{
+			ID:         fmt.Sprintf("%s|%s", ev.StreamName, ev.MsgID),
+			StreamName: ev.StreamName,
+			Subject:    ev.Subject,
+			Payload:    ev.Payload,
+		})
+		if perr != nil {
+			metrics.RecordNATSFailure(ev.StreamName, ev.Subject, perr)
+			attempts := ev.Attempts + 1
+			if _, uerr := d.Collection.UpdateOne(ctx,
+				bson.M{ScheduledFieldID: ev.ID, ScheduledFieldMsgID: ev.MsgID},
+				bson.M{
+					"$set": bson.M{
+						ScheduledFieldLastError:   perr.Error(),
+						ScheduledFieldNextAttempt: now.Add(d.backoff(attempts)),
+					},
+					"$inc": bson.M{ScheduledFieldAttempts: 1},
+				}); uerr != nil {
+				logger.Error("scheduled event update failed", uerr, "id", ev.ID)
+			}
+			logger.Warn("Failed to publish scheduled event, will retry", perr,
+				"id", ev.ID, logger.KeySubject, ev.Subject, "attempts", attempts)
+			continue
+		}
+		metrics.RecordNATSPublished(ev.StreamName, ev.Subject)
+
+		// Matching the MsgID leaves an event rescheduled meanwhile in place.
+		if _, derr := d.Collection.DeleteOne(ctx, bson.M{ScheduledFieldID: ev.ID, ScheduledFieldMsgID: ev.MsgID}); derr != nil {
+			// Re-publish on the next pass is deduplicated by MsgID.
+			logger.Error("scheduled event delete failed after publish", derr, "id", ev.ID)
+		}
+		logger.Debug("Dispatched scheduled event",
+			"stream", ack.Stream, "seq", ack.Sequence, "duplicate", ack.Duplicate,
+			"subject", ev.Subject, "key", ev.Key, "dueAt", ev.DueAt, "delay", now.Sub(ev.DueAt).String())
+	}
+	return seen, cur.Err()
+}
+
+func (d *ScheduleDispatcher) backoff(attempts int) time.Duration {
+	// Cap the exponent so the shift in nextBackoff can't overflow.
+	if attempts > 30 {
+		attempts = 30
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+	base := d.BaseBackoff
+	if base <= 0 {
+		base = time.Second
+	}
+	return nextBackoff(base, d.MaxBackoff, 0, attempts)
+}
@@ -0,0 +1,43 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestScheduleDispatcherBackoff(t *testing.T) {
+	d := &ScheduleDispatcher{BaseBackoff: time.Second, MaxBackoff: time.Minute}
+	cases := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{3, 4 * time.Second},
+		{10, time.Minute},
+		{1000, time.Minute},
+	}
+	for _, c := range cases {
+		if got := d.backoff(c.attempts); got != c.want {
+			t.Errorf("backoff(%d) = %v, want %v", c.attempts, got, c.want)
+		}
+	}
+	if got := (&ScheduleDispatcher{}).backoff(1); got != time.Second {
+		t.Errorf("default backoff = %v, want 1s", got)
+	}
+}
+
+func TestScheduleDueFilter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	f := scheduleDueFilter(now)
+	if due, ok := f[ScheduledFieldDueAt].(bson.M); !ok || due["$lte"] != now {
+		t.Fatalf("dueAt filter = %v", f[ScheduledFieldDueAt])
+	}
+	if or, ok := f["$or"].(bson.A); !ok || len(or) != 2 {
+		t.Fatalf("retry backoff filter = %v", f["$or"])
+	}
+	if got := scheduledDocID("JOBS", "offer-expiry:42"); got != "JOBS|offer-expiry:42" {
+		t.Fatalf("scheduledDocID = %q", got)
+	}
+}
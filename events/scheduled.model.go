@@ -0,0 +1,38 @@
+package events
+
+import (
+	"time"
+)
+
+const (
+	ScheduledFieldID          = "_id"
+	ScheduledFieldKey         = "key"
+	ScheduledFieldStreamName  = "streamName"
+	ScheduledFieldSubject     = "subject"
+	ScheduledFieldPayload     = "payload"
+	ScheduledFieldMsgID       = "msgId"
+	ScheduledFieldDueAt       = "dueAt"
+	ScheduledFieldCreatedAt   = "createdAt"
+	ScheduledFieldAttempts    = "attempts"
+	ScheduledFieldLastError   = "lastError"
+	ScheduledFieldNextAttempt = "nextAttemptAt"
+)
+
+// ScheduledEvent is an event waiting in a schedule collection until DueAt
+// (see Publisher.PublishAt and ScheduleDispatcher). ID is "<Stream>|<Key>",
+// so a key is unique per stream.
+type ScheduledEvent struct {
+	ID         string    `bson:"_id" json:"_id"`
+	Key        string    `bson:"key" json:"key"`
+	StreamName string    `bson:"streamName" json:"streamName"`
+	Subject    string    `bson:"subject" json:"subject"`
+	Payload    []byte    `bson:"payload" json:"payload"`
+	MsgID      string    `bson:"msgId" json:"msgId"` // changes on every (re)schedule
+	DueAt      time.Time `bson:"dueAt" json:"dueAt"`
+	CreatedAt  time.Time `bson:"createdAt" json:"createdAt"`
+	Attempts   int       `bson:"attempts" json:"attempts"`
+	LastError  string    `bson:"lastError,omitempty" json:"lastError,omitempty"`
+
+	// Set by ScheduleDispatcher after a failed publish.
+	NextAttemptAt time.Time `bson:"nextAttemptAt,omitempty" json:"nextAttemptAt,omitzero"`
+}
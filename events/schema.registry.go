@@ -0,0 +1,242 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// ErrSchemaViolation marks a payload that does not match its registered
+// schema. It wraps ErrPoisonMessage, so a Listener with Schemas set routes
+// such messages to PoisonHandler / DeadLetter instead of the handler.
+var ErrSchemaViolation = fmt.Errorf("%w: schema violation", ErrPoisonMessage)
+
+// SchemaEntry is a registered payload type.
+type SchemaEntry struct {
+	Subject Subject
+	Version int
+	Type    reflect.Type
+	Schema  *JSONSchema
+}
+
+// SchemaRegistry maps subjects to their Go payload types and the JSON
+// Schemas generated from them. Producers register their payloads (usually in
+// one catalog package), commit a snapshot, and a test runs
+// CheckCompatibility against it so a breaking change fails CI.
+type SchemaRegistry struct {
+	mu      sync.RWMutex
+	entries map[Subject]SchemaEntry
+}
+
+// NewSchemaRegistry creates an empty registry.
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{entries: map[Subject]SchemaEntry{}}
+}
+
+// Schemas is the process-wide registry.
+var Schemas = NewSchemaRegistry()
+
+// RegisterSchema registers T as the payload of subject at version.
+// Registering a subject again replaces it.
+func RegisterSchema[T any](r *SchemaRegistry, subject Subject, version int) {
+	var zero *T
+	entry := SchemaEntry{
+		Subject: subject,
+		Version: version,
+		Type:    reflect.TypeOf(zero).Elem(),
+		Schema:  SchemaOf[T](),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[subject] = entry
+}
+
+// Lookup returns the entry registered for subject.
+func (r *SchemaRegistry) Lookup(subject Subject) (SchemaEntry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.entries[subject]
+	return e, ok
+}
+
+// Subjects returns the registered subjects, sorted.
+func (r *SchemaRegistry) Subjects() []Subject {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Subject, 0, len(r.entries))
+	for s := range r.entries {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// Validate checks data against the schema registered for subject. Subjects
+// without a registration pass.
+func (r *SchemaRegistry) Validate(subject Subject, data json.RawMessage) error {
+	entry, ok := r.Lookup(subject)
+	if !ok {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrSchemaViolation, subject, err)
+	}
+	if err := validateValue(entry.Schema, v, "data"); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrSchemaViolation, subject, err)
+	}
+	return nil
+}
+
+// SchemaSnapshot is the committed form of a registry, keyed by subject.
+type SchemaSnapshot map[Subject]SchemaSnapshotEntry
+
+// SchemaSnapshotEntry is one subject in a SchemaSnapshot.
+type SchemaSnapshotEntry struct {
+	Version int         `json:"version"`
+	Schema  *JSONSchema `json:"schema"`
+}
+
+// Snapshot returns the registry's current schemas.
+func (r *SchemaRegistry) Snapshot() SchemaSnapshot {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make(SchemaSnapshot, len(r.entries))
+	for s, e := range r.entries {
+		out[s] = SchemaSnapshotEntry{Version: e.Version, Schema: e.Schema}
+	}
+	return out
+}
+
+// WriteSnapshot writes the registry's snapshot as indented JSON.
+func (r *SchemaRegistry) WriteSnapshot(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.Snapshot())
+}
+
+// ReadSchemaSnapshot reads a snapshot written by WriteSnapshot.
+func ReadSchemaSnapshot(rd io.Reader) (SchemaSnapshot, error) {
+	var snap SchemaSnapshot
+	if err := json.NewDecoder(rd).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("failed to read schema snapshot: %w", err)
+	}
+	return snap, nil
+}
+
+// SchemaIncompatibility is one breaking change found by CheckCompatibility.
+type SchemaIncompatibility struct {
+	Subject Subject
+	Path    string
+	Reason  string
+}
+
+func (i SchemaIncompatibility) String() string {
+	return fmt.Sprintf("%s %s: %s", i.Subject, i.Path, i.Reason)
+}
+
+// CheckCompatibility compares the registry with a committed snapshot and
+// reports changes that break existing consumers or producers: a removed
+// subject, a removed field, a changed type, or a field that became required.
+// New optional fields are compatible. A subject whose Version was bumped is
+// skipped — the bump acknowledges the break (see the upcaster registry) —
+// but the snapshot must then be regenerated.
+func (r *SchemaRegistry) CheckCompatibility(old SchemaSnapshot) []SchemaIncompatibility {
+	current := r.Snapshot()
+	subjects := make([]Subject, 0, len(old))
+	for s := range old {
+		subjects = append(subjects, s)
+	}
+	sort.Slice(subjects, func(i, j int) bool { return subjects[i] < subjects[j] })
+
+	var out []SchemaIncompatibility
+	for _, s := range subjects {
+		prev := old[s]
+		cur, ok := current[s]
+		if !ok {
+			out = append(out, SchemaIncompatibility{Subject: s, Path: "data", Reason: "subject no longer registered"})
+			continue
+		}
+		if cur.Version > prev.Version {
+			continue
+		}
+		for _, d := range CompareSchemas(prev.Schema, cur.Schema, "data") {
+			d.Subject = s
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// ErrSchemaIncompatible is returned by CheckSnapshot when breaking changes
+// were found.
+var ErrSchemaIncompatible = errors.New("event schema is not backward compatible")
+
+// CheckSnapshot reads a snapshot and returns ErrSchemaIncompatible, listing
+// every incompatibility, if the registry breaks it.
+func (r *SchemaRegistry) CheckSnapshot(rd io.Reader) error {
+	snap, err := ReadSchemaSnapshot(rd)
+	if err != nil {
+		return err
+	}
+	diffs := r.CheckCompatibility(snap)
+	if len(diffs) == 0 {
+		return nil
+	}
+	msgs := make([]error, len(diffs))
+	for i, d := range diffs {
+		msgs[i] = errors.New(d.String())
+	}
+	return fmt.Errorf("%w:\n%w", ErrSchemaIncompatible, errors.Join(msgs...))
+}
+
+// CompareSchemas returns the breaking differences from old to cur under path.
+func CompareSchemas(old, cur *JSONSchema, path string) []SchemaIncompatibility {
+	if old == nil || old.Type == "" {
+		return nil // anything goes
+	}
+	if cur == nil || cur.Type == "" {
+		return nil // widened to any
+	}
+	if old.Type != cur.Type {
+		return []SchemaIncompatibility{{Path: path, Reason: fmt.Sprintf("type changed from %s to %s", old.Type, cur.Type)}}
+	}
+	if old.Format != cur.Format {
+		return []SchemaIncompatibility{{Path: path, Reason: fmt.Sprintf("format changed from %q to %q", old.Format, cur.Format)}}
+	}
+
+	var out []SchemaIncompatibility
+	switch old.Type {
+	case "object":
+		names := make([]string, 0, len(old.Properties))
+		for name := range old.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			next, ok := cur.Properties[name]
+			if !ok {
+				out = append(out, SchemaIncompatibility{Path: path + "." + name, Reason: "field removed"})
+				continue
+			}
+			out = append(out, CompareSchemas(old.Properties[name], next, path+"."+name)...)
+		}
+		wasRequired := map[string]bool{}
+		for _, name := range old.Required {
+			wasRequired[name] = true
+		}
+		for _, name := range cur.Required {
+			if !wasRequired[name] {
+				out = append(out, SchemaIncompatibility{Path: path + "." + name, Reason: "field became required"})
+			}
+		}
+		out = append(out, CompareSchemas(old.AdditionalProperties, cur.AdditionalProperties, path+"[*]")...)
+	case "array":
+		out = append(out, CompareSchemas(old.Items, cur.Items, path+"[]")...)
+	}
+	return out
+}
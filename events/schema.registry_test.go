@@ -0,0 +1,85 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type schemaV1 struct {
+	ID    string    `json:"id"`
+	Count int       `json:"count"`
+	Tags  []string  `json:"tags,omitempty"`
+	At    time.Time `json:"at"`
+}
+
+type schemaV2Compatible struct {
+	ID    string    `json:"id"`
+	Count int       `json:"count"`
+	Tags  []string  `json:"tags,omitempty"`
+	At    time.Time `json:"at"`
+	Note  string    `json:"note,omitempty"` // new optional field
+}
+
+type schemaV2Breaking struct {
+	ID    string    `json:"id"`
+	Count string    `json:"count"` // type change
+	At    time.Time `json:"at"`
+	Owner string    `json:"owner"` // new required field
+}
+
+func TestSchemaCompatibility(t *testing.T) {
+	old := NewSchemaRegistry()
+	RegisterSchema[schemaV1](old, "test.subject", 1)
+	snap := old.Snapshot()
+
+	ok := NewSchemaRegistry()
+	RegisterSchema[schemaV2Compatible](ok, "test.subject", 1)
+	if diffs := ok.CheckCompatibility(snap); len(diffs) != 0 {
+		t.Fatalf("expected compatible, got %v", diffs)
+	}
+
+	bad := NewSchemaRegistry()
+	RegisterSchema[schemaV2Breaking](bad, "test.subject", 1)
+	got := map[string]string{}
+	for _, d := range bad.CheckCompatibility(snap) {
+		got[d.Path] = d.Reason
+	}
+	if len(got) != 3 || got["data.tags"] != "field removed" ||
+		!strings.Contains(got["data.count"], "type changed") || got["data.owner"] != "field became required" {
+		t.Fatalf("unexpected incompatibilities: %v", got)
+	}
+
+	// A version bump acknowledges the break.
+	bumped := NewSchemaRegistry()
+	RegisterSchema[schemaV2Breaking](bumped, "test.subject", 2)
+	if diffs := bumped.CheckCompatibility(snap); len(diffs) != 0 {
+		t.Fatalf("expected version bump to be accepted, got %v", diffs)
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	r := NewSchemaRegistry()
+	RegisterSchema[schemaV1](r, "test.subject", 1)
+
+	valid := json.RawMessage(`{"id":"a","count":2,"tags":null,"at":"2024-01-02T03:04:05Z"}`)
+	if err := r.Validate("test.subject", valid); err != nil {
+		t.Fatalf("valid payload rejected: %v", err)
+	}
+	for _, bad := range []string{
+		`{"id":"a","count":2}`,
+		`{"id":"a","count":"2","at":"2024-01-02T03:04:05Z"}`,
+		`{"id":"a","count":2.5,"at":"2024-01-02T03:04:05Z"}`,
+		`{"id":"a","count":2,"at":"yesterday"}`,
+	} {
+		err := r.Validate("test.subject", json.RawMessage(bad))
+		if !errors.Is(err, ErrSchemaViolation) || !errors.Is(err, ErrPoisonMessage) {
+			t.Fatalf("expected schema violation for %s, got %v", bad, err)
+		}
+	}
+	if err := r.Validate("unregistered", json.RawMessage(`42`)); err != nil {
+		t.Fatalf("unregistered subject should pass: %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/praction-networks/common/logger"
+	"github.com/praction-networks/common/metrics"
+)
+
+// DefaultConsumerStatsInterval is how often RunConsumerMetrics collects.
+const DefaultConsumerStatsInterval = 15 * time.Second
+
+type consumerKey struct {
+	stream  StreamName
+	durable string
+}
+
+type trackedConsumer struct {
+	listening bool
+}
+
+// ConsumerStats is the state of one durable consumer as seen by the server.
+type ConsumerStats struct {
+	Stream      string     `json:"stream"`
+	Consumer    string     `json:"consumer"`
+	Listening   bool       `json:"listening"`   // a Listener on this manager is consuming
+	Pending     uint64     `json:"pending"`     // matching messages not delivered yet
+	AckPending  int        `json:"ackPending"`  // delivered, not acked yet
+	Redelivered int        `json:"redelivered"` // unacked messages delivered more than once
+	LastActive  *time.Time `json:"lastActive,omitempty"`
+	LagSeconds  float64    `json:"lagSeconds"` // age of the oldest unacked message
+	Error       string     `json:"error,omitempty"`
+}
+
+// ConsumerHealthOptions are the thresholds a consumer must stay within to be
+// reported healthy. Zero disables a check.
+type ConsumerHealthOptions struct {
+	MaxLag     time.Duration
+	MaxPending uint64
+}
+
+// ConsumerHealth is ConsumerStats with a verdict.
+type ConsumerHealth struct {
+	ConsumerStats
+	Healthy bool   `json:"healthy"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// trackConsumer records that a Listener uses durable on stream; Listen calls
+// it so every consumer a service runs shows up in ConsumersStats.
+func (jsm *JsStreamManager) trackConsumer(stream StreamName, durable string, listening bool) {
+	if durable == "" {
+		return
+	}
+	jsm.consumersMu.Lock()
+	defer jsm.consumersMu.Unlock()
+	if jsm.consumers == nil {
+		jsm.consumers = map[consumerKey]*trackedConsumer{}
+	}
+	key := consumerKey{stream: stream, durable: durable}
+	if jsm.consumers[key] == nil {
+		jsm.consumers[key] = &trackedConsumer{}
+	}
+	jsm.consumers[key].listening = listening
+}
+
+// trackedConsumers returns the tracked consumers sorted by stream and durable.
+func (jsm *JsStreamManager) trackedConsumers() (keys []consumerKey, listening map[consumerKey]bool) {
+	jsm.consumersMu.Lock()
+	defer jsm.consumersMu.Unlock()
+	listening = make(map[consumerKey]bool, len(jsm.consumers))
+	for k, c := range jsm.consumers {
+		keys = append(keys, k)
+		listening[k] = c.listening
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].stream != keys[j].stream {
+			return keys[i].stream < keys[j].stream
+		}
+		return keys[i].durable < keys[j].durable
+	})
+	return keys, listening
+}
+
+// ConsumerStats fetches the state of a durable consumer.
+func (jsm *JsStreamManager) ConsumerStats(ctx context.Context, stream StreamName, durable string) (ConsumerStats, error) {
+	stats := ConsumerStats{Stream: string(stream), Consumer: durable}
+	s, err := jsm.JsClient.Stream(ctx, string(stream))
+	if err != nil {
+		return stats, fmt.Errorf("failed to fetch stream %s: %w", stream, err)
+	}
+	consumer, err := s.Consumer(ctx, durable)
+	if err != nil {
+		return stats, fmt.Errorf("failed to fetch consumer %s on %s: %w", durable, stream, err)
+	}
+	info, err := consumer.Info(ctx)
+	if err != nil {
+		return stats, fmt.Errorf("failed to fetch consumer info %s on %s: %w", durable, stream, err)
+	}
+	stats.Pending = info.NumPending
+	stats.AckPending = info.NumAckPending
+	stats.Redelivered = info.NumRedelivered
+	stats.LastActive = info.Delivered.Last
+	stats.LagSeconds = consumerLag(ctx, s, info, time.Now()).Seconds()
+	return stats, nil
+}
+
+// consumerLag is the age of the first message after the ack floor, i.e. how
+// far behind the consumer is. With several filter subjects it is the oldest
+// of the first pending message on each. If none can be read (limits,
+// deletes) it falls back to the time since the last ack.
+func consumerLag(ctx context.Context, s jetstream.Stream, info *jetstream.ConsumerInfo, now time.Time) time.Duration {
+	if info.NumPending == 0 && info.NumAckPending == 0 {
+		return 0
+	}
+	subjects := info.Config.FilterSubjects
+	if info.Config.FilterSubject != "" {
+		subjects = []string{info.Config.FilterSubject}
+	}
+	var filters [][]jetstream.GetMsgOpt
+	for _, subject := range subjects {
+		filters = append(filters, []jetstream.GetMsgOpt{jetstream.WithGetMsgSubject(subject)})
+	}
+	if len(filters) == 0 {
+		filters = [][]jetstream.GetMsgOpt{nil}
+	}
+
+	var lag time.Duration
+	found := false
+	for _, opts := range filters {
+		if msg, err := s.GetMsg(ctx, info.AckFloor.Stream+1, opts...); err == nil {
+			lag, found = max(lag, now.Sub(msg.Time)), true
+		}
+	}
+	if found {
+		return max(lag, 0)
+	}
+	if info.AckFloor.Last != nil {
+		return max(now.Sub(*info.AckFloor.Last), 0)
+	}
+	return 0
+}
+
+// ConsumersStats fetches the state of every consumer a Listener on this
+// manager has used. A consumer that can't be read is returned with Error set.
+func (jsm *JsStreamManager) ConsumersStats(ctx context.Context) []ConsumerStats {
+	keys, listening := jsm.trackedConsumers()
+	out := make([]ConsumerStats, 0, len(keys))
+	for _, k := range keys {
+		stats, err := jsm.ConsumerStats(ctx, k.stream, k.durable)
+		if err != nil {
+			stats.Error = err.Error()
+		}
+		stats.Listening = listening[k]
+		out = append(out, stats)
+	}
+	return out
+}
+
+// Evaluate applies the thresholds to stats.
+func (o ConsumerHealthOptions) Evaluate(stats ConsumerStats) ConsumerHealth {
+	h := ConsumerHealth{ConsumerStats: stats, Healthy: true}
+	switch {
+	case stats.Error != "":
+		h.Healthy, h.Reason = false, "consumer info unavailable"
+	case !stats.Listening:
+		h.Healthy, h.Reason = false, "listener not running"
+	case o.MaxLag > 0 && stats.LagSeconds > o.MaxLag.Seconds():
+		h.Healthy, h.Reason = false, fmt.Sprintf("lag %.0fs exceeds %s", stats.LagSeconds, o.MaxLag)
+	case o.MaxPending > 0 && stats.Pending > o.MaxPending:
+		h.Healthy, h.Reason = false, fmt.Sprintf("%d pending exceeds %d", stats.Pending, o.MaxPending)
+	}
+	return h
+}
+
+// ConsumersHealth evaluates every tracked consumer against opts.
+func (jsm *JsStreamManager) ConsumersHealth(ctx context.Context, opts ConsumerHealthOptions) (healthy bool, consumers []ConsumerHealth) {
+	healthy = true
+	for _, stats := range jsm.ConsumersStats(ctx) {
+		h := opts.Evaluate(stats)
+		healthy = healthy && h.Healthy
+		consumers = append(consumers, h)
+	}
+	return healthy, consumers
+}
+
+// RunConsumerMetrics exports the stats of every tracked consumer as gauges
+// (nats_consumer_*) every interval until ctx is cancelled.
+func (jsm *JsStreamManager) RunConsumerMetrics(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultConsumerStatsInterval
+	}
+	name := "nats-consumer-metrics"
+	logger.GoroutineStarted(name)
+	defer logger.GoroutineStopped(name, nil)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		jsm.collectConsumerMetrics(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (jsm *JsStreamManager) collectConsumerMetrics(ctx context.Context) {
+	for _, s := range jsm.ConsumersStats(ctx) {
+		if s.Error != "" {
+			if ctx.Err() == nil {
+				logger.Warn("Failed to collect consumer stats", errors.New(s.Error), logger.KeyStream, s.Stream, "consumer", s.Consumer)
+			}
+			continue
+		}
+		var lastActive time.Time
+		if s.LastActive != nil {
+			lastActive = *s.LastActive
+		}
+		metrics.SetNATSConsumerStats(s.Stream, s.Consumer, s.Pending, s.AckPending, s.Redelivered,
+			time.Duration(s.LagSeconds*float64(time.Second)), lastActive)
+	}
+}
+
+// consumerHealthResponse is the body written by ConsumerHealthHandler.
+type consumerHealthResponse struct {
+	Status    string           `json:"status"` // "ok" or "degraded"
+	Consumers []ConsumerHealth `json:"consumers"`
+}
+
+// ConsumerHealthHandler serves the health of every tracked consumer as JSON,
+// with 200 when all are healthy and 503 otherwise, for readiness probes.
+func (jsm *JsStreamManager) ConsumerHealthHandler(opts ConsumerHealthOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		healthy, consumers := jsm.ConsumersHealth(ctx, opts)
+		resp := consumerHealthResponse{Status: "ok", Consumers: consumers}
+		status := http.StatusOK
+		if !healthy {
+			resp.Status, status = "degraded", http.StatusServiceUnavailable
+		}
+		if resp.Consumers == nil {
+			resp.Consumers = []ConsumerHealth{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+}
@@ -0,0 +1,145 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestConsumerHealthOptionsEvaluate(t *testing.T) {
+	opts := ConsumerHealthOptions{MaxLag: time.Minute, MaxPending: 100}
+	ok := ConsumerStats{Stream: "PLAN", Consumer: "svc", Listening: true, Pending: 10, LagSeconds: 5}
+
+	cases := []struct {
+		name    string
+		stats   func(ConsumerStats) ConsumerStats
+		healthy bool
+	}{
+		{"within thresholds", func(s ConsumerStats) ConsumerStats { return s }, true},
+		{"info error", func(s ConsumerStats) ConsumerStats { s.Error = "boom"; return s }, false},
+		{"not listening", func(s ConsumerStats) ConsumerStats { s.Listening = false; return s }, false},
+		{"lagging", func(s ConsumerStats) ConsumerStats { s.LagSeconds = 120; return s }, false},
+		{"backlog", func(s ConsumerStats) ConsumerStats { s.Pending = 101; return s }, false},
+	}
+	for _, c := range cases {
+		h := opts.Evaluate(c.stats(ok))
+		if h.Healthy != c.healthy {
+			t.Errorf("%s: healthy = %v (%s), want %v", c.name, h.Healthy, h.Reason, c.healthy)
+		}
+		if !h.Healthy && h.Reason == "" {
+			t.Errorf("%s: unhealthy without reason", c.name)
+		}
+	}
+
+	if h := (ConsumerHealthOptions{}).Evaluate(ConsumerStats{Listening: true, Pending: 1 << 20, LagSeconds: 1e6}); !h.Healthy {
+		t.Fatalf("zero thresholds should only check errors and listening, got %s", h.Reason)
+	}
+}
+
+func TestTrackConsumer(t *testing.T) {
+	jsm := &JsStreamManager{}
+	jsm.trackConsumer("PLAN", "b", false)
+	jsm.trackConsumer("PLAN", "a", false)
+	jsm.trackConsumer("LICENSE", "a", true)
+	jsm.trackConsumer("PLAN", "b", true)
+	jsm.trackConsumer("PLAN", "", true) // ephemeral, not tracked
+
+	keys, listening := jsm.trackedConsumers()
+	want := []consumerKey{{"LICENSE", "a"}, {"PLAN", "a"}, {"PLAN", "b"}}
+	if len(keys) != len(want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("keys = %v, want %v", keys, want)
+		}
+	}
+	if !listening[consumerKey{"PLAN", "b"}] || listening[consumerKey{"PLAN", "a"}] {
+		t.Fatalf("listening = %v", listening)
+	}
+}
+
+type fakeLagStream struct {
+	jetstream.Stream
+	msgs    map[uint64]time.Time
+	gotOpts int
+
+	// next, when set, answers successive GetMsg calls in order (zero: not
+	// found), standing in for the first message on each filter subject.
+	next []time.Time
+}
+
+func (s *fakeLagStream) GetMsg(_ context.Context, seq uint64, opts ...jetstream.GetMsgOpt) (*jetstream.RawStreamMsg, error) {
+	s.gotOpts = len(opts)
+	ts, ok := s.msgs[seq]
+	if s.next != nil {
+		ts, s.next = s.next[0], s.next[1:]
+		ok = !ts.IsZero()
+	}
+	if !ok {
+		return nil, errors.New("no message found")
+	}
+	return &jetstream.RawStreamMsg{Sequence: seq, Time: ts}, nil
+}
+
+func TestConsumerLag(t *testing.T) {
+	now := time.Now()
+	lastAck := now.Add(-3 * time.Minute)
+	s := &fakeLagStream{msgs: map[uint64]time.Time{11: now.Add(-90 * time.Second)}}
+
+	caught := &jetstream.ConsumerInfo{AckFloor: jetstream.SequenceInfo{Stream: 10}}
+	if lag := consumerLag(context.Background(), s, caught, now); lag != 0 {
+		t.Fatalf("caught up lag = %v, want 0", lag)
+	}
+
+	behind := &jetstream.ConsumerInfo{
+		NumPending: 5,
+		AckFloor:   jetstream.SequenceInfo{Stream: 10, Last: &lastAck},
+		Config:     jetstream.ConsumerConfig{FilterSubject: "plan.created"},
+	}
+	if lag := consumerLag(context.Background(), s, behind, now); lag != 90*time.Second {
+		t.Fatalf("lag = %v, want 90s", lag)
+	}
+	if s.gotOpts != 1 {
+		t.Fatalf("filter subject not passed to GetMsg")
+	}
+
+	behind.AckFloor.Stream = 20 // next message purged
+	if lag := consumerLag(context.Background(), s, behind, now); lag != 3*time.Minute {
+		t.Fatalf("fallback lag = %v, want 3m", lag)
+	}
+
+	// Several filter subjects: the consumer is as far behind as its oldest
+	// pending message on any of them.
+	behind.Config = jetstream.ConsumerConfig{FilterSubjects: []string{"plan.created", "plan.updated", "plan.deleted"}}
+	s.next = []time.Time{now.Add(-time.Minute), now.Add(-2 * time.Minute), {}}
+	if lag := consumerLag(context.Background(), s, behind, now); lag != 2*time.Minute || s.gotOpts != 1 {
+		t.Fatalf("multi-filter lag = %v, want 2m", lag)
+	}
+	if len(s.next) != 0 {
+		t.Fatalf("%d filter subjects not read", len(s.next))
+	}
+}
+
+func TestConsumerHealthHandlerNoConsumers(t *testing.T) {
+	rec := httptest.NewRecorder()
+	(&JsStreamManager{}).ConsumerHealthHandler(ConsumerHealthOptions{}).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var body consumerHealthResponse
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != "ok" || body.Consumers == nil || len(body.Consumers) != 0 {
+		t.Fatalf("body = %+v", body)
+	}
+}
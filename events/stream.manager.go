@@ -3,6 +3,7 @@ package events
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go/jetstream"
@@ -11,6 +12,9 @@ import (
 
 type JsStreamManager struct {
 	JsClient jetstream.JetStream
+
+	consumersMu sync.Mutex
+	consumers   map[consumerKey]*trackedConsumer // durables of Listeners using this manager
 }
 
 func NewStreamManager(jsClient jetstream.JetStream) *JsStreamManager {
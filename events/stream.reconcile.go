@@ -0,0 +1,327 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/praction-networks/common/logger"
+)
+
+// DriftKind classifies a difference between the Streams registry and the
+// live JetStream state.
+type DriftKind string
+
+const (
+	DriftStreamMissing  DriftKind = "stream_missing"  // registry stream does not exist
+	DriftSubjectMissing DriftKind = "subject_missing" // registry subject not on the live stream
+	DriftSubjectExtra   DriftKind = "subject_extra"   // live subject not in the registry
+	DriftSetting        DriftKind = "setting"         // managed setting differs
+	DriftSubjectOverlap DriftKind = "subject_overlap" // subject claimed by two streams
+)
+
+// StreamDrift is one reported difference.
+type StreamDrift struct {
+	Stream StreamName
+	Kind   DriftKind
+	Field  string // setting name, or the subject for subject drifts
+	Want   string
+	Got    string
+}
+
+func (d StreamDrift) String() string {
+	switch d.Kind {
+	case DriftSetting:
+		return fmt.Sprintf("%s: %s want=%s got=%s", d.Stream, d.Field, d.Want, d.Got)
+	case DriftSubjectOverlap:
+		return fmt.Sprintf("%s: subject %s overlaps %s on %s", d.Stream, d.Field, d.Got, d.Want)
+	default:
+		return fmt.Sprintf("%s: %s %s", d.Stream, d.Kind, d.Field)
+	}
+}
+
+// ReconcileOptions controls ReconcileStreams.
+type ReconcileOptions struct {
+	// Registry to reconcile against; nil means the package-level Streams.
+	Registry map[StreamName]StreamMetadata
+
+	// Only restricts the run to these streams; empty means the whole
+	// registry.
+	Only []StreamName
+
+	// Apply creates missing streams and updates drifted ones. Subject
+	// overlaps are never auto-fixed.
+	Apply bool
+
+	// RemoveExtraSubjects drops live subjects that are not in the registry
+	// when applying. Off by default: a subject added by hand is usually
+	// still being published to.
+	RemoveExtraSubjects bool
+}
+
+// ReconcileReport is the outcome of ReconcileStreams.
+type ReconcileReport struct {
+	Drifts  []StreamDrift
+	Applied []StreamName
+	Errors  map[StreamName]error
+}
+
+// HasDrift reports whether any drift was found.
+func (r *ReconcileReport) HasDrift() bool {
+	return len(r.Drifts) > 0
+}
+
+// ReconcileStreams diffs the live StreamInfo of every registry stream against
+// its StreamMetadata, checks subject overlaps (within the registry and against
+// live streams outside it) and, with opts.Apply, fixes what it can. Errors on
+// individual streams are collected in the report; the returned error is only
+// set when the live state could not be listed at all.
+func ReconcileStreams(ctx context.Context, jsm *JsStreamManager, opts ReconcileOptions) (*ReconcileReport, error) {
+	registry := opts.Registry
+	if registry == nil {
+		registry = Streams
+	}
+	names := opts.Only
+	if len(names) == 0 {
+		for name := range registry {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	}
+
+	report := &ReconcileReport{Errors: map[StreamName]error{}}
+
+	live := map[StreamName]*jetstream.StreamInfo{}
+	lister := jsm.JsClient.ListStreams(ctx)
+	for info := range lister.Info() {
+		live[StreamName(info.Config.Name)] = info
+	}
+	if err := lister.Err(); err != nil && !errors.Is(err, jetstream.ErrEndOfData) {
+		return nil, fmt.Errorf("failed to list streams: %w", err)
+	}
+
+	report.Drifts = append(report.Drifts, subjectOverlaps(registry, live)...)
+
+	for _, name := range names {
+		meta, ok := registry[name]
+		if !ok {
+			report.Errors[name] = fmt.Errorf("stream %s is not in the registry", name)
+			continue
+		}
+		info, exists := live[name]
+		if !exists {
+			report.Drifts = append(report.Drifts, StreamDrift{Stream: name, Kind: DriftStreamMissing})
+			if opts.Apply {
+				if err := jsm.CreateOrUpdateStream(ctx, meta.StreamConfig()); err != nil {
+					report.Errors[name] = err
+				} else {
+					report.Applied = append(report.Applied, name)
+				}
+			}
+			continue
+		}
+
+		drifts := diffStream(meta, info.Config)
+		report.Drifts = append(report.Drifts, drifts...)
+		if !opts.Apply || len(drifts) == 0 {
+			continue
+		}
+		cfg := applyMetadata(meta, info.Config, opts.RemoveExtraSubjects)
+		if _, err := jsm.JsClient.UpdateStream(ctx, cfg); err != nil {
+			report.Errors[name] = fmt.Errorf("failed to update stream %s: %w", name, err)
+			continue
+		}
+		report.Applied = append(report.Applied, name)
+	}
+
+	for _, d := range report.Drifts {
+		logger.Warn("Stream drift detected", logger.KeyStream, string(d.Stream), "kind", string(d.Kind), "detail", d.String())
+	}
+	for _, name := range report.Applied {
+		logger.Info("Stream reconciled", logger.KeyStream, string(name))
+	}
+	return report, nil
+}
+
+// diffStream compares a registry entry with a live stream config.
+func diffStream(meta StreamMetadata, cfg jetstream.StreamConfig) []StreamDrift {
+	var out []StreamDrift
+
+	liveSubjects := map[string]bool{}
+	for _, s := range cfg.Subjects {
+		liveSubjects[s] = true
+	}
+	wantSubjects := map[string]bool{}
+	for _, s := range meta.Subjects {
+		wantSubjects[string(s)] = true
+		if !liveSubjects[string(s)] {
+			out = append(out, StreamDrift{Stream: meta.Name, Kind: DriftSubjectMissing, Field: string(s)})
+		}
+	}
+	for _, s := range cfg.Subjects {
+		if !wantSubjects[s] {
+			out = append(out, StreamDrift{Stream: meta.Name, Kind: DriftSubjectExtra, Field: s})
+		}
+	}
+
+	check := func(field string, want, got any) {
+		if want != got {
+			out = append(out, StreamDrift{
+				Stream: meta.Name, Kind: DriftSetting, Field: field,
+				Want: fmt.Sprint(want), Got: fmt.Sprint(got),
+			})
+		}
+	}
+	if meta.Retention != nil {
+		check("retention", *meta.Retention, cfg.Retention)
+	}
+	if meta.Storage != nil {
+		check("storage", *meta.Storage, cfg.Storage)
+	}
+	if meta.Replicas != nil {
+		check("replicas", *meta.Replicas, cfg.Replicas)
+	}
+	if meta.MaxConsumers != nil {
+		check("maxConsumers", *meta.MaxConsumers, cfg.MaxConsumers)
+	}
+	if meta.MaxMsgs != nil {
+		check("maxMsgs", *meta.MaxMsgs, cfg.MaxMsgs)
+	}
+	if meta.MaxBytes != nil {
+		check("maxBytes", *meta.MaxBytes, cfg.MaxBytes)
+	}
+	if meta.MaxAge != nil {
+		check("maxAge", *meta.MaxAge, cfg.MaxAge)
+	}
+	if meta.MaxMsgSize != nil {
+		check("maxMsgSize", *meta.MaxMsgSize, cfg.MaxMsgSize)
+	}
+	if meta.Discard != nil {
+		check("discard", *meta.Discard, cfg.Discard)
+	}
+	if meta.Compression != nil {
+		check("compression", *meta.Compression, cfg.Compression)
+	}
+	if meta.Duplicates != nil {
+		check("duplicates", *meta.Duplicates, cfg.Duplicates)
+	}
+	return out
+}
+
+// applyMetadata returns the live config with every managed (non-nil)
+// registry setting applied. Unmanaged settings keep their live value.
+func applyMetadata(meta StreamMetadata, cfg jetstream.StreamConfig, removeExtra bool) jetstream.StreamConfig {
+	subjects := make([]string, 0, len(meta.Subjects)+len(cfg.Subjects))
+	seen := map[string]bool{}
+	for _, s := range meta.Subjects {
+		if !seen[string(s)] {
+			seen[string(s)] = true
+			subjects = append(subjects, string(s))
+		}
+	}
+	if !removeExtra {
+		for _, s := range cfg.Subjects {
+			if !seen[s] {
+				seen[s] = true
+				subjects = append(subjects, s)
+			}
+		}
+	}
+	cfg.Subjects = subjects
+
+	if meta.Description != "" {
+		cfg.Description = meta.Description
+	}
+	apply(&cfg.Retention, meta.Retention)
+	apply(&cfg.Storage, meta.Storage)
+	apply(&cfg.Replicas, meta.Replicas)
+	apply(&cfg.MaxConsumers, meta.MaxConsumers)
+	apply(&cfg.MaxMsgs, meta.MaxMsgs)
+	apply(&cfg.MaxBytes, meta.MaxBytes)
+	apply(&cfg.MaxAge, meta.MaxAge)
+	apply(&cfg.MaxMsgSize, meta.MaxMsgSize)
+	apply(&cfg.Discard, meta.Discard)
+	apply(&cfg.Compression, meta.Compression)
+	apply(&cfg.Duplicates, meta.Duplicates)
+	return cfg
+}
+
+// apply sets a live setting to its managed registry value.
+func apply[T any](dst *T, want *T) {
+	if want != nil {
+		*dst = *want
+	}
+}
+
+// subjectOverlaps reports subjects claimed by two registry streams, and
+// registry subjects that overlap a live stream outside the registry entry.
+// JetStream rejects overlapping subjects at create/update time, so these
+// must be fixed by hand.
+func subjectOverlaps(registry map[StreamName]StreamMetadata, live map[StreamName]*jetstream.StreamInfo) []StreamDrift {
+	owners := map[StreamName][]string{}
+	for name, meta := range registry {
+		for _, s := range meta.Subjects {
+			owners[name] = append(owners[name], string(s))
+		}
+	}
+	for name, info := range live {
+		if _, inRegistry := registry[name]; inRegistry {
+			continue
+		}
+		owners[name] = append(owners[name], info.Config.Subjects...)
+	}
+
+	names := make([]StreamName, 0, len(owners))
+	for name := range owners {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+
+	var out []StreamDrift
+	for i, a := range names {
+		if _, inRegistry := registry[a]; !inRegistry {
+			continue // only report from the registry side
+		}
+		for j, b := range names {
+			if i == j {
+				continue
+			}
+			if _, bInRegistry := registry[b]; bInRegistry && j < i {
+				continue // registry pair already reported
+			}
+			for _, sa := range owners[a] {
+				for _, sb := range owners[b] {
+					if subjectsOverlap(sa, sb) {
+						out = append(out, StreamDrift{
+							Stream: a, Kind: DriftSubjectOverlap, Field: sa,
+							Want: string(b), Got: sb,
+						})
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+// subjectsOverlap reports whether some concrete subject matches both
+// patterns.
+func subjectsOverlap(a, b string) bool {
+	at := strings.Split(a, ".")
+	bt := strings.Split(b, ".")
+	for i := 0; ; i++ {
+		aEnd, bEnd := i >= len(at), i >= len(bt)
+		if aEnd || bEnd {
+			return aEnd && bEnd
+		}
+		if at[i] == ">" || bt[i] == ">" {
+			return true
+		}
+		if at[i] != "*" && bt[i] != "*" && at[i] != bt[i] {
+			return false
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestSubjectsOverlap(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want bool
+	}{
+		{"olt.event.created", "olt.event.created", true},
+		{"olt.event.*", "olt.event.created", true},
+		{"olt.>", "olt.event.created", true},
+		{"olt.*", "olt.event.created", false},
+		{"olt.event.created", "olt.event.deleted", false},
+		{"olt.>", "olt", false},
+		{"*.event", "olt.*", true},
+	}
+	for _, c := range cases {
+		if got := subjectsOverlap(c.a, c.b); got != c.want {
+			t.Errorf("subjectsOverlap(%q, %q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+		if got := subjectsOverlap(c.b, c.a); got != c.want {
+			t.Errorf("subjectsOverlap(%q, %q) = %v, want %v", c.b, c.a, got, c.want)
+		}
+	}
+}
+
+func TestDiffStreamAndApply(t *testing.T) {
+	meta := StreamMetadata{
+		Name:      "TEST",
+		Subjects:  []Subject{"test.a", "test.b"},
+		Replicas:  new(3),
+		MaxAge:    new(24 * time.Hour),
+		Retention: new(jetstream.LimitsPolicy),
+		Discard:   new(jetstream.DiscardOld),
+	}
+	live := jetstream.StreamConfig{
+		Name:      "TEST",
+		Subjects:  []string{"test.a", "test.manual"},
+		Replicas:  1,
+		MaxAge:    24 * time.Hour,
+		MaxMsgs:   500, // unmanaged, must survive apply
+		Retention: jetstream.WorkQueuePolicy,
+		Discard:   jetstream.DiscardOld,
+	}
+
+	kinds := map[DriftKind]int{}
+	fields := map[string]bool{}
+	for _, d := range diffStream(meta, live) {
+		kinds[d.Kind]++
+		fields[d.Field] = true
+	}
+	// Drift to a zero-valued setting (LimitsPolicy) is drift too.
+	if kinds[DriftSubjectMissing] != 1 || kinds[DriftSubjectExtra] != 1 || kinds[DriftSetting] != 2 ||
+		!fields["replicas"] || !fields["retention"] {
+		t.Fatalf("unexpected drift: %v %v", kinds, fields)
+	}
+
+	cfg := applyMetadata(meta, live, false)
+	if cfg.Replicas != 3 || cfg.MaxMsgs != 500 || len(cfg.Subjects) != 3 || cfg.Retention != jetstream.LimitsPolicy {
+		t.Fatalf("unexpected applied config: %+v", cfg)
+	}
+	if len(diffStream(meta, applyMetadata(meta, live, true))) != 0 {
+		t.Fatal("expected no drift after applying with subject removal")
+	}
+}
+
+func TestSubjectOverlapsRegistry(t *testing.T) {
+	registry := map[StreamName]StreamMetadata{
+		"A": {Name: "A", Subjects: []Subject{"x.>"}},
+		"B": {Name: "B", Subjects: []Subject{"x.y"}},
+	}
+	live := map[StreamName]*jetstream.StreamInfo{
+		"C": {Config: jetstream.StreamConfig{Name: "C", Subjects: []string{"x.*"}}},
+	}
+	// A/B reported once, A/C and B/C from the registry side.
+	if got := subjectOverlaps(registry, live); len(got) != 3 {
+		t.Fatalf("expected 3 overlaps, got %v", got)
+	}
+}
+
+func TestStreamsRegistryManagesSettings(t *testing.T) {
+	for name, meta := range Streams {
+		if meta.Retention == nil || meta.Storage == nil || meta.Discard == nil {
+			t.Errorf("%s: retention, storage and discard must be managed", name)
+		}
+		// Retention limits stay with each deployment until the registry
+		// carries the deployed values.
+		if meta.MaxAge != nil || meta.Duplicates != nil {
+			t.Errorf("%s: maxAge and duplicates must not be managed", name)
+		}
+		cfg := meta.StreamConfig()
+		if cfg.Storage != jetstream.FileStorage {
+			t.Errorf("%s: unexpected config %+v", name, cfg)
+		}
+	}
+}
@@ -1,5 +1,11 @@
 package events
 
+import (
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
 type StreamName string
 
 // Stream names as constants
@@ -409,6 +415,53 @@ type StreamMetadata struct {
 	Name        StreamName
 	Description string
 	Subjects    []Subject
+
+	// JetStream settings. A nil setting is not managed by the registry:
+	// ReconcileStreams neither reports drift on it nor changes it, and the
+	// server default applies when the stream is created. Pointers because
+	// the zero values (LimitsPolicy, FileStorage, DiscardOld, NoCompression,
+	// unlimited MaxAge) are real settings.
+	Retention    *jetstream.RetentionPolicy
+	Storage      *jetstream.StorageType
+	Replicas     *int
+	MaxConsumers *int
+	MaxMsgs      *int64
+	MaxBytes     *int64
+	MaxAge       *time.Duration
+	MaxMsgSize   *int32
+	Discard      *jetstream.DiscardPolicy
+	Compression  *jetstream.StoreCompression
+	Duplicates   *time.Duration // dedup window
+}
+
+// StreamConfig converts the registry entry into the StreamConfig accepted by
+// JsStreamManager.CreateOrUpdateStream.
+func (m StreamMetadata) StreamConfig() StreamConfig {
+	return StreamConfig{
+		Name:         m.Name,
+		Description:  m.Description,
+		Subjects:     m.Subjects,
+		Retention:    deref(m.Retention),
+		MaxConsumers: deref(m.MaxConsumers),
+		MaxMsgs:      deref(m.MaxMsgs),
+		MaxBytes:     deref(m.MaxBytes),
+		MaxAge:       deref(m.MaxAge),
+		Discard:      deref(m.Discard),
+		Storage:      deref(m.Storage),
+		Replicas:     deref(m.Replicas),
+		Compression:  deref(m.Compression),
+		Duplicates:   deref(m.Duplicates),
+		MaxMsgSize:   deref(m.MaxMsgSize),
+	}
+}
+
+// deref returns *p, or the zero value (the server default) for nil.
+func deref[T any](p *T) T {
+	if p == nil {
+		var zero T
+		return zero
+	}
+	return *p
 }
 
 // Predefined stream configurations
@@ -417,6 +470,9 @@ var Streams = map[StreamName]StreamMetadata{
 	TenantStream: {
 		Name:        TenantStream,
 		Description: "Stream for domain-related events",
+		Retention:   new(jetstream.LimitsPolicy),
+		Storage:     new(jetstream.FileStorage),
+		Discard:     new(jetstream.DiscardOld),
 		Subjects: []Subject{TenantCreatedSubject,
 			TenantUpdatedSubject,
 			TenantDeletedSubject,
@@ -470,6 +526,9 @@ var Streams = map[StreamName]StreamMetadata{
 	TenantUserStream: {
 		Name:        TenantUserStream,
 		Description: "Stream for tenant user service events",
+		Retention:   new(jetstream.LimitsPolicy),
+		Storage:     new(jetstream.FileStorage),
+		Discard:     new(jetstream.DiscardOld),
 		Subjects: []Subject{
 			// Tenant User Events (Extended Profiles)
 			TenantUserCreatedSubject,
@@ -490,6 +549,9 @@ var Streams = map[StreamName]StreamMetadata{
 	AuthStream: {
 		Name:        AuthStream,
 		Description: "Stream for auth service events",
+		Retention:   new(jetstream.LimitsPolicy),
+		Storage:     new(jetstream.FileStorage),
+		Discard:     new(jetstream.DiscardOld),
 		Subjects: []Subject{
 
 			// Tenant User Role Events
@@ -506,6 +568,9 @@ var Streams = map[StreamName]StreamMetadata{
 	InventoryStream: {
 		Name:        InventoryStream,
 		Description: "Stream for inventory service events",
+		Retention:   new(jetstream.LimitsPolicy),
+		Storage:     new(jetstream.FileStorage),
+		Discard:     new(jetstream.DiscardOld),
 		Subjects: []Subject{
 			// Device events
 			InventoryDeviceCreatedSubject,
@@ -536,6 +601,9 @@ var Streams = map[StreamName]StreamMetadata{
 	NotificationGlobalStream: {
 		Name:        NotificationGlobalStream,
 		Description: "Global stream for notification events from all services",
+		Retention:   new(jetstream.LimitsPolicy),
+		Storage:     new(jetstream.FileStorage),
+		Discard:     new(jetstream.DiscardOld),
 		Subjects: []Subject{
 			UserNotifcationSentSubject,
 			UserNotifcationVerifiedSubject,
@@ -550,6 +618,9 @@ var Streams = map[StreamName]StreamMetadata{
 	SubscriberStream: {
 		Name:        SubscriberStream,
 		Description: "Stream for subscriber service events including broadband, hotspot, and field configurations",
+		Retention:   new(jetstream.LimitsPolicy),
+		Storage:     new(jetstream.FileStorage),
+		Discard:     new(jetstream.DiscardOld),
 		Subjects: []Subject{
 			// Subscriber Events
 			SubscriberCreatedSubject,
@@ -585,6 +656,9 @@ var Streams = map[StreamName]StreamMetadata{
 	RadiusAccountingStream: {
 		Name:        RadiusAccountingStream,
 		Description: "Stream for radius accounting CDC events from radius-event-manager-service",
+		Retention:   new(jetstream.LimitsPolicy),
+		Storage:     new(jetstream.FileStorage),
+		Discard:     new(jetstream.DiscardOld),
 		Subjects: []Subject{
 			RadiusAccountingRadAcctSessionStartSubject,
 			RadiusAccountingRadAcctSessionUpdateSubject,
@@ -594,6 +668,9 @@ var Streams = map[StreamName]StreamMetadata{
 	CaptivePortalStream: {
 		Name:        CaptivePortalStream,
 		Description: "Stream for captive portal service events",
+		Retention:   new(jetstream.LimitsPolicy),
+		Storage:     new(jetstream.FileStorage),
+		Discard:     new(jetstream.DiscardOld),
 		Subjects: []Subject{
 			GuestHotspotSubscriberCreatedSubject,
 			GuestHotspotSubscriberUpdatedSubject,
@@ -606,6 +683,9 @@ var Streams = map[StreamName]StreamMetadata{
 	PlanStream: {
 		Name:        PlanStream,
 		Description: "Stream for plan service events",
+		Retention:   new(jetstream.LimitsPolicy),
+		Storage:     new(jetstream.FileStorage),
+		Discard:     new(jetstream.DiscardOld),
 		Subjects: []Subject{
 			// Plan Events (consumed by subscriber/billing services)
 			PlanCreatedSubject,
@@ -632,6 +712,9 @@ var Streams = map[StreamName]StreamMetadata{
 	TicketStream: {
 		Name:        TicketStream,
 		Description: "Stream for ticket service events",
+		Retention:   new(jetstream.LimitsPolicy),
+		Storage:     new(jetstream.FileStorage),
+		Discard:     new(jetstream.DiscardOld),
 		Subjects: []Subject{
 			// Core Ticket Events
 			TicketCreatedSubject,
@@ -673,6 +756,9 @@ var Streams = map[StreamName]StreamMetadata{
 	AuditGlobalStream: {
 		Name:        AuditGlobalStream,
 		Description: "Global stream for audit trail events from all services",
+		Retention:   new(jetstream.LimitsPolicy),
+		Storage:     new(jetstream.FileStorage),
+		Discard:     new(jetstream.DiscardOld),
 		Subjects: []Subject{
 			AuditUserActionSubject,
 			AuditAuthActionSubject,
@@ -688,6 +774,9 @@ var Streams = map[StreamName]StreamMetadata{
 	VenueStream: {
 		Name:        VenueStream,
 		Description: "Stream for venue service events (orders, menus)",
+		Retention:   new(jetstream.LimitsPolicy),
+		Storage:     new(jetstream.FileStorage),
+		Discard:     new(jetstream.DiscardOld),
 		Subjects: []Subject{
 			VenueOrderCreatedSubject,
 			VenueOrderUpdatedSubject,
@@ -701,6 +790,9 @@ var Streams = map[StreamName]StreamMetadata{
 	BillingStream: {
 		Name:        BillingStream,
 		Description: "Stream for billing service events (payments, invoices, subscriptions, commissions, CDC)",
+		Retention:   new(jetstream.LimitsPolicy),
+		Storage:     new(jetstream.FileStorage),
+		Discard:     new(jetstream.DiscardOld),
 		Subjects: []Subject{
 			BillingPaymentCompletedSubject,
 			BillingPaymentFailedSubject,
@@ -734,6 +826,9 @@ var Streams = map[StreamName]StreamMetadata{
 	OLTEventStream: {
 		Name:        OLTEventStream,
 		Description: "Real-time OLT runtime events from olt-manager (SNMP traps + active polling). Higher velocity than TenantStream; isolated so trap storms don't crowd out lifecycle events.",
+		Retention:   new(jetstream.LimitsPolicy),
+		Storage:     new(jetstream.FileStorage),
+		Discard:     new(jetstream.DiscardOld),
 		Subjects: []Subject{
 			OLTEventONTDownSubject,
 			OLTEventONTUpSubject,
@@ -754,6 +849,9 @@ var Streams = map[StreamName]StreamMetadata{
 	OLTManagerStream: {
 		Name:        OLTManagerStream,
 		Description: "Service-level events from olt-manager-service: OLT lifecycle (created/updated/deleted), sync lifecycle, capability detection, ONT reconciliation, alarm reconciliation, health changes. olt-manager owns the source-of-truth for OLT records and is the publisher of olt.* lifecycle events.",
+		Retention:   new(jetstream.LimitsPolicy),
+		Storage:     new(jetstream.FileStorage),
+		Discard:     new(jetstream.DiscardOld),
 		Subjects: []Subject{
 			// OLT lifecycle (olt-manager is the publisher; was on TenantStream pre-cutover)
 			OLTCreatedSubject,
@@ -787,6 +885,9 @@ var Streams = map[StreamName]StreamMetadata{
 	LicenseStream: {
 		Name:        LicenseStream,
 		Description: "Stream for license-service events: licenses, entitlements, wallets, installations, JWS revocation",
+		Retention:   new(jetstream.LimitsPolicy),
+		Storage:     new(jetstream.FileStorage),
+		Discard:     new(jetstream.DiscardOld),
 		Subjects: []Subject{
 			LicenseIssuedSubject,
 			LicenseEntitlementChangedSubject,
@@ -0,0 +1,304 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/praction-networks/common/appError"
+	"github.com/praction-networks/common/helpers"
+	"github.com/praction-networks/common/logger"
+	"github.com/praction-networks/common/metrics"
+)
+
+// Request/reply headers. Envelope headers (correlation, tenant, actor) are
+// sent as well so the responder continues the caller's trace.
+const (
+	// HeaderSyncDeadline carries the caller's ctx deadline (RFC3339Nano) so
+	// the responder stops working once nobody is waiting for the answer.
+	HeaderSyncDeadline = "Sync-Deadline"
+
+	// HeaderSyncErrorCode marks an error reply and carries its
+	// appError.ErrorCode. The body of an error reply is a SyncReply.
+	HeaderSyncErrorCode = "Sync-Error-Code"
+)
+
+// DefaultSyncTimeout bounds a request whose ctx has no deadline, and a
+// handler whose request carried none.
+const DefaultSyncTimeout = 5 * time.Second
+
+// SyncHandler answers one request.
+type SyncHandler[Req, Resp any] func(ctx context.Context, req Req) (Resp, error)
+
+// Requester sends typed requests on a core NATS (not JetStream) subject.
+//
+// The request timeout is the ctx deadline (Timeout when ctx has none). A
+// "no responders" reply — the responder service is restarting — is retried
+// up to NoResponderRetries times within that deadline. Failures are returned
+// as *appError.AppError: the responder's own code for handler errors,
+// NATSRequestTimeout, ServiceUnavailable or NATSConnectionError otherwise.
+type Requester[Req, Resp any] struct {
+	Conn    *nats.Conn
+	Subject Subject
+
+	Timeout            time.Duration // default DefaultSyncTimeout
+	NoResponderRetries int           // default 3
+	RetryBackoff       time.Duration // first retry delay, doubled each time; default 100ms
+}
+
+// NewRequester creates a requester with default timeout and retry settings.
+func NewRequester[Req, Resp any](nc *nats.Conn, subject Subject) *Requester[Req, Resp] {
+	return &Requester[Req, Resp]{
+		Conn:               nc,
+		Subject:            subject,
+		Timeout:            DefaultSyncTimeout,
+		NoResponderRetries: 3,
+		RetryBackoff:       100 * time.Millisecond,
+	}
+}
+
+// Request sends req and waits for the typed response.
+func (r *Requester[Req, Resp]) Request(ctx context.Context, req Req) (Resp, error) {
+	var resp Resp
+	subject := string(r.Subject)
+	start := time.Now()
+	result := "failed"
+	defer func() {
+		metrics.RecordNATSSyncRequest(subject, "client", result, time.Since(start))
+	}()
+
+	if _, ok := ctx.Deadline(); !ok {
+		timeout := r.Timeout
+		if timeout <= 0 {
+			timeout = DefaultSyncTimeout
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return resp, appError.New(appError.NATSPublishError, "failed to marshal sync request", 0, err)
+	}
+	header := newEnvelope(ctx, "", 0, Envelope{}).Header()
+	deadline, _ := ctx.Deadline()
+	header.Set(HeaderSyncDeadline, deadline.UTC().Format(time.RFC3339Nano))
+
+	var reply *nats.Msg
+	for attempt := 1; ; attempt++ {
+		reply, err = r.Conn.RequestMsgWithContext(ctx, &nats.Msg{Subject: subject, Data: body, Header: header})
+		if err == nil || !errors.Is(err, nats.ErrNoResponders) || attempt > r.NoResponderRetries {
+			break
+		}
+		logger.Debug("No responders for sync request, retrying", logger.KeySubject, subject, "attempt", attempt)
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-time.After(nextBackoff(r.RetryBackoff, 0, 0, attempt)):
+			continue
+		}
+		break
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, nats.ErrNoResponders):
+			result = "no_responders"
+			return resp, appError.New(appError.ServiceUnavailable, fmt.Sprintf("no responders for %s", subject), 0, err)
+		case errors.Is(err, context.DeadlineExceeded), errors.Is(err, nats.ErrTimeout):
+			result = "timeout"
+			return resp, appError.New(appError.NATSRequestTimeout, fmt.Sprintf("sync request %s timed out", subject), 0, err)
+		default:
+			return resp, appError.New(appError.NATSConnectionError, fmt.Sprintf("sync request %s failed", subject), 0, err)
+		}
+	}
+
+	if code := reply.Header.Get(HeaderSyncErrorCode); code != "" {
+		result = "error"
+		var sr SyncReply
+		_ = json.Unmarshal(reply.Data, &sr)
+		return resp, appError.New(appError.ErrorCode(code), sr.Error, 0, nil)
+	}
+	if err := json.Unmarshal(reply.Data, &resp); err != nil {
+		return resp, appError.New(appError.EventDeserializationFail, fmt.Sprintf("failed to decode reply from %s", subject), 0, err)
+	}
+	result = "ok"
+	return resp, nil
+}
+
+// Responder serves typed requests on a core NATS subject. Replicas sharing a
+// Queue group split the requests between them.
+//
+// The handler ctx carries the caller's deadline (MaxHandleTime when the
+// request has none), its tenant, actor and correlation ID. A handler error is
+// sent back as a SyncReply with the appError code in HeaderSyncErrorCode, so
+// the Requester re-creates the same AppError on the calling side.
+type Responder[Req, Resp any] struct {
+	Conn          *nats.Conn
+	Subject       Subject
+	Queue         string
+	Handler       SyncHandler[Req, Resp]
+	MaxHandleTime time.Duration // default DefaultSyncTimeout
+
+	ctx context.Context
+	sub *nats.Subscription
+}
+
+// NewResponder creates a responder. Call Start to subscribe.
+func NewResponder[Req, Resp any](nc *nats.Conn, subject Subject, queue string, handler SyncHandler[Req, Resp]) *Responder[Req, Resp] {
+	return &Responder[Req, Resp]{
+		Conn:          nc,
+		Subject:       subject,
+		Queue:         queue,
+		Handler:       handler,
+		MaxHandleTime: DefaultSyncTimeout,
+	}
+}
+
+// Start subscribes to the subject. Handler contexts derive from ctx, so
+// cancelling it aborts in-flight handlers; call Stop to unsubscribe.
+func (r *Responder[Req, Resp]) Start(ctx context.Context) error {
+	r.ctx = ctx
+	sub, err := r.Conn.QueueSubscribe(string(r.Subject), r.Queue, r.handle)
+	if err != nil {
+		return appError.New(appError.NATSSubscriptionError, fmt.Sprintf("failed to subscribe to %s", r.Subject), 0, err)
+	}
+	r.sub = sub
+	logger.Info("Sync responder started", logger.KeySubject, string(r.Subject), "queue", r.Queue)
+	return nil
+}
+
+// Stop drains the subscription, letting in-flight requests finish.
+func (r *Responder[Req, Resp]) Stop() error {
+	if r.sub == nil {
+		return nil
+	}
+	return r.sub.Drain()
+}
+
+func (r *Responder[Req, Resp]) handle(msg *nats.Msg) {
+	subject := string(r.Subject)
+	start := time.Now()
+	result := "ok"
+	defer func() {
+		metrics.RecordNATSSyncRequest(subject, "server", result, time.Since(start))
+	}()
+
+	ctx, cancel := r.handlerContext(msg)
+	defer cancel()
+	if ctx.Err() != nil {
+		// The caller has already given up; don't spend work on it.
+		result = "expired"
+		return
+	}
+
+	var req Req
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		result = "error"
+		r.replyError(msg, appError.New(appError.EventDeserializationFail, "invalid sync request payload", 0, err))
+		return
+	}
+
+	resp, err := r.call(ctx, req)
+	if err != nil {
+		result = "error"
+		r.replyError(msg, err)
+		return
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		result = "error"
+		r.replyError(msg, appError.New(appError.InternalServerError, "failed to marshal sync reply", 0, err))
+		return
+	}
+	if err := msg.Respond(body); err != nil {
+		logger.Error("Failed to send sync reply", err, logger.KeySubject, subject)
+	}
+}
+
+// call runs the handler, turning a panic into an error reply instead of
+// crashing the connection's dispatch goroutine.
+func (r *Responder[Req, Resp]) call(ctx context.Context, req Req) (resp Resp, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Error("Sync handler panicked", fmt.Errorf("%v", p), logger.KeySubject, string(r.Subject))
+			err = appError.New(appError.InternalServerError, "sync handler panicked", 0, nil)
+		}
+	}()
+	return r.Handler(ctx, req)
+}
+
+func (r *Responder[Req, Resp]) handlerContext(msg *nats.Msg) (context.Context, context.CancelFunc) {
+	base := r.ctx
+	if base == nil {
+		base = context.Background()
+	}
+
+	var env Envelope
+	env.mergeHeader(msg.Header)
+	base = ContextWithEnvelope(base, env)
+	if env.TenantID != "" {
+		base = context.WithValue(base, helpers.TenantIDKey, env.TenantID)
+	}
+	if env.Actor != "" {
+		base = context.WithValue(base, helpers.UserIDKey, env.Actor)
+	}
+
+	if d, err := time.Parse(time.RFC3339Nano, msg.Header.Get(HeaderSyncDeadline)); err == nil {
+		return context.WithDeadline(base, d)
+	}
+	timeout := r.MaxHandleTime
+	if timeout <= 0 {
+		timeout = DefaultSyncTimeout
+	}
+	return context.WithTimeout(base, timeout)
+}
+
+func (r *Responder[Req, Resp]) replyError(msg *nats.Msg, err error) {
+	ae := appError.As(err)
+	switch {
+	case ae != nil:
+	case errors.Is(err, context.DeadlineExceeded):
+		ae = appError.New(appError.NATSRequestTimeout, err.Error(), 0, err)
+	default:
+		ae = appError.New(appError.InternalServerError, err.Error(), 0, err)
+	}
+	logger.Warn("Sync request failed", err, logger.KeySubject, string(r.Subject), "code", string(ae.Code))
+
+	body, _ := json.Marshal(SyncReply{OK: false, Error: ae.Message, Code: string(ae.Code)})
+	reply := &nats.Msg{Subject: msg.Reply, Data: body, Header: nats.Header{}}
+	reply.Header.Set(HeaderSyncErrorCode, string(ae.Code))
+	if rerr := msg.RespondMsg(reply); rerr != nil {
+		logger.Error("Failed to send sync error reply", rerr, logger.KeySubject, string(r.Subject))
+	}
+}
+
+// NewValidatePlanAssignmentRequester returns the subscriber-service side of
+// SyncBillingValidatePlanAssignment.
+func NewValidatePlanAssignmentRequester(nc *nats.Conn) *Requester[ValidatePlanAssignmentRequest, ValidatePlanAssignmentResponse] {
+	return NewRequester[ValidatePlanAssignmentRequest, ValidatePlanAssignmentResponse](nc, SyncBillingValidatePlanAssignment)
+}
+
+// NewValidatePlanAssignmentResponder returns the billing-service side of
+// SyncBillingValidatePlanAssignment.
+func NewValidatePlanAssignmentResponder(nc *nats.Conn, queue string, handler SyncHandler[ValidatePlanAssignmentRequest, ValidatePlanAssignmentResponse]) *Responder[ValidatePlanAssignmentRequest, ValidatePlanAssignmentResponse] {
+	return NewResponder(nc, SyncBillingValidatePlanAssignment, queue, handler)
+}
+
+// NewSyncSubscriberCreatedRequester returns the captive-portal-service side
+// of SyncSubscriberCreatedSubject. Req is the payload the portal sends (its
+// guest hotspot subscriber event); the reply carries the created subscriber
+// and profile IDs.
+func NewSyncSubscriberCreatedRequester[Req any](nc *nats.Conn) *Requester[Req, SyncReply] {
+	return NewRequester[Req, SyncReply](nc, SyncSubscriberCreatedSubject)
+}
+
+// NewSyncSubscriberCreatedResponder returns the subscriber-service side of
+// SyncSubscriberCreatedSubject.
+func NewSyncSubscriberCreatedResponder[Req any](nc *nats.Conn, queue string, handler SyncHandler[Req, SyncReply]) *Responder[Req, SyncReply] {
+	return NewResponder(nc, SyncSubscriberCreatedSubject, queue, handler)
+}
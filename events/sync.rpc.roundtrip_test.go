@@ -0,0 +1,149 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/praction-networks/common/appError"
+	"github.com/praction-networks/common/events"
+	"github.com/praction-networks/common/events/eventstest"
+	"github.com/praction-networks/common/helpers"
+)
+
+const syncTestSubject events.Subject = "test.sync.echo"
+
+type echoRequest struct {
+	Say string `json:"say"`
+}
+
+type echoReply struct {
+	Said   string `json:"said"`
+	Tenant string `json:"tenant"`
+}
+
+func echo(ctx context.Context, req echoRequest) (echoReply, error) {
+	switch req.Say {
+	case "conflict":
+		return echoReply{}, appError.New(appError.ResourceConflict, "plan already assigned", 0, nil)
+	case "fail":
+		return echoReply{}, errors.New("db down")
+	case "panic":
+		panic("nil map")
+	}
+	return echoReply{Said: req.Say, Tenant: helpers.GetTenantID(ctx)}, nil
+}
+
+func startResponder(t *testing.T, srv *eventstest.Server, handler events.SyncHandler[echoRequest, echoReply]) {
+	t.Helper()
+	nc := srv.Connect(t)
+	r := events.NewResponder(nc, syncTestSubject, "q", handler)
+	if err := r.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = r.Stop() })
+	// The subscription must reach the server before the first request.
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncRequestRoundTrip(t *testing.T) {
+	initLogger(t)
+	srv := eventstest.NewServer(t)
+	startResponder(t, srv, echo)
+	req := events.NewRequester[echoRequest, echoReply](srv.Connect(t), syncTestSubject)
+	ctx := context.WithValue(context.Background(), helpers.TenantIDKey, "tenant-1")
+
+	resp, err := req.Request(ctx, echoRequest{Say: "hi"})
+	if err != nil || resp != (echoReply{Said: "hi", Tenant: "tenant-1"}) {
+		t.Fatalf("Request = %+v, %v", resp, err)
+	}
+
+	// Handler errors come back as the AppError the handler returned; other
+	// errors and panics as InternalServerError, and the responder survives.
+	cases := []struct {
+		say     string
+		code    appError.ErrorCode
+		message string
+	}{
+		{"conflict", appError.ResourceConflict, "plan already assigned"},
+		{"fail", appError.InternalServerError, "db down"},
+		{"panic", appError.InternalServerError, "sync handler panicked"},
+	}
+	for _, c := range cases {
+		_, err := req.Request(ctx, echoRequest{Say: c.say})
+		var ae *appError.AppError
+		if !errors.As(err, &ae) || ae.Code != c.code || ae.Message != c.message {
+			t.Fatalf("%s: err = %#v, want %s %q", c.say, err, c.code, c.message)
+		}
+	}
+	if resp, err := req.Request(ctx, echoRequest{Say: "still here"}); err != nil || resp.Said != "still here" {
+		t.Fatalf("after panic: %+v, %v", resp, err)
+	}
+}
+
+func TestSyncRequestRetriesNoResponders(t *testing.T) {
+	initLogger(t)
+	srv := eventstest.NewServer(t)
+	req := events.NewRequester[echoRequest, echoReply](srv.Connect(t), syncTestSubject)
+	req.NoResponderRetries = 2
+	req.RetryBackoff = 20 * time.Millisecond
+
+	// Nobody answers: the first try and two retries, 20ms then 40ms apart.
+	start := time.Now()
+	_, err := req.Request(context.Background(), echoRequest{Say: "hi"})
+	if ae := appError.As(err); ae == nil || ae.Code != appError.ServiceUnavailable {
+		t.Fatalf("err = %#v, want %s", err, appError.ServiceUnavailable)
+	}
+	if n := srv.Published(syncTestSubject); n != 3 {
+		t.Fatalf("%d requests sent, want 3", n)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Fatalf("gave up after %v, want the 60ms of backoff", elapsed)
+	}
+
+	// A responder back while the requester waits to retry is reached.
+	req.RetryBackoff = 200 * time.Millisecond
+	done := make(chan error, 1)
+	go func() {
+		_, err := req.Request(context.Background(), echoRequest{Say: "hi"})
+		done <- err
+	}()
+	for srv.Published(syncTestSubject) < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	startResponder(t, srv, echo)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Published(syncTestSubject); n != 5 {
+		t.Fatalf("%d requests sent, want 5", n)
+	}
+}
+
+func TestSyncRequestTimeout(t *testing.T) {
+	initLogger(t)
+	srv := eventstest.NewServer(t)
+	handlerDeadline := make(chan time.Time, 1)
+	startResponder(t, srv, func(ctx context.Context, _ echoRequest) (echoReply, error) {
+		d, _ := ctx.Deadline()
+		handlerDeadline <- d
+		<-ctx.Done()
+		return echoReply{}, ctx.Err()
+	})
+	req := events.NewRequester[echoRequest, echoReply](srv.Connect(t), syncTestSubject)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	_, err := req.Request(ctx, echoRequest{Say: "slow"})
+	if ae := appError.As(err); ae == nil || ae.Code != appError.NATSRequestTimeout {
+		t.Fatalf("err = %#v, want %s", err, appError.NATSRequestTimeout)
+	}
+	// The handler stops when the caller does.
+	if got := <-handlerDeadline; !got.Equal(deadline) {
+		t.Fatalf("handler deadline = %v, want %v", got, deadline)
+	}
+}
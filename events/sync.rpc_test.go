@@ -0,0 +1,60 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/praction-networks/common/helpers"
+)
+
+func TestResponderHandlerContext(t *testing.T) {
+	r := NewResponder[SyncReply, SyncReply](nil, "test.sync", "q", nil)
+
+	deadline := time.Now().Add(2 * time.Second).UTC()
+	msg := &nats.Msg{Header: nats.Header{}}
+	msg.Header.Set(HeaderSyncDeadline, deadline.Format(time.RFC3339Nano))
+	msg.Header.Set(HeaderTenantID, "tenant-1")
+	msg.Header.Set(HeaderCorrelationID, "corr-1")
+	msg.Header.Set(HeaderEventID, "req-1")
+
+	ctx, cancel := r.handlerContext(msg)
+	defer cancel()
+	if got, ok := ctx.Deadline(); !ok || !got.Equal(deadline) {
+		t.Fatalf("deadline = %v, want %v", got, deadline)
+	}
+	if helpers.GetTenantID(ctx) != "tenant-1" {
+		t.Fatalf("tenant not propagated")
+	}
+	if GetCorrelationID(ctx) != "corr-1" || GetCausationID(ctx) != "req-1" {
+		t.Fatalf("correlation chain not propagated: %q %q", GetCorrelationID(ctx), GetCausationID(ctx))
+	}
+
+	// No deadline header: bounded by MaxHandleTime.
+	ctx2, cancel2 := r.handlerContext(&nats.Msg{})
+	defer cancel2()
+	if got, ok := ctx2.Deadline(); !ok || time.Until(got) > DefaultSyncTimeout {
+		t.Fatalf("expected default handler deadline, got %v", got)
+	}
+
+	// Expired deadline: ctx is already done.
+	msg.Header.Set(HeaderSyncDeadline, time.Now().Add(-time.Second).Format(time.RFC3339Nano))
+	ctx3, cancel3 := r.handlerContext(msg)
+	defer cancel3()
+	if ctx3.Err() != context.DeadlineExceeded {
+		t.Fatalf("expected expired ctx, got %v", ctx3.Err())
+	}
+}
+
+func TestSyncSubscriberCreatedHelpers(t *testing.T) {
+	type guest struct{ SessionID string }
+	req := NewSyncSubscriberCreatedRequester[guest](nil)
+	resp := NewSyncSubscriberCreatedResponder[guest](nil, "subscriber-service", nil)
+	if req.Subject != SyncSubscriberCreatedSubject || resp.Subject != SyncSubscriberCreatedSubject {
+		t.Fatalf("subjects = %q, %q", req.Subject, resp.Subject)
+	}
+	if resp.Queue != "subscriber-service" || req.Timeout != DefaultSyncTimeout {
+		t.Fatalf("requester %+v, responder %+v", req, resp)
+	}
+}
@@ -3,6 +3,7 @@ package events
 // Sync Request/Reply Subjects — Direct NATS (NOT JetStream)
 // Used by captive-portal-service to get synchronous confirmation from subscriber-service
 // after publishing guest hotspot events. These run alongside the existing JetStream events.
+// Use Requester / Responder (sync.rpc.go) rather than calling nc.Request directly.
 const (
 	// SyncSubscriberCreated is the subject for synchronous subscriber creation confirmation
 	SyncSubscriberCreatedSubject = "captive.sync.subscriber.created"
@@ -22,6 +23,7 @@ const (
 type SyncReply struct {
 	OK           bool   `json:"ok"`
 	Error        string `json:"error,omitempty"`
+	Code         string `json:"code,omitempty"` // appError.ErrorCode when OK is false
 	SubscriberID string `json:"subscriberId,omitempty"`
 	ProfileID    string `json:"profileId,omitempty"`
 }
@@ -0,0 +1,90 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// UpcastFunc transforms a payload from one schema version to the next.
+type UpcastFunc func(data json.RawMessage) (json.RawMessage, error)
+
+// UpcasterRegistry holds per-subject vN → vN+1 payload transforms. A Listener
+// with Upcasters set runs every applicable step before dispatch, so handlers
+// only ever see the latest shape of a payload.
+//
+// The version is the envelope's SchemaVersion (Publisher.SchemaVersion), not
+// the payload's own Version field, which on most models counts entity
+// revisions. Events published before envelopes existed count as
+// DefaultSchemaVersion.
+type UpcasterRegistry struct {
+	mu    sync.RWMutex
+	steps map[Subject]map[int]UpcastFunc // subject -> from version -> step
+}
+
+// NewUpcasterRegistry creates an empty registry.
+func NewUpcasterRegistry() *UpcasterRegistry {
+	return &UpcasterRegistry{steps: map[Subject]map[int]UpcastFunc{}}
+}
+
+// Upcasters is the process-wide registry.
+var Upcasters = NewUpcasterRegistry()
+
+// Register adds the step that turns version from of subject's payload into
+// version from+1. Registering the same step twice replaces it.
+func (r *UpcasterRegistry) Register(subject Subject, from int, fn UpcastFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.steps[subject] == nil {
+		r.steps[subject] = map[int]UpcastFunc{}
+	}
+	r.steps[subject][from] = fn
+}
+
+// RegisterUpcast registers a typed step: the payload is decoded as From,
+// transformed, and re-encoded from To.
+func RegisterUpcast[From, To any](r *UpcasterRegistry, subject Subject, from int, fn func(From) (To, error)) {
+	r.Register(subject, from, func(data json.RawMessage) (json.RawMessage, error) {
+		var in From
+		if err := json.Unmarshal(data, &in); err != nil {
+			return nil, fmt.Errorf("decode v%d: %w", from, err)
+		}
+		out, err := fn(in)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(out)
+	})
+}
+
+// Latest returns the version an event of subject reaches after upcasting
+// from version.
+func (r *UpcasterRegistry) Latest(subject Subject, version int) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for r.steps[subject][version] != nil {
+		version++
+	}
+	return version
+}
+
+// Upcast applies every registered step starting at version and returns the
+// transformed payload with its final version. Data is returned unchanged when
+// no step applies. A failing step is reported as ErrPoisonMessage: it is a
+// pure function of the payload, so redelivery can't fix it.
+func (r *UpcasterRegistry) Upcast(subject Subject, version int, data json.RawMessage) (json.RawMessage, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for {
+		step := r.steps[subject][version]
+		if step == nil {
+			return data, version, nil
+		}
+		next, err := step(data)
+		if err != nil {
+			return nil, version, fmt.Errorf("%w: upcast %s v%d->v%d: %v", ErrPoisonMessage, subject, version, version+1, err)
+		}
+		data = next
+		version++
+	}
+}
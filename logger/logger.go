@@ -428,6 +428,23 @@ func GetComplianceConfig() *ComplianceConfig {
 	return complianceConfig
 }
 
+// SensitiveKeys returns the key fragments whose values are always redacted,
+// for packages that redact other records (audit diffs) like log fields.
+func SensitiveKeys() []string {
+	return append([]string(nil), sensitiveKeys...)
+}
+
+// PersonalKeys returns the key fragments whose values are masked as PII.
+func PersonalKeys() []string {
+	return append([]string(nil), personalKeys...)
+}
+
+// MaskPII masks a personal value stored under key the way log fields are
+// masked in the current compliance mode.
+func MaskPII(key, value string) string {
+	return maskPII(strings.ToLower(key), value)
+}
+
 func GetGlobalLogger() *zap.Logger {
 	if logInstance == nil {
 		panic("Logger not initialized")
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -95,6 +96,159 @@ var (
 		},
 		[]string{"stream", "subject", "success"},
 	)
+
+	NATSDeadLettered = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_dead_lettered_total",
+			Help: "NATS messages moved to a dead-letter stream",
+		},
+		[]string{"stream", "subject", "reason"}, // reason: "poison", "max_deliver"
+	)
+
+	NATSDeadLetterFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_dead_letter_failures_total",
+			Help: "Failures writing NATS messages to a dead-letter stream",
+		},
+		[]string{"stream", "subject", "error"},
+	)
+
+	NATSDeadLetterRedriven = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_dead_letter_redriven_total",
+			Help: "Dead-letter entries re-driven to their origin subject",
+		},
+		[]string{"stream", "subject"},
+	)
+
+	NATSFallbackBacklog = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "nats_fallback_backlog",
+			Help: "Documents waiting in a NATS fallback collection",
+		},
+		[]string{"collection", "state"}, // state: "pending", "parked"
+	)
+
+	NATSFallbackOldestAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "nats_fallback_oldest_age_seconds",
+			Help: "Age of the oldest pending document in a NATS fallback collection",
+		},
+		[]string{"collection"},
+	)
+
+	NATSFallbackReplays = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_fallback_replays_total",
+			Help: "NATS fallback replay attempts by result",
+		},
+		[]string{"collection", "result"}, // result: "ok", "failed", "parked"
+	)
+
+	NATSSyncRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_sync_requests_total",
+			Help: "NATS request/reply calls by side and result",
+		},
+		[]string{"subject", "side", "result"}, // side: "client", "server"
+	)
+
+	NATSSyncRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "nats_sync_request_duration_seconds",
+			Help:    "Duration of NATS request/reply calls",
+			Buckets: []float64{.001, .005, .01, .05, .1, .5, 1, 2.5, 5, 10},
+		},
+		[]string{"subject", "side"},
+	)
+
+	NATSDuplicatesSuppressed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_duplicates_suppressed_total",
+			Help: "NATS messages skipped by a consumer because they were already processed",
+		},
+		[]string{"stream", "consumer", "subject"},
+	)
+
+	NATSUpcasts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_upcasts_total",
+			Help: "NATS event payloads upcast to a newer schema version before dispatch",
+		},
+		[]string{"stream", "subject", "from", "to"},
+	)
+
+	NATSConsumerMessages = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "nats_consumer_messages",
+			Help: "Messages of a NATS durable consumer by state",
+		},
+		[]string{"stream", "consumer", "state"}, // state: "pending", "ack_pending", "redelivered"
+	)
+
+	NATSConsumerLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "nats_consumer_lag_seconds",
+			Help: "Age of the oldest message a NATS durable consumer has not acked yet",
+		},
+		[]string{"stream", "consumer"},
+	)
+
+	NATSConsumerLastActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "nats_consumer_last_active_timestamp_seconds",
+			Help: "Unix time a NATS durable consumer last had a message delivered",
+		},
+		[]string{"stream", "consumer"},
+	)
+
+	NATSCircuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "nats_consumer_circuit_breaker_state",
+			Help: "Current circuit breaker state per NATS consumer (0=closed, 1=open, 2=half-open)",
+		},
+		[]string{"stream", "consumer"},
+	)
+
+	NATSCircuitBreakerTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_consumer_circuit_breaker_transitions_total",
+			Help: "Total NATS consumer circuit breaker state transitions",
+		},
+		[]string{"stream", "consumer", "from", "to"},
+	)
+
+	NATSConsumerFiltered = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nats_consumer_filtered_total",
+			Help: "NATS messages acked and skipped by a listener's client-side filters",
+		},
+		[]string{"stream", "consumer", "filter", "subject"},
+	)
+
+	SagaTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "saga_transitions_total",
+			Help: "Saga instances entering each status",
+		},
+		[]string{"saga", "status"}, // status: "running", "completed", "compensating", "compensated", "failed"
+	)
+
+	AuditPipelineEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "audit_pipeline_events_total",
+			Help: "Audit events leaving the async audit pipeline, by outcome",
+		},
+		[]string{"service", "outcome"}, // outcome: "published", "spooled", "dropped"
+	)
+
+	AuditPipelineQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "audit_pipeline_queue_depth",
+			Help: "Audit events waiting in the async audit pipeline's queue",
+		},
+		[]string{"service"},
+	)
 )
 
 // System Metrics
@@ -870,6 +1024,25 @@ func RegisterAllMetrics() {
 		NATSEventProcessingTime,
 		NATSInflightMessages,
 		NATSPublishDuration,
+		NATSDeadLettered,
+		NATSDeadLetterFailures,
+		NATSDeadLetterRedriven,
+		NATSFallbackBacklog,
+		NATSFallbackOldestAge,
+		NATSFallbackReplays,
+		NATSSyncRequests,
+		NATSSyncRequestDuration,
+		NATSDuplicatesSuppressed,
+		NATSUpcasts,
+		NATSConsumerMessages,
+		NATSConsumerLag,
+		NATSConsumerLastActive,
+		NATSCircuitBreakerState,
+		NATSCircuitBreakerTransitions,
+		NATSConsumerFiltered,
+		SagaTransitions,
+		AuditPipelineEvents,
+		AuditPipelineQueueDepth,
 
 		// System metrics
 		CPUUsage,
@@ -1052,6 +1225,75 @@ func DecNATSInflight(stream, subject string) {
 	NATSInflightMessages.WithLabelValues(stream, subject).Dec()
 }
 
+func RecordNATSDeadLettered(stream, subject, reason string) {
+	NATSDeadLettered.WithLabelValues(stream, subject, reason).Inc()
+}
+
+func RecordNATSDeadLetterFailure(stream, subject string, err error) {
+	NATSDeadLetterFailures.WithLabelValues(stream, subject, err.Error()).Inc()
+}
+
+func RecordNATSDeadLetterRedriven(stream, subject string) {
+	NATSDeadLetterRedriven.WithLabelValues(stream, subject).Inc()
+}
+
+func SetNATSFallbackBacklog(collection string, pending, parked int64, oldestAge time.Duration) {
+	NATSFallbackBacklog.WithLabelValues(collection, "pending").Set(float64(pending))
+	NATSFallbackBacklog.WithLabelValues(collection, "parked").Set(float64(parked))
+	NATSFallbackOldestAge.WithLabelValues(collection).Set(oldestAge.Seconds())
+}
+
+func RecordNATSFallbackReplay(collection, result string) {
+	NATSFallbackReplays.WithLabelValues(collection, result).Inc()
+}
+
+func RecordNATSSyncRequest(subject, side, result string, duration time.Duration) {
+	NATSSyncRequests.WithLabelValues(subject, side, result).Inc()
+	NATSSyncRequestDuration.WithLabelValues(subject, side).Observe(duration.Seconds())
+}
+
+func RecordNATSDuplicateSuppressed(stream, consumer, subject string) {
+	NATSDuplicatesSuppressed.WithLabelValues(stream, consumer, subject).Inc()
+}
+
+func RecordNATSUpcast(stream, subject string, from, to int) {
+	NATSUpcasts.WithLabelValues(stream, subject, strconv.Itoa(from), strconv.Itoa(to)).Inc()
+}
+
+func SetNATSConsumerStats(stream, consumer string, pending uint64, ackPending, redelivered int, lag time.Duration, lastActive time.Time) {
+	NATSConsumerMessages.WithLabelValues(stream, consumer, "pending").Set(float64(pending))
+	NATSConsumerMessages.WithLabelValues(stream, consumer, "ack_pending").Set(float64(ackPending))
+	NATSConsumerMessages.WithLabelValues(stream, consumer, "redelivered").Set(float64(redelivered))
+	NATSConsumerLag.WithLabelValues(stream, consumer).Set(lag.Seconds())
+	if !lastActive.IsZero() {
+		NATSConsumerLastActive.WithLabelValues(stream, consumer).Set(float64(lastActive.Unix()))
+	}
+}
+
+func SetNATSCircuitBreakerState(stream, consumer string, state int) {
+	NATSCircuitBreakerState.WithLabelValues(stream, consumer).Set(float64(state))
+}
+
+func RecordNATSCircuitBreakerTransition(stream, consumer, from, to string) {
+	NATSCircuitBreakerTransitions.WithLabelValues(stream, consumer, from, to).Inc()
+}
+
+func RecordNATSConsumerFiltered(stream, consumer, filter, subject string) {
+	NATSConsumerFiltered.WithLabelValues(stream, consumer, filter, subject).Inc()
+}
+
+func RecordSagaTransition(saga, status string) {
+	SagaTransitions.WithLabelValues(saga, status).Inc()
+}
+
+func RecordAuditPipelineEvents(service, outcome string, n int) {
+	AuditPipelineEvents.WithLabelValues(service, outcome).Add(float64(n))
+}
+
+func SetAuditPipelineQueueDepth(service string, depth int) {
+	AuditPipelineQueueDepth.WithLabelValues(service).Set(float64(depth))
+}
+
 // Security Metrics Helpers
 func RecordNonceStored(status string) {
 	NonceStored.WithLabelValues(status).Inc()